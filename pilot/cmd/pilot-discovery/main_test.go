@@ -0,0 +1,52 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build testrunmain
+
+package main
+
+import (
+	"flag"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestRunMain runs pilot-discovery with the arguments after --, in the binary built with coverage by
+// make pilot-discovery-coverage, such as
+//
+//	pilot-discovery -test.run ^TestRunMain$ -test.coverprofile /var/istio-coverage/pilot-discovery.out -- discovery ...
+//
+// It returns, and so writes the coverage profile, once the file of COVERAGE_FLUSH_FILE exists, which it
+// removes so that the restarted container keeps running.
+func TestRunMain(t *testing.T) {
+	flushFile := os.Getenv("COVERAGE_FLUSH_FILE")
+	if flushFile == "" {
+		t.Fatal("COVERAGE_FLUSH_FILE is not set")
+	}
+	// left by the previous container
+	_ = os.Remove(flushFile)
+
+	os.Args = append([]string{os.Args[0]}, flag.Args()...)
+	go main()
+	for {
+		if _, err := os.Stat(flushFile); err == nil {
+			if err = os.Remove(flushFile); err != nil {
+				t.Log(err)
+			}
+			return
+		}
+		time.Sleep(time.Second)
+	}
+}
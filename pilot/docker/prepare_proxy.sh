@@ -12,12 +12,14 @@ usage() {
   echo '  -u: Specify the UID of the user for which the redirection is not'
   echo '      applied. Typically, this is the UID of the proxy container'
   echo '  -i: Comma separated list of IP ranges in CIDR form to redirect to envoy (optional)'
+  echo '  -6: Also redirect the IPv6 traffic with ip6tables, for pods of IPv6 and dual-stack clusters (optional)'
   echo ''
 }
 
 IP_RANGES_INCLUDE=""
+ENABLE_IPV6=""
 
-while getopts ":p:u:e:i:h" opt; do
+while getopts ":p:u:e:i:6h" opt; do
   case ${opt} in
     p)
       ENVOY_PORT=${OPTARG}
@@ -28,6 +30,9 @@ while getopts ":p:u:e:i:h" opt; do
     i)
       IP_RANGES_INCLUDE=${OPTARG}
       ;;
+    6)
+      ENABLE_IPV6=1
+      ;;
     h)
       usage
       exit 0
@@ -46,47 +51,81 @@ if [[ -z "${ENVOY_PORT-}" ]] || [[ -z "${ENVOY_UID-}" ]]; then
   exit 1
 fi
 
-# Create a new chain for redirecting inbound and outbound traffic to
-# the common Envoy port.
-iptables -t nat -N ISTIO_REDIRECT                                             -m comment --comment "istio/redirect-common-chain"
-iptables -t nat -A ISTIO_REDIRECT -p tcp -j REDIRECT --to-port ${ENVOY_PORT}  -m comment --comment "istio/redirect-to-envoy-port"
+# install_rules installs the rules with the iptables command of an IP family, given the loopback range
+# of the family and the ranges of IP_RANGES_INCLUDE in that family.
+install_rules() {
+  local cmd=$1
+  local loopback=$2
+  local ranges=$3
+
+  # Create a new chain for redirecting inbound and outbound traffic to
+  # the common Envoy port.
+  ${cmd} -t nat -N ISTIO_REDIRECT                                             -m comment --comment "istio/redirect-common-chain"
+  ${cmd} -t nat -A ISTIO_REDIRECT -p tcp -j REDIRECT --to-port ${ENVOY_PORT}  -m comment --comment "istio/redirect-to-envoy-port"
 
-# Redirect all inbound traffic to Envoy.
-iptables -t nat -A PREROUTING -j ISTIO_REDIRECT                               -m comment --comment "istio/install-istio-prerouting"
+  # Redirect all inbound traffic to Envoy.
+  ${cmd} -t nat -A PREROUTING -j ISTIO_REDIRECT                               -m comment --comment "istio/install-istio-prerouting"
 
-# Create a new chain for selectively redirecting outbound packets to
-# Envoy.
-iptables -t nat -N ISTIO_OUTPUT                                               -m comment --comment "istio/common-output-chain"
+  # Create a new chain for selectively redirecting outbound packets to
+  # Envoy.
+  ${cmd} -t nat -N ISTIO_OUTPUT                                               -m comment --comment "istio/common-output-chain"
 
-# Jump to the ISTIO_OUTPUT chain from OUTPUT chain for all tcp
-# traffic. '-j RETURN' bypasses Envoy and '-j ISTIO_REDIRECT'
-# redirects to Envoy.
-iptables -t nat -A OUTPUT -p tcp -j ISTIO_OUTPUT                              -m comment --comment "istio/install-istio-output"
+  # Jump to the ISTIO_OUTPUT chain from OUTPUT chain for all tcp
+  # traffic. '-j RETURN' bypasses Envoy and '-j ISTIO_REDIRECT'
+  # redirects to Envoy.
+  ${cmd} -t nat -A OUTPUT -p tcp -j ISTIO_OUTPUT                              -m comment --comment "istio/install-istio-output"
 
-# Redirect app calls to back itself via Envoy when using the service VIP or endpoint
-# address, e.g. appN => Envoy (client) => Envoy (server) => appN.
-iptables -t nat -A ISTIO_OUTPUT -o lo ! -d 127.0.0.1/32 -j ISTIO_REDIRECT     -m comment --comment "istio/redirect-implicit-loopback"
+  # Redirect app calls to back itself via Envoy when using the service VIP or endpoint
+  # address, e.g. appN => Envoy (client) => Envoy (server) => appN.
+  ${cmd} -t nat -A ISTIO_OUTPUT -o lo ! -d ${loopback} -j ISTIO_REDIRECT      -m comment --comment "istio/redirect-implicit-loopback"
 
-# Avoid infinite loops. Don't redirect Envoy traffic directly back to
-# Envoy for non-loopback traffic.
-iptables -t nat -A ISTIO_OUTPUT -m owner --uid-owner ${ENVOY_UID} -j RETURN   -m comment --comment "istio/bypass-envoy"
+  # Avoid infinite loops. Don't redirect Envoy traffic directly back to
+  # Envoy for non-loopback traffic.
+  ${cmd} -t nat -A ISTIO_OUTPUT -m owner --uid-owner ${ENVOY_UID} -j RETURN   -m comment --comment "istio/bypass-envoy"
 
-# Skip redirection for Envoy-aware applications and
-# container-to-container traffic both of which explicitly use
-# localhost.
-iptables -t nat -A ISTIO_OUTPUT -d 127.0.0.1/32 -j RETURN                     -m comment --comment "istio/bypass-explicit-loopback"
+  # Skip redirection for Envoy-aware applications and
+  # container-to-container traffic both of which explicitly use
+  # localhost.
+  ${cmd} -t nat -A ISTIO_OUTPUT -d ${loopback} -j RETURN                      -m comment --comment "istio/bypass-explicit-loopback"
+
+  # All outbound traffic will be redirected to Envoy by default. If
+  # IP_RANGES_INCLUDE is non-empty, only traffic bound for the
+  # destinations specified in this list will be captured.
+  if [ "${IP_RANGES_INCLUDE}" != "" ]; then
+      for cidr in ${ranges}; do
+          ${cmd} -t nat -A ISTIO_OUTPUT -d ${cidr} -j ISTIO_REDIRECT          -m comment --comment "istio/redirect-ip-range-${cidr}"
+      done
+      ${cmd} -t nat -A ISTIO_OUTPUT -j RETURN                                 -m comment --comment "istio/bypass-default-outbound"
+  else
+      ${cmd} -t nat -A ISTIO_OUTPUT -j ISTIO_REDIRECT                         -m comment --comment "istio/redirect-default-outbound"
+  fi
+}
 
-# All outbound traffic will be redirected to Envoy by default. If
-# IP_RANGES_INCLUDE is non-empty, only traffic bound for the
-# destinations specified in this list will be captured.
+# Split IP_RANGES_INCLUDE by IP family, the IPv6 ranges holding colons.
+IP_RANGES_INCLUDE_V4=""
+IP_RANGES_INCLUDE_V6=""
 IFS=,
-if [ "${IP_RANGES_INCLUDE}" != "" ]; then
-    for cidr in ${IP_RANGES_INCLUDE}; do
-        iptables -t nat -A ISTIO_OUTPUT -d ${cidr} -j ISTIO_REDIRECT          -m comment --comment "istio/redirect-ip-range-${cidr}"
-    done
-    iptables -t nat -A ISTIO_OUTPUT -j RETURN                                 -m comment --comment "istio/bypass-default-outbound"
-else
-    iptables -t nat -A ISTIO_OUTPUT -j ISTIO_REDIRECT                         -m comment --comment "istio/redirect-default-outbound"
+for cidr in ${IP_RANGES_INCLUDE}; do
+  if [[ ${cidr} == *:* ]]; then
+    IP_RANGES_INCLUDE_V6="${IP_RANGES_INCLUDE_V6:+${IP_RANGES_INCLUDE_V6},}${cidr}"
+  else
+    IP_RANGES_INCLUDE_V4="${IP_RANGES_INCLUDE_V4:+${IP_RANGES_INCLUDE_V4},}${cidr}"
+  fi
+done
+
+install_rules iptables 127.0.0.1/32 "${IP_RANGES_INCLUDE_V4}"
+
+# With -6, IPv6 and dual-stack pods get the same rules from ip6tables. Without it the IPv6 traffic
+# is left alone, as the listeners of Envoy only bind IPv4 addresses unless configured for IPv6.
+if [ "${ENABLE_IPV6}" != "" ]; then
+  if ! ip6tables -t nat -L -n > /dev/null 2>&1; then
+    echo "Cannot redirect the IPv6 traffic without the IPv6 nat table" >&2
+    exit 1
+  fi
+  install_rules ip6tables ::1/128 "${IP_RANGES_INCLUDE_V6}"
+elif [ "${IP_RANGES_INCLUDE_V6}" != "" ]; then
+  echo "Cannot redirect the IPv6 ranges ${IP_RANGES_INCLUDE_V6} without -6" >&2
+  exit 1
 fi
 
 exit 0
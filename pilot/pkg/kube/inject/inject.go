@@ -129,6 +129,9 @@ type Params struct {
 	// redirect outbound traffic to Envoy for these IP
 	// ranges. Otherwise all outbound traffic is redirected to Envoy.
 	IncludeIPRanges string `json:"includeIPRanges"`
+	// If set, also redirect the IPv6 traffic to Envoy, for pods of
+	// IPv6 and dual-stack clusters.
+	EnableIPv6 bool `json:"enableIPv6"`
 }
 
 // Config specifies the sidecar injection configuration This includes
@@ -30,6 +30,9 @@ initContainers:
   - "-i"
   - [[ .IncludeIPRanges ]]
   [[ end -]]
+  [[ if eq .EnableIPv6 true -]]
+  - "-6"
+  [[ end -]]
   [[ if eq .ImagePullPolicy "" -]]
   imagePullPolicy: IfNotPresent
   [[ else -]]
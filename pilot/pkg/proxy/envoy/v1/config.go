@@ -925,6 +925,15 @@ func TruncateClusterName(name string) string {
 	return name
 }
 
+// buildExternalSSLContext returns the context of the TLS connections originated to the external host,
+// presenting the host as SNI unless it is a wildcard.
+func buildExternalSSLContext(host string) *SSLContextExternal {
+	if strings.Contains(host, "*") {
+		return &SSLContextExternal{}
+	}
+	return &SSLContextExternal{SNI: host}
+}
+
 func buildEgressVirtualHost(serviceName string, destination string,
 	mesh *meshconfig.MeshConfig, node model.Proxy, port *model.Port, proxyInstances []*model.ServiceInstance,
 	config model.IstioConfigStore) *VirtualHost {
@@ -944,7 +953,7 @@ func buildEgressVirtualHost(serviceName string, destination string,
 	externalTrafficCluster.hostname = destination
 	externalTrafficCluster.port = port
 	if protocolToHandle == model.ProtocolHTTPS {
-		externalTrafficCluster.SSLContext = &SSLContextExternal{}
+		externalTrafficCluster.SSLContext = buildExternalSSLContext(destination)
 	}
 
 	if protocolToHandle == model.ProtocolHTTP2 {
@@ -643,6 +643,22 @@ func TestTruncateClusterName(t *testing.T) {
 	}
 }
 
+func TestBuildExternalSSLContext(t *testing.T) {
+	cases := []struct {
+		host    string
+		wantSNI string
+	}{
+		{host: "httpbin.org", wantSNI: "httpbin.org"},
+		{host: "*.google.com", wantSNI: ""},
+		{host: "*google.com", wantSNI: ""},
+	}
+	for _, c := range cases {
+		if got := buildExternalSSLContext(c.host); got.SNI != c.wantSNI {
+			t.Errorf("buildExternalSSLContext(%q) has SNI %q, want %q", c.host, got.SNI, c.wantSNI)
+		}
+	}
+}
+
 func TestBuildJwksUriClusterNameAndAddress(t *testing.T) {
 	cases := []struct {
 		in          string
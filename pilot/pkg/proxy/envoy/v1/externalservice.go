@@ -147,7 +147,7 @@ func buildExternalServiceCluster(mesh *meshconfig.MeshConfig,
 
 	var sslContext interface{}
 	if port.Protocol == model.ProtocolHTTPS {
-		sslContext = &SSLContextExternal{}
+		sslContext = buildExternalSSLContext(address)
 	}
 
 	var features string
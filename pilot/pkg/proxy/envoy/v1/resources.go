@@ -729,6 +729,7 @@ type SSLContext struct {
 // SSLContextExternal definition
 type SSLContextExternal struct {
 	CaCertFile string `json:"ca_cert_file,omitempty"`
+	SNI        string `json:"sni,omitempty"`
 }
 
 // SSLContextWithSAN definition, VerifySubjectAltName cannot be nil.
@@ -17,21 +17,31 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/rand"
 	"crypto/tls"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang/sync/errgroup"
 	"github.com/gorilla/websocket"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 
 	pb "istio.io/istio/pilot/test/grpcecho"
 )
@@ -41,36 +51,102 @@ var (
 	timeout time.Duration
 
 	url       string
+	method    string
 	headerKey string
 	headerVal string
+	headers   headerList
 	msg       string
 
 	caFile string
+
+	messages int
+	stream   string
+	interval time.Duration
+
+	healthCheck   bool
+	healthService string
+
+	qps      int
+	duration time.Duration
+	warmup   time.Duration
+
+	payload int
+	idle    time.Duration
+
+	followRedirects bool
+
+	jsonOutput bool
 )
 
 const (
 	hostKey = "Host"
 )
 
+// headerList holds the Key:value pairs of a repeated -headers, so that a value may contain commas.
+type headerList []string
+
+func (h *headerList) String() string {
+	return strings.Join(*h, " ")
+}
+
+func (h *headerList) Set(header string) error {
+	if !strings.Contains(header, ":") {
+		return fmt.Errorf("header %q is not a Key:value pair", header)
+	}
+	*h = append(*h, header)
+	return nil
+}
+
 func init() {
 	flag.IntVar(&count, "count", 1, "Number of times to make the request")
 	flag.DurationVar(&timeout, "timeout", 15*time.Second, "Request timeout")
 	flag.StringVar(&url, "url", "", "Specify URL")
+	flag.StringVar(&method, "method", "GET", "Request method (for http://)")
+	flag.BoolVar(&followRedirects, "follow-redirects", true,
+		"Follow redirects, or log the redirect response with its Location header (for http://)")
 	flag.StringVar(&headerKey, "key", "", "Header key (use Host for authority)")
 	flag.StringVar(&headerVal, "val", "", "Header value")
+	flag.Var(&headers, "headers", "More headers, as a Key:value pair each time the flag is repeated (for http://)")
 	flag.StringVar(&caFile, "ca", "/cert.crt", "CA root cert file")
-	flag.StringVar(&msg, "msg", "HelloWorld", "message to send (for websockets)")
+	flag.IntVar(&messages, "messages", 1,
+		"Number of messages sent in sequence on each connection (for ws://), the later ones expecting their echo, "+
+			"or on each stream (for grpc:// with -stream)")
+	flag.StringVar(&stream, "stream", "",
+		"Make a streaming gRPC call of -messages messages instead of a unary one: server, client or bidi (for grpc://)")
+	flag.DurationVar(&interval, "interval", 0,
+		"Delay between two messages of a stream, to keep it open longer (for grpc:// with -stream client or bidi)")
+	flag.BoolVar(&healthCheck, "health", false,
+		"Check the health of -health-service with grpc.health.v1 instead of calling Echo (for grpc://)")
+	flag.StringVar(&healthService, "health-service", "",
+		"Service whose health -health checks, the whole server if empty")
+	flag.IntVar(&qps, "qps", 0,
+		"Send requests at this rate for -duration instead of -count of them at once, counting the failed ones "+
+			"instead of failing, and log the latency percentiles")
+	flag.DurationVar(&duration, "duration", 10*time.Second, "How long requests are sent at -qps")
+	flag.DurationVar(&warmup, "warmup", 0,
+		"How long requests are sent at -qps before -duration, left out of the percentiles and of -json")
+	flag.IntVar(&payload, "payload", 1024, "Number of random bytes sent on each connection (for tcp://)")
+	flag.DurationVar(&idle, "idle", 0,
+		"How long the connection stays idle halfway through the payload (for tcp://)")
+	flag.BoolVar(&jsonOutput, "json", false,
+		"Log the result of each request as a line of JSON once all are done, reporting the failed ones "+
+			"instead of failing")
+	flag.StringVar(&msg, "msg", "HelloWorld",
+		"message to send (for websockets, or Go-escaped bytes written verbatim for raw://)")
 }
 
 func makeHTTPRequest(client *http.Client) func(int) func() error {
 	return func(i int) func() error {
 		return func() error {
-			req, err := http.NewRequest("GET", url, nil)
+			req, err := http.NewRequest(method, url, nil)
 			if err != nil {
 				return err
 			}
 
 			log.Printf("[%d] Url=%s\n", i, url)
+			if method != "GET" {
+				log.Printf("[%d] Method=%s\n", i, method)
+			}
 			if headerKey == hostKey {
 				req.Host = headerVal
 				log.Printf("[%d] Host=%s\n", i, headerVal)
@@ -78,13 +154,26 @@ func makeHTTPRequest(client *http.Client) func(int) func() error {
 				req.Header.Add(headerKey, headerVal)
 				log.Printf("[%d] Header=%s:%s\n", i, headerKey, headerVal)
 			}
+			for _, header := range headers {
+				kv := strings.SplitN(header, ":", 2)
+				req.Header.Add(kv[0], kv[1])
+				log.Printf("[%d] Header=%s:%s\n", i, kv[0], kv[1])
+			}
 
+			start := time.Now()
 			resp, err := client.Do(req)
 			if err != nil {
 				return err
 			}
 
-			log.Printf("[%d] StatusCode=%d\n", i, resp.StatusCode)
+			logStatusCode(i, resp.StatusCode)
+			logLatency(i, time.Since(start))
+			for key, values := range resp.Header {
+				for _, value := range values {
+					log.Printf("[%d] ResponseHeader=%s:%s\n", i, key, value)
+					record(i, func(r *result) { r.ResponseHeaders[key] = append(r.ResponseHeaders[key], value) })
+				}
+			}
 
 			data, err := ioutil.ReadAll(resp.Body)
 			defer func() {
@@ -97,12 +186,12 @@ func makeHTTPRequest(client *http.Client) func(int) func() error {
 				return err
 			}
 
-			for _, line := range strings.Split(string(data), "\n") {
-				if line != "" {
-					log.Printf("[%d body] %s\n", i, line)
-				}
-			}
+			logBody(i, string(data))
 
+			// under load the failed requests are counted from the errors
+			if qps > 0 && resp.StatusCode >= 500 {
+				return fmt.Errorf("status code %d", resp.StatusCode)
+			}
 			return nil
 		}
 	}
@@ -144,10 +233,22 @@ func makeWebSocketRequest(client *websocket.Dialer) func(int) func() error {
 				return err
 			}
 
-			for _, line := range strings.Split(string(resp), "\n") {
-				if line != "" {
-					log.Printf("[%d body] %s\n", i, line)
+			logBody(i, string(resp))
+
+			// the server echoes the later messages verbatim
+			for m := 1; m < messages; m++ {
+				sent := fmt.Sprintf("%s-%d", msg, m)
+				if err = conn.WriteMessage(websocket.TextMessage, []byte(sent)); err != nil {
+					return err
+				}
+				_, echo, readErr := conn.ReadMessage()
+				if readErr != nil {
+					return readErr
+				}
+				if string(echo) != sent {
+					return fmt.Errorf("websocket message %q echoed as %q", sent, echo)
 				}
+				log.Printf("[%d] Echo=%s\n", i, echo)
 			}
 
 			return nil
@@ -160,19 +261,383 @@ func makeGRPCRequest(client pb.EchoTestServiceClient) func(int) func() error {
 		return func() error {
 			req := &pb.EchoRequest{Message: fmt.Sprintf("request #%d", i)}
 			log.Printf("[%d] grpcecho.Echo(%v)\n", i, req)
+			start := time.Now()
 			resp, err := client.Echo(context.Background(), req)
 			if err != nil {
 				return err
 			}
+			logLatency(i, time.Since(start))
 
 			// when the underlying HTTP2 request returns status 404, GRPC
 			// request does not return an error in grpc-go.
 			// instead it just returns an empty response
-			for _, line := range strings.Split(resp.GetMessage(), "\n") {
-				if line != "" {
-					log.Printf("[%d body] %s\n", i, line)
+			logBody(i, resp.GetMessage())
+			return nil
+		}
+	}
+}
+
+// makeGRPCHealthCheck checks the health of -health-service, and logs its status.
+func makeGRPCHealthCheck(client healthpb.HealthClient) func(int) func() error {
+	return func(i int) func() error {
+		return func() error {
+			req := &healthpb.HealthCheckRequest{Service: healthService}
+			log.Printf("[%d] grpc.health.v1.Check(%v)\n", i, req)
+			start := time.Now()
+			resp, err := client.Check(context.Background(), req)
+			if err != nil {
+				return err
+			}
+			logLatency(i, time.Since(start))
+			log.Printf("[%d] HealthStatus=%v\n", i, resp.GetStatus())
+			return nil
+		}
+	}
+}
+
+// makeGRPCServerStream asks for a stream of -messages responses, and checks that the server ends
+// the stream after the last one.
+func makeGRPCServerStream(client pb.EchoTestServiceClient) func(int) func() error {
+	return func(i int) func() error {
+		return func() error {
+			req := &pb.EchoRequest{Message: fmt.Sprintf("request #%d", i), Count: int32(messages)}
+			log.Printf("[%d] grpcecho.ServerStream(%v)\n", i, req)
+			start := time.Now()
+			s, err := client.ServerStream(context.Background(), req)
+			if err != nil {
+				return err
+			}
+			received := 0
+			for {
+				resp, recvErr := s.Recv()
+				if recvErr == io.EOF {
+					break
+				}
+				if recvErr != nil {
+					return recvErr
+				}
+				received++
+				logGRPCBody(i, resp)
+			}
+			if received != messages {
+				return fmt.Errorf("server stream ended after %d responses, want %d", received, messages)
+			}
+			logLatency(i, time.Since(start))
+			log.Printf("[%d] StreamMessages=%d\n", i, received)
+			return nil
+		}
+	}
+}
+
+// makeGRPCClientStream streams -messages requests, and checks that the server counted them all.
+func makeGRPCClientStream(client pb.EchoTestServiceClient) func(int) func() error {
+	return func(i int) func() error {
+		return func() error {
+			log.Printf("[%d] grpcecho.ClientStream(%d requests)\n", i, messages)
+			start := time.Now()
+			s, err := client.ClientStream(context.Background())
+			if err != nil {
+				return err
+			}
+			for m := 1; m <= messages; m++ {
+				if m > 1 {
+					time.Sleep(interval)
+				}
+				if err = s.Send(&pb.EchoRequest{Message: fmt.Sprintf("request #%d.%d", i, m)}); err != nil {
+					return err
+				}
+			}
+			resp, err := s.CloseAndRecv()
+			if err != nil {
+				return err
+			}
+			if int(resp.GetCount()) != messages {
+				return fmt.Errorf("server received %d streamed requests, want %d", resp.GetCount(), messages)
+			}
+			logGRPCBody(i, resp)
+			logLatency(i, time.Since(start))
+			log.Printf("[%d] StreamMessages=%d\n", i, resp.GetCount())
+			return nil
+		}
+	}
+}
+
+// makeGRPCBidiStream sends -messages requests one at a time, each after the echo of the previous
+// one, then checks that the server ends the stream once the client is done.
+func makeGRPCBidiStream(client pb.EchoTestServiceClient) func(int) func() error {
+	return func(i int) func() error {
+		return func() error {
+			log.Printf("[%d] grpcecho.BidiStream(%d requests)\n", i, messages)
+			start := time.Now()
+			s, err := client.BidiStream(context.Background())
+			if err != nil {
+				return err
+			}
+			for m := 1; m <= messages; m++ {
+				if m > 1 {
+					time.Sleep(interval)
+				}
+				message := fmt.Sprintf("request #%d.%d", i, m)
+				if err = s.Send(&pb.EchoRequest{Message: message}); err != nil {
+					return err
+				}
+				resp, recvErr := s.Recv()
+				if recvErr != nil {
+					return recvErr
+				}
+				if !strings.HasSuffix(resp.GetMessage(), "Echo="+message) {
+					return fmt.Errorf("bidi stream answered %q with %q", message, resp.GetMessage())
+				}
+				logGRPCBody(i, resp)
+			}
+			if err = s.CloseSend(); err != nil {
+				return err
+			}
+			if _, err = s.Recv(); err != io.EOF {
+				return fmt.Errorf("bidi stream did not end after the client was done: %v", err)
+			}
+			logLatency(i, time.Since(start))
+			log.Printf("[%d] StreamMessages=%d\n", i, messages)
+			return nil
+		}
+	}
+}
+
+func logGRPCBody(i int, resp *pb.EchoResponse) {
+	logBody(i, resp.GetMessage())
+}
+
+// result is the outcome of one request, logged as a line of JSON with -json.
+type result struct {
+	Index int    `json:"index"`
+	URL   string `json:"url"`
+	// status code of the HTTP response, 0 for the other protocols
+	Code int `json:"code,omitempty"`
+	// version, port and pod of the app that served the request, from the echo of the server
+	Version  string `json:"version,omitempty"`
+	Port     string `json:"port,omitempty"`
+	Hostname string `json:"hostname,omitempty"`
+	// x-request-id the server saw
+	ID string `json:"id,omitempty"`
+	// headers or gRPC metadata the server saw, by canonical name
+	Headers         map[string][]string `json:"headers,omitempty"`
+	ResponseHeaders map[string][]string `json:"response_headers,omitempty"`
+	LatencyMs       float64             `json:"latency_ms,omitempty"`
+	Error           string              `json:"error,omitempty"`
+
+	// forwarded is true once the echo of the server the request was forwarded to starts
+	forwarded bool
+}
+
+// echoFields are the lines of the echo of the server that are not request headers.
+var echoFields = map[string]bool{
+	"ServiceVersion": true,
+	"ServicePort":    true,
+	"Method":         true,
+	"URL":            true,
+	"Proto":          true,
+	"RemoteAddr":     true,
+	"Host":           true,
+	"Hostname":       true,
+	"Echo":           true,
+	"Stream":         true,
+	"ForwardedCode":  true,
+	"Healthy":        true,
+	"TCPReceived":    true,
+}
+
+var (
+	resultsMutex sync.Mutex
+	results      = make(map[int]*result)
+)
+
+// record updates the result of the request with -json.
+func record(i int, update func(*result)) {
+	if !jsonOutput {
+		return
+	}
+	resultsMutex.Lock()
+	defer resultsMutex.Unlock()
+	r, ok := results[i]
+	if !ok {
+		r = &result{
+			Index:           i,
+			URL:             url,
+			Headers:         make(map[string][]string),
+			ResponseHeaders: make(map[string][]string),
+		}
+		results[i] = r
+	}
+	update(r)
+}
+
+// logBody logs the lines of the body of the response to the request, and records the echo of the
+// server in its result, up to the echo of the server the request was forwarded to, if any.
+func logBody(i int, body string) {
+	for _, line := range strings.Split(body, "\n") {
+		if line == "" {
+			continue
+		}
+		log.Printf("[%d body] %s\n", i, line)
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key, value := kv[0], kv[1]
+		record(i, func(r *result) {
+			if r.forwarded {
+				return
+			}
+			switch key {
+			case "ServiceVersion":
+				r.Version = value
+			case "ServicePort":
+				r.Port = value
+			case "Hostname":
+				r.Hostname = value
+			case "ForwardedCode":
+				r.forwarded = true
+			}
+			if echoFields[key] {
+				return
+			}
+			name := http.CanonicalHeaderKey(key)
+			r.Headers[name] = append(r.Headers[name], value)
+			if name == "X-Request-Id" {
+				r.ID = value
+			}
+		})
+	}
+}
+
+// logStatusCode logs the status code of the HTTP response to the request.
+func logStatusCode(i, code int) {
+	log.Printf("[%d] StatusCode=%d\n", i, code)
+	record(i, func(r *result) { r.Code = code })
+}
+
+// logLatency logs the time the request took.
+func logLatency(i int, latency time.Duration) {
+	log.Printf("[%d] Latency=%v\n", i, latency)
+	record(i, func(r *result) { r.LatencyMs = float64(latency) / float64(time.Millisecond) })
+}
+
+// logResults logs the result of each request as a line of JSON, in the order they were started.
+func logResults() {
+	resultsMutex.Lock()
+	defer resultsMutex.Unlock()
+	indexes := make([]int, 0, len(results))
+	for i := range results {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		data, err := json.Marshal(results[i])
+		if err != nil {
+			log.Fatalf("cannot marshal the result of request %d: %v", i, err)
+		}
+		log.Printf("[%d json] %s\n", i, data)
+	}
+}
+
+// makeRawRequest writes msg as is to a TCP connection and parses whatever HTTP response comes back,
+// so that the server side can be fed requests that no HTTP client library would produce.
+func makeRawRequest() func(int) func() error {
+	return func(i int) func() error {
+		return func() error {
+			address := url[len("raw://"):]
+			payload, err := strconv.Unquote(`"` + msg + `"`)
+			if err != nil {
+				return fmt.Errorf("invalid raw message %q: %v", msg, err)
+			}
+
+			log.Printf("[%d] Url=%s\n", i, url)
+			log.Printf("[%d] Body=%q\n", i, payload)
+			conn, err := net.DialTimeout("tcp", address, timeout)
+			if err != nil {
+				return err
+			}
+			// nolint: errcheck
+			defer conn.Close()
+			if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+				return err
+			}
+
+			if _, err = conn.Write([]byte(payload)); err != nil {
+				return err
+			}
+			// the method decides whether the response has a body, e.g. none for a successful CONNECT
+			req := &http.Request{Method: strings.SplitN(payload, " ", 2)[0]}
+			resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+			if err != nil {
+				return err
+			}
+			// nolint: errcheck
+			defer resp.Body.Close()
+			logStatusCode(i, resp.StatusCode)
+
+			data, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				return err
+			}
+			logBody(i, string(data))
+			return nil
+		}
+	}
+}
+
+// makeTCPRequest sends -payload random bytes on a connection, idle for -idle halfway through, then
+// closes its side and reads the echo of the payload followed by the answer of the server.
+func makeTCPRequest() func(int) func() error {
+	return func(i int) func() error {
+		return func() error {
+			address := url[len("tcp://"):]
+			log.Printf("[%d] Url=%s\n", i, url)
+			sent := make([]byte, payload)
+			if _, err := rand.Read(sent); err != nil {
+				return err
+			}
+			conn, err := net.DialTimeout("tcp", address, timeout)
+			if err != nil {
+				return err
+			}
+			// nolint: errcheck
+			defer conn.Close()
+			if err = conn.SetDeadline(time.Now().Add(timeout + idle)); err != nil {
+				return err
+			}
+
+			written := make(chan error, 1)
+			go func() {
+				half := len(sent) / 2
+				if _, writeErr := conn.Write(sent[:half]); writeErr != nil {
+					written <- writeErr
+					return
+				}
+				time.Sleep(idle)
+				if _, writeErr := conn.Write(sent[half:]); writeErr != nil {
+					written <- writeErr
+					return
 				}
+				written <- conn.(*net.TCPConn).CloseWrite()
+			}()
+			received, err := ioutil.ReadAll(conn)
+			if writeErr := <-written; writeErr != nil {
+				return writeErr
+			}
+			if err != nil {
+				return err
+			}
+			log.Printf("[%d] TCPSent=%d\n", i, len(sent))
+
+			if len(received) < len(sent) {
+				return fmt.Errorf("echo of %d bytes for a payload of %d", len(received), len(sent))
 			}
+			if !bytes.Equal(received[:len(sent)], sent) {
+				return fmt.Errorf("echo differs from the payload of %d bytes", len(sent))
+			}
+			log.Printf("[%d] TCPEchoed=%d\n", i, len(sent))
+			logBody(i, string(received[len(sent):]))
 			return nil
 		}
 	}
@@ -191,6 +656,11 @@ func main() {
 			},
 			Timeout: timeout,
 		}
+		if !followRedirects {
+			client.CheckRedirect = func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			}
+		}
 		f = makeHTTPRequest(client)
 	} else if strings.HasPrefix(url, "grpc://") || strings.HasPrefix(url, "grpcs://") {
 		secure := strings.HasPrefix(url, "grpcs://")
@@ -231,7 +701,24 @@ func main() {
 			}
 		}()
 		client := pb.NewEchoTestServiceClient(conn)
-		f = makeGRPCRequest(client)
+		switch {
+		case healthCheck:
+			f = makeGRPCHealthCheck(healthpb.NewHealthClient(conn))
+		case stream == "":
+			f = makeGRPCRequest(client)
+		case stream == "server":
+			f = makeGRPCServerStream(client)
+		case stream == "client":
+			f = makeGRPCClientStream(client)
+		case stream == "bidi":
+			f = makeGRPCBidiStream(client)
+		default:
+			log.Fatalf("Unrecognized stream %q", stream)
+		}
+	} else if strings.HasPrefix(url, "tcp://") {
+		f = makeTCPRequest()
+	} else if strings.HasPrefix(url, "raw://") {
+		f = makeRawRequest()
 	} else if strings.HasPrefix(url, "ws://") || strings.HasPrefix(url, "wss://") {
 		/* #nosec */
 		client := &websocket.Dialer{
@@ -245,6 +732,16 @@ func main() {
 		log.Fatalf("Unrecognized protocol %q", url)
 	}
 
+	if qps > 0 {
+		load(f)
+		return
+	}
+
+	if jsonOutput {
+		run(f)
+		return
+	}
+
 	g, _ := errgroup.WithContext(context.Background())
 	for i := 0; i < count; i++ {
 		g.Go(f(i))
@@ -256,3 +753,104 @@ func main() {
 
 	log.Println("All requests succeeded")
 }
+
+// run makes the -count requests at once and logs their results, the errors of the failed ones
+// included, once all are done.
+func run(f func(int) func() error) {
+	var wg sync.WaitGroup
+	failed := 0
+	for i := 0; i < count; i++ {
+		// the result of a request that fails before logging anything still shows up
+		record(i, func(*result) {})
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := f(i)(); err != nil {
+				log.Printf("[%d error] %s\n", i, err)
+				record(i, func(r *result) {
+					r.Error = err.Error()
+					failed++
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+	logResults()
+	if failed > 0 {
+		log.Printf("%d of %d requests failed\n", failed, count)
+		return
+	}
+	log.Println("All requests succeeded")
+}
+
+// load starts a request every 1/qps for warmup then duration, without waiting for the previous
+// ones, and logs the number of requests, of failed ones, the rate reached and the latency
+// percentiles, along with the result of each request with -json, the warm-up ones left out. The logs
+// of the requests themselves are discarded.
+func load(f func(int) func() error) {
+	var (
+		mutex     sync.Mutex
+		latencies []time.Duration
+		failures  int
+		warm      []int
+		wg        sync.WaitGroup
+	)
+	log.SetOutput(ioutil.Discard)
+	ticker := time.NewTicker(time.Second / time.Duration(qps))
+	start := time.Now()
+	measured := start.Add(warmup)
+	for i := 0; time.Since(start) < warmup+duration; i++ {
+		counted := !time.Now().Before(measured)
+		if !counted {
+			warm = append(warm, i)
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			requestStart := time.Now()
+			err := f(i)()
+			latency := time.Since(requestStart)
+			if !counted {
+				return
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			latencies = append(latencies, latency)
+			if err != nil {
+				failures++
+				record(i, func(r *result) { r.Error = err.Error() })
+			} else {
+				record(i, func(*result) {})
+			}
+		}(i)
+		<-ticker.C
+	}
+	// the rate is that of the requests started, not slowed down by the slowest of them to return
+	elapsed := time.Since(measured)
+	ticker.Stop()
+	wg.Wait()
+	log.SetOutput(os.Stderr)
+
+	resultsMutex.Lock()
+	for _, i := range warm {
+		delete(results, i)
+	}
+	resultsMutex.Unlock()
+	if jsonOutput {
+		logResults()
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	percentile := func(p int) time.Duration {
+		if len(latencies) == 0 {
+			return 0
+		}
+		return latencies[(len(latencies)-1)*p/100]
+	}
+	log.Printf("LoadRequests=%d\n", len(latencies))
+	log.Printf("LoadErrors=%d\n", failures)
+	log.Printf("LoadQPS=%.1f\n", float64(len(latencies))/elapsed.Seconds())
+	log.Printf("LoadP50=%v\n", percentile(50))
+	log.Printf("LoadP90=%v\n", percentile(90))
+	log.Printf("LoadP99=%v\n", percentile(99))
+}
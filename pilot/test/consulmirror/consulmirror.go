@@ -0,0 +1,292 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Mirrors Kubernetes endpoint instances into the catalog of a Consul server.
+//
+// The Consul mirror process watches endpoints in Kubernetes and registers
+// each endpoint instance, one per address and port, as a node of the Consul
+// catalog running a single service. The Consul adapter of Pilot reads the
+// protocol of a port from the node metadata, so every port of an address
+// needs a node of its own. The pod labels of the instances are converted to
+// service tags of the form key|value, and the pod IP is the service address,
+// which Pilot matches against the sidecars.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+
+	"istio.io/istio/pilot/pkg/serviceregistry/kube"
+)
+
+var (
+	kubeconfig string
+	consulURL  string
+	namespace  string
+)
+
+func init() {
+	flag.StringVar(&kubeconfig, "kubeconfig", "",
+		"Use a Kubernetes configuration file instead of in-cluster configuration")
+	flag.StringVar(&consulURL, "url", "",
+		"Consul server url")
+	flag.StringVar(&namespace, "namespace", "",
+		"Select a namespace for the controller loop. If not set, uses ${POD_NAMESPACE} environment variable")
+}
+
+const (
+	// resyncInterval re-registers every instance, picking up the labels of the pods seen after
+	// their endpoints and restoring the catalog of a restarted Consul dev server
+	resyncInterval = 30 * time.Second
+	// protocolMeta is the node metadata the Consul adapter reads the port protocol from
+	protocolMeta = "protocol"
+	datacenter   = "dc1"
+)
+
+type eventKind int
+
+const (
+	addEvent eventKind = iota
+	deleteEvent
+)
+
+type event struct {
+	kind eventKind
+	obj  interface{}
+}
+
+// mirrors k8s instances to the Consul catalog
+type mirror struct {
+	catalog *api.Catalog
+	// mapping of endpoint name to the nodes registered for it
+	nodes map[string]map[string]bool
+	// mapping of ip to pod name
+	podCache map[string]string
+	podStore cache.Store
+}
+
+func maintainMirror(catalog *api.Catalog, podStore cache.Store, events <-chan event) {
+	m := &mirror{
+		catalog:  catalog,
+		nodes:    make(map[string]map[string]bool),
+		podStore: podStore,
+		podCache: make(map[string]string),
+	}
+	m.sync(events)
+}
+
+func (m *mirror) sync(events <-chan event) {
+	for ev := range events {
+		switch obj := ev.obj.(type) {
+		case *v1.Endpoints:
+			switch ev.kind {
+			case addEvent:
+				registrations := m.convertEndpoints(obj)
+
+				newNodes := make(map[string]bool)
+				for _, reg := range registrations {
+					newNodes[reg.Node] = true
+				}
+
+				// remove instances that are gone
+				for node := range m.nodes[obj.Name] {
+					if !newNodes[node] {
+						m.deregister(node)
+					}
+				}
+
+				// registering again only updates the instances that exist
+				for _, reg := range registrations {
+					m.register(reg)
+				}
+				m.nodes[obj.Name] = newNodes
+			case deleteEvent:
+				for node := range m.nodes[obj.Name] {
+					m.deregister(node)
+				}
+				delete(m.nodes, obj.Name)
+			}
+		case *v1.Pod:
+			switch ev.kind {
+			case addEvent:
+				if obj.Status.PodIP != "" {
+					m.podCache[obj.Status.PodIP] = podKey(obj)
+				}
+			case deleteEvent:
+				if m.podCache[obj.Status.PodIP] == podKey(obj) {
+					delete(m.podCache, obj.Status.PodIP)
+				}
+			}
+		}
+	}
+
+	// cleanup the catalog
+	for name := range m.nodes {
+		for node := range m.nodes[name] {
+			m.deregister(node)
+		}
+	}
+}
+
+func (m *mirror) convertEndpoints(ep *v1.Endpoints) []*api.CatalogRegistration {
+	registrations := make([]*api.CatalogRegistration, 0)
+	for _, ss := range ep.Subsets {
+		for _, addr := range ss.Addresses {
+			// add labels
+			tags := make([]string, 0)
+			if pod, exists := m.getPodByIP(addr.IP); exists {
+				for k, v := range pod.Labels {
+					tags = append(tags, fmt.Sprintf("%s|%s", k, v))
+				}
+			}
+
+			for _, ssPort := range ss.Ports {
+				node := fmt.Sprintf("%s-%s-%d", ep.Name, addr.IP, ssPort.Port)
+				registrations = append(registrations, &api.CatalogRegistration{
+					Node:       node,
+					Address:    addr.IP,
+					Datacenter: datacenter,
+					NodeMeta: map[string]string{
+						protocolMeta: strings.ToLower(string(kube.ConvertProtocol(ssPort.Name, ssPort.Protocol))),
+					},
+					Service: &api.AgentService{
+						ID:      node,
+						Service: ep.Name,
+						Tags:    tags,
+						Address: addr.IP,
+						Port:    int(ssPort.Port),
+					},
+				})
+			}
+		}
+	}
+	return registrations
+}
+
+func (m *mirror) register(reg *api.CatalogRegistration) {
+	if _, err := m.catalog.Register(reg, nil); err != nil {
+		log.Println("failed to register", reg.Node, err)
+	}
+}
+
+func (m *mirror) deregister(node string) {
+	log.Println("Deregistering", node)
+	if _, err := m.catalog.Deregister(&api.CatalogDeregistration{Node: node, Datacenter: datacenter}, nil); err != nil {
+		log.Println("failed to deregister", node, err)
+	}
+}
+
+func (m *mirror) getPodByIP(addr string) (*v1.Pod, bool) {
+	name, exists := m.podCache[addr]
+	if !exists {
+		return nil, false
+	}
+	obj, exists, err := m.podStore.GetByKey(name)
+	if err != nil {
+		log.Println(err)
+	}
+	if !exists {
+		return nil, false
+	}
+	return obj.(*v1.Pod), true
+}
+
+func podKey(pod *v1.Pod) string {
+	return pod.Namespace + "/" + pod.Name
+}
+
+func main() {
+	flag.Parse()
+
+	_, client, err := kube.CreateInterface(kubeconfig)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	conf := api.DefaultConfig()
+	conf.Address = consulURL
+	consul, err := api.NewClient(conf)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	events := make(chan event)
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			events <- event{addEvent, obj}
+		},
+		UpdateFunc: func(old, cur interface{}) {
+			events <- event{addEvent, cur}
+		},
+		DeleteFunc: func(obj interface{}) {
+			events <- event{deleteEvent, obj}
+		},
+	}
+
+	endpointInformer := cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(opts meta_v1.ListOptions) (runtime.Object, error) {
+				return client.CoreV1().Endpoints(namespace).List(opts)
+			},
+			WatchFunc: func(opts meta_v1.ListOptions) (watch.Interface, error) {
+				return client.CoreV1().Endpoints(namespace).Watch(opts)
+			},
+		},
+		&v1.Endpoints{}, resyncInterval, cache.Indexers{},
+	)
+	endpointInformer.AddEventHandler(handler)
+
+	podInformer := cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(opts meta_v1.ListOptions) (runtime.Object, error) {
+				return client.CoreV1().Pods(namespace).List(opts)
+			},
+			WatchFunc: func(opts meta_v1.ListOptions) (watch.Interface, error) {
+				return client.CoreV1().Pods(namespace).Watch(opts)
+			},
+		},
+		&v1.Pod{}, resyncInterval, cache.Indexers{},
+	)
+	podInformer.AddEventHandler(handler)
+
+	stop := make(chan struct{})
+
+	go endpointInformer.Run(stop)
+	go podInformer.Run(stop)
+	go maintainMirror(consul.Catalog(), podInformer.GetStore(), events)
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-c
+	log.Printf("captured sig %v, exiting\n", sig)
+	close(stop)
+	close(events)
+	os.Exit(1)
+}
@@ -36,6 +36,8 @@ const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
 
 type EchoRequest struct {
 	Message string `protobuf:"bytes,1,opt,name=message" json:"message,omitempty"`
+	// number of responses to stream back, for ServerStream
+	Count int32 `protobuf:"varint,2,opt,name=count" json:"count,omitempty"`
 }
 
 func (m *EchoRequest) Reset()                    { *m = EchoRequest{} }
@@ -50,8 +52,17 @@ func (m *EchoRequest) GetMessage() string {
 	return ""
 }
 
+func (m *EchoRequest) GetCount() int32 {
+	if m != nil {
+		return m.Count
+	}
+	return 0
+}
+
 type EchoResponse struct {
 	Message string `protobuf:"bytes,1,opt,name=message" json:"message,omitempty"`
+	// number of requests received, for ClientStream
+	Count int32 `protobuf:"varint,2,opt,name=count" json:"count,omitempty"`
 }
 
 func (m *EchoResponse) Reset()                    { *m = EchoResponse{} }
@@ -66,6 +77,13 @@ func (m *EchoResponse) GetMessage() string {
 	return ""
 }
 
+func (m *EchoResponse) GetCount() int32 {
+	if m != nil {
+		return m.Count
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*EchoRequest)(nil), "grpecho.EchoRequest")
 	proto.RegisterType((*EchoResponse)(nil), "grpecho.EchoResponse")
@@ -83,6 +101,13 @@ const _ = grpc.SupportPackageIsVersion4
 
 type EchoTestServiceClient interface {
 	Echo(ctx context.Context, in *EchoRequest, opts ...grpc.CallOption) (*EchoResponse, error)
+	// ServerStream answers with count responses echoing the message, then ends the stream.
+	ServerStream(ctx context.Context, in *EchoRequest, opts ...grpc.CallOption) (EchoTestService_ServerStreamClient, error)
+	// ClientStream answers once the client is done, with the number of requests received
+	// and the message of the last one.
+	ClientStream(ctx context.Context, opts ...grpc.CallOption) (EchoTestService_ClientStreamClient, error)
+	// BidiStream echoes every request as it comes, until the client is done.
+	BidiStream(ctx context.Context, opts ...grpc.CallOption) (EchoTestService_BidiStreamClient, error)
 }
 
 type echoTestServiceClient struct {
@@ -102,10 +127,114 @@ func (c *echoTestServiceClient) Echo(ctx context.Context, in *EchoRequest, opts
 	return out, nil
 }
 
+func (c *echoTestServiceClient) ServerStream(ctx context.Context, in *EchoRequest, opts ...grpc.CallOption) (EchoTestService_ServerStreamClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_EchoTestService_serviceDesc.Streams[0], c.cc, "/grpecho.EchoTestService/ServerStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &echoTestServiceServerStreamClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type EchoTestService_ServerStreamClient interface {
+	Recv() (*EchoResponse, error)
+	grpc.ClientStream
+}
+
+type echoTestServiceServerStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *echoTestServiceServerStreamClient) Recv() (*EchoResponse, error) {
+	m := new(EchoResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *echoTestServiceClient) ClientStream(ctx context.Context, opts ...grpc.CallOption) (EchoTestService_ClientStreamClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_EchoTestService_serviceDesc.Streams[1], c.cc, "/grpecho.EchoTestService/ClientStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &echoTestServiceClientStreamClient{stream}
+	return x, nil
+}
+
+type EchoTestService_ClientStreamClient interface {
+	Send(*EchoRequest) error
+	CloseAndRecv() (*EchoResponse, error)
+	grpc.ClientStream
+}
+
+type echoTestServiceClientStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *echoTestServiceClientStreamClient) Send(m *EchoRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *echoTestServiceClientStreamClient) CloseAndRecv() (*EchoResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(EchoResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *echoTestServiceClient) BidiStream(ctx context.Context, opts ...grpc.CallOption) (EchoTestService_BidiStreamClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_EchoTestService_serviceDesc.Streams[2], c.cc, "/grpecho.EchoTestService/BidiStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &echoTestServiceBidiStreamClient{stream}
+	return x, nil
+}
+
+type EchoTestService_BidiStreamClient interface {
+	Send(*EchoRequest) error
+	Recv() (*EchoResponse, error)
+	grpc.ClientStream
+}
+
+type echoTestServiceBidiStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *echoTestServiceBidiStreamClient) Send(m *EchoRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *echoTestServiceBidiStreamClient) Recv() (*EchoResponse, error) {
+	m := new(EchoResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // Server API for EchoTestService service
 
 type EchoTestServiceServer interface {
 	Echo(context.Context, *EchoRequest) (*EchoResponse, error)
+	// ServerStream answers with count responses echoing the message, then ends the stream.
+	ServerStream(*EchoRequest, EchoTestService_ServerStreamServer) error
+	// ClientStream answers once the client is done, with the number of requests received
+	// and the message of the last one.
+	ClientStream(EchoTestService_ClientStreamServer) error
+	// BidiStream echoes every request as it comes, until the client is done.
+	BidiStream(EchoTestService_BidiStreamServer) error
 }
 
 func RegisterEchoTestServiceServer(s *grpc.Server, srv EchoTestServiceServer) {
@@ -130,6 +259,79 @@ func _EchoTestService_Echo_Handler(srv interface{}, ctx context.Context, dec fun
 	return interceptor(ctx, in, info, handler)
 }
 
+func _EchoTestService_ServerStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(EchoRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(EchoTestServiceServer).ServerStream(m, &echoTestServiceServerStreamServer{stream})
+}
+
+type EchoTestService_ServerStreamServer interface {
+	Send(*EchoResponse) error
+	grpc.ServerStream
+}
+
+type echoTestServiceServerStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *echoTestServiceServerStreamServer) Send(m *EchoResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _EchoTestService_ClientStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(EchoTestServiceServer).ClientStream(&echoTestServiceClientStreamServer{stream})
+}
+
+type EchoTestService_ClientStreamServer interface {
+	SendAndClose(*EchoResponse) error
+	Recv() (*EchoRequest, error)
+	grpc.ServerStream
+}
+
+type echoTestServiceClientStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *echoTestServiceClientStreamServer) SendAndClose(m *EchoResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *echoTestServiceClientStreamServer) Recv() (*EchoRequest, error) {
+	m := new(EchoRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _EchoTestService_BidiStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(EchoTestServiceServer).BidiStream(&echoTestServiceBidiStreamServer{stream})
+}
+
+type EchoTestService_BidiStreamServer interface {
+	Send(*EchoResponse) error
+	Recv() (*EchoRequest, error)
+	grpc.ServerStream
+}
+
+type echoTestServiceBidiStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *echoTestServiceBidiStreamServer) Send(m *EchoResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *echoTestServiceBidiStreamServer) Recv() (*EchoRequest, error) {
+	m := new(EchoRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 var _EchoTestService_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "grpecho.EchoTestService",
 	HandlerType: (*EchoTestServiceServer)(nil),
@@ -139,22 +341,42 @@ var _EchoTestService_serviceDesc = grpc.ServiceDesc{
 			Handler:    _EchoTestService_Echo_Handler,
 		},
 	},
-	Streams:  []grpc.StreamDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ServerStream",
+			Handler:       _EchoTestService_ServerStream_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ClientStream",
+			Handler:       _EchoTestService_ClientStream_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "BidiStream",
+			Handler:       _EchoTestService_BidiStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
 	Metadata: "pilot/test/grpcecho/echo.proto",
 }
 
 func init() { proto.RegisterFile("pilot/test/grpcecho/echo.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 152 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0x92, 0x2b, 0xc8, 0xcc, 0xc9,
+	// 202 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe3, 0x92, 0x2b, 0xc8, 0xcc, 0xc9,
 	0x2f, 0xd1, 0x2f, 0x49, 0x2d, 0x2e, 0xd1, 0x4f, 0x2f, 0x2a, 0x48, 0x4e, 0x4d, 0xce, 0xc8, 0xd7,
-	0x07, 0x11, 0x7a, 0x05, 0x45, 0xf9, 0x25, 0xf9, 0x42, 0xec, 0xe9, 0x45, 0x05, 0x20, 0xae, 0x92,
-	0x3a, 0x17, 0xb7, 0x6b, 0x72, 0x46, 0x7e, 0x50, 0x6a, 0x61, 0x69, 0x6a, 0x71, 0x89, 0x90, 0x04,
-	0x17, 0x7b, 0x6e, 0x6a, 0x71, 0x71, 0x62, 0x7a, 0xaa, 0x04, 0xa3, 0x02, 0xa3, 0x06, 0x67, 0x10,
-	0x8c, 0xab, 0xa4, 0xc1, 0xc5, 0x03, 0x51, 0x58, 0x5c, 0x90, 0x9f, 0x57, 0x9c, 0x8a, 0x5b, 0xa5,
-	0x91, 0x1b, 0x17, 0x3f, 0x48, 0x65, 0x48, 0x6a, 0x71, 0x49, 0x70, 0x6a, 0x51, 0x59, 0x66, 0x72,
-	0xaa, 0x90, 0x31, 0x17, 0x0b, 0x48, 0x48, 0x48, 0x44, 0x0f, 0x6a, 0xaf, 0x1e, 0x92, 0xa5, 0x52,
-	0xa2, 0x68, 0xa2, 0x10, 0x1b, 0x92, 0xd8, 0xc0, 0x4e, 0x35, 0x06, 0x04, 0x00, 0x00, 0xff, 0xff,
-	0x8c, 0xce, 0x8e, 0x4b, 0xcc, 0x00, 0x00, 0x00,
+	0x07, 0x11, 0x7a, 0x05, 0x45, 0xf9, 0x25, 0xf9, 0x42, 0xec, 0x40, 0x41, 0x10, 0x57, 0xc9, 0x96,
+	0x8b, 0xdb, 0x15, 0x48, 0x07, 0xa5, 0x16, 0x96, 0x02, 0xd5, 0x0a, 0x49, 0x70, 0xb1, 0xe7, 0xa6,
+	0x16, 0x17, 0x27, 0xa6, 0xa7, 0x4a, 0x30, 0x2a, 0x30, 0x6a, 0x70, 0x06, 0xc1, 0xb8, 0x42, 0x22,
+	0x5c, 0xac, 0xc9, 0xf9, 0xa5, 0x79, 0x25, 0x12, 0x4c, 0x40, 0x71, 0xd6, 0x20, 0x08, 0x47, 0xc9,
+	0x8e, 0x8b, 0x07, 0xa2, 0xbd, 0xb8, 0x20, 0x3f, 0xaf, 0x38, 0x95, 0x54, 0xfd, 0x46, 0xcd, 0x4c,
+	0x5c, 0xfc, 0x20, 0x03, 0x42, 0x80, 0x96, 0x07, 0xa7, 0x16, 0x95, 0x65, 0x26, 0xa7, 0x0a, 0x19,
+	0x73, 0xb1, 0x80, 0x84, 0x84, 0x44, 0xf4, 0xa0, 0x8e, 0xd4, 0x43, 0x72, 0xa1, 0x94, 0x28, 0x9a,
+	0x28, 0xd4, 0x62, 0x5b, 0x2e, 0x1e, 0x90, 0xfe, 0xd4, 0xa2, 0xe0, 0x92, 0xa2, 0xd4, 0xc4, 0x5c,
+	0x92, 0x34, 0x1b, 0x30, 0x82, 0xb4, 0x3b, 0xe7, 0x64, 0xa6, 0xe6, 0x95, 0x90, 0xa1, 0x5d, 0x03,
+	0xa4, 0x9d, 0xcb, 0x29, 0x33, 0x25, 0x93, 0x2c, 0xcd, 0x06, 0x8c, 0x49, 0x6c, 0xe0, 0x48, 0x31,
+	0x06, 0x00, 0xce, 0x18, 0x33, 0x0c, 0xb6, 0x01, 0x00, 0x00,
 }
@@ -21,12 +21,43 @@
 // For example, ?codes=500:1,200:1 returns 500 50% of times and 200 50% of times
 // For example, ?codes=501:999,401:1 returns 500 99.9% of times and 401 0.1% of times.
 // For example, ?codes=500,200 returns 500 50% of times and 200 50% of times
+//
+// To test retries, the "?flaky=" query parameter makes it alternate between the given code and 200,
+// starting with the code, across all the requests that use it. For example, ?flaky=503
+//
+// To test timeouts, the "?sleep=" query parameter delays the response by the given duration, for example ?sleep=3s
+//
+// Besides unary Echo calls, the gRPC service streams: ServerStream sends back the number of responses
+// asked for, ClientStream counts the requests of the client and BidiStream echoes each of them.
+// The gRPC ports also serve grpc.health.v1, with the server, the echo service and a service named
+// after the --version SERVING, for tests to tell which version answered a health check.
+//
+// WebSocket upgrades, or requests with a "testwebsocket" header, are answered over a WebSocket: the
+// reply to the first message carries the payload and the message, and the later messages are
+// echoed verbatim until the client closes the connection.
+//
+// The "?format=json" query parameter replaces the text payload with a JSON object, whose "headers" map
+// holds every value of every request header for tests to parse, and "?body=" replies with the given
+// text verbatim, to serve fixed documents such as a JWKS.
+//
+// To test trace propagation, the "?forward=" query parameter makes it send a GET request to the given
+// URL with the tracing headers of the request, and reply with the code and body of the response
+// before its own payload, for example ?forward=http://c/b.
+//
+// To test connection draining, the "?stream=" query parameter makes it stream a line every second
+// for the given duration before the usual payload, for example ?stream=10s, and on SIGTERM it keeps
+// serving the requests in flight for the --drain duration.
+//
+// The --tcp ports echo the raw bytes of each connection until the client closes its side, then write
+// the version, the port, the hostname and the number of bytes received before closing theirs.
 
 package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"math/rand"
 	"net"
@@ -35,13 +66,17 @@ import (
 	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/gorilla/websocket"
 	flag "github.com/spf13/pflag"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/metadata"
 
 	pb "istio.io/istio/pilot/test/grpcecho"
@@ -50,11 +85,36 @@ import (
 var (
 	ports     []int
 	grpcPorts []int
+	tcpPorts  []int
 	version   string
+	drain     time.Duration
+
+	// HTTP servers shut down gracefully on SIGTERM
+	httpServers []*http.Server
+	// number of requests that used ?flaky=
+	flakyRequests int
+	mu            sync.Mutex
 
 	crt, key string
 )
 
+// traceHeaders are copied from the requests to the ones forwarded with ?forward=, so that they join the same trace
+var traceHeaders = []string{
+	"x-request-id",
+	"x-b3-traceid",
+	"x-b3-spanid",
+	"x-b3-parentspanid",
+	"x-b3-sampled",
+	"x-b3-flags",
+	"x-ot-span-context",
+}
+
+// forwardTimeout bounds the requests forwarded with ?forward=
+const forwardTimeout = 10 * time.Second
+
+// grpcEchoService is the name of the echo service, whose health the gRPC ports serve
+const grpcEchoService = "grpecho.EchoTestService"
+
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		// allow all connections by default
@@ -65,7 +125,9 @@ var upgrader = websocket.Upgrader{
 func init() {
 	flag.IntSliceVar(&ports, "port", []int{8080}, "HTTP/1.1 ports")
 	flag.IntSliceVar(&grpcPorts, "grpc", []int{7070}, "GRPC ports")
+	flag.IntSliceVar(&tcpPorts, "tcp", []int{}, "Raw TCP echo ports")
 	flag.StringVar(&version, "version", "", "Version string")
+	flag.DurationVar(&drain, "drain", 0, "How long to keep serving the requests in flight on SIGTERM")
 	flag.StringVar(&crt, "crt", "", "gRPC TLS server-side certificate")
 	flag.StringVar(&key, "key", "", "gRPC TLS server-side key")
 }
@@ -102,8 +164,40 @@ func (h handler) addResponsePayload(r *http.Request, body *bytes.Buffer) {
 	}
 }
 
+// jsonPayload is the payload of addResponsePayload as a JSON object, with the request headers by
+// canonical name.
+type jsonPayload struct {
+	ServiceVersion string              `json:"service_version"`
+	ServicePort    int                 `json:"service_port"`
+	Method         string              `json:"method"`
+	URL            string              `json:"url"`
+	Host           string              `json:"host"`
+	Hostname       string              `json:"hostname"`
+	Headers        map[string][]string `json:"headers"`
+}
+
+// addJSONPayload writes the payload as a JSON object on a single line, so that the client logs it as one body line.
+func (h handler) addJSONPayload(r *http.Request, body *bytes.Buffer) error {
+	hostname, _ := os.Hostname()
+	data, err := json.Marshal(jsonPayload{
+		ServiceVersion: version,
+		ServicePort:    h.port,
+		Method:         r.Method,
+		URL:            r.URL.String(),
+		Host:           r.Host,
+		Hostname:       hostname,
+		Headers:        r.Header,
+	})
+	if err != nil {
+		return err
+	}
+	body.Write(data)
+	body.WriteString("\n")
+	return nil
+}
+
 func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.Header.Get("testwebsocket") != "" {
+	if r.Header.Get("testwebsocket") != "" || websocket.IsWebSocketUpgrade(r) {
 		h.WebSocketEcho(w, r)
 		return
 	}
@@ -114,13 +208,58 @@ func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		body.WriteString("ParseForm() error: " + err.Error() + "\n")
 	}
 
+	// If the request has form ?body=text, reply with the text verbatim instead of the echo, to serve
+	// fixed documents such as a JWKS
+	if raw := r.FormValue("body"); raw != "" {
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write([]byte(raw)); err != nil {
+			log.Println(err.Error())
+		}
+		return
+	}
+
 	// If the request has form ?codes=code[:chance][,code[:chance]]* return those codes, rather than 200
 	// For example, ?codes=500:1,200:1 returns 500 1/2 times and 200 1/2 times
 	// For example, ?codes=500:90,200:10 returns 500 90% of times and 200 10% of times
-	if err := setResponseFromCodes(r, w); err != nil {
+	if sleep := r.FormValue("sleep"); sleep != "" {
+		if d, err := time.ParseDuration(sleep); err != nil {
+			body.WriteString("sleep error: " + err.Error() + "\n")
+		} else {
+			time.Sleep(d)
+		}
+	}
+
+	if flaky := r.FormValue("flaky"); flaky != "" {
+		if err := setFlakyResponse(flaky, w); err != nil {
+			body.WriteString("flaky error: " + err.Error() + "\n")
+		}
+	} else if err := setResponseFromCodes(r, w); err != nil {
 		body.WriteString("codes error: " + err.Error() + "\n")
 	}
 
+	if stream := r.FormValue("stream"); stream != "" {
+		if err := h.stream(w, stream); err != nil {
+			body.WriteString("stream error: " + err.Error() + "\n")
+		}
+	}
+
+	if target := r.FormValue("forward"); target != "" {
+		if err := forward(r, target, &body); err != nil {
+			body.WriteString("forward error: " + err.Error() + "\n")
+		}
+	}
+
+	if r.FormValue("format") == "json" {
+		if err := h.addJSONPayload(r, &body); err != nil {
+			body.WriteString("json error: " + err.Error() + "\n")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write(body.Bytes()); err != nil {
+			log.Println(err.Error())
+		}
+		return
+	}
+
 	h.addResponsePayload(r, &body)
 
 	w.Header().Set("Content-Type", "application/text")
@@ -129,7 +268,97 @@ func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// forward sends a GET request to the target with the tracing headers of the request, and writes the
+// code and body of the response.
+func forward(r *http.Request, target string, body *bytes.Buffer) error {
+	req, err := http.NewRequest("GET", target, nil)
+	if err != nil {
+		return err
+	}
+	for _, name := range traceHeaders {
+		if value := r.Header.Get(name); value != "" {
+			req.Header.Set(name, value)
+		}
+	}
+	client := http.Client{Timeout: forwardTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body.WriteString(fmt.Sprintf("ForwardedCode=%d\n", resp.StatusCode))
+	_, err = io.Copy(body, resp.Body)
+	return err
+}
+
+// stream writes a numbered line every second for the given duration, flushing each one.
+func (h handler) stream(w http.ResponseWriter, duration string) error {
+	d, err := time.ParseDuration(duration)
+	if err != nil {
+		return err
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return fmt.Errorf("streaming is not supported")
+	}
+	w.Header().Set("Content-Type", "application/text")
+	for i := 0; i < int(d/time.Second); i++ {
+		if _, err = fmt.Fprintf(w, "Stream=%d\n", i); err != nil {
+			return err
+		}
+		flusher.Flush()
+		time.Sleep(time.Second)
+	}
+	return nil
+}
+
 func (h handler) Echo(ctx context.Context, req *pb.EchoRequest) (*pb.EchoResponse, error) {
+	return &pb.EchoResponse{Message: h.grpcPayload(ctx, req.GetMessage())}, nil
+}
+
+func (h handler) ServerStream(req *pb.EchoRequest, stream pb.EchoTestService_ServerStreamServer) error {
+	for n := 1; n <= int(req.GetCount()); n++ {
+		message := fmt.Sprintf("%s #%d", req.GetMessage(), n)
+		if err := stream.Send(&pb.EchoResponse{Message: h.grpcPayload(stream.Context(), message)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h handler) ClientStream(stream pb.EchoTestService_ClientStreamServer) error {
+	var count int32
+	var last string
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&pb.EchoResponse{Message: h.grpcPayload(stream.Context(), last), Count: count})
+		}
+		if err != nil {
+			return err
+		}
+		count++
+		last = req.GetMessage()
+	}
+}
+
+func (h handler) BidiStream(stream pb.EchoTestService_BidiStreamServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = stream.Send(&pb.EchoResponse{Message: h.grpcPayload(stream.Context(), req.GetMessage())}); err != nil {
+			return err
+		}
+	}
+}
+
+// grpcPayload returns the metadata of the call and the service version and port, followed by the message.
+func (h handler) grpcPayload(ctx context.Context, message string) string {
 	body := bytes.Buffer{}
 	md, ok := metadata.FromIncomingContext(ctx)
 	if ok {
@@ -139,8 +368,8 @@ func (h handler) Echo(ctx context.Context, req *pb.EchoRequest) (*pb.EchoRespons
 	}
 	body.WriteString("ServiceVersion=" + version + "\n")
 	body.WriteString("ServicePort=" + strconv.Itoa(h.port) + "\n")
-	body.WriteString("Echo=" + req.GetMessage())
-	return &pb.EchoResponse{Message: body.String()}, nil
+	body.WriteString("Echo=" + message)
+	return body.String()
 }
 
 func (h handler) WebSocketEcho(w http.ResponseWriter, r *http.Request) {
@@ -172,16 +401,51 @@ func (h handler) WebSocketEcho(w http.ResponseWriter, r *http.Request) {
 		log.Println("websocket-echo write failed:", err)
 		return
 	}
+
+	// echo the later messages as they are, until the client is done
+	for {
+		if mt, message, err = c.ReadMessage(); err != nil {
+			return
+		}
+		if err = c.WriteMessage(mt, message); err != nil {
+			log.Println("websocket-echo write failed:", err)
+			return
+		}
+	}
 }
 
 func runHTTP(port int) {
 	fmt.Printf("Listening HTTP1.1 on %v\n", port)
-	h := handler{port: port}
-	if err := http.ListenAndServe(fmt.Sprintf(":%d", port), h); err != nil {
+	server := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: handler{port: port}}
+	mu.Lock()
+	httpServers = append(httpServers, server)
+	mu.Unlock()
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Println(err.Error())
 	}
 }
 
+// shutdownHTTP stops accepting HTTP requests and waits, up to the drain duration, for the ones in flight.
+func shutdownHTTP() {
+	ctx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	// the flaky and healthy handlers take mu, so it is not held while their requests drain
+	mu.Lock()
+	servers := append([]*http.Server(nil), httpServers...)
+	mu.Unlock()
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server *http.Server) {
+			defer wg.Done()
+			if err := server.Shutdown(ctx); err != nil {
+				log.Println(err.Error())
+			}
+		}(server)
+	}
+	wg.Wait()
+}
+
 func runGRPC(port int) {
 	fmt.Printf("Listening GRPC on %v\n", port)
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
@@ -202,11 +466,50 @@ func runGRPC(port int) {
 		grpcServer = grpc.NewServer()
 	}
 	pb.RegisterEchoTestServiceServer(grpcServer, &h)
+	healthServer := health.NewServer()
+	for _, service := range []string{"", grpcEchoService, version} {
+		healthServer.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
+	}
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
 	if err = grpcServer.Serve(lis); err != nil {
 		log.Println(err.Error())
 	}
 }
 
+// runTCP echoes the bytes of every connection until the client half-closes it, then answers with
+// the version, port, hostname and number of bytes received, and closes it.
+func runTCP(port int) {
+	fmt.Printf("Listening TCP on %v\n", port)
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		log.Fatalf("failed to listen: %v", err)
+	}
+	for {
+		conn, acceptErr := listener.Accept()
+		if acceptErr != nil {
+			log.Println(acceptErr.Error())
+			return
+		}
+		go echoTCP(conn, port)
+	}
+}
+
+func echoTCP(conn net.Conn, port int) {
+	// nolint: errcheck
+	defer conn.Close()
+	received, err := io.Copy(conn, conn)
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+	hostname, _ := os.Hostname()
+	trailer := fmt.Sprintf("ServiceVersion=%s\nServicePort=%d\nHostname=%s\nTCPReceived=%d\n",
+		version, port, hostname, received)
+	if _, err = conn.Write([]byte(trailer)); err != nil {
+		log.Println(err.Error())
+	}
+}
+
 func main() {
 	flag.Parse()
 	for _, port := range ports {
@@ -215,9 +518,31 @@ func main() {
 	for _, grpcPort := range grpcPorts {
 		go runGRPC(grpcPort)
 	}
+	for _, tcpPort := range tcpPorts {
+		go runTCP(tcpPort)
+	}
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 	<-sigs
+	if drain > 0 {
+		shutdownHTTP()
+	}
+}
+
+// setFlakyResponse returns the given code to every other request that uses ?flaky=, starting with the first.
+func setFlakyResponse(flaky string, response http.ResponseWriter) error {
+	code, err := strconv.Atoi(flaky)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	fail := flakyRequests%2 == 0
+	flakyRequests++
+	mu.Unlock()
+	if fail {
+		response.WriteHeader(code)
+	}
+	return nil
 }
 
 func setResponseFromCodes(request *http.Request, response http.ResponseWriter) error {
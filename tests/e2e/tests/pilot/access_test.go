@@ -116,5 +116,5 @@ func (a *accessLogs) check(e *tutil.Environment) error {
 			}
 		})(app)
 	}
-	return tutil.Parallel(funcs)
+	return e.Eventually(tutil.DefaultBudget, funcs)
 }
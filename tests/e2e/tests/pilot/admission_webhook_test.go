@@ -0,0 +1,193 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	admissionclientv1beta1 "k8s.io/client-go/kubernetes/typed/admissionregistration/v1beta1"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	// default -admission-webhook-name of Pilot
+	admissionWebhookName   = "pilot-webhook.istio.io"
+	admissionValidConfig   = "v1alpha2/webhook-valid-virtual-service.yaml.tmpl"
+	admissionDeniedMessage = "denied the request"
+	// service of no pod the webhook is pointed at to take it down
+	admissionDownService = "admission-webhook-down"
+)
+
+// admissionWebhook submits invalid configs to the validating webhook of Pilot, applying them with
+// kubectl so that they skip the validation of the config client, and checks that the API server
+// rejects them with the reason. It then points the webhook at a service without pods, and checks
+// that a valid config is rejected with the Fail failure policy and accepted with Ignore.
+type admissionWebhook struct {
+	*tutil.Environment
+	// webhook configuration registered by Pilot, restored after the failure policy checks
+	original *admissionregistrationv1beta1.ValidatingWebhookConfiguration
+}
+
+func (t *admissionWebhook) String() string {
+	return "admission-webhook"
+}
+
+func (t *admissionWebhook) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelRouting}
+}
+
+func (t *admissionWebhook) Setup() error {
+	return nil
+}
+
+func (t *admissionWebhook) Run() error {
+	if !t.Config.UseAdmissionWebhook {
+		return tutil.Skip("the admission webhook is not deployed")
+	}
+	if !t.Config.V1alpha2 {
+		return tutil.Skip("v1alpha2 routing rules are disabled")
+	}
+	err := tutil.Repeat(func() error {
+		webhook, getErr := t.webhooks().Get(admissionWebhookName, metav1.GetOptions{})
+		if getErr != nil {
+			return getErr
+		}
+		t.original = webhook
+		return nil
+	}, 30, time.Second)
+	if err != nil {
+		return err
+	}
+
+	cases := []struct {
+		config  string
+		message string
+	}{
+		{"v1alpha2/webhook-invalid-virtual-service.yaml.tmpl", "HTTP route cannot contain both route and redirect"},
+		{"v1alpha2/webhook-invalid-destination-rule.yaml.tmpl", "subset name cannot be empty"},
+	}
+	for _, cs := range cases {
+		tutil.Tlog("Checking admission webhook test", "rejection of "+cs.config)
+		yaml, fillErr := t.Fill(cs.config, nil)
+		if fillErr != nil {
+			return fillErr
+		}
+		// invalid configs get through until the webhook serves
+		err = tutil.Repeat(func() error {
+			out, applyErr := t.KubeApplyOutput(yaml, t.Config.Namespace)
+			if applyErr == nil {
+				if deleteErr := t.KubeDelete(yaml, t.Config.Namespace); deleteErr != nil {
+					log.Warna(deleteErr)
+				}
+				return fmt.Errorf("%s was accepted", cs.config)
+			}
+			if !strings.Contains(out, admissionDeniedMessage) || !strings.Contains(out, cs.message) {
+				return fmt.Errorf("%s was rejected with %q, want %q from the webhook", cs.config, out, cs.message)
+			}
+			return nil
+		}, 10, time.Second)
+		if err != nil {
+			return err
+		}
+	}
+
+	defer t.restoreWebhook()
+	return t.verifyFailurePolicies()
+}
+
+// verifyFailurePolicies points the webhook at admissionDownService and applies a valid config, which
+// the API server must reject with the Fail policy and accept with Ignore.
+func (t *admissionWebhook) verifyFailurePolicies() error {
+	yaml, err := t.Fill(admissionValidConfig, nil)
+	if err != nil {
+		return err
+	}
+	for _, policy := range []admissionregistrationv1beta1.FailurePolicyType{
+		admissionregistrationv1beta1.Fail,
+		admissionregistrationv1beta1.Ignore,
+	} {
+		tutil.Tlog("Checking admission webhook test", fmt.Sprintf("%s failure policy with the webhook down", policy))
+		if err = t.updateWebhook(admissionDownService, policy); err != nil {
+			return err
+		}
+		accepted := policy == admissionregistrationv1beta1.Ignore
+		err = tutil.Repeat(func() error {
+			out, applyErr := t.KubeApplyOutput(yaml, t.Config.Namespace)
+			if applyErr == nil {
+				if deleteErr := t.KubeDelete(yaml, t.Config.Namespace); deleteErr != nil {
+					log.Warna(deleteErr)
+				}
+				if !accepted {
+					return fmt.Errorf("valid config accepted with the webhook down and the %s policy", policy)
+				}
+				return nil
+			}
+			if accepted {
+				return fmt.Errorf("valid config rejected with the webhook down and the %s policy: %s", policy, out)
+			}
+			if strings.Contains(out, admissionDeniedMessage) {
+				return fmt.Errorf("valid config rejected by the webhook, which should be down: %s", out)
+			}
+			return nil
+		}, 10, time.Second)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateWebhook points the webhooks of Pilot at the service of the Istio namespace, with the failure
+// policy.
+func (t *admissionWebhook) updateWebhook(service string, policy admissionregistrationv1beta1.FailurePolicyType) error {
+	webhook, err := t.webhooks().Get(admissionWebhookName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	for i := range webhook.Webhooks {
+		hook := &webhook.Webhooks[i]
+		if hook.ClientConfig.Service != nil {
+			hook.ClientConfig.Service.Name = service
+		}
+		hook.FailurePolicy = &policy
+	}
+	_, err = t.webhooks().Update(webhook)
+	return err
+}
+
+// restoreWebhook puts back the webhooks registered by Pilot.
+func (t *admissionWebhook) restoreWebhook() {
+	webhook, err := t.webhooks().Get(admissionWebhookName, metav1.GetOptions{})
+	if err != nil {
+		log.Warna(err)
+		return
+	}
+	webhook.Webhooks = t.original.Webhooks
+	if _, err = t.webhooks().Update(webhook); err != nil {
+		log.Warna(err)
+	}
+}
+
+func (t *admissionWebhook) webhooks() admissionclientv1beta1.ValidatingWebhookConfigurationInterface {
+	return t.KubeClient.AdmissionregistrationV1beta1().ValidatingWebhookConfigurations()
+}
+
+func (t *admissionWebhook) Teardown() {}
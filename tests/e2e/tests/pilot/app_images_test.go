@@ -0,0 +1,75 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"strings"
+
+	multierror "github.com/hashicorp/go-multierror"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/istio/pilot/pkg/kube/inject"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+type appImages struct {
+	*tutil.Environment
+}
+
+func (t *appImages) String() string {
+	return "app-images"
+}
+
+func (t *appImages) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelReachability}
+}
+
+func (t *appImages) Setup() error {
+	return nil
+}
+
+func (t *appImages) Teardown() {
+}
+
+// Run checks that the test apps run the image from -app-hub and -app-tag, while their sidecars
+// keep the Istio image from -hub and -tag.
+func (t *appImages) Run() error {
+	pods, err := t.KubeClient.CoreV1().Pods(t.Config.Namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	appImage := fmt.Sprintf("%s/app:%s", t.Config.AppImageHub(), t.Config.AppImageTag())
+	var errs error
+	for _, pod := range pods.Items {
+		for _, container := range pod.Spec.Containers {
+			switch container.Name {
+			case "app":
+				if container.Image != appImage {
+					errs = multierror.Append(errs, fmt.Errorf("app of pod %s runs %s, want %s",
+						pod.Name, container.Image, appImage))
+				}
+			case inject.ProxyContainerName:
+				if !strings.HasPrefix(container.Image, t.Config.Hub+"/") ||
+					!strings.HasSuffix(container.Image, ":"+t.Config.Tag) {
+					errs = multierror.Append(errs, fmt.Errorf("sidecar of pod %s runs %s, want an image from %s with tag %s",
+						pod.Name, container.Image, t.Config.Hub, t.Config.Tag))
+				}
+			}
+		}
+	}
+	return errs
+}
@@ -18,29 +18,95 @@ package pilot
 
 import (
 	"fmt"
+	"time"
 
+	meshconfig "istio.io/api/mesh/v1alpha1"
+	"istio.io/istio/pkg/log"
 	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
 )
 
+const (
+	// traffic sent from all the sources together to each port and name of the excluded app
+	authExclusionQPS      = 30
+	authExclusionRequests = 30
+	authExclusionWarmup   = time.Second
+	// requests from each source expected to be refused
+	authExclusionRefusedSamples = 5
+	// apps with the sidecar, with mTLS disabled for their port 80 only, and for all their ports
+	authExclusionPortApp    = "auth-excl-port"
+	authExclusionServiceApp = "auth-excl-service"
+)
+
+// authExclusion checks the exclusions from the mTLS of the mesh: the app without a sidecar excluded by
+// the mesh config, and, with the mesh in mTLS, an app with mTLS disabled for one of its ports and one with
+// mTLS disabled for all of them, by the auth.istio.io annotations of their service. Disabling mTLS for a
+// namespace is not covered: neither the mesh config nor the annotations have a namespace scope.
 type authExclusion struct {
 	*tutil.Environment
+	// YAML of the apps with mTLS disabled
+	deployed []string
 }
 
 func (r *authExclusion) String() string {
 	return "auth-exclusion"
 }
 
+func (r *authExclusion) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelSecurity}
+}
+
 func (r *authExclusion) Setup() error {
-	return nil
+	if r.Auth != meshconfig.MeshConfig_MUTUAL_TLS {
+		return nil
+	}
+	for _, app := range []struct {
+		name  string
+		ports []int
+	}{
+		{authExclusionPortApp, []int{80}},
+		{authExclusionServiceApp, []int{80, 8080}},
+	} {
+		annotations := make(map[string]string)
+		for _, port := range app.ports {
+			annotations[fmt.Sprintf("auth.istio.io/%d", port)] = meshconfig.AuthenticationPolicy_NONE.String()
+		}
+		deployed, err := r.DeployApp(tutil.AppSpec{
+			Deployment: app.name,
+			Ports: []tutil.AppPort{
+				{Name: "http", Port: 80},
+				{Name: "http-two", Port: 8080},
+			},
+			ServiceAnnotations: annotations,
+		})
+		if err != nil {
+			return err
+		}
+		r.deployed = append(r.deployed, deployed)
+	}
+	return r.RefreshApps()
 }
 
-func (r *authExclusion) Teardown() {}
+func (r *authExclusion) Teardown() {
+	for _, deployed := range r.deployed {
+		if err := r.KubeDelete(deployed, r.Config.Namespace); err != nil {
+			log.Warna(err)
+		}
+	}
+	r.deployed = nil
+}
 
 func (r *authExclusion) Run() error {
-	return r.makeRequests()
+	if err := r.makeRequests(); err != nil {
+		return err
+	}
+	if r.Auth != meshconfig.MeshConfig_MUTUAL_TLS {
+		return nil
+	}
+	return r.checkDisabled()
 }
 
-// makeRequests executes requests in pods and collects request ids per pod to check against access logs
+// makeRequests sends concurrent traffic from the apps with and without a sidecar to the app excluded
+// from mTLS, through each of its ports and names, and checks that every request succeeds.
 func (r *authExclusion) makeRequests() error {
 	// fake-control service doesn't have sidecar, and is excluded from mTLS so
 	// client with sidecar should never use mTLS when talking to it. As the result,
@@ -49,23 +115,78 @@ func (r *authExclusion) makeRequests() error {
 	dst := "fake-control"
 
 	funcs := make(map[string]func() tutil.Status)
-	for _, src := range srcPods {
-		for _, port := range []string{"", ":80", ":8080"} {
-			for _, domain := range []string{"", "." + r.Config.Namespace} {
-				name := fmt.Sprintf("Request from %s to %s%s%s", src, dst, domain, port)
-				funcs[name] = (func(src, dst, port, domain string) func() tutil.Status {
-					url := fmt.Sprintf("http://%s%s%s/%s", dst, domain, port, src)
-					return func() tutil.Status {
-						resp := r.ClientRequest(src, url, 1, "")
-						// Request should return successfully (status 200)
-						if resp.IsHTTPOk() {
-							return nil
-						}
-						return tutil.ErrAgain
-					}
-				})(src, dst, port, domain)
+	for _, port := range []string{"", ":80", ":8080"} {
+		for _, domain := range []string{"", "." + r.Config.Namespace} {
+			name := fmt.Sprintf("Requests from %v to %s%s%s", srcPods, dst, domain, port)
+			url := fmt.Sprintf("http://%s%s%s/auth-exclusion", dst, domain, port)
+			funcs[name] = r.expectOK(srcPods, url)
+		}
+	}
+	return r.Eventually(tutil.DefaultBudget, funcs)
+}
+
+// checkDisabled checks that the ports with mTLS disabled are reachable from the apps with and without a
+// sidecar, and that the port left in mTLS refuses the app without one.
+func (r *authExclusion) checkDisabled() error {
+	cases := []struct {
+		dst  string
+		port string
+		// whether t, without a sidecar, reaches the port: a and b always do
+		plaintext bool
+	}{
+		{authExclusionPortApp, ":80", true},
+		{authExclusionPortApp, ":8080", false},
+		{authExclusionServiceApp, ":80", true},
+		{authExclusionServiceApp, ":8080", true},
+	}
+	funcs := make(map[string]func() tutil.Status)
+	for _, cs := range cases {
+		url := fmt.Sprintf("http://%s%s/auth-exclusion", cs.dst, cs.port)
+		sources := []string{"a", "b"}
+		if cs.plaintext {
+			sources = append(sources, "t")
+		} else {
+			funcs[fmt.Sprintf("Requests from t to %s%s refused", cs.dst, cs.port)] = r.expectRefused("t", url)
+		}
+		funcs[fmt.Sprintf("Requests from %v to %s%s", sources, cs.dst, cs.port)] = r.expectOK(sources, url)
+	}
+	return r.Eventually(tutil.DefaultBudget, funcs)
+}
+
+// expectOK returns a check that the traffic from the sources to the url all succeeds.
+func (r *authExclusion) expectOK(sources []string, url string) func() tutil.Status {
+	return func() tutil.Status {
+		requests, err := r.DriveTraffic(tutil.Traffic{
+			Sources:  sources,
+			URL:      url,
+			QPS:      authExclusionQPS,
+			Requests: authExclusionRequests,
+			Warmup:   authExclusionWarmup,
+		})
+		if err != nil {
+			return err
+		}
+		if err = requests.ExpectCode(200); err != nil {
+			log.Infof("requests from %v to %s: %v", sources, url, err)
+			return tutil.ErrAgain
+		}
+		return nil
+	}
+}
+
+// expectRefused returns a check that none of the requests from the source to the url reach the app.
+func (r *authExclusion) expectRefused(src, url string) func() tutil.Status {
+	return func() tutil.Status {
+		requests, err := r.ClientRequests(src, url, authExclusionRefusedSamples, "")
+		if err != nil {
+			return err
+		}
+		for i := range requests {
+			if requests[i].Reached() {
+				log.Infof("%v, want it refused", &requests[i])
+				return tutil.ErrAgain
 			}
 		}
+		return nil
 	}
-	return tutil.Parallel(funcs)
 }
@@ -0,0 +1,112 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	meshconfig "istio.io/api/mesh/v1alpha1"
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const rewrittenAuthority = "rewritten.b.example.com"
+
+type authorityRewriteMTLS struct {
+	*tutil.Environment
+}
+
+func (t *authorityRewriteMTLS) String() string {
+	return "authority-rewrite-mtls"
+}
+
+func (t *authorityRewriteMTLS) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelRouting, tutil.LabelSecurity}
+}
+
+func (t *authorityRewriteMTLS) enabled() bool {
+	return t.Auth == meshconfig.MeshConfig_MUTUAL_TLS && t.Config.V1alpha2
+}
+
+func (t *authorityRewriteMTLS) Setup() error {
+	if !t.enabled() {
+		return nil
+	}
+	return t.ApplyConfig("v1alpha2/rule-rewrite-authority-b.yaml.tmpl",
+		map[string]string{"Authority": rewrittenAuthority})
+}
+
+// Run checks that when the authority of requests to "b" is rewritten, the backend sees the new
+// authority while the sidecar of "a" still completes the mTLS handshake with the identity of "b".
+// The outbound cluster verifies the subject alt names of b, so a handshake picked from the
+// rewritten host would fail the request.
+func (t *authorityRewriteMTLS) Run() error {
+	if t.Auth != meshconfig.MeshConfig_MUTUAL_TLS {
+		return tutil.Skip("auth is disabled")
+	}
+	if !t.Config.V1alpha2 {
+		return tutil.Skip("v1alpha2 routing rules are disabled")
+	}
+
+	return tutil.Repeat(func() error {
+		resp := t.ClientRequest("a", "http://b/a", 1, "")
+		if !resp.IsHTTPOk() {
+			return fmt.Errorf("request with rewritten authority failed: %v", resp.Code)
+		}
+		if !strings.Contains(resp.Body, "Host="+rewrittenAuthority) {
+			return fmt.Errorf("backend did not receive the rewritten authority %s", rewrittenAuthority)
+		}
+		if len(resp.Hostname) == 0 || !containsPod(t.Apps["b"], resp.Hostname[0]) {
+			return fmt.Errorf("request with rewritten authority did not reach b: %v", resp.Hostname)
+		}
+
+		stats, err := t.ProxyStats("a")
+		if err != nil {
+			return err
+		}
+		// clusters are named after the real destination, never after the rewritten authority
+		cluster := fmt.Sprintf("cluster.out.b.%s.", t.Config.Namespace)
+		if sumStats(stats, cluster, ".ssl.handshake") == 0 {
+			return fmt.Errorf("no mTLS handshake from a to b was recorded in the stats of %s", cluster)
+		}
+		if n := sumStats(stats, cluster, ".ssl.fail_verify_san"); n > 0 {
+			return fmt.Errorf("%d mTLS handshakes from a to b failed the subject alt name check", n)
+		}
+		return nil
+	}, 5, time.Second)
+}
+
+func (t *authorityRewriteMTLS) Teardown() {
+	if !t.enabled() {
+		return
+	}
+	log.Info("Cleaning up route rules...")
+	if err := t.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+}
+
+// sumStats adds up the Envoy stats whose names contain prefix and end with suffix.
+func sumStats(stats map[string]int, prefix, suffix string) int {
+	sum := 0
+	for name, value := range stats {
+		if strings.Contains(name, prefix) && strings.HasSuffix(name, suffix) {
+			sum += value
+		}
+	}
+	return sum
+}
@@ -0,0 +1,233 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	authzRuleConfig      = "authz-rbac.yaml.tmpl"
+	authzPolicyConfig    = "authz-policy.yaml.tmpl"
+	authzAppPolicyConfig = "authz-rbac-rules.yaml.tmpl"
+	authzServiceAccount  = "authz-allowed"
+	authzClient          = "authz-client"
+	authzCRD             = "serviceroles.config.istio.io"
+	// the only backend the authorization rule applies to
+	authzBackend = "c"
+	// the only app and path prefix the app policy grants GET to
+	authzApp  = "a"
+	authzPath = "/authz-allowed"
+)
+
+// authzPolicy checks the service roles and bindings of the rbac check on the backend: the principal, the
+// method and the path of a role bound to the app label of the source, then a role bound to the service
+// account of a client. The app label is matched without auth, the service account only with auth.
+type authzPolicy struct {
+	*tutil.Environment
+	// mixer rule enabling the rbac check on the backend
+	rule string
+	// service role granting GET on the path prefix, bound to the app
+	appPolicy string
+	// service role and binding granting access to the allowed client
+	policy string
+	// YAML of the client deployed with the allowed identity
+	client string
+}
+
+// authzCase is a request from an app to the backend, and the status code the rbac check must answer.
+type authzCase struct {
+	src    string
+	method string
+	path   string
+	code   string
+}
+
+func (t *authzPolicy) String() string {
+	return "authz-policy"
+}
+
+func (t *authzPolicy) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelSecurity, tutil.LabelTelemetry}
+}
+
+func (t *authzPolicy) Setup() error {
+	if !t.Config.Mixer || !t.HasCRD(authzCRD) {
+		return nil
+	}
+	rule, err := t.Fill(authzRuleConfig, t.authzValues())
+	if err != nil {
+		return err
+	}
+
+	if t.Config.Auth {
+		if _, err = t.KubeClient.CoreV1().ServiceAccounts(t.Config.Namespace).Create(&v1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{Name: authzServiceAccount},
+		}); err != nil {
+			return err
+		}
+		if t.client, err = t.DeployApp(tutil.AppSpec{
+			Deployment:     authzClient,
+			Version:        "v1",
+			ServiceAccount: authzServiceAccount,
+		}); err != nil {
+			return err
+		}
+		if err = t.RefreshApps(); err != nil {
+			return err
+		}
+	}
+
+	if err = t.KubeApply(rule, t.Config.IstioNamespace); err != nil {
+		return err
+	}
+	t.rule = rule
+	return nil
+}
+
+// Run checks that the backend denies every request while no service role grants access to it, then that
+// once the app policy is applied only GET requests from the bound app under the allowed path get through:
+// another app, another method or another path are all denied. With auth, it then checks that only the
+// client running as the bound service account is let through by the policy on the service account.
+func (t *authzPolicy) Run() error {
+	if !t.Config.Mixer {
+		return tutil.Skip("mixer is disabled")
+	}
+	if t.rule == "" {
+		return tutil.Skip("the %s CRD is not installed", authzCRD)
+	}
+
+	// default deny: the rbac check is on, but nothing grants access yet
+	allowed := authzPath + "/get"
+	deny := []authzCase{{authzApp, "GET", allowed, "403"}}
+	if t.Config.Auth {
+		deny = append(deny, authzCase{authzClient, "GET", "/" + authzClient, "403"})
+	}
+	if err := t.expectCodes(deny); err != nil {
+		return fmt.Errorf("default deny: %v", err)
+	}
+
+	appPolicy, err := t.Fill(authzAppPolicyConfig, t.authzValues())
+	if err != nil {
+		return err
+	}
+	if err = t.KubeApply(appPolicy, t.Config.Namespace); err != nil {
+		return err
+	}
+	t.appPolicy = appPolicy
+	if err = t.expectCodes([]authzCase{
+		{authzApp, "GET", allowed, "200"},
+		// denied principal
+		{"b", "GET", allowed, "403"},
+		// denied method
+		{authzApp, "POST", allowed, "403"},
+		// denied path
+		{authzApp, "GET", "/authz-denied", "403"},
+	}); err != nil {
+		return fmt.Errorf("with a policy for app %s: %v", authzApp, err)
+	}
+
+	if t.Config.Auth {
+		policy, err := t.Fill(authzPolicyConfig, t.authzValues())
+		if err != nil {
+			return err
+		}
+		if err = t.KubeApply(policy, t.Config.Namespace); err != nil {
+			return err
+		}
+		t.policy = policy
+		// the app policy still only grants a its path, while the client is granted every path
+		if err = t.expectCodes([]authzCase{
+			{authzApp, "GET", "/" + authzApp, "403"},
+			{authzClient, "GET", "/" + authzClient, "200"},
+		}); err != nil {
+			return fmt.Errorf("with a policy for %s: %v", authzServiceAccount, err)
+		}
+	}
+
+	// the rule must not leak to the other backends
+	resp := t.ClientRequest("a", "http://b/a", 1, "")
+	if !resp.IsHTTPOk() {
+		return fmt.Errorf("request from a to b failed with authorization enabled on %s: %v", authzBackend, resp.Code)
+	}
+	return nil
+}
+
+func (t *authzPolicy) authzValues() map[string]string {
+	return map[string]string{
+		"Name":           "authz",
+		"Namespace":      t.Config.Namespace,
+		"Service":        authzBackend,
+		"ServiceAccount": authzServiceAccount,
+		"App":            authzApp,
+		"Path":           authzPath,
+	}
+}
+
+// expectCodes waits for each request to the backend to get the status code of its case.
+func (t *authzPolicy) expectCodes(cases []authzCase) error {
+	funcs := make(map[string]func() tutil.Status)
+	for _, cs := range cases {
+		name := fmt.Sprintf("%s %s from %s to %s expecting %s", cs.method, cs.path, cs.src, authzBackend, cs.code)
+		funcs[name] = (func(cs authzCase) func() tutil.Status {
+			url := fmt.Sprintf("http://%s%s", authzBackend, cs.path)
+			return func() tutil.Status {
+				resp := t.ClientRequest(cs.src, url, 1, "-method "+cs.method)
+				if len(resp.Code) > 0 && resp.Code[0] == cs.code {
+					return nil
+				}
+				log.Infof("%s %s from %s to %s returned %v, want %s", cs.method, cs.path, cs.src, authzBackend,
+					resp.Code, cs.code)
+				return tutil.ErrAgain
+			}
+		})(cs)
+	}
+	return t.Eventually(tutil.DefaultBudget, funcs)
+}
+
+func (t *authzPolicy) Teardown() {
+	for _, policy := range []*string{&t.policy, &t.appPolicy} {
+		if *policy == "" {
+			continue
+		}
+		if err := t.KubeDelete(*policy, t.Config.Namespace); err != nil {
+			log.Warna(err)
+		}
+		*policy = ""
+	}
+	if t.rule != "" {
+		if err := t.KubeDelete(t.rule, t.Config.IstioNamespace); err != nil {
+			log.Warna(err)
+		}
+		t.rule = ""
+	}
+	if t.client == "" {
+		return
+	}
+	if err := t.KubeDelete(t.client, t.Config.Namespace); err != nil {
+		log.Warna(err)
+	}
+	t.client = ""
+	if err := t.KubeClient.CoreV1().ServiceAccounts(t.Config.Namespace).Delete(authzServiceAccount,
+		&metav1.DeleteOptions{}); err != nil {
+		log.Warna(err)
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	// secret of Citadel with the workload certificate of the default service account, that of a and b
+	certRotationSecret = "istio.default"
+	// number of requests sent at once from a to b during the rotation
+	certRotationBatch = 10
+	// polls of the sidecars for the new certificate, the kubelet taking up to a minute or so to update
+	// the mounted secret
+	certRotationPolls = 36
+	certRotationDelay = 5 * time.Second
+)
+
+// certRotation forces Citadel to issue new workload certificates to a and b, by deleting their
+// secret, while a sends requests to b over mTLS. The sidecars must pick the new certificates up and
+// serve them, without any request failing.
+type certRotation struct {
+	*tutil.Environment
+}
+
+func (t *certRotation) String() string {
+	return "cert-rotation"
+}
+
+func (t *certRotation) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelSecurity, tutil.LabelSlow}
+}
+
+func (t *certRotation) Setup() error {
+	return nil
+}
+
+func (t *certRotation) Teardown() {}
+
+// Run rotates the certificates under traffic from a to b, and checks the certificates each sidecar
+// serves before and after.
+func (t *certRotation) Run() error {
+	if !t.Config.Auth {
+		return tutil.Skip("certificates are only rotated with mTLS")
+	}
+	pods := append(append([]string(nil), t.Apps["a"]...), t.Apps["b"]...)
+	before := make(map[string]string)
+	for _, pod := range pods {
+		cert, err := t.PodProxyCert(pod)
+		if err != nil {
+			return err
+		}
+		before[pod] = cert.SerialNumber.String()
+	}
+
+	var (
+		mutex              sync.Mutex
+		requests, failures int
+		wg                 sync.WaitGroup
+	)
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			resp := t.ClientRequest("a", "http://b/a", certRotationBatch, "")
+			ok := 0
+			for _, code := range resp.Code {
+				if code == "200" {
+					ok++
+				}
+			}
+			mutex.Lock()
+			requests += certRotationBatch
+			failures += certRotationBatch - ok
+			mutex.Unlock()
+		}
+	}()
+
+	err := t.rotate(pods, before)
+	close(stop)
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+	log.Infof("%d/%d requests from a to b failed during the certificate rotation", failures, requests)
+	if failures > 0 {
+		return fmt.Errorf("%d/%d requests from a to b failed during the certificate rotation", failures, requests)
+	}
+	return nil
+}
+
+// rotate deletes the secret of the certificates and waits for Citadel to recreate it, then for every
+// sidecar to serve a new certificate valid for the root.
+func (t *certRotation) rotate(pods []string, before map[string]string) error {
+	secrets := t.KubeClient.CoreV1().Secrets(t.Config.Namespace)
+	old, err := secrets.Get(certRotationSecret, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	log.Infof("Deleting secret %s to rotate the certificates of %v", certRotationSecret, pods)
+	if err = secrets.Delete(certRotationSecret, &metav1.DeleteOptions{}); err != nil {
+		return err
+	}
+	err = tutil.Repeat(func() error {
+		secret, getErr := secrets.Get(certRotationSecret, metav1.GetOptions{})
+		if apierrors.IsNotFound(getErr) {
+			return fmt.Errorf("secret %s not recreated yet", certRotationSecret)
+		} else if getErr != nil {
+			return getErr
+		}
+		if secret.UID == old.UID {
+			return fmt.Errorf("secret %s not deleted yet", certRotationSecret)
+		}
+		return nil
+	}, 30, time.Second)
+	if err != nil {
+		return err
+	}
+
+	return tutil.Repeat(func() error {
+		for _, pod := range pods {
+			cert, certErr := t.PodProxyCert(pod)
+			if certErr != nil {
+				return certErr
+			}
+			if serial := cert.SerialNumber.String(); serial == before[pod] {
+				return fmt.Errorf("the sidecar of %s still serves the certificate %s", pod, serial)
+			}
+		}
+		return nil
+	}, certRotationPolls, certRotationDelay)
+}
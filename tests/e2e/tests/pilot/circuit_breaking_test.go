@@ -0,0 +1,147 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"time"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	// number of requests sent at once from a to b, beyond the single one the pool allows
+	circuitBreakerRequests = 20
+	// how long b holds each request, so that they pile up in the pool
+	circuitBreakerSleep = time.Second
+	// number of failing requests sent from a to b to get it ejected
+	outlierRequests = 5
+)
+
+type circuitBreaking struct {
+	*tutil.Environment
+}
+
+func (t *circuitBreaking) String() string {
+	return "circuit-breaking"
+}
+
+func (t *circuitBreaking) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelRouting}
+}
+
+func (t *circuitBreaking) Setup() error {
+	return nil
+}
+
+// Run limits the connection pool of "b" to one request and sends concurrent slow requests from "a",
+// checking that the sidecar of "a" rejects the overflow. It then makes b fail every request and
+// checks that the sidecar ejects it.
+func (t *circuitBreaking) Run() error {
+	if !t.Config.V1alpha2 {
+		return tutil.Skip("v1alpha2 routing rules are disabled")
+	}
+	if err := t.ApplyConfig("v1alpha2/destination-rule-circuit-breaker-b.yaml.tmpl", nil); err != nil {
+		return err
+	}
+
+	if err := tutil.Repeat(t.verifyOverflow, 5, time.Second); err != nil {
+		return err
+	}
+	return tutil.Repeat(t.verifyEjection, 5, time.Second)
+}
+
+func (t *circuitBreaking) verifyOverflow() error {
+	before, err := t.ProxyStats("a")
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("http://b/a?sleep=%v", circuitBreakerSleep)
+	resp := t.ClientRequest("a", url, circuitBreakerRequests, "")
+	if len(resp.Code) != circuitBreakerRequests {
+		return fmt.Errorf("%d/%d concurrent requests from a to b returned", len(resp.Code), circuitBreakerRequests)
+	}
+	rejected := 0
+	for _, code := range resp.Code {
+		if code == "503" {
+			rejected++
+		}
+	}
+	if rejected == 0 {
+		return fmt.Errorf("none of %d concurrent requests from a to b overflowed the pool: %v",
+			circuitBreakerRequests, resp.Code)
+	}
+
+	after, err := t.ProxyStats("a")
+	if err != nil {
+		return err
+	}
+	overflows := t.overflowStats(after) - t.overflowStats(before)
+	log.Infof("%d/%d concurrent requests from a to b rejected, %d overflows", rejected, circuitBreakerRequests,
+		overflows)
+	if overflows == 0 {
+		return fmt.Errorf("the sidecar of a rejected %d requests to b without counting an overflow", rejected)
+	}
+	return nil
+}
+
+// overflowStats returns the number of connections and requests to b rejected by its connection pool.
+func (t *circuitBreaking) overflowStats(stats map[string]int) int {
+	return sumStats(stats, t.clusterB(), ".upstream_cx_overflow") +
+		sumStats(stats, t.clusterB(), ".upstream_rq_pending_overflow")
+}
+
+// clusterB returns the prefix of the stats of the clusters of b.
+func (t *circuitBreaking) clusterB() string {
+	return fmt.Sprintf("cluster.out.b.%s.", t.Config.Namespace)
+}
+
+func (t *circuitBreaking) verifyEjection() error {
+	before, err := t.ProxyStats("a")
+	if err != nil {
+		return err
+	}
+	// b answers 503 to every request asking for it
+	resp := t.ClientRequest("a", "http://b/a?codes=503:1", outlierRequests, "")
+	if len(resp.Code) != outlierRequests {
+		return fmt.Errorf("%d/%d failing requests from a to b returned", len(resp.Code), outlierRequests)
+	}
+
+	after, err := t.ProxyStats("a")
+	if err != nil {
+		return err
+	}
+	ejections := sumStats(after, t.clusterB(), ".outlier_detection.ejections_total") -
+		sumStats(before, t.clusterB(), ".outlier_detection.ejections_total")
+	log.Infof("%d ejections after %d failing requests from a to b", ejections, outlierRequests)
+	if ejections == 0 {
+		return fmt.Errorf("the sidecar of a did not eject b after %d failing requests: %v", outlierRequests,
+			resp.Code)
+	}
+	return nil
+}
+
+// Teardown removes the destination rule, whether or not Run got to apply it. The ejection of b
+// ends once its base ejection time is over.
+func (t *circuitBreaking) Teardown() {
+	if !t.Config.V1alpha2 {
+		return
+	}
+	log.Info("Cleaning up route rules...")
+	if err := t.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"time"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	propagationConfig = "v1alpha2/rule-propagation.yaml.tmpl"
+	// how long a route rule may take to reach every sidecar
+	propagationTimeout = time.Minute
+)
+
+type configPropagation struct {
+	*tutil.Environment
+	// time from applying the rule to it being in the config of a sidecar, over all rounds and sidecars
+	latencies []time.Duration
+}
+
+func (t *configPropagation) String() string {
+	return "config-propagation"
+}
+
+func (t *configPropagation) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelRouting, tutil.LabelSlow}
+}
+
+func (t *configPropagation) Setup() error {
+	return nil
+}
+
+func (t *configPropagation) Teardown() {
+	if !t.Config.V1alpha2 {
+		return
+	}
+	if err := t.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+}
+
+// Run applies a route rule carrying a new marker every round, and measures the time it takes
+// for the marker to show up in the config_dump of every sidecar.
+func (t *configPropagation) Run() error {
+	if !t.Config.V1alpha2 {
+		return tutil.Skip("v1alpha2 routing rules are disabled")
+	}
+
+	var pods []string
+	for _, app := range []string{"a", "b", "c", "d"} {
+		pods = append(pods, t.Apps[app]...)
+	}
+	t.latencies = nil
+	for round := 1; round <= t.Config.PropagationRounds; round++ {
+		marker := fmt.Sprintf("propagation-%d-%d", round, time.Now().UnixNano())
+		latencies, err := t.TimeConfigPropagation(propagationConfig, map[string]string{"Marker": marker}, pods,
+			marker, propagationTimeout)
+		if err == tutil.ErrNoConfigDump {
+			return tutil.Skip("the sidecars do not serve /config_dump")
+		}
+		if err != nil {
+			return fmt.Errorf("round %d: %v", round, err)
+		}
+		for _, latency := range latencies {
+			t.latencies = append(t.latencies, latency)
+		}
+		log.Infof("Round %d: %v", round, tutil.NewDistribution(t.latencies[len(t.latencies)-len(latencies):]))
+	}
+	log.Infof("Config propagation latency: %v", tutil.NewDistribution(t.latencies))
+	return nil
+}
+
+func (t *configPropagation) Measurements() map[string]tutil.Distribution {
+	return map[string]tutil.Distribution{"propagation": tutil.NewDistribution(t.latencies)}
+}
@@ -0,0 +1,189 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	connectionPoolConfig = "v1alpha2/destination-rule-connection-pool-b.yaml.tmpl"
+	// requests sent at once from a to b to trip a limit
+	connectionPoolRequests = 10
+	// how long b holds each HTTP request, so that they pile up in the pool
+	connectionPoolSleep = time.Second
+	// messages of each gRPC stream, one per second, holding an HTTP/2 request open
+	connectionPoolMessages = 3
+	// requests sent one after the other from a to b, each on a connection of its own
+	connectionPoolSequential = 5
+)
+
+// connectionPool applies the connection pool settings of a destination rule of b one at a time, trips
+// each limit from a and checks the 503s the sidecar of a answers and the counters it keeps. Unlike
+// circuitBreaking, which limits everything at once, each limit is checked alone.
+type connectionPool struct {
+	*tutil.Environment
+}
+
+func (t *connectionPool) String() string {
+	return "connection-pool"
+}
+
+func (t *connectionPool) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelRouting}
+}
+
+func (t *connectionPool) Setup() error {
+	return nil
+}
+
+func (t *connectionPool) Teardown() {
+	if !t.Config.V1alpha2 {
+		return
+	}
+	if err := t.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+}
+
+// Run checks http1MaxPendingRequests behind a single connection, http2MaxRequests on the HTTP/2 port of
+// b and maxRequestsPerConnection, in turn.
+func (t *connectionPool) Run() error {
+	if !t.Config.V1alpha2 {
+		return tutil.Skip("v1alpha2 routing rules are disabled")
+	}
+	for _, check := range []struct {
+		name string
+		// limits are the data of connectionPoolConfig, by setting name, those left out not being set
+		limits map[string]int
+		verify func() error
+	}{
+		{"http1MaxPendingRequests", map[string]int{"MaxConnections": 1, "Http1MaxPendingRequests": 1},
+			t.verifyPendingRequests},
+		{"http2MaxRequests", map[string]int{"Http2MaxRequests": 1}, t.verifyHTTP2Requests},
+		{"maxRequestsPerConnection", map[string]int{"MaxRequestsPerConnection": 1}, t.verifyRequestsPerConnection},
+	} {
+		tutil.Tlog("Checking connectionPool test", check.name)
+		if err := t.ApplyConfig(connectionPoolConfig, check.limits); err != nil {
+			return err
+		}
+		if err := tutil.Repeat(check.verify, 5, time.Second); err != nil {
+			return fmt.Errorf("%s: %v", check.name, err)
+		}
+	}
+	return nil
+}
+
+// verifyPendingRequests sends concurrent slow requests through the single connection to b: one is in
+// flight, one is pending and the others overflow the queue, each counted as a pending overflow.
+func (t *connectionPool) verifyPendingRequests() error {
+	var rejected int
+	overflows, err := t.statDelta(".upstream_rq_pending_overflow", func() error {
+		url := fmt.Sprintf("http://b/a?sleep=%v", connectionPoolSleep)
+		resp := t.ClientRequest("a", url, connectionPoolRequests, "")
+		if len(resp.Code) != connectionPoolRequests {
+			return fmt.Errorf("%d/%d concurrent requests from a to b returned", len(resp.Code), connectionPoolRequests)
+		}
+		for _, code := range resp.Code {
+			if code == "503" {
+				rejected++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Infof("%d/%d concurrent requests from a to b rejected, %d pending overflows", rejected,
+		connectionPoolRequests, overflows)
+	if rejected == 0 || rejected == connectionPoolRequests {
+		return fmt.Errorf("%d/%d concurrent requests from a to b rejected, want some but not all", rejected,
+			connectionPoolRequests)
+	}
+	if overflows != rejected {
+		return fmt.Errorf("the sidecar of a rejected %d requests to b and counted %d pending overflows", rejected,
+			overflows)
+	}
+	return nil
+}
+
+// verifyHTTP2Requests opens concurrent gRPC streams to the HTTP/2 port of b, each an HTTP/2 request
+// held open by its messages: the streams beyond the first overflow.
+func (t *connectionPool) verifyHTTP2Requests() error {
+	var completed int
+	overflows, err := t.statDelta(".upstream_rq_pending_overflow", func() error {
+		resp := t.ClientRequest("a", "grpc://b:70", connectionPoolRequests,
+			fmt.Sprintf("-stream bidi -messages %d -interval 1s", connectionPoolMessages))
+		// the client fails as a whole, logging nothing, when a stream fails
+		completed = strings.Count(resp.Body, fmt.Sprintf("StreamMessages=%d", connectionPoolMessages))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Infof("%d/%d concurrent gRPC streams from a to b completed, %d overflows", completed,
+		connectionPoolRequests, overflows)
+	if completed == connectionPoolRequests {
+		return fmt.Errorf("all %d concurrent gRPC streams from a to b completed", connectionPoolRequests)
+	}
+	if overflows == 0 {
+		return fmt.Errorf("the sidecar of a counted no overflow of the HTTP/2 requests to b")
+	}
+	return nil
+}
+
+// verifyRequestsPerConnection sends requests from a to b one after the other, which the sidecar of a
+// would otherwise send on the same connection.
+func (t *connectionPool) verifyRequestsPerConnection() error {
+	connections, err := t.statDelta(".upstream_cx_total", func() error {
+		for i := 0; i < connectionPoolSequential; i++ {
+			if resp := t.ClientRequest("a", "http://b/a", 1, ""); !resp.IsHTTPOk() {
+				return fmt.Errorf("request from a to b failed: %v", resp.Code)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Infof("%d requests from a to b opened %d connections", connectionPoolSequential, connections)
+	if connections < connectionPoolSequential {
+		return fmt.Errorf("%d requests from a to b opened %d connections, want one per request",
+			connectionPoolSequential, connections)
+	}
+	return nil
+}
+
+// statDelta returns how much the counter of the clusters of b in the sidecar of a grew while f ran.
+func (t *connectionPool) statDelta(suffix string, f func() error) (int, error) {
+	prefix := fmt.Sprintf("cluster.out.b.%s.", t.Config.Namespace)
+	before, err := t.ProxyStats("a")
+	if err != nil {
+		return 0, err
+	}
+	if err = f(); err != nil {
+		return 0, err
+	}
+	after, err := t.ProxyStats("a")
+	if err != nil {
+		return 0, err
+	}
+	return sumStats(after, prefix, suffix) - sumStats(before, prefix, suffix), nil
+}
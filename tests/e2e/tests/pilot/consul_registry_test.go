@@ -0,0 +1,137 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"time"
+
+	"istio.io/istio/pilot/pkg/serviceregistry"
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	// domain of the hostnames of the Consul adapter, <name>.service.consul
+	consulDomain = ".service.consul"
+	// requests from a to c checking its routes
+	consulRoutingSamples = 20
+)
+
+// consulRegistry checks HTTP, TCP and routing against Pilot discovering the apps with the Consul adapter,
+// from the catalog the mirror of consul.yaml.tmpl fills with the Kubernetes endpoints of the apps. The
+// Consul hostnames are not resolved by the cluster DNS, so the requests connect to the Kubernetes services,
+// which the sidecars intercept, with the authority of the Consul hostname. The other tests address the
+// apps by their Kubernetes hostnames: run this one alone, as testdata/profiles/consul.yaml does.
+type consulRegistry struct {
+	*tutil.Environment
+}
+
+func (t *consulRegistry) String() string {
+	return "consul-registry"
+}
+
+func (t *consulRegistry) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelReachability, tutil.LabelRouting}
+}
+
+func (t *consulRegistry) Setup() error {
+	return nil
+}
+
+func (t *consulRegistry) Teardown() {
+	if serviceregistry.ServiceRegistry(t.Config.Registry) != serviceregistry.ConsulRegistry || !t.Config.V1alpha2 {
+		return
+	}
+	if err := t.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+}
+
+// Run checks the HTTP and TCP requests between the apps behind sidecars, then routes c to each of its
+// versions in turn.
+func (t *consulRegistry) Run() error {
+	if serviceregistry.ServiceRegistry(t.Config.Registry) != serviceregistry.ConsulRegistry {
+		return tutil.Skip("the registry is %s, see -registry", t.Config.Registry)
+	}
+
+	funcs := make(map[string]func() tutil.Status)
+	for _, src := range []string{"a", "b"} {
+		for _, dst := range []string{"a", "b"} {
+			for _, port := range []string{"", ":8080"} {
+				name := fmt.Sprintf("HTTP request from %s to %s%s%s", src, dst, consulDomain, port)
+				funcs[name] = (func(src, dst, port string) func() tutil.Status {
+					return func() tutil.Status {
+						resp := t.consulRequest(src, "http://"+dst+port+"/"+src, dst, 1)
+						if !resp.IsHTTPOk() {
+							return tutil.ErrAgain
+						}
+						return nil
+					}
+				})(src, dst, port)
+			}
+			for _, port := range []string{":90", ":9090"} {
+				name := fmt.Sprintf("TCP connection from %s to %s%s%s", src, dst, consulDomain, port)
+				funcs[name] = (func(src, dst, port string) func() tutil.Status {
+					return func() tutil.Status {
+						// the TCP listeners match the destination port, not the authority
+						resp := t.ClientRequest(src, "http://"+dst+port+"/"+src, 1, "")
+						if !resp.IsHTTPOk() {
+							return tutil.ErrAgain
+						}
+						return nil
+					}
+				})(src, dst, port)
+			}
+		}
+	}
+	if err := t.Eventually(tutil.DefaultBudget, funcs); err != nil {
+		return err
+	}
+
+	if !t.Config.V1alpha2 {
+		return tutil.Skip("v1alpha2 routing rules are disabled")
+	}
+	for _, version := range []string{"v2", "v1"} {
+		tutil.Tlog("Checking consulRegistry test", fmt.Sprintf("routes of c%s to %s", consulDomain, version))
+		if err := t.ApplyConfig(subsetsConfig, map[string]interface{}{
+			"Service": "c" + consulDomain,
+			"Subsets": []subsetWeight{{Name: version, Weight: 100}},
+		}); err != nil {
+			return err
+		}
+		if err := tutil.Repeat(func() error {
+			resp := t.consulRequest("a", "http://c/a", "c", consulRoutingSamples)
+			if len(resp.Version) != consulRoutingSamples {
+				return fmt.Errorf("%d of %d requests from a to c%s succeeded", len(resp.Version),
+					consulRoutingSamples, consulDomain)
+			}
+			for _, got := range resp.Version {
+				if got != version {
+					return fmt.Errorf("request from a to c%s reached %s, want %s", consulDomain, got, version)
+				}
+			}
+			return nil
+		}, 5, time.Second); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// consulRequest sends requests from the app to the URL with the authority of the Consul hostname of dst.
+func (t *consulRegistry) consulRequest(src, url, dst string, count int) tutil.Response {
+	return t.ClientRequest(src, url, count, "-key Host -val "+dst+consulDomain)
+}
@@ -0,0 +1,116 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	corsPolicyConfig     = "v1alpha2/rule-cors-b.yaml.tmpl"
+	corsAllowedOrigin    = "http://allowed.example"
+	corsDisallowedOrigin = "http://disallowed.example"
+)
+
+type corsPolicy struct {
+	*tutil.Environment
+}
+
+func (t *corsPolicy) String() string {
+	return "cors-policy"
+}
+
+func (t *corsPolicy) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelRouting}
+}
+
+func (t *corsPolicy) Setup() error {
+	if !t.Config.V1alpha2 {
+		return nil
+	}
+	return t.ApplyConfig(corsPolicyConfig, map[string]string{"Origin": corsAllowedOrigin})
+}
+
+// Run sends preflight requests from "a" to "b", and checks that the sidecar answers them with the
+// configured policy for the allowed origin, and without any Access-Control-Allow-Origin for another one.
+func (t *corsPolicy) Run() error {
+	if !t.Config.V1alpha2 {
+		return tutil.Skip("v1alpha2 routing rules are disabled")
+	}
+
+	cases := []struct {
+		name   string
+		origin string
+		want   map[string]string
+	}{
+		{"allowed origin", corsAllowedOrigin, map[string]string{
+			"Access-Control-Allow-Origin":      corsAllowedOrigin,
+			"Access-Control-Allow-Methods":     "GET,POST",
+			"Access-Control-Allow-Headers":     "content-type,x-cors-test",
+			"Access-Control-Allow-Credentials": "true",
+		}},
+		{"disallowed origin", corsDisallowedOrigin, map[string]string{
+			"Access-Control-Allow-Origin": "",
+		}},
+	}
+	for _, cs := range cases {
+		tutil.Tlog("Checking CORS preflight", cs.name)
+		origin, want := cs.origin, cs.want
+		err := tutil.Repeat(func() error {
+			return t.verifyPreflight(origin, want)
+		}, 5, time.Second)
+		if err != nil {
+			return fmt.Errorf("preflight from the %s: %v", cs.name, err)
+		}
+	}
+	return nil
+}
+
+// verifyPreflight checks the response headers of a preflight request from the origin, an empty
+// value meaning that the header must be missing.
+func (t *corsPolicy) verifyPreflight(origin string, want map[string]string) error {
+	extra := fmt.Sprintf("-method OPTIONS -headers Origin:%s -headers Access-Control-Request-Method:POST "+
+		"-headers Access-Control-Request-Headers:x-cors-test", origin)
+	resp := t.ClientRequest("a", "http://b/cors", 1, extra)
+	if !resp.IsHTTPOk() {
+		return fmt.Errorf("preflight from a to b failed: %v", resp.Code)
+	}
+	for name, value := range want {
+		matches := regexp.MustCompile("ResponseHeader=" + name + ":(.*)").FindStringSubmatch(resp.Body)
+		got := ""
+		if len(matches) > 1 {
+			got = matches[1]
+		}
+		if got != value {
+			return fmt.Errorf("preflight response has %s=%q, want %q", name, got, value)
+		}
+	}
+	return nil
+}
+
+func (t *corsPolicy) Teardown() {
+	if !t.Config.V1alpha2 {
+		return
+	}
+	log.Info("Cleaning up route rules...")
+	if err := t.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+}
@@ -18,6 +18,8 @@ package pilot
 
 import (
 	"fmt"
+	"net"
+	"strconv"
 	"strings"
 	"time"
 
@@ -27,79 +29,124 @@ import (
 	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
 )
 
+const (
+	egressTCPConfig = "v1alpha1/egress-rule-tcp-cidr.yaml.tmpl"
+	// raw TCP port of the apps, which no egress rule of the HTTP cases opens
+	egressTCPPort = 9070
+)
+
 type egressRules struct {
 	*tutil.Environment
+	// pod IPs standing in for external TCP endpoints: that of t, without a sidecar, is allowed by
+	// egressTCPConfig, and that of c is not
+	allowedAddress, blockedAddress string
 }
 
 func (t *egressRules) String() string {
 	return "egress-rules"
 }
 
+func (t *egressRules) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelEgress}
+}
+
 func (t *egressRules) Setup() error {
-	return nil
+	var err error
+	if t.allowedAddress, err = podIP(t.Environment, t.Apps["t"][0]); err != nil {
+		return err
+	}
+	t.blockedAddress, err = podIP(t.Environment, t.Apps["c"][0])
+	return err
 }
 
-// TODO: test negatives
 func (t *egressRules) Run() error {
+	tcpData := map[string]string{
+		"CIDR": t.allowedAddress + "/32",
+		"Port": strconv.Itoa(egressTCPPort),
+	}
 	cases := []struct {
 		description string
 		config      string
+		data        map[string]string
 		check       func() error
 	}{
 		{
 			description: "allow external traffic to httbin.org",
 			config:      "v1alpha1/egress-rule-httpbin.yaml.tmpl",
 			check: func() error {
-				return t.verifyReachable("http://httpbin.org/headers", true)
+				return verifyReachable(t.Environment, "http://httpbin.org/headers", true)
 			},
 		},
 		{
 			description: "allow external traffic to *.httbin.org",
 			config:      "v1alpha1/egress-rule-wildcard-httpbin.yaml.tmpl",
 			check: func() error {
-				return t.verifyReachable("http://www.httpbin.org/headers", true)
+				return verifyReachable(t.Environment, "http://www.httpbin.org/headers", true)
 			},
 		},
 		{
 			description: "ensure traffic to httbin.org is prohibited when setting *.httbin.org",
 			config:      "v1alpha1/egress-rule-wildcard-httpbin.yaml.tmpl",
 			check: func() error {
-				return t.verifyReachable("http://httpbin.org/headers", false)
+				return verifyReachable(t.Environment, "http://httpbin.org/headers", false)
+			},
+		},
+		{
+			description: "ensure traffic to nghttp2.org is prohibited when setting *.httbin.org",
+			config:      "v1alpha1/egress-rule-wildcard-httpbin.yaml.tmpl",
+			check: func() error {
+				return verifyReachable(t.Environment, "http://nghttp2.org", false)
 			},
 		},
 		{
 			description: "allow external http2 traffic to nghttp2.org",
 			config:      "v1alpha1/egress-rule-nghttp2.yaml.tmpl",
 			check: func() error {
-				return t.verifyReachable("http://nghttp2.org", true)
+				return verifyReachable(t.Environment, "http://nghttp2.org", true)
 			},
 		},
 		{
 			description: "prohibit https to httbin.org",
 			config:      "v1alpha1/egress-rule-httpbin.yaml.tmpl",
 			check: func() error {
-				return t.verifyReachable("http://httpbin.org:443/headers", false)
+				return verifyReachable(t.Environment, "http://httpbin.org:443/headers", false)
 			},
 		},
 		{
 			description: "allow https external traffic to www.wikipedia.org by a tcp egress rule with cidr",
 			config:      "v1alpha1/egress-rule-tcp-wikipedia-cidr.yaml.tmpl",
 			check: func() error {
-				return t.verifyReachable("https://www.wikipedia.org", true)
+				return verifyReachable(t.Environment, "https://www.wikipedia.org", true)
 			},
 		},
 		{
 			description: "prohibit http external traffic to cnn.com by a tcp egress rule",
 			config:      "v1alpha1/egress-rule-tcp-wikipedia-cidr.yaml.tmpl",
 			check: func() error {
-				return t.verifyReachable("https://cnn.com", false)
+				return verifyReachable(t.Environment, "https://cnn.com", false)
+			},
+		},
+		{
+			description: "allow raw tcp traffic to an address by a tcp egress rule with cidr",
+			config:      egressTCPConfig,
+			data:        tcpData,
+			check: func() error {
+				return verifyTCPReachable(t.Environment, t.allowedAddress, egressTCPPort, true)
+			},
+		},
+		{
+			description: "prohibit raw tcp traffic to an address outside of the cidr of a tcp egress rule",
+			config:      egressTCPConfig,
+			data:        tcpData,
+			check: func() error {
+				return verifyTCPReachable(t.Environment, t.blockedAddress, egressTCPPort, false)
 			},
 		},
 	}
 	var errs error
 	for _, cs := range cases {
 		tutil.Tlog("Checking egressRules test", cs.description)
-		if err := t.ApplyConfig(cs.config, nil); err != nil {
+		if err := t.ApplyConfig(cs.config, cs.data); err != nil {
 			return err
 		}
 
@@ -110,7 +157,7 @@ func (t *egressRules) Run() error {
 			log.Info("Success!")
 		}
 
-		if err := t.DeleteConfig(cs.config, nil); err != nil {
+		if err := t.DeleteConfig(cs.config, cs.data); err != nil {
 			return err
 		}
 	}
@@ -124,8 +171,9 @@ func (t *egressRules) Teardown() {
 	}
 }
 
-// verifyReachable verifies that the url is reachable
-func (t *egressRules) verifyReachable(url string, shouldBeReachable bool) error {
+// verifyReachable verifies that the url is reachable from the sidecars of "a" and "b", or that it is not if
+// shouldBeReachable is false. It is shared by the tests that control external traffic.
+func verifyReachable(t *tutil.Environment, url string, shouldBeReachable bool) error {
 	funcs := make(map[string]func() tutil.Status)
 	for _, src := range []string{"a", "b"} {
 		name := fmt.Sprintf("Request from %s to %s", src, url)
@@ -146,5 +194,31 @@ func (t *egressRules) verifyReachable(url string, shouldBeReachable bool) error
 		})(src)
 	}
 
-	return tutil.Parallel(funcs)
+	return t.Eventually(tutil.DefaultBudget, funcs)
+}
+
+// verifyTCPReachable verifies that a raw TCP connection to the address and port from the sidecars of "a"
+// and "b" gets its payload echoed, or that it does not if shouldBeReachable is false.
+func verifyTCPReachable(t *tutil.Environment, address string, port int, shouldBeReachable bool) error {
+	url := fmt.Sprintf("tcp://%s", net.JoinHostPort(address, strconv.Itoa(port)))
+	funcs := make(map[string]func() tutil.Status)
+	for _, src := range []string{"a", "b"} {
+		name := fmt.Sprintf("Raw TCP connection from %s to %s", src, url)
+		funcs[name] = (func(src string) func() tutil.Status {
+			return func() tutil.Status {
+				resp := t.ClientRequest(src, url, 1, "")
+				reachable := strings.Contains(resp.Body, "TCPEchoed=")
+				if reachable && !shouldBeReachable {
+					return fmt.Errorf("%s is reachable from %s (should be unreachable)", url, src)
+				}
+				if !reachable && shouldBeReachable {
+					return tutil.ErrAgain
+				}
+
+				return nil
+			}
+		})(src)
+	}
+
+	return t.Eventually(tutil.DefaultBudget, funcs)
 }
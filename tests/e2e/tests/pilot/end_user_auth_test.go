@@ -0,0 +1,166 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	endUserAuthConfig = "end-user-auth.yaml.tmpl"
+	endUserAuthCRD    = "enduserauthenticationpolicyspecs.config.istio.io"
+	// the only backend requiring a token
+	endUserAuthBackend  = "b"
+	endUserAuthIssuer   = "e2e@istio.io"
+	endUserAuthAudience = "e2e-audience"
+	// URL query the token is read from
+	endUserAuthQuery = "token"
+	// app without a sidecar serving the JWKS, which the sidecar of the backend fetches in plain text
+	endUserAuthJWKSApp = "t"
+)
+
+type endUserAuth struct {
+	*tutil.Environment
+	signer *tutil.JWTSigner
+	// YAML of the policy, its binding to the backend and the ingress in front of it
+	config string
+}
+
+func (t *endUserAuth) String() string {
+	return "end-user-auth"
+}
+
+func (t *endUserAuth) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelSecurity}
+}
+
+func (t *endUserAuth) Setup() error {
+	if !t.Config.Mixer || !t.HasCRD(endUserAuthCRD) {
+		return nil
+	}
+	signer, err := tutil.NewJWTSigner()
+	if err != nil {
+		return err
+	}
+	jwks, err := signer.JWKS()
+	if err != nil {
+		return err
+	}
+	values := map[string]string{
+		"Namespace":      t.Config.Namespace,
+		"IstioNamespace": t.Config.IstioNamespace,
+		"Service":        endUserAuthBackend,
+		"Issuer":         endUserAuthIssuer,
+		"Audience":       endUserAuthAudience,
+		"Query":          endUserAuthQuery,
+		"JwksURI": fmt.Sprintf("http://%s.%s.svc.cluster.local:80/jwks?body=%s", endUserAuthJWKSApp,
+			t.Config.Namespace, url.QueryEscape(jwks)),
+	}
+	if t.Config.Ingress {
+		values["Ingress"] = strconv.FormatBool(t.Config.Ingress)
+	}
+	config, err := t.Fill(endUserAuthConfig, values)
+	if err != nil {
+		return err
+	}
+	if err = t.KubeApply(config, t.Config.Namespace); err != nil {
+		return err
+	}
+	t.signer = signer
+	t.config = config
+	return nil
+}
+
+// Run checks that the sidecar of the backend lets through requests with a valid token only, and rejects
+// expired tokens, tokens for another audience and requests without a token, both for requests from another
+// sidecar and for requests through the ingress.
+func (t *endUserAuth) Run() error {
+	if !t.Config.Mixer {
+		return tutil.Skip("mixer is disabled, the sidecars have no JWT authentication filter")
+	}
+	if t.config == "" {
+		return tutil.Skip("the %s CRD is not installed", endUserAuthCRD)
+	}
+
+	now := time.Now()
+	tokens := []struct {
+		name string
+		// empty audience to send no token
+		audience string
+		expiry   time.Time
+		code     string
+	}{
+		{"valid", endUserAuthAudience, now.Add(time.Hour), "200"},
+		{"expired", endUserAuthAudience, now.Add(-time.Hour), "401"},
+		{"wrong-audience", "other-audience", now.Add(time.Hour), "401"},
+		{"missing", "", time.Time{}, "401"},
+	}
+	paths := []struct {
+		name string
+		src  string
+		url  string
+	}{
+		{"sidecar", "a", fmt.Sprintf("http://%s/jwt", endUserAuthBackend)},
+	}
+	if t.Config.Ingress {
+		paths = append(paths, struct {
+			name string
+			src  string
+			url  string
+		}{"ingress", "t", fmt.Sprintf("http://%s.%s/jwt", ingressServiceName, t.Config.IstioNamespace)})
+	}
+
+	funcs := make(map[string]func() tutil.Status)
+	for _, token := range tokens {
+		query := ""
+		if token.audience != "" {
+			jwt, err := t.signer.Token(endUserAuthIssuer, token.audience, token.expiry)
+			if err != nil {
+				return err
+			}
+			query = "?" + endUserAuthQuery + "=" + jwt
+		}
+		for _, path := range paths {
+			name := fmt.Sprintf("Request through %s with %s token expecting %s", path.name, token.name, token.code)
+			funcs[name] = (func(name, src, target, code string) func() tutil.Status {
+				return func() tutil.Status {
+					resp := t.ClientRequest(src, target, 1, "")
+					if len(resp.Code) > 0 && resp.Code[0] == code {
+						return nil
+					}
+					log.Infof("%s returned %v, want %s", name, resp.Code, code)
+					return tutil.ErrAgain
+				}
+			})(name, path.src, path.url+query, token.code)
+		}
+	}
+	return t.Eventually(tutil.DefaultBudget, funcs)
+}
+
+func (t *endUserAuth) Teardown() {
+	if t.config == "" {
+		return
+	}
+	if err := t.KubeDelete(t.config, t.Config.Namespace); err != nil {
+		log.Warna(err)
+	}
+	t.config = ""
+}
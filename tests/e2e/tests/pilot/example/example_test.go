@@ -0,0 +1,78 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package example runs a test of its own with the pilot e2e harness, as a package outside of tests/e2e
+// would, taking the same flags as the pilot tests:
+//
+//	go test ./tests/e2e/tests/pilot/example -args -hub <hub> -tag <tag>
+package example
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+var suite = tutil.NewSuite(func(env *tutil.Environment, _ func(string) *tutil.Environment) []tutil.Test {
+	return []tutil.Test{&echo{Environment: env}}
+})
+
+func init() {
+	// the templates and certificates of the pilot tests, relative to this package
+	suite.Config.TestDataDir = "../testdata/"
+	suite.Config.CertDir = "../../../../../pilot/docker/certs/"
+	suite.Config.HelmChart = "../../../../../install/kubernetes/helm/istio"
+}
+
+// echo checks that the requests from "a" reach every other app of the environment through the sidecars.
+type echo struct {
+	*tutil.Environment
+}
+
+func (t *echo) String() string {
+	return "example-echo"
+}
+
+func (t *echo) Setup() error {
+	return nil
+}
+
+func (t *echo) Teardown() {}
+
+func (t *echo) Run() error {
+	checks := make(map[string]func() tutil.Status)
+	for _, dst := range []string{"b", "c"} {
+		dst := dst
+		checks[fmt.Sprintf("Request from a to %s", dst)] = func() tutil.Status {
+			resp := t.ClientRequest("a", "http://"+dst, 1, "")
+			if !resp.IsHTTPOk() {
+				log.Infof("Request from a to %s returned %v", dst, resp.Code)
+				return tutil.ErrAgain
+			}
+			return nil
+		}
+	}
+	return t.Eventually(tutil.DefaultBudget, checks)
+}
+
+func TestExample(t *testing.T) {
+	suite.Run(t)
+}
+
+func TestMain(m *testing.M) {
+	os.Exit(suite.Main(m))
+}
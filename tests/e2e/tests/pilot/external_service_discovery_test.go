@@ -0,0 +1,122 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	externalDiscoveryConfig = "v1alpha2/external-service-discovery.yaml.tmpl"
+	// app without a sidecar standing in for the external backend
+	externalDiscoveryBackend = "t"
+	// ports of the TCP external services, which no mesh service listens on
+	externalStaticTCPPort = 3333
+	externalDNSTCPPort    = 3334
+	externalRawRequest    = `GET\x20/external\x20HTTP/1.1\r\nHost:\x20t\r\nConnection:\x20close\r\n\r\n`
+)
+
+// externalServiceDiscovery covers the discovery modes of external services, in front of an in-cluster
+// backend so that it does not depend on the Internet. NONE is only covered on an HTTP port: a TCP port
+// without discovery forwards to the original destination, which would need an address and port no mesh
+// service listens on.
+type externalServiceDiscovery struct {
+	*tutil.Environment
+	// ClusterIP of the backend, which the STATIC endpoints and the TCP hosts use
+	address string
+}
+
+func (t *externalServiceDiscovery) String() string {
+	return "external-service-discovery"
+}
+
+func (t *externalServiceDiscovery) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelEgress}
+}
+
+func (t *externalServiceDiscovery) Setup() error {
+	if !t.Config.V1alpha2 {
+		return nil
+	}
+	svc, err := t.KubeClient.CoreV1().Services(t.Config.Namespace).Get(externalDiscoveryBackend, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	t.address = svc.Spec.ClusterIP
+	return t.ApplyConfig(externalDiscoveryConfig, map[string]string{
+		"Address":       t.address,
+		"Hostname":      fmt.Sprintf("%s.%s.svc.cluster.local", externalDiscoveryBackend, t.Config.Namespace),
+		"StaticTCPPort": strconv.Itoa(externalStaticTCPPort),
+		"DNSTCPPort":    strconv.Itoa(externalDNSTCPPort),
+	})
+}
+
+// Run checks that requests from a sidecar reach the backend through each external service. The HTTP
+// requests are sent to the address of the backend, and the sidecar picks the external service by host.
+func (t *externalServiceDiscovery) Run() error {
+	if !t.Config.V1alpha2 {
+		return tutil.Skip("v1alpha2 routing rules are disabled")
+	}
+
+	url := fmt.Sprintf("http://%s/external", net.JoinHostPort(t.address, "80"))
+	rawMsg := "-msg " + externalRawRequest
+	cases := []struct {
+		name  string
+		url   string
+		extra string
+	}{
+		{"HTTP STATIC", url, "-key Host -val static.external.test"},
+		{"HTTP DNS", url, "-key Host -val dns.external.test"},
+		{"HTTP NONE", url, "-key Host -val none.external.test"},
+		{"TCP STATIC", "raw://" + net.JoinHostPort(t.address, strconv.Itoa(externalStaticTCPPort)), rawMsg},
+		{"TCP DNS", "raw://" + net.JoinHostPort(t.address, strconv.Itoa(externalDNSTCPPort)), rawMsg},
+	}
+	funcs := make(map[string]func() tutil.Status)
+	for _, cs := range cases {
+		name := fmt.Sprintf("Request through the %s external service", cs.name)
+		funcs[name] = (func(name, url, extra string) func() tutil.Status {
+			return func() tutil.Status {
+				resp := t.ClientRequest("a", url, 1, extra)
+				if !resp.IsHTTPOk() || len(resp.Hostname) == 0 {
+					log.Infof("%s returned %v", name, resp.Code)
+					return tutil.ErrAgain
+				}
+				if !containsPod(t.Apps[externalDiscoveryBackend], resp.Hostname[0]) {
+					return fmt.Errorf("%s reached pod %s, want app %s", name, resp.Hostname[0],
+						externalDiscoveryBackend)
+				}
+				return nil
+			}
+		})(name, cs.url, cs.extra)
+	}
+	return t.Eventually(tutil.DefaultBudget, funcs)
+}
+
+func (t *externalServiceDiscovery) Teardown() {
+	if !t.Config.V1alpha2 {
+		return
+	}
+	log.Info("Cleaning up external services...")
+	if err := t.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	// share of the requests to b aborted with faultAbortStatus
+	faultAbortPercent = 50
+	faultAbortStatus  = "503"
+	// number of requests the abort rate is measured over
+	faultAbortRequests = 100
+	// allowed gap, in percentage points, between the measured and configured abort rates:
+	// three standard deviations of the abort count over faultAbortRequests requests
+	faultAbortTolerance = 15
+	// delay added to every request to b
+	faultDelay = 2 * time.Second
+	// number of requests whose latency is checked
+	faultDelayRequests = 5
+	// slack given to the delay for the round trips through kubectl exec and the sidecars
+	faultDelaySlack = 2 * time.Second
+)
+
+type faultInjection struct {
+	*tutil.Environment
+}
+
+func (t *faultInjection) String() string {
+	return "fault-injection"
+}
+
+func (t *faultInjection) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelRouting}
+}
+
+func (t *faultInjection) Setup() error {
+	return nil
+}
+
+// Run injects aborts into a share of the requests from "a" to "b" and checks the rate of aborted
+// requests, then injects a fixed delay and checks the latency of the requests.
+func (t *faultInjection) Run() error {
+	if !t.Config.V1alpha2 {
+		return tutil.Skip("v1alpha2 routing rules are disabled")
+	}
+
+	if err := t.ApplyConfig("v1alpha2/rule-fault-abort-b.yaml.tmpl", map[string]string{
+		"Percent": strconv.Itoa(faultAbortPercent),
+		"Status":  faultAbortStatus,
+	}); err != nil {
+		return err
+	}
+	if err := tutil.Repeat(t.verifyAbortRate, 5, time.Second); err != nil {
+		return err
+	}
+
+	// proto durations must be in seconds
+	delay := fmt.Sprintf("%.3fs", faultDelay.Seconds())
+	if err := t.ApplyConfig("v1alpha2/rule-fault-delay-b.yaml.tmpl", map[string]string{"Delay": delay}); err != nil {
+		return err
+	}
+	return tutil.Repeat(t.verifyDelay, 5, time.Second)
+}
+
+func (t *faultInjection) verifyAbortRate() error {
+	resp := t.ClientRequest("a", "http://b/a", faultAbortRequests, "")
+	aborted := 0
+	for _, code := range resp.Code {
+		switch code {
+		case faultAbortStatus:
+			aborted++
+		case "200":
+		default:
+			return fmt.Errorf("request from a to b with aborts injected returned %s", code)
+		}
+	}
+	if len(resp.Code) != faultAbortRequests {
+		return fmt.Errorf("%d/%d requests from a to b returned a status code", len(resp.Code), faultAbortRequests)
+	}
+	percent := 100 * aborted / faultAbortRequests
+	log.Infof("%d%% of the requests from a to b were aborted, want %d%%", percent, faultAbortPercent)
+	if percent < faultAbortPercent-faultAbortTolerance || percent > faultAbortPercent+faultAbortTolerance {
+		return fmt.Errorf("%d%% of the requests from a to b were aborted with %s, want %d%% (+/- %d)",
+			percent, faultAbortStatus, faultAbortPercent, faultAbortTolerance)
+	}
+	return nil
+}
+
+func (t *faultInjection) verifyDelay() error {
+	resp := t.ClientRequest("a", "http://b/a", faultDelayRequests, "")
+	if len(resp.Code) != faultDelayRequests || len(resp.Latency) != faultDelayRequests {
+		return fmt.Errorf("%d/%d requests from a to b with a delay injected returned", len(resp.Code),
+			faultDelayRequests)
+	}
+	for _, code := range resp.Code {
+		if code != "200" {
+			return fmt.Errorf("request from a to b with a delay injected returned %s", code)
+		}
+	}
+	for _, latency := range resp.Latency {
+		if latency < faultDelay || latency > faultDelay+faultDelaySlack {
+			return fmt.Errorf("request from a to b with a %v delay injected took %v", faultDelay, latency)
+		}
+	}
+	return nil
+}
+
+// Teardown removes the fault rule, whether or not Run got to apply it.
+func (t *faultInjection) Teardown() {
+	if !t.Config.V1alpha2 {
+		return
+	}
+	log.Info("Cleaning up route rules...")
+	if err := t.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"strings"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+type gatewayToExternal struct {
+	*tutil.Environment
+	// whether the cluster can reach httpbin.org at all
+	connected bool
+}
+
+func (t *gatewayToExternal) String() string {
+	return "gateway-to-external"
+}
+
+func (t *gatewayToExternal) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelGateway, tutil.LabelEgress}
+}
+
+func (t *gatewayToExternal) Setup() error {
+	if !t.Config.V1alpha2 {
+		return nil
+	}
+	// t has no sidecar, so this only depends on the cluster network
+	resp := t.ClientRequest("t", "http://httpbin.org/headers", 1, "")
+	t.connected = resp.IsHTTPOk()
+	if !t.connected {
+		return nil
+	}
+	return t.ApplyConfig("v1alpha2/gateway-external.yaml.tmpl", nil)
+}
+
+// Run checks that a path on the gateway is proxied to an external service, with the authority
+// of the external host rather than the one the client used.
+func (t *gatewayToExternal) Run() error {
+	if !t.Config.V1alpha2 {
+		return tutil.Skip("v1alpha2 routing rules are disabled")
+	}
+	if !t.connected {
+		return tutil.Skip("the cluster cannot reach httpbin.org")
+	}
+
+	url := fmt.Sprintf("http://%s.%s/external", gatewayServiceName, t.Config.IstioNamespace)
+	return t.Eventually(tutil.DefaultBudget, map[string]func() tutil.Status{
+		"Gateway request to httpbin.org": func() tutil.Status {
+			resp := t.ClientRequest("t", url, 1, "-key Host -val external.example.com")
+			if !resp.IsHTTPOk() {
+				return tutil.ErrAgain
+			}
+			// httpbin echoes the request headers it received as JSON
+			if !strings.Contains(resp.Body, `"Host": "httpbin.org"`) {
+				log.Infof("httpbin.org did not receive its own authority: %s", resp.Body)
+				return tutil.ErrAgain
+			}
+			return nil
+		},
+	})
+}
+
+func (t *gatewayToExternal) Teardown() {
+	if !t.connected {
+		return
+	}
+	log.Info("Cleaning up gateway route rules...")
+	if err := t.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+}
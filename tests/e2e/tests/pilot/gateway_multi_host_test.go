@@ -0,0 +1,110 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	gatewayServiceName = "istio-gateway"
+)
+
+type multiHostVirtualService struct {
+	*tutil.Environment
+}
+
+func (t *multiHostVirtualService) String() string {
+	return "multi-host-virtual-service"
+}
+
+func (t *multiHostVirtualService) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelGateway, tutil.LabelRouting}
+}
+
+func (t *multiHostVirtualService) Setup() error {
+	if !t.Config.V1alpha2 {
+		return nil
+	}
+	return t.ApplyConfig("v1alpha2/gateway-multi-host.yaml.tmpl", nil)
+}
+
+// Run checks that a single VirtualService bound to a gateway serves each of its hosts
+// from the right backend, and that hosts it does not list are not routed at all.
+func (t *multiHostVirtualService) Run() error {
+	if !t.Config.V1alpha2 {
+		return tutil.Skip("v1alpha2 routing rules are disabled")
+	}
+
+	cases := []struct {
+		// empty destination to expect 404
+		dst  string
+		host string
+	}{
+		{"a", "a.multihost.example.com"},
+		{"b", "b.multihost.example.com"},
+		{"c", "c.multihost.example.com"},
+		{"", "d.multihost.example.com"},
+	}
+
+	url := fmt.Sprintf("http://%s.%s/multihost", gatewayServiceName, t.Config.IstioNamespace)
+	funcs := make(map[string]func() tutil.Status)
+	for _, cs := range cases {
+		name := fmt.Sprintf("Gateway request for host %s", cs.host)
+		funcs[name] = (func(dst, host string) func() tutil.Status {
+			return func() tutil.Status {
+				resp := t.ClientRequest("t", url, 1, "-key Host -val "+host)
+				if dst == "" {
+					if len(resp.Code) > 0 && resp.Code[0] == "404" {
+						return nil
+					}
+					return tutil.ErrAgain
+				}
+				if !resp.IsHTTPOk() || len(resp.Hostname) == 0 {
+					return tutil.ErrAgain
+				}
+				if !containsPod(t.Apps[dst], resp.Hostname[0]) {
+					log.Infof("Request for host %s reached pod %s, want app %s", host, resp.Hostname[0], dst)
+					return tutil.ErrAgain
+				}
+				return nil
+			}
+		})(cs.dst, cs.host)
+	}
+	return t.Eventually(tutil.DefaultBudget, funcs)
+}
+
+func (t *multiHostVirtualService) Teardown() {
+	if !t.Config.V1alpha2 {
+		return
+	}
+	log.Info("Cleaning up gateway route rules...")
+	if err := t.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+}
+
+// containsPod returns true if the pod name is one of the given pods
+func containsPod(pods []string, pod string) bool {
+	for _, p := range pods {
+		if p == pod {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+	"istio.io/istio/tests/e2e/tests/pilot/util/builder"
+)
+
+const (
+	multiServerGateway = "multi-server-gateway"
+	// hosts of the HTTP server of the gateway, each with a virtual service of its own
+	multiServerHostA    = "a.multiserver.example.com"
+	multiServerHostB    = "b.multiserver.example.com"
+	multiServerWildcard = "*.wild.multiserver.example.com"
+	// host of the HTTPS server of the gateway only
+	multiServerSecureHost = "secure.multiserver.example.com"
+)
+
+// gatewayMultiServer binds several virtual services to the servers of a single gateway: an HTTP server
+// of a host routed to a, one routed to b and a wildcard host routed to c, and an HTTPS server of a host
+// routed to b. The HTTP server redirecting to HTTPS is not covered: Pilot does not implement
+// httpsRedirect, and puts an SSL context on the listener of any HTTP server with TLS options. Nor is a
+// TCP server: Pilot skips them when it builds the gateway listeners.
+type gatewayMultiServer struct {
+	*tutil.Environment
+}
+
+func (t *gatewayMultiServer) String() string {
+	return "gateway-multi-server"
+}
+
+func (t *gatewayMultiServer) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelGateway, tutil.LabelRouting}
+}
+
+func (t *gatewayMultiServer) configs() []builder.Builder {
+	route := func(name, host, dst string) builder.Builder {
+		return builder.VirtualService(name, host).Gateways(multiServerGateway).
+			HTTP(builder.HTTPRoute().Route(dst, "", 0))
+	}
+	return []builder.Builder{
+		builder.Gateway(multiServerGateway).
+			Server(builder.Port(80, "http", "HTTP"), multiServerHostA, multiServerHostB, multiServerWildcard).
+			TLSServer(builder.Port(443, "https", "HTTPS"),
+				builder.SimpleTLS("/etc/istio/gateway-certs/tls.crt", "/etc/istio/gateway-certs/tls.key"),
+				multiServerSecureHost),
+		route("multi-server-a", multiServerHostA, "a"),
+		route("multi-server-b", multiServerHostB, "b"),
+		route("multi-server-wildcard", multiServerWildcard, "c"),
+		route("multi-server-secure", multiServerSecureHost, "b"),
+	}
+}
+
+func (t *gatewayMultiServer) Setup() error {
+	if !t.Config.V1alpha2 {
+		return nil
+	}
+	return builder.ApplyAndWait(t.Environment, "v1alpha2/gateway-multi-server", t.configs()...)
+}
+
+// Run checks that each host of the gateway is served by its backend on the port of its server only,
+// that the wildcard host serves any of its subdomains, and that the gateway answers 404 for the
+// hosts of no server.
+func (t *gatewayMultiServer) Run() error {
+	if !t.Config.V1alpha2 {
+		return tutil.Skip("v1alpha2 routing rules are disabled")
+	}
+
+	gateway := fmt.Sprintf("%s.%s", gatewayServiceName, t.Config.IstioNamespace)
+	cases := []struct {
+		url  string
+		host string
+		// empty destination to expect 404
+		dst string
+	}{
+		{"http://" + gateway + "/multiserver", multiServerHostA, "a"},
+		{"http://" + gateway + "/multiserver", multiServerHostB, "b"},
+		{"http://" + gateway + "/multiserver", "one.wild.multiserver.example.com", "c"},
+		{"http://" + gateway + "/multiserver", "two.wild.multiserver.example.com", "c"},
+		{"http://" + gateway + "/multiserver", "unknown.multiserver.example.com", ""},
+		{"http://" + gateway + "/multiserver", multiServerSecureHost, ""},
+		{"https://" + gateway + ":443/multiserver", multiServerSecureHost, "b"},
+	}
+
+	funcs := make(map[string]func() tutil.Status)
+	for _, cs := range cases {
+		name := fmt.Sprintf("Gateway request %s for host %s", cs.url, cs.host)
+		funcs[name] = (func(url, host, dst string) func() tutil.Status {
+			return func() tutil.Status {
+				resp := t.ClientRequest("t", url, 1, "-key Host -val "+host)
+				if dst == "" {
+					if len(resp.Code) > 0 && resp.Code[0] == "404" {
+						return nil
+					}
+					return tutil.ErrAgain
+				}
+				if !resp.IsHTTPOk() || len(resp.Hostname) == 0 {
+					return tutil.ErrAgain
+				}
+				if !containsPod(t.Apps[dst], resp.Hostname[0]) {
+					log.Infof("Request %s for host %s reached pod %s, want app %s", url, host, resp.Hostname[0], dst)
+					return tutil.ErrAgain
+				}
+				return nil
+			}
+		})(cs.url, cs.host, cs.dst)
+	}
+	return t.Eventually(tutil.DefaultBudget, funcs)
+}
+
+func (t *gatewayMultiServer) Teardown() {
+	if !t.Config.V1alpha2 {
+		return
+	}
+	log.Info("Cleaning up gateway route rules...")
+	if err := t.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+	"istio.io/istio/tests/e2e/tests/pilot/util/builder"
+)
+
+const (
+	gatewayScalingGateway = "scaling-gateway"
+	gatewayScalingHost    = "scaling.example.com"
+	// replicas of the gateway during the test, enough for the rolling update to keep some of them
+	gatewayScalingReplicas = 3
+	// number of requests sent to each replica, and at once through the gateway service
+	gatewayScalingSamples = 10
+	// traffic through the gateway service once the rollout is over, to the replaced replicas
+	gatewayScalingSettle = 5 * time.Second
+	// share of the requests allowed to fail during the rolling update, while the new replicas get
+	// their config
+	gatewayScalingFailureShare = 0.05
+)
+
+// gatewayScaling scales the gateway to several replicas serving an HTTP and an HTTPS server of a host
+// routed to c-v2, and checks that every replica routes the host alike, before and after a rolling update
+// of the gateway, and that the traffic through the gateway service keeps being routed during it.
+type gatewayScaling struct {
+	*tutil.Environment
+}
+
+func (t *gatewayScaling) String() string {
+	return "gateway-scaling"
+}
+
+func (t *gatewayScaling) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelGateway, tutil.LabelSlow}
+}
+
+func (t *gatewayScaling) configs() []builder.Builder {
+	return []builder.Builder{
+		builder.Gateway(gatewayScalingGateway).
+			Server(builder.Port(80, "http", "HTTP"), gatewayScalingHost).
+			TLSServer(builder.Port(443, "https", "HTTPS"),
+				builder.SimpleTLS("/etc/istio/gateway-certs/tls.crt", "/etc/istio/gateway-certs/tls.key"),
+				gatewayScalingHost),
+		builder.DestinationRule("destination-c", "c").VersionSubsets("v1", "v2"),
+		builder.VirtualService("gateway-scaling", gatewayScalingHost).Gateways(gatewayScalingGateway).
+			HTTP(builder.HTTPRoute().Route("c", "v2", 0)),
+	}
+}
+
+func (t *gatewayScaling) Setup() error {
+	if !t.Config.V1alpha2 {
+		return nil
+	}
+	if err := t.ScaleGateway(gatewayScalingReplicas); err != nil {
+		return err
+	}
+	return builder.ApplyAndWait(t.Environment, "v1alpha2/gateway-scaling", t.configs()...)
+}
+
+// Run checks the replicas, rolls the gateway out while sending requests through the gateway service,
+// and checks the replicas that replaced them.
+func (t *gatewayScaling) Run() error {
+	if !t.Config.V1alpha2 {
+		return tutil.Skip("v1alpha2 routing rules are disabled")
+	}
+	if err := t.checkReplicas(); err != nil {
+		return err
+	}
+	if err := t.rollOut(); err != nil {
+		return err
+	}
+	return t.checkReplicas()
+}
+
+// checkReplicas checks that each of the replicas of the gateway routes the host to c-v2 over HTTP and
+// HTTPS, sending the requests to the address of its pod.
+func (t *gatewayScaling) checkReplicas() error {
+	var (
+		pods  []string
+		funcs map[string]func() tutil.Status
+	)
+	err := tutil.Repeat(func() error {
+		replicas, err := t.GatewayPods()
+		if err != nil {
+			return err
+		}
+		if len(replicas) != gatewayScalingReplicas {
+			return fmt.Errorf("the gateway has %d ready replicas, want %d", len(replicas), gatewayScalingReplicas)
+		}
+		pods = nil
+		funcs = make(map[string]func() tutil.Status)
+		for i := range replicas {
+			pod := &replicas[i]
+			pods = append(pods, pod.Name)
+			for _, url := range []string{"http://" + pod.Status.PodIP + "/scaling",
+				"https://" + pod.Status.PodIP + ":443/scaling"} {
+				name := fmt.Sprintf("Gateway replica %s request %s for host %s", pod.Name, url, gatewayScalingHost)
+				funcs[name] = t.expectV2(url)
+			}
+		}
+		return nil
+	}, 30, time.Second)
+	if err != nil {
+		return err
+	}
+	log.Infof("Checking the routing of the gateway replicas %v", pods)
+	return t.Eventually(tutil.DefaultBudget, funcs)
+}
+
+// expectV2 returns a check that the requests from t to the url for the host all reach c-v2.
+func (t *gatewayScaling) expectV2(url string) func() tutil.Status {
+	return func() tutil.Status {
+		requests, err := t.ClientRequests("t", url, gatewayScalingSamples, "-key Host -val "+gatewayScalingHost)
+		if err != nil {
+			return err
+		}
+		if err = requests.ExpectDistribution(map[string]int{"v2": 100}, 0); err != nil {
+			log.Infof("%s for host %s: %v", url, gatewayScalingHost, err)
+			return tutil.ErrAgain
+		}
+		return nil
+	}
+}
+
+// rollOut sends requests from t through the gateway service while the gateway is rolled out, and checks
+// that none of them reached another version than c-v2 and that no more than gatewayScalingFailureShare
+// of them failed.
+func (t *gatewayScaling) rollOut() error {
+	url := fmt.Sprintf("http://%s.%s/scaling", gatewayServiceName, t.Config.IstioNamespace)
+	var (
+		mutex     sync.Mutex
+		requests  tutil.Requests
+		clientErr error
+		wg        sync.WaitGroup
+	)
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			batch, err := t.ClientRequests("t", url, gatewayScalingSamples, "-key Host -val "+gatewayScalingHost)
+			mutex.Lock()
+			if err != nil {
+				clientErr = err
+			}
+			requests = append(requests, batch...)
+			mutex.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	err := t.RollGateway()
+	if err == nil {
+		if err = t.WaitForGatewayRollout(); err == nil {
+			time.Sleep(gatewayScalingSettle)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+	if clientErr != nil {
+		return clientErr
+	}
+	if len(requests) == 0 {
+		return fmt.Errorf("no request was sent through the gateway during its rollout")
+	}
+
+	failures := 0
+	for i := range requests {
+		if !requests[i].Reached() {
+			failures++
+		} else if requests[i].Version != "v2" {
+			return fmt.Errorf("%v during the rollout of the gateway, want c-v2", &requests[i])
+		}
+	}
+	log.Infof("%d/%d requests through the gateway failed during its rollout", failures, len(requests))
+	if share := float64(failures) / float64(len(requests)); share > gatewayScalingFailureShare {
+		return fmt.Errorf("%d/%d requests through the gateway failed during its rollout, want at most %.0f%%",
+			failures, len(requests), gatewayScalingFailureShare*100)
+	}
+	return nil
+}
+
+func (t *gatewayScaling) Teardown() {
+	if !t.Config.V1alpha2 {
+		return
+	}
+	log.Info("Cleaning up gateway route rules...")
+	if err := t.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+	if err := t.ScaleGateway(1); err != nil {
+		log.Warna(err)
+	}
+	// the pods of the gateway were replaced
+	if err := t.RefreshApps(); err != nil {
+		log.Warna(err)
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	// time given to the streaming request to reach a backend before it is looked for
+	gracefulDrainLead = 10 * time.Second
+	// maximum number of 1s polls waiting for the deleted pod to disappear
+	gracefulDrainDeleteBudget = 60
+	// requests from a to c per batch of the steady traffic
+	gracefulDrainBatch = 5
+	// steady traffic sent before the deletion, and after the endpoint update SLO
+	gracefulDrainWarmup = 5 * time.Second
+)
+
+// drainBatch is a batch of the steady traffic from a to c.
+type drainBatch struct {
+	start, end time.Time
+	failed     int
+	// reachedDeleted is true if a request of the batch was served by the deleted pod
+	reachedDeleted bool
+}
+
+// gracefulDrain deletes a pod of c serving a long-lived stream from a, under steady traffic from a to c.
+// The stream in flight must complete while the pod drains, and the new requests must shift to the other
+// pods within -endpoint-update-slo, failing for no longer than -drain-blip-budget.
+type gracefulDrain struct {
+	*tutil.Environment
+	// backend pod deleted by Run, replaced by its deployment
+	deleted string
+}
+
+func (t *gracefulDrain) String() string {
+	return "graceful-drain"
+}
+
+func (t *gracefulDrain) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelReachability, tutil.LabelSlow}
+}
+
+func (t *gracefulDrain) Setup() error {
+	return nil
+}
+
+// Teardown waits for the deleted pod to be gone, so that later tests only target its replacement.
+func (t *gracefulDrain) Teardown() {
+	if t.deleted == "" {
+		return
+	}
+	if err := waitForPodGone(t.Environment, t.deleted, gracefulDrainDeleteBudget); err != nil {
+		log.Warna(err)
+	}
+	if err := t.RefreshApps(); err != nil {
+		log.Warna(err)
+	}
+	t.deleted = ""
+}
+
+// Run deletes the pod of "c" serving a streaming request from "a" while the steady traffic runs, until the
+// endpoint update SLO is over, and checks that the stream completes while new requests shift to the other
+// pods of "c" within the SLO and the blip budget.
+func (t *gracefulDrain) Run() error {
+	if len(t.Apps["c"]) < 2 {
+		return tutil.Skip("c has a single replica")
+	}
+
+	stream := gracefulDrainLead + t.Config.DrainWait
+	url := fmt.Sprintf("http://c/a?stream=%v", stream)
+	done := make(chan tutil.Response, 1)
+	go func() {
+		done <- t.ClientRequest("a", url, 1, fmt.Sprintf("-timeout %v", stream+time.Minute))
+	}()
+
+	pod, err := streamingPod(t.Environment, "c")
+	if err != nil {
+		<-done
+		return err
+	}
+
+	var (
+		mutex   sync.Mutex
+		batches []drainBatch
+		wg      sync.WaitGroup
+	)
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			batch := drainBatch{start: time.Now()}
+			resp := t.ClientRequest("a", "http://c/a", gracefulDrainBatch, "")
+			batch.end = time.Now()
+			batch.failed = gracefulDrainBatch
+			for _, code := range resp.Code {
+				if code == "200" {
+					batch.failed--
+				}
+			}
+			batch.reachedDeleted = containsPod(resp.Hostname, pod)
+			mutex.Lock()
+			batches = append(batches, batch)
+			mutex.Unlock()
+		}
+	}()
+
+	time.Sleep(gracefulDrainWarmup)
+	log.Infof("Deleting pod %s while it serves a streaming request", pod)
+	deleted := time.Now()
+	err = t.KubeClient.CoreV1().Pods(t.Config.Namespace).Delete(pod, &metav1.DeleteOptions{})
+	if err == nil {
+		t.deleted = pod
+		time.Sleep(t.Config.EndpointUpdateSLO + gracefulDrainWarmup)
+	}
+	close(stop)
+	wg.Wait()
+
+	resp := <-done
+	if err != nil {
+		return err
+	}
+	if strings.Contains(resp.Body, "connection reset") {
+		return fmt.Errorf("streaming request was reset while %s was draining:\n%s", pod, resp.Body)
+	}
+	if !resp.IsHTTPOk() {
+		return fmt.Errorf("streaming request failed while %s was draining: %v", pod, resp.Code)
+	}
+	if got, want := strings.Count(resp.Body, "Stream="), int(stream/time.Second); got != want {
+		return fmt.Errorf("streaming request got %d out of %d lines while %s was draining", got, want, pod)
+	}
+
+	shift, blip, settled := drainTimes(batches, deleted)
+	log.Infof("New requests from a to c shifted from %s after %v, and failed for %v", pod, shift, blip)
+	if !settled {
+		return fmt.Errorf("requests from a to c still failed or reached %s %v after its deletion", pod,
+			t.Config.EndpointUpdateSLO+gracefulDrainWarmup)
+	}
+	if shift > t.Config.EndpointUpdateSLO {
+		return fmt.Errorf("requests from a to c reached %s for %v after its deletion, above %v", pod, shift,
+			t.Config.EndpointUpdateSLO)
+	}
+	if blip > t.Config.DrainBlipBudget {
+		return fmt.Errorf("requests from a to c failed for %v while %s was deleted, above %v", blip, pod,
+			t.Config.DrainBlipBudget)
+	}
+	return nil
+}
+
+// drainTimes returns, over the batches ending after the deletion, the time from the deletion to the end
+// of the last batch that failed or reached the deleted pod, the time from the first failed request to
+// the last one, and whether the last batch succeeded without the deleted pod.
+func drainTimes(batches []drainBatch, deleted time.Time) (shift, blip time.Duration, settled bool) {
+	var firstFailed, lastFailed time.Time
+	for _, batch := range batches {
+		if batch.end.Before(deleted) {
+			continue
+		}
+		settled = batch.failed == 0 && !batch.reachedDeleted
+		if !settled {
+			shift = batch.end.Sub(deleted)
+		}
+		if batch.failed > 0 {
+			if firstFailed.IsZero() {
+				firstFailed = batch.start
+				if firstFailed.Before(deleted) {
+					firstFailed = deleted
+				}
+			}
+			lastFailed = batch.end
+		}
+	}
+	if !firstFailed.IsZero() {
+		blip = lastFailed.Sub(firstFailed)
+	}
+	return shift, blip, settled
+}
+
+// waitForPodGone waits for the deleted pod of the app namespace to be gone, checking it up to attempts
+// times a second apart.
+func waitForPodGone(env *tutil.Environment, name string, attempts int) error {
+	return env.Eventually(tutil.DefaultBudget.Attempting(attempts), map[string]func() tutil.Status{
+		fmt.Sprintf("Pod %s gone", name): func() tutil.Status {
+			if _, err := env.KubeClient.CoreV1().Pods(env.Config.Namespace).Get(name, metav1.GetOptions{}); err == nil {
+				return tutil.ErrAgain
+			}
+			return nil
+		},
+	})
+}
+
+// streamingPod finds the pod of the app whose sidecar has a request to the app in flight.
+func streamingPod(env *tutil.Environment, app string) (string, error) {
+	var pod string
+	err := tutil.Repeat(func() error {
+		for _, candidate := range env.Apps[app] {
+			stats, err := env.PodProxyStats(candidate)
+			if err != nil {
+				return err
+			}
+			if sumStats(stats, "cluster.in.", ".upstream_rq_active") > 0 {
+				pod = candidate
+				return nil
+			}
+		}
+		return fmt.Errorf("no pod of %s is serving the streaming request", app)
+	}, int(gracefulDrainLead/time.Second), time.Second)
+	return pod, err
+}
@@ -0,0 +1,132 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	meshconfig "istio.io/api/mesh/v1alpha1"
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	// health status of the services the test apps serve, as the client logs it
+	grpcHealthServing = "HealthStatus=SERVING"
+	// health checks sent to c while its version is routed
+	grpcHealthSamples = 10
+)
+
+// grpcHealth checks grpc.health.v1 through the sidecars, on both gRPC ports, and that the health checks
+// follow the routes of the DestinationRule subsets like the other gRPC calls. The test apps serve the
+// health of a service named after their version, which only the pods of that version report SERVING.
+type grpcHealth struct {
+	*tutil.Environment
+}
+
+func (t *grpcHealth) String() string {
+	return "grpc-health"
+}
+
+func (t *grpcHealth) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelReachability, tutil.LabelRouting}
+}
+
+func (t *grpcHealth) Setup() error {
+	return nil
+}
+
+func (t *grpcHealth) Teardown() {
+	if !t.Config.V1alpha2 {
+		return
+	}
+	if err := t.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+}
+
+// Run checks the health of the servers and of the echo service of b and d from a, and from t without
+// auth, then routes c to v2 and checks that the health checks of c only reach v2.
+func (t *grpcHealth) Run() error {
+	srcPods := []string{"a"}
+	if t.Auth == meshconfig.MeshConfig_NONE {
+		// t is not behind proxy, so it cannot talk in Istio auth.
+		srcPods = append(srcPods, "t")
+	}
+	funcs := make(map[string]func() tutil.Status)
+	for _, src := range srcPods {
+		for _, dst := range []string{"b", "d"} {
+			for _, port := range []string{":70", ":7070"} {
+				if src == "t" && dst == "d" && port == ":7070" {
+					// d:7070 has mTLS enabled per service
+					continue
+				}
+				for _, service := range []string{"", "grpecho.EchoTestService"} {
+					name := fmt.Sprintf("gRPC health of %q from %s to %s%s", service, src, dst, port)
+					funcs[name] = (func(src, dst, port, service string) func() tutil.Status {
+						return func() tutil.Status {
+							resp := t.healthCheck(src, fmt.Sprintf("grpc://%s%s", dst, port), service, 1)
+							if !strings.Contains(resp.Body, grpcHealthServing) {
+								return tutil.ErrAgain
+							}
+							return nil
+						}
+					})(src, dst, port, service)
+				}
+			}
+		}
+	}
+	if err := t.Eventually(tutil.DefaultBudget, funcs); err != nil {
+		return err
+	}
+
+	if !t.Config.V1alpha2 {
+		return tutil.Skip("v1alpha2 routing rules are disabled")
+	}
+	tutil.Tlog("Checking grpcHealth test", "health checks of c follow its routes to v2")
+	if err := t.ApplyConfig(subsetsConfig, map[string]interface{}{
+		"Service": "c",
+		"Subsets": []subsetWeight{{Name: "v2", Weight: 100}},
+	}); err != nil {
+		return err
+	}
+	return tutil.Repeat(func() error {
+		for _, port := range []string{":70", ":7070"} {
+			url := "grpc://c" + port
+			resp := t.healthCheck("a", url, "v2", grpcHealthSamples)
+			if serving := strings.Count(resp.Body, grpcHealthServing); serving != grpcHealthSamples {
+				return fmt.Errorf("%d of %d health checks of v2 from a to %s were SERVING", serving,
+					grpcHealthSamples, url)
+			}
+			// v2 does not know the service of v1, failing the checks
+			resp = t.healthCheck("a", url, "v1", grpcHealthSamples)
+			if strings.Contains(resp.Body, grpcHealthServing) {
+				return fmt.Errorf("health check of v1 from a to %s was SERVING with c routed to v2", url)
+			}
+		}
+		return nil
+	}, 5, time.Second)
+}
+
+// healthCheck sends health checks of the service from the app to the gRPC URL.
+func (t *grpcHealth) healthCheck(src, url, service string, count int) tutil.Response {
+	extra := "-health"
+	if service != "" {
+		extra += " -health-service " + service
+	}
+	return t.ClientRequest(src, url, count, extra)
+}
@@ -16,11 +16,18 @@ package pilot
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	meshconfig "istio.io/api/mesh/v1alpha1"
 	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
 )
 
+const (
+	// number of messages of each gRPC stream
+	grpcStreamMessages = 5
+)
+
 type grpc struct {
 	*tutil.Environment
 	logs *accessLogs
@@ -30,6 +37,10 @@ func (t *grpc) String() string {
 	return "http2-reachability"
 }
 
+func (t *grpc) Labels() []string {
+	return []string{tutil.LabelSmoke, tutil.LabelFull, tutil.LabelReachability}
+}
+
 func (t *grpc) Setup() error {
 	t.logs = makeAccessLogs()
 	return nil
@@ -42,9 +53,25 @@ func (t *grpc) Run() error {
 	if err := t.makeRequests(); err != nil {
 		return err
 	}
+	if err := t.makeStreamRequests(); err != nil {
+		return err
+	}
 	return t.logs.check(t.Environment)
 }
 
+// BenchmarkRun measures the latency of gRPC requests from a to b, in both gRPC ports.
+func (t *grpc) BenchmarkRun() (map[string][]time.Duration, error) {
+	out := make(map[string][]time.Duration)
+	for _, url := range []string{"grpc://b:70", "grpc://b:7070"} {
+		latencies, err := measureLatencies(t.Environment, "a", url)
+		if err != nil {
+			return nil, err
+		}
+		out["a->"+url] = latencies
+	}
+	return out, nil
+}
+
 func (t *grpc) makeRequests() error {
 	// Auth is enabled for d:7070 using per-service policy. We expect request
 	// from non-envoy client ("t") should fail all the time.
@@ -102,5 +129,31 @@ func (t *grpc) makeRequests() error {
 			}
 		}
 	}
-	return tutil.Parallel(funcs)
+	return t.Eventually(tutil.DefaultBudget, funcs)
+}
+
+// makeStreamRequests makes server, client and bidi streaming calls of grpcStreamMessages messages
+// from a to b and d, including d:7070 where auth is enabled per service. The client checks the
+// message counts and that every stream ends properly, and only reports the count once it did.
+func (t *grpc) makeStreamRequests() error {
+	funcs := make(map[string]func() tutil.Status)
+	for _, dst := range []string{"b", "d"} {
+		for _, port := range []string{":70", ":7070"} {
+			for _, stream := range []string{"server", "client", "bidi"} {
+				name := fmt.Sprintf("GRPC %s stream from a to %s%s", stream, dst, port)
+				funcs[name] = (func(dst, port, stream string) func() tutil.Status {
+					url := fmt.Sprintf("grpc://%s%s", dst, port)
+					extra := fmt.Sprintf("-stream %s -messages %d", stream, grpcStreamMessages)
+					return func() tutil.Status {
+						resp := t.ClientRequest("a", url, 1, extra)
+						if !strings.Contains(resp.Body, fmt.Sprintf("StreamMessages=%d", grpcStreamMessages)) {
+							return tutil.ErrAgain
+						}
+						return nil
+					}
+				})(dst, port, stream)
+			}
+		}
+	}
+	return t.Eventually(tutil.DefaultBudget, funcs)
 }
@@ -0,0 +1,126 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"time"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	headerManipulationConfig = "v1alpha2/rule-header-manipulation.yaml.tmpl"
+	// canonical name, as the backend reports it
+	headerManipulationName  = "X-Manipulated"
+	headerManipulationValue = "route"
+	// value of the header sent by the client, which the route must keep
+	headerManipulationClient = "client"
+)
+
+// headerManipulation checks the request headers a route appends, from the headers the backend reports in
+// its JSON payload. VirtualServices only support appending request headers at the route level in this
+// version: setting and removing headers, response headers and headers per weighted destination are not
+// part of the API yet.
+type headerManipulation struct {
+	*tutil.Environment
+}
+
+func (t *headerManipulation) String() string {
+	return "header-manipulation"
+}
+
+func (t *headerManipulation) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelRouting}
+}
+
+func (t *headerManipulation) Setup() error {
+	if !t.Config.V1alpha2 {
+		return nil
+	}
+	return t.ApplyConfig(headerManipulationConfig, map[string]string{
+		"Header": headerManipulationName,
+		"Value":  headerManipulationValue,
+	})
+}
+
+// Run checks that the backend receives the appended header, and that appending keeps the value
+// the client sent for the same header.
+func (t *headerManipulation) Run() error {
+	if !t.Config.V1alpha2 {
+		return tutil.Skip("v1alpha2 routing rules are disabled")
+	}
+
+	cases := []struct {
+		name  string
+		extra string
+		want  []string
+	}{
+		{"without the header", "", []string{headerManipulationValue}},
+		{"with the header", fmt.Sprintf("-key %s -val %s", headerManipulationName, headerManipulationClient),
+			[]string{headerManipulationClient, headerManipulationValue}},
+	}
+	for _, cs := range cases {
+		tutil.Tlog("Checking header manipulation", cs.name)
+		extra, want := cs.extra, cs.want
+		err := tutil.Repeat(func() error {
+			return t.verifyHeader(extra, want)
+		}, 5, time.Second)
+		if err != nil {
+			return fmt.Errorf("request %s: %v", cs.name, err)
+		}
+	}
+	return nil
+}
+
+// verifyHeader checks that c received exactly the given values of the header, in any order.
+func (t *headerManipulation) verifyHeader(extra string, want []string) error {
+	resp := t.ClientRequest("a", "http://c/a?format=json", 1, extra)
+	if !resp.IsHTTPOk() {
+		return fmt.Errorf("request from a to c failed: %v", resp.Code)
+	}
+	payloads, err := resp.EchoPayloads()
+	if err != nil {
+		return err
+	}
+	if len(payloads) != 1 {
+		return fmt.Errorf("got %d echo payloads, want 1", len(payloads))
+	}
+	got := payloads[0].Headers[headerManipulationName]
+	counts := make(map[string]int)
+	for _, value := range got {
+		counts[value]++
+	}
+	for _, value := range want {
+		counts[value]--
+	}
+	for _, count := range counts {
+		if count != 0 {
+			return fmt.Errorf("c received %s: %v, want %v", headerManipulationName, got, want)
+		}
+	}
+	return nil
+}
+
+func (t *headerManipulation) Teardown() {
+	if !t.Config.V1alpha2 {
+		return
+	}
+	log.Info("Cleaning up route rules...")
+	if err := t.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+}
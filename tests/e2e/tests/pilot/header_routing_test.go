@@ -0,0 +1,101 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	// number of requests sent for each case
+	headerRoutingSamples = 10
+	// Go-escaped for the raw client, which does not canonicalize header names like the HTTP client does
+	headerRoutingUpperCaseMsg = `GET\x20/a\x20HTTP/1.1\r\nHost:\x20c\r\nX-VERSION:\x20v2\r\n\r\n`
+)
+
+type headerRouting struct {
+	*tutil.Environment
+}
+
+func (t *headerRouting) String() string {
+	return "header-routing"
+}
+
+func (t *headerRouting) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelRouting}
+}
+
+func (t *headerRouting) Setup() error {
+	if !t.Config.V1alpha2 {
+		return nil
+	}
+	if err := t.ApplyConfig("v1alpha2/destination-rule-c.yaml.tmpl", nil); err != nil {
+		return err
+	}
+	return t.ApplyConfig("v1alpha2/rule-header-route.yaml.tmpl", nil)
+}
+
+// Run checks that requests from "a" to "c" with the header x-version: v2 reach c-v2, whatever
+// the case of the header name, and that all other requests reach c-v1.
+func (t *headerRouting) Run() error {
+	if !t.Config.V1alpha2 {
+		return tutil.Skip("v1alpha2 routing rules are disabled")
+	}
+
+	cases := []struct {
+		description string
+		url         string
+		extra       string
+		version     string
+	}{
+		{"with x-version: v2", "http://c/a", "-key x-version -val v2", "v2"},
+		{"without x-version", "http://c/a", "", "v1"},
+		{"with x-version: v3", "http://c/a", "-key x-version -val v3", "v1"},
+		{"with X-VERSION: v2", "raw://c:80", "-msg " + headerRoutingUpperCaseMsg, "v2"},
+	}
+	funcs := make(map[string]func() tutil.Status)
+	for _, cs := range cases {
+		name := fmt.Sprintf("Request from a to c %s", cs.description)
+		funcs[name] = (func(url, extra, version string) func() tutil.Status {
+			return func() tutil.Status {
+				resp := t.ClientRequest("a", url, headerRoutingSamples, extra)
+				if len(resp.Version) != headerRoutingSamples {
+					return tutil.ErrAgain
+				}
+				for _, v := range resp.Version {
+					if v != version {
+						log.Infof("got versions %v, want %s", resp.Version, version)
+						return tutil.ErrAgain
+					}
+				}
+				return nil
+			}
+		})(cs.url, cs.extra, cs.version)
+	}
+	return t.Eventually(tutil.DefaultBudget, funcs)
+}
+
+func (t *headerRouting) Teardown() {
+	if !t.Config.V1alpha2 {
+		return
+	}
+	log.Info("Cleaning up route rules...")
+	if err := t.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+}
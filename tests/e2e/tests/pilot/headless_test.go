@@ -16,29 +16,81 @@ package pilot
 
 import (
 	"fmt"
+	"net"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	meshconfig "istio.io/api/mesh/v1alpha1"
+	"istio.io/istio/pkg/log"
 	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
 )
 
+const (
+	// stateful set of the test app governed by a headless service of the same name
+	headlessSet = "headless-set"
+	// pods of headlessSet
+	headlessSetReplicas = 2
+	// maximum number of 1s polls waiting for a pod of headlessSet to be replaced
+	headlessRestartBudget = 120
+)
+
+// headlessSetPorts are the ports of headlessSet, an HTTP and a TCP one.
+var headlessSetPorts = []string{"80", "10090"}
+
 type headless struct {
 	*tutil.Environment
+	deployed string
 }
 
 func (t *headless) String() string {
 	return "tcp-headless-reachability"
 }
 
+func (t *headless) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelReachability}
+}
+
+// Setup deploys headlessSet, whose pods have DNS names of their own.
 func (t *headless) Setup() error {
-	return nil
+	if t.Auth == meshconfig.MeshConfig_MUTUAL_TLS {
+		return nil
+	}
+	var err error
+	if t.deployed, err = t.DeployApp(tutil.AppSpec{
+		Deployment: headlessSet,
+		Version:    "v1",
+		Replicas:   headlessSetReplicas,
+		Ports: []tutil.AppPort{
+			{Name: "http", Port: 80},
+			{Name: "tcp", Port: 10090},
+		},
+		StatefulSet: true,
+	}); err != nil {
+		return err
+	}
+	return t.RefreshApps()
 }
 
 func (t *headless) Teardown() {
+	if t.deployed == "" {
+		return
+	}
+	if err := t.KubeDelete(t.deployed, t.Config.Namespace); err != nil {
+		log.Warna(err)
+	}
+	t.deployed = ""
+	if err := t.RefreshApps(); err != nil {
+		log.Warna(err)
+	}
 }
 
+// Run checks the TCP ports of the headless service of b, then that every pod of headlessSet is reached
+// on its HTTP and TCP ports by its DNS name and by its IP, also after each pod in turn is replaced and
+// comes back with a new IP.
 func (t *headless) Run() error {
 	if t.Auth == meshconfig.MeshConfig_MUTUAL_TLS {
-		return nil // TODO: mTLS
+		return tutil.Skip("headless services are not tested with mTLS") // TODO: mTLS
 	}
 
 	srcPods := []string{"a", "b", "t"}
@@ -63,5 +115,105 @@ func (t *headless) Run() error {
 			}
 		}
 	}
-	return tutil.Parallel(funcs)
+	if err := t.Eventually(tutil.DefaultBudget, funcs); err != nil {
+		return err
+	}
+
+	if err := t.verifyPods(); err != nil {
+		return err
+	}
+	for i := 0; i < headlessSetReplicas; i++ {
+		pod := fmt.Sprintf("%s-%d", headlessSet, i)
+		if err := t.restartPod(pod); err != nil {
+			return err
+		}
+		if err := t.verifyPods(); err != nil {
+			return fmt.Errorf("after the restart of %s: %v", pod, err)
+		}
+	}
+	return nil
+}
+
+// verifyPods checks that the requests from a and b, through their sidecars, and from t, reach each
+// pod of headlessSet when sent to its DNS name or to its current IP, on every port.
+func (t *headless) verifyPods() error {
+	funcs := make(map[string]func() tutil.Status)
+	for i := 0; i < headlessSetReplicas; i++ {
+		pod := fmt.Sprintf("%s-%d", headlessSet, i)
+		ip, err := podIP(t.Environment, pod)
+		if err != nil {
+			return err
+		}
+		for _, src := range []string{"a", "b", "t"} {
+			for _, port := range headlessSetPorts {
+				for _, host := range []string{
+					pod + "." + headlessSet,
+					pod + "." + headlessSet + "." + t.Config.Namespace,
+					ip,
+				} {
+					name := fmt.Sprintf("request from %s to %s:%s", src, host, port)
+					funcs[name] = (func(src, url, pod string) func() tutil.Status {
+						return func() tutil.Status {
+							resp := t.ClientRequest(src, url, 1, "")
+							if resp.IsHTTPOk() && len(resp.Hostname) == 1 && resp.Hostname[0] == pod {
+								return nil
+							}
+							return tutil.ErrAgain
+						}
+					})(src, fmt.Sprintf("http://%s/%s", net.JoinHostPort(host, port), src), pod)
+				}
+			}
+		}
+	}
+	return t.Eventually(tutil.DefaultBudget, funcs)
+}
+
+// podIP returns the IP of the pod of the app namespace.
+func podIP(t *tutil.Environment, name string) (string, error) {
+	pod, err := t.KubeClient.CoreV1().Pods(t.Config.Namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	if pod.Status.PodIP == "" {
+		return "", fmt.Errorf("pod %s has no IP", name)
+	}
+	return pod.Status.PodIP, nil
+}
+
+// restartPod deletes the pod of headlessSet and waits for the stateful set to bring it back ready,
+// under the same name.
+func (t *headless) restartPod(name string) error {
+	pods := t.KubeClient.CoreV1().Pods(t.Config.Namespace)
+	old, err := pods.Get(name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	log.Infof("Restarting pod %s (IP %s)", name, old.Status.PodIP)
+	if err = pods.Delete(name, &metav1.DeleteOptions{}); err != nil {
+		return err
+	}
+	err = t.Eventually(tutil.DefaultBudget.Attempting(headlessRestartBudget), map[string]func() tutil.Status{
+		fmt.Sprintf("Pod %s back", name): func() tutil.Status {
+			pod, getErr := pods.Get(name, metav1.GetOptions{})
+			if getErr != nil || pod.UID == old.UID || pod.Status.PodIP == "" || !podReady(pod) {
+				return tutil.ErrAgain
+			}
+			log.Infof("Pod %s is back with IP %s", name, pod.Status.PodIP)
+			return nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return t.RefreshApps()
+}
+
+// podReady returns true if all the containers of the pod are ready.
+func podReady(pod *v1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
 }
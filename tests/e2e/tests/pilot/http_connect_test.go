@@ -0,0 +1,99 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"strconv"
+
+	meshconfig "istio.io/api/mesh/v1alpha1"
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	// number of CONNECT requests, each on its own connection, that must get the same answer
+	httpConnectAttempts = 5
+	// Go-escaped for the raw client, which must not see spaces
+	httpConnectMsg = `CONNECT\x20b:80\x20HTTP/1.1\r\nHost:\x20b:80\r\n\r\n`
+)
+
+type httpConnect struct {
+	*tutil.Environment
+}
+
+func (t *httpConnect) String() string {
+	return "http-connect"
+}
+
+func (t *httpConnect) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelEgress}
+}
+
+func (t *httpConnect) Setup() error {
+	return nil
+}
+
+func (t *httpConnect) Teardown() {
+}
+
+// Run sends HTTP CONNECT requests through the sidecars and checks that they are either tunneled (200)
+// or rejected with an HTTP error, the same way every time, rather than having their connection dropped.
+func (t *httpConnect) Run() error {
+	srcPods := []string{"a"}
+	if t.Auth == meshconfig.MeshConfig_NONE {
+		// t is not behind proxy, so it only goes through the inbound sidecar of b
+		srcPods = append(srcPods, "t")
+	}
+
+	funcs := make(map[string]func() tutil.Status)
+	for _, src := range srcPods {
+		name := fmt.Sprintf("HTTP CONNECT from %s to b", src)
+		funcs[name] = (func(src string) func() tutil.Status {
+			return func() tutil.Status {
+				resp := t.ClientRequest(src, "raw://b:80", httpConnectAttempts, "-msg "+httpConnectMsg)
+				if len(resp.Code) != httpConnectAttempts {
+					log.Infof("%d out of %d CONNECT requests from %s got an HTTP response",
+						len(resp.Code), httpConnectAttempts, src)
+					return tutil.ErrAgain
+				}
+				for _, code := range resp.Code {
+					if code != resp.Code[0] {
+						return fmt.Errorf("CONNECT requests from %s got inconsistent responses %v", src, resp.Code)
+					}
+				}
+				status, err := strconv.Atoi(resp.Code[0])
+				if err != nil {
+					return err
+				}
+				if status != 200 && status < 400 {
+					return fmt.Errorf("CONNECT request from %s returned %d, want 200 or an error status", src, status)
+				}
+				log.Infof("CONNECT requests from %s consistently returned %d", src, status)
+				return nil
+			}
+		})(src)
+	}
+	if err := t.Eventually(tutil.DefaultBudget, funcs); err != nil {
+		return err
+	}
+
+	// the sidecars must keep serving regular requests
+	resp := t.ClientRequest("a", "http://b/a", 1, "")
+	if !resp.IsHTTPOk() {
+		return fmt.Errorf("request from a to b failed after CONNECT requests: %v", resp.Code)
+	}
+	return nil
+}
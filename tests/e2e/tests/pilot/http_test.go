@@ -18,11 +18,19 @@ package pilot
 
 import (
 	"fmt"
+	"time"
 
 	meshconfig "istio.io/api/mesh/v1alpha1"
 	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
 )
 
+const (
+	// number of requests sent to each target in benchmark mode
+	benchmarkRequests = 100
+	// number of requests the client sends concurrently in benchmark mode
+	benchmarkBatch = 10
+)
+
 type http struct {
 	*tutil.Environment
 	logs *accessLogs
@@ -32,6 +40,10 @@ func (r *http) String() string {
 	return "http-reachability"
 }
 
+func (r *http) Labels() []string {
+	return []string{tutil.LabelSmoke, tutil.LabelFull, tutil.LabelReachability}
+}
+
 func (r *http) Setup() error {
 	r.logs = makeAccessLogs()
 	return nil
@@ -47,6 +59,33 @@ func (r *http) Run() error {
 	return r.logs.check(r.Environment)
 }
 
+// BenchmarkRun measures the latency of HTTP requests from a to b, in both service ports.
+func (r *http) BenchmarkRun() (map[string][]time.Duration, error) {
+	out := make(map[string][]time.Duration)
+	for _, url := range []string{"http://b/a", "http://b:8080/a"} {
+		latencies, err := measureLatencies(r.Environment, "a", url)
+		if err != nil {
+			return nil, err
+		}
+		out["a->"+url] = latencies
+	}
+	return out, nil
+}
+
+// measureLatencies sends benchmarkRequests requests from src to url and returns their latencies.
+func measureLatencies(env *tutil.Environment, src, url string) ([]time.Duration, error) {
+	var latencies []time.Duration
+	for len(latencies) < benchmarkRequests {
+		resp := env.ClientRequest(src, url, benchmarkBatch, "")
+		if len(resp.Latency) != benchmarkBatch {
+			return nil, fmt.Errorf("only %d out of %d requests from %s to %s succeeded",
+				len(resp.Latency), benchmarkBatch, src, url)
+		}
+		latencies = append(latencies, resp.Latency...)
+	}
+	return latencies, nil
+}
+
 // makeRequests executes requests in pods and collects request ids per pod to check against access logs
 func (r *http) makeRequests() error {
 	// Auth is enabled for d:80, and disabled for d:8080 using per-service policy.
@@ -119,5 +158,5 @@ func (r *http) makeRequests() error {
 			}
 		}
 	}
-	return tutil.Parallel(funcs)
+	return r.Eventually(tutil.DefaultBudget, funcs)
 }
@@ -0,0 +1,86 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"time"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	// how long the connections from the sidecar of a to b stay idle between two requests
+	idleConnectionWait = 10 * time.Second
+	// idle periods checked, each ending with a request
+	idleConnectionRounds = 3
+)
+
+// idleConnections checks that the connections of the sidecar of a to b survive being idle and are
+// reused by the next request. It checks nothing else: the connection pool settings of destination rules
+// have neither an idle timeout nor TCP keepalive in this version, and Pilot configures neither in the
+// clusters it serves, so closing connections idle past a timeout is not tested, and the limits of the
+// pool are left to connectionPool.
+type idleConnections struct {
+	*tutil.Environment
+}
+
+func (t *idleConnections) String() string {
+	return "idle-connections"
+}
+
+func (t *idleConnections) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelReachability, tutil.LabelSlow}
+}
+
+func (t *idleConnections) Setup() error {
+	return nil
+}
+
+func (t *idleConnections) Teardown() {}
+
+// Run opens a connection from a to b, then lets it idle for idleConnectionWait before each request,
+// checking that the connection is still open and that the request opens no other.
+func (t *idleConnections) Run() error {
+	if resp := t.ClientRequest("a", "http://b/a", 1, ""); !resp.IsHTTPOk() {
+		return fmt.Errorf("request from a to b failed: %v", resp.Code)
+	}
+	prefix := fmt.Sprintf("cluster.out.b.%s.", t.Config.Namespace)
+	for i := 0; i < idleConnectionRounds; i++ {
+		time.Sleep(idleConnectionWait)
+		before, err := t.ProxyStats("a")
+		if err != nil {
+			return err
+		}
+		if active := sumStats(before, prefix, ".upstream_cx_active"); active == 0 {
+			return fmt.Errorf("the sidecar of a closed its connections to b after %v idle", idleConnectionWait)
+		}
+		if resp := t.ClientRequest("a", "http://b/a", 1, ""); !resp.IsHTTPOk() {
+			return fmt.Errorf("request from a to b failed after %v idle: %v", idleConnectionWait, resp.Code)
+		}
+		after, err := t.ProxyStats("a")
+		if err != nil {
+			return err
+		}
+		opened := sumStats(after, prefix, ".upstream_cx_total") - sumStats(before, prefix, ".upstream_cx_total")
+		log.Infof("request from a to b after %v idle opened %d connections", idleConnectionWait, opened)
+		if opened > 0 {
+			return fmt.Errorf("request from a to b after %v idle opened %d connections instead of reusing one",
+				idleConnectionWait, opened)
+		}
+	}
+	return nil
+}
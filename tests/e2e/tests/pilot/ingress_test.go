@@ -16,7 +16,9 @@ package pilot
 
 import (
 	"fmt"
+	"sort"
 	"strings"
+	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
@@ -32,18 +34,25 @@ type ingress struct {
 
 const (
 	ingressServiceName = "istio-ingress"
+	ingressClass       = "kubernetes.io/ingress.class"
+	// Ingress of ingress.yaml.tmpl with the same path on the hosts a.multi.com and b.multi.com
+	ingressMultiHost = "multi-host-ingress"
 )
 
 func (t *ingress) String() string {
 	return "ingress"
 }
 
+func (t *ingress) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelGateway, tutil.LabelRouting}
+}
+
 func (t *ingress) Setup() error {
 	if !t.Config.Ingress {
 		return nil
 	}
 	if serviceregistry.ServiceRegistry(t.Config.Registry) != serviceregistry.KubernetesRegistry {
-		return nil
+		return tutil.Skip("ingress is only supported by the Kubernetes registry")
 	}
 	t.logs = makeAccessLogs()
 
@@ -60,8 +69,7 @@ func (t *ingress) Setup() error {
 
 func (t *ingress) Run() error {
 	if !t.Config.Ingress {
-		log.Info("skipping test since ingress is missing")
-		return nil
+		return tutil.Skip("ingress is missing")
 	}
 	if serviceregistry.ServiceRegistry(t.Config.Registry) != serviceregistry.KubernetesRegistry {
 		return nil
@@ -89,6 +97,11 @@ func (t *ingress) Run() error {
 		{"a", fmt.Sprintf("grpcs://%s.%s:443", ingressServiceName, t.Config.IstioNamespace), "api.company.com"},
 		{"", fmt.Sprintf("http://%s.%s/notfound", ingressServiceName, t.Config.IstioNamespace), ""},
 		{"", fmt.Sprintf("http://%s.%s/foo", ingressServiceName, t.Config.IstioNamespace), ""},
+		{"a", fmt.Sprintf("http://%s.%s/multi", ingressServiceName, t.Config.IstioNamespace), "a.multi.com"},
+		{"b", fmt.Sprintf("http://%s.%s/multi", ingressServiceName, t.Config.IstioNamespace), "b.multi.com"},
+		{"b", fmt.Sprintf("https://%s.%s:443/secure", ingressServiceName, t.Config.IstioNamespace), "secure.multi.com"},
+		// the Ingress of another class is ignored
+		{"", fmt.Sprintf("http://%s.%s/other", ingressServiceName, t.Config.IstioNamespace), ""},
 	}
 	for _, req := range cases {
 		name := fmt.Sprintf("Ingress request to %+v", req)
@@ -120,10 +133,75 @@ func (t *ingress) Run() error {
 		})(req.dst, req.url, req.host)
 	}
 
-	if err := tutil.Parallel(funcs); err != nil {
+	if err := t.Eventually(tutil.DefaultBudget, funcs); err != nil {
+		return err
+	}
+	if err := t.logs.check(t.Environment); err != nil {
 		return err
 	}
-	return t.logs.check(t.Environment)
+	return t.checkUpdate()
+}
+
+// checkUpdate moves the host a.multi.com of an existing Ingress to b, and checks that the ingress picks
+// it up without restarting.
+func (t *ingress) checkUpdate() error {
+	before, err := t.ingressPods()
+	if err != nil {
+		return err
+	}
+	ings := t.KubeClient.ExtensionsV1beta1().Ingresses(t.Config.Namespace)
+	ing, err := ings.Get(ingressMultiHost, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	for _, rule := range ing.Spec.Rules {
+		if rule.Host != "a.multi.com" || rule.HTTP == nil {
+			continue
+		}
+		for i := range rule.HTTP.Paths {
+			rule.HTTP.Paths[i].Backend.ServiceName = "b"
+		}
+	}
+	if _, err = ings.Update(ing); err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("http://%s.%s/multi", ingressServiceName, t.Config.IstioNamespace)
+	err = tutil.Repeat(func() error {
+		resp := t.ClientRequest("t", url, 1, "-key Host -val a.multi.com")
+		if len(resp.Hostname) == 0 || !containsPod(t.Apps["b"], resp.Hostname[0]) {
+			return fmt.Errorf("request to a.multi.com/multi not served by b after the update of %s: %v",
+				ingressMultiHost, resp.Hostname)
+		}
+		return nil
+	}, 10, time.Second)
+	if err != nil {
+		return err
+	}
+
+	after, err := t.ingressPods()
+	if err != nil {
+		return err
+	}
+	if strings.Join(before, ",") != strings.Join(after, ",") {
+		return fmt.Errorf("ingress pods went from %v to %v on the update of %s, want no restart", before,
+			after, ingressMultiHost)
+	}
+	return nil
+}
+
+// ingressPods returns the sorted UIDs of the ingress pods.
+func (t *ingress) ingressPods() ([]string, error) {
+	pods, err := t.KubeClient.CoreV1().Pods(t.Config.IstioNamespace).List(metav1.ListOptions{LabelSelector: "app=ingress"})
+	if err != nil {
+		return nil, err
+	}
+	uids := make([]string, 0, len(pods.Items))
+	for _, pod := range pods.Items {
+		uids = append(uids, string(pod.UID))
+	}
+	sort.Strings(uids)
+	return uids, nil
 }
 
 // checkRouteRule verifies that version splitting is applied to ingress paths
@@ -148,6 +226,10 @@ func (t *ingress) checkIngressStatus() tutil.Status {
 		return fmt.Errorf("ingress status failure: no ingress")
 	}
 	for _, ing := range ings.Items {
+		// only the Ingresses of the Istio class get a status from it
+		if ing.Annotations[ingressClass] != t.Config.IstioNamespace {
+			continue
+		}
 		if len(ing.Status.LoadBalancer.Ingress) == 0 {
 			return tutil.ErrAgain
 		}
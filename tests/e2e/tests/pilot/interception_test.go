@@ -0,0 +1,228 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	meshconfig "istio.io/api/mesh/v1alpha1"
+	"istio.io/istio/pilot/pkg/kube/inject"
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	// app whose sidecar only intercepts the outbound traffic to the service addresses
+	interceptionApp    = "intercept"
+	interceptionConfig = "v1alpha2/rule-fault-abort-b.yaml.tmpl"
+	// status of the requests to b aborted by the sidecars
+	interceptionAbortStatus = "418"
+)
+
+// interception covers the outbound IP ranges of the sidecar, the interception setting of this proxy:
+// the range restricted to the addresses of the services, traffic to b goes through the sidecar
+// and gets the fault of b, and traffic to the pod IP of b bypasses it. The TPROXY mode and the
+// excluded inbound ports are left out: prepare_proxy.sh only installs REDIRECT rules, for every
+// inbound port, and the injection parameters have neither an interception mode nor excluded ports.
+type interception struct {
+	*tutil.Environment
+	// outbound range of the sidecar of interceptionApp
+	ipRange  string
+	deployed string
+}
+
+func (t *interception) String() string {
+	return "interception"
+}
+
+func (t *interception) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelReachability, tutil.LabelRouting}
+}
+
+func (t *interception) Setup() error {
+	if !t.Config.V1alpha2 {
+		return nil
+	}
+	services, err := t.KubeClient.CoreV1().Services(t.Config.Namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	var ipv4, ipv6 []net.IP
+	for _, svc := range services.Items {
+		ip := net.ParseIP(svc.Spec.ClusterIP)
+		switch {
+		case ip == nil:
+			// headless
+		case ip.To4() != nil:
+			ipv4 = append(ipv4, ip.To4())
+		default:
+			ipv6 = append(ipv6, ip)
+		}
+	}
+	// one range per family of the cluster, which the init container splits between iptables and ip6tables
+	var ranges []string
+	for _, family := range []struct {
+		name      string
+		enabled   bool
+		addresses []net.IP
+	}{
+		{"IPv4", t.Config.HasIPv4(), ipv4},
+		{"IPv6", t.Config.HasIPv6(), ipv6},
+	} {
+		if !family.enabled {
+			continue
+		}
+		if len(family.addresses) == 0 {
+			return fmt.Errorf("no %s cluster IP in %s, see -ip-family", family.name, t.Config.Namespace)
+		}
+		ranges = append(ranges, coveringRange(family.addresses))
+	}
+	t.ipRange = strings.Join(ranges, ",")
+
+	params := t.SidecarTemplateParams(t.Config.InstallTag())
+	params.IncludeIPRanges = t.ipRange
+	template, err := inject.GenerateTemplateFromParams(params)
+	if err != nil {
+		return err
+	}
+	if t.deployed, err = t.DeployApp(tutil.AppSpec{Deployment: interceptionApp, SidecarTemplate: template}); err != nil {
+		return err
+	}
+	return t.RefreshApps()
+}
+
+// coveringRange returns the smallest range holding the addresses, all of the same family, within the
+// service range of the cluster when they are cluster IPs.
+func coveringRange(addresses []net.IP) string {
+	bits := 8 * len(addresses[0])
+	ones := bits
+	for _, ip := range addresses[1:] {
+		for ones > 0 {
+			mask := net.CIDRMask(ones, bits)
+			if ip.Mask(mask).Equal(addresses[0].Mask(mask)) {
+				break
+			}
+			ones--
+		}
+	}
+	return fmt.Sprintf("%s/%d", addresses[0].Mask(net.CIDRMask(ones, bits)), ones)
+}
+
+// Run aborts the requests to b, and checks that those of interceptionApp, through its sidecar, are
+// aborted, while those sent to the pod IP of b are not. The sidecar of a intercepts both.
+func (t *interception) Run() error {
+	if !t.Config.V1alpha2 {
+		return tutil.Skip("v1alpha2 routing rules are disabled")
+	}
+	if err := t.verifyInitContainer(); err != nil {
+		return err
+	}
+	if len(t.Apps["b"]) == 0 {
+		return fmt.Errorf("missing pod names for app b")
+	}
+	address, err := podIP(t.Environment, t.Apps["b"][0])
+	if err != nil {
+		return err
+	}
+	if err = t.ApplyConfig(interceptionConfig, map[string]string{
+		"Percent": "100",
+		"Status":  interceptionAbortStatus,
+	}); err != nil {
+		return err
+	}
+
+	// without mTLS, the bypassing requests reach b
+	plain := t.Auth == meshconfig.MeshConfig_NONE
+	podURL := fmt.Sprintf("http://%s/a", net.JoinHostPort(address, "80"))
+	cases := []struct {
+		description string
+		src, url    string
+		aborted     bool
+	}{
+		{"request intercepted within the range", interceptionApp, "http://b/a", true},
+		{"request bypassing the sidecar outside of the range", interceptionApp, podURL, false},
+		{"request to the pod IP intercepted without a range", "a", podURL, true},
+	}
+	funcs := make(map[string]func() tutil.Status)
+	for _, cs := range cases {
+		name := fmt.Sprintf("%s, from %s to %s", cs.description, cs.src, cs.url)
+		funcs[name] = (func(src, url string, aborted bool) func() tutil.Status {
+			return func() tutil.Status {
+				resp := t.ClientRequest(src, url, 1, "-key Host -val b")
+				if len(resp.Code) == 0 {
+					return tutil.ErrAgain
+				}
+				code := resp.Code[0]
+				if aborted {
+					if code != interceptionAbortStatus {
+						log.Infof("%s returned %s, want the abort %s", name, code, interceptionAbortStatus)
+						return tutil.ErrAgain
+					}
+					return nil
+				}
+				if code == interceptionAbortStatus {
+					return fmt.Errorf("%s was aborted by the sidecar", name)
+				}
+				if plain && !resp.IsHTTPOk() {
+					log.Infof("%s returned %s", name, code)
+					return tutil.ErrAgain
+				}
+				return nil
+			}
+		})(cs.src, cs.url, cs.aborted)
+	}
+	return t.Eventually(tutil.DefaultBudget, funcs)
+}
+
+// verifyInitContainer checks that the init container of interceptionApp only redirects the range.
+func (t *interception) verifyInitContainer() error {
+	pod, err := appPod(t.Environment, t.Config.Namespace, interceptionApp)
+	if err != nil {
+		return err
+	}
+	for _, container := range pod.Spec.InitContainers {
+		if container.Name != injectionInitContainer {
+			continue
+		}
+		if args := strings.Join(container.Args, " "); !strings.Contains(args, "-i "+t.ipRange) {
+			return fmt.Errorf("init container of %s has the arguments %q, want -i %s", pod.Name, args, t.ipRange)
+		}
+		return nil
+	}
+	return fmt.Errorf("pod %s of %s has no %s container", pod.Name, interceptionApp, injectionInitContainer)
+}
+
+func (t *interception) Teardown() {
+	if !t.Config.V1alpha2 {
+		return
+	}
+	if err := t.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+	if t.deployed == "" {
+		return
+	}
+	if err := t.KubeDelete(t.deployed, t.Config.Namespace); err != nil {
+		log.Warna(err)
+	}
+	t.deployed = ""
+	if err := t.RefreshApps(); err != nil {
+		log.Warna(err)
+	}
+}
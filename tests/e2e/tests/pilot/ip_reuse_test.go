@@ -0,0 +1,150 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	multierror "github.com/hashicorp/go-multierror"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/istio/pilot/test/util"
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	// number of times the backend pods are replaced
+	ipReuseCycles = 3
+	// maximum number of 1s polls waiting for a deleted pod to disappear
+	ipReuseDeleteBudget = 60
+)
+
+type ipReuse struct {
+	*tutil.Environment
+}
+
+// ipReuseSample is a request sent while the backend pods were churning
+type ipReuseSample struct {
+	sent time.Time
+	pods []string
+}
+
+func (t *ipReuse) String() string {
+	return "ip-reuse"
+}
+
+func (t *ipReuse) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelReachability, tutil.LabelSlow}
+}
+
+func (t *ipReuse) Setup() error {
+	return nil
+}
+
+func (t *ipReuse) Teardown() {
+}
+
+// Run replaces the pods of "b" several times in quick succession while "a" keeps sending
+// requests to it, and checks that no request sent after a pod is gone is answered by that pod.
+func (t *ipReuse) Run() error {
+	var (
+		mu      sync.Mutex
+		samples []ipReuseSample
+		wg      sync.WaitGroup
+	)
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			sent := time.Now()
+			resp := t.ClientRequest("a", "http://b/a", 5, "")
+			mu.Lock()
+			samples = append(samples, ipReuseSample{sent: sent, pods: resp.Hostname})
+			mu.Unlock()
+		}
+	}()
+
+	deleted := make(map[string]time.Time)
+	seenIPs := make(map[string]string)
+	var err error
+	for i := 0; i < ipReuseCycles && err == nil; i++ {
+		err = t.cycleBackends(deleted, seenIPs)
+	}
+	close(stop)
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+
+	// other tests make requests from and to "b", so pick up the new pod names
+	if err = t.RefreshApps(); err != nil {
+		return err
+	}
+
+	var errs error
+	for _, sample := range samples {
+		for _, pod := range sample.pods {
+			if gone, exists := deleted[pod]; exists && sample.sent.After(gone) {
+				errs = multierror.Append(errs, fmt.Errorf("request sent at %v was served by pod %s deleted at %v",
+					sample.sent.Format(time.RFC3339Nano), pod, gone.Format(time.RFC3339Nano)))
+			}
+		}
+	}
+	log.Infof("Sent %d request batches while replacing the pods of b %d times", len(samples), ipReuseCycles)
+	return errs
+}
+
+// cycleBackends force-deletes the current pods of "b", records when each is gone,
+// and waits for the replacements to be running.
+func (t *ipReuse) cycleBackends(deleted map[string]time.Time, seenIPs map[string]string) error {
+	pods, err := t.KubeClient.CoreV1().Pods(t.Config.Namespace).List(metav1.ListOptions{LabelSelector: "app=b"})
+	if err != nil {
+		return err
+	}
+
+	zero := int64(0)
+	for _, pod := range pods.Items {
+		if previous, exists := seenIPs[pod.Status.PodIP]; exists && previous != pod.Name {
+			log.Infof("Pod %s reuses IP %s of deleted pod %s", pod.Name, pod.Status.PodIP, previous)
+		}
+		seenIPs[pod.Status.PodIP] = pod.Name
+
+		log.Infof("Deleting pod %s (IP %s)", pod.Name, pod.Status.PodIP)
+		if err = t.KubeClient.CoreV1().Pods(t.Config.Namespace).Delete(pod.Name,
+			&metav1.DeleteOptions{GracePeriodSeconds: &zero}); err != nil {
+			return err
+		}
+	}
+
+	for _, pod := range pods.Items {
+		if err = waitForPodGone(t.Environment, pod.Name, ipReuseDeleteBudget); err != nil {
+			return err
+		}
+		deleted[pod.Name] = time.Now()
+	}
+
+	// the traffic goroutine reads the app to pods mapping, so only wait for the replacements here
+	_, err = util.GetAppPods(t.KubeClient, t.Config.KubeConfig, []string{t.Config.Namespace})
+	return err
+}
@@ -0,0 +1,108 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"net"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	meshconfig "istio.io/api/mesh/v1alpha1"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+type ipv6 struct {
+	*tutil.Environment
+}
+
+func (t *ipv6) String() string {
+	return "ipv6-reachability"
+}
+
+func (t *ipv6) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelReachability}
+}
+
+func (t *ipv6) Setup() error {
+	return nil
+}
+
+func (t *ipv6) Teardown() {
+}
+
+// Run sends requests through the sidecar to the IPv6 addresses of "b": its service IP and,
+// through the headless service, each of its pod IPs.
+func (t *ipv6) Run() error {
+	targets, err := t.ipv6Targets()
+	if err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		if t.Config.HasIPv6() {
+			return fmt.Errorf("no IPv6 address for b in the %s cluster", t.Config.IPFamily)
+		}
+		return tutil.Skip("the cluster does not advertise IPv6 addresses, see -ip-family")
+	}
+
+	srcPods := []string{"a", "b"}
+	if t.Auth == meshconfig.MeshConfig_NONE {
+		// t is not behind proxy, so it cannot talk in Istio auth.
+		srcPods = append(srcPods, "t")
+	}
+	funcs := make(map[string]func() tutil.Status)
+	for _, src := range srcPods {
+		for _, target := range targets {
+			name := fmt.Sprintf("IPv6 request from %s to %s", src, target)
+			funcs[name] = (func(src, target string) func() tutil.Status {
+				url := fmt.Sprintf("http://%s/%s", target, src)
+				return func() tutil.Status {
+					resp := t.ClientRequest(src, url, 1, "")
+					if resp.IsHTTPOk() {
+						return nil
+					}
+					return tutil.ErrAgain
+				}
+			})(src, target)
+		}
+	}
+	return t.Eventually(tutil.DefaultBudget, funcs)
+}
+
+// ipv6Targets returns the IPv6 host:port addresses of the "b" service and of its pods.
+func (t *ipv6) ipv6Targets() ([]string, error) {
+	var targets []string
+	svc, err := t.KubeClient.CoreV1().Services(t.Config.Namespace).Get("b", metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if tutil.IsIPv6(svc.Spec.ClusterIP) {
+		targets = append(targets, net.JoinHostPort(svc.Spec.ClusterIP, "80"))
+	}
+
+	// mTLS is not supported for headless services
+	if t.Auth == meshconfig.MeshConfig_NONE {
+		pods, podErr := t.KubeClient.CoreV1().Pods(t.Config.Namespace).List(metav1.ListOptions{LabelSelector: "app=b"})
+		if podErr != nil {
+			return nil, podErr
+		}
+		for _, pod := range pods.Items {
+			if tutil.IsIPv6(pod.Status.PodIP) {
+				targets = append(targets, net.JoinHostPort(pod.Status.PodIP, "80"))
+			}
+		}
+	}
+	return targets, nil
+}
@@ -16,21 +16,105 @@ package pilot
 
 import (
 	"fmt"
+	"strings"
 
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	meshconfig "istio.io/api/mesh/v1alpha1"
+	"istio.io/istio/pilot/test/util"
 	"istio.io/istio/pkg/log"
 	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
 )
 
+const (
+	// ExternalName service of httpbin.org with an HTTP and an HTTPS port
+	externalPortsService = "externalbin-ports"
+	// ExternalName service of externalMeshApp, in the namespace of its own
+	externalMeshService = "externalmesh"
+	// app of the mesh behind externalMeshService
+	externalMeshApp = "external-target"
+	// seconds the setup waits for the pod of externalMeshApp to be ready
+	externalMeshReadyBudget = 120
+)
+
+// kubernetesExternalNameServices checks the requests to ExternalName services: of external hosts over
+// HTTP on their only port, of httpbin.org over HTTP and HTTPS through explicit ports, and of an app of
+// the mesh in another namespace on two of its ports.
 type kubernetesExternalNameServices struct {
 	*tutil.Environment
+	// namespace of externalMeshApp
+	meshNamespace string
 }
 
 func (t *kubernetesExternalNameServices) String() string {
 	return "kubernetes-external-name-services"
 }
 
+func (t *kubernetesExternalNameServices) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelEgress}
+}
+
+// Setup rewrites the authority of the requests to httpbin.org through the sidecars, and deploys the
+// ExternalName services with explicit ports, and externalMeshApp in a namespace of its own.
 func (t *kubernetesExternalNameServices) Setup() error {
-	return t.ApplyConfig("v1alpha1/rule-rewrite-authority-externalbin.yaml.tmpl", nil)
+	for _, config := range []string{
+		"v1alpha1/rule-rewrite-authority-externalbin.yaml.tmpl",
+		"v1alpha1/rule-rewrite-authority-externalbin-ports.yaml.tmpl",
+	} {
+		if err := t.ApplyConfig(config, nil); err != nil {
+			return err
+		}
+	}
+	if err := t.createExternalName(externalPortsService, "httpbin.org", []v1.ServicePort{
+		{Name: "http", Port: 80},
+		{Name: "https", Port: 443},
+	}); err != nil {
+		return err
+	}
+
+	var err error
+	if t.meshNamespace, err = util.CreateNamespaceWithPrefix(t.KubeClient, "istio-test-external-",
+		t.Config.UseAutomaticInjection); err != nil {
+		return err
+	}
+	if _, err = t.DeployApp(tutil.AppSpec{Deployment: externalMeshApp, Namespace: t.meshNamespace}); err != nil {
+		return err
+	}
+	if err = t.createExternalName(externalMeshService,
+		fmt.Sprintf("%s.%s.svc.cluster.local", externalMeshApp, t.meshNamespace), []v1.ServicePort{
+			{Name: "http", Port: 80},
+			{Name: "http-two", Port: 8080},
+		}); err != nil {
+		return err
+	}
+	// the requests expected to fail with mTLS would also fail before the app runs
+	return t.waitForMeshApp()
+}
+
+// createExternalName creates the ExternalName service of the host in the app namespace.
+func (t *kubernetesExternalNameServices) createExternalName(name, host string, ports []v1.ServicePort) error {
+	_, err := t.KubeClient.CoreV1().Services(t.Config.Namespace).Create(&v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: v1.ServiceSpec{
+			Type:         v1.ServiceTypeExternalName,
+			ExternalName: host,
+			Ports:        ports,
+		},
+	})
+	return err
+}
+
+// waitForMeshApp waits for the pod of externalMeshApp to be ready.
+func (t *kubernetesExternalNameServices) waitForMeshApp() error {
+	return t.Eventually(tutil.DefaultBudget.Attempting(externalMeshReadyBudget), map[string]func() tutil.Status{
+		fmt.Sprintf("Pod of %s in %s ready", externalMeshApp, t.meshNamespace): func() tutil.Status {
+			if pod, err := appPod(t.Environment, t.meshNamespace, externalMeshApp); err != nil || !podReady(pod) {
+				return tutil.ErrAgain
+			}
+			return nil
+		},
+	})
 }
 
 func (t *kubernetesExternalNameServices) Teardown() {
@@ -38,6 +122,14 @@ func (t *kubernetesExternalNameServices) Teardown() {
 	if err := t.DeleteAllConfigs(); err != nil {
 		log.Warna(err)
 	}
+	for _, name := range []string{externalPortsService, externalMeshService} {
+		if err := t.KubeClient.CoreV1().Services(t.Config.Namespace).Delete(name,
+			&metav1.DeleteOptions{}); err != nil {
+			log.Warna(err)
+		}
+	}
+	util.DeleteNamespace(t.KubeClient, t.meshNamespace)
+	t.meshNamespace = ""
 }
 
 func (t *kubernetesExternalNameServices) Run() error {
@@ -55,23 +147,71 @@ func (t *kubernetesExternalNameServices) Run() error {
 	for src, withIstioProxy := range srcPods {
 		for dst, externalHost := range dstServices {
 			for _, domain := range []string{"", "." + t.Config.Namespace} {
-				name := fmt.Sprintf("HTTP connection from %s to %s%s", src, dst, domain)
-				funcs[name] = (func(src, dst, domain string) func() tutil.Status {
-					url := fmt.Sprintf("http://%s%s", dst, domain)
-					extra := ""
-					if !withIstioProxy {
-						extra = "-key Host -val " + externalHost
-					}
-					return func() tutil.Status {
-						resp := t.ClientRequest(src, url, 1, extra)
-						if resp.IsHTTPOk() {
-							return nil
-						}
-						return tutil.ErrAgain
-					}
-				})(src, dst, domain)
+				extra := ""
+				if !withIstioProxy {
+					extra = "-key Host -val " + externalHost
+				}
+				t.expectOK(funcs, fmt.Sprintf("HTTP connection from %s to %s%s", src, dst, domain), src,
+					fmt.Sprintf("http://%s%s", dst, domain), extra)
 			}
 		}
+
+		// the sidecars rewrite the authority of the requests to the explicit HTTP port
+		extra := ""
+		if !withIstioProxy {
+			extra = "-key Host -val httpbin.org"
+		}
+		t.expectOK(funcs, fmt.Sprintf("HTTP connection from %s to %s:80", src, externalPortsService), src,
+			fmt.Sprintf("http://%s:80", externalPortsService), extra)
+
+		for _, port := range []int{80, 8080} {
+			name := fmt.Sprintf("HTTP connection from %s to %s:%d in %s", src, externalMeshService, port,
+				t.meshNamespace)
+			funcs[name] = t.meshRequest(src, fmt.Sprintf("http://%s:%d/%s", externalMeshService, port, src))
+		}
+	}
+	// Pilot sends plain HTTP to the HTTPS ports of the ExternalName services, without originating TLS,
+	// so that only the app without a sidecar reaches an HTTPS target
+	t.expectOK(funcs, fmt.Sprintf("HTTPS connection from t to %s:443", externalPortsService), "t",
+		fmt.Sprintf("https://%s:443", externalPortsService), "-key Host -val httpbin.org")
+	return t.Eventually(tutil.DefaultBudget, funcs)
+}
+
+// expectOK adds the check of a request of the url from src answered with 200.
+func (t *kubernetesExternalNameServices) expectOK(funcs map[string]func() tutil.Status, name, src, url,
+	extra string) {
+	funcs[name] = func() tutil.Status {
+		resp := t.ClientRequest(src, url, 1, extra)
+		if resp.IsHTTPOk() {
+			return nil
+		}
+		return tutil.ErrAgain
+	}
+}
+
+// meshRequest returns the check of a request of externalMeshService from src. Without mTLS, the
+// request reaches externalMeshApp. With mTLS, it fails: the app without a sidecar sends it in plain
+// text, and the sidecars do too, as Pilot leaves the clusters of the ExternalName services out of the
+// mesh, while the sidecar of externalMeshApp only accepts mTLS.
+func (t *kubernetesExternalNameServices) meshRequest(src, url string) func() tutil.Status {
+	return func() tutil.Status {
+		requests, err := t.ClientRequests(src, url, 1, "")
+		if err != nil {
+			return err
+		}
+		if err = requests.ExpectReachable(); t.Auth == meshconfig.MeshConfig_MUTUAL_TLS {
+			if err == nil {
+				return fmt.Errorf("%v, want a failure with mTLS", &requests[0])
+			}
+			return nil
+		}
+		if err != nil {
+			log.Infof("%v", err)
+			return tutil.ErrAgain
+		}
+		if !strings.HasPrefix(requests[0].Hostname, externalMeshApp) {
+			return fmt.Errorf("%v, want %s", &requests[0], externalMeshApp)
+		}
+		return nil
 	}
-	return tutil.Parallel(funcs)
 }
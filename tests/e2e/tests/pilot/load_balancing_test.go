@@ -0,0 +1,223 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	envoyv1 "istio.io/istio/pilot/pkg/proxy/envoy/v1"
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	loadBalancingConfig = "v1alpha2/destination-rule-lb-b.yaml.tmpl"
+	// pods of b while the test runs, which every policy must spread the requests across
+	loadBalancingReplicas = 3
+	// requests from a for each policy, enough for the random policy to reach every pod
+	loadBalancingSamples = 30
+	// difference of the requests each pod serves with round robin and an even share accepted
+	loadBalancingRoundRobinTolerance = 3
+	// how long the request keeping a pod of b busy lasts while the least connection policy is checked
+	loadBalancingBusy = 20 * time.Second
+)
+
+// loadBalancing checks the simple load balancer policies of destination rules: the load balancer of the
+// clusters of b Pilot serves to a, and a behaviour of each policy. Consistent hash load balancing is not
+// checked, as Pilot does not configure the ring hash load balancer in this version.
+type loadBalancing struct {
+	*tutil.Environment
+	scaled bool
+}
+
+func (t *loadBalancing) String() string {
+	return "load-balancing"
+}
+
+func (t *loadBalancing) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelRouting, tutil.LabelSlow}
+}
+
+func (t *loadBalancing) Setup() error {
+	if !t.Config.V1alpha2 {
+		return nil
+	}
+	if err := t.ScaleDeployment("b", loadBalancingReplicas); err != nil {
+		return err
+	}
+	t.scaled = true
+	return t.waitForReplicas(loadBalancingReplicas)
+}
+
+// Run applies each policy to b in turn, and checks that Pilot configures its load balancer for the
+// clusters of b and that the requests from a spread across the pods of b as the policy does. Round robin
+// comes last, so that its load balancer, the default one, is only seen once Pilot applied the policy.
+func (t *loadBalancing) Run() error {
+	if !t.Config.V1alpha2 {
+		return tutil.Skip("v1alpha2 routing rules are disabled")
+	}
+	if err := t.WaitForPilotEndpoints("b", "http", loadBalancingReplicas); err != nil {
+		return err
+	}
+
+	for _, policy := range []struct {
+		name   string
+		lbType string
+		verify func() error
+	}{
+		{"RANDOM", envoyv1.LbTypeRandom, t.verifySpread},
+		{"LEAST_CONN", envoyv1.LbTypeLeastRequest, t.verifyLeastConn},
+		{"ROUND_ROBIN", envoyv1.LbTypeRoundRobin, t.verifyRoundRobin},
+	} {
+		tutil.Tlog("Checking load balancing", policy.name)
+		if err := t.ApplyConfig(loadBalancingConfig, map[string]string{"Policy": policy.name}); err != nil {
+			return err
+		}
+		if err := tutil.Repeat(func() error { return t.verifyLbType(policy.lbType) }, 5, time.Second); err != nil {
+			return fmt.Errorf("%s: %v", policy.name, err)
+		}
+		if err := tutil.Repeat(policy.verify, 5, time.Second); err != nil {
+			return fmt.Errorf("%s: %v", policy.name, err)
+		}
+	}
+	return nil
+}
+
+// verifyLbType checks that every cluster of b Pilot serves to a uses the load balancer.
+func (t *loadBalancing) verifyLbType(lbType string) error {
+	clusters, err := t.PilotClusters("a")
+	if err != nil {
+		return err
+	}
+	found := 0
+	for name, cluster := range clusters {
+		if !strings.HasPrefix(name, envoyv1.OutboundClusterPrefix+"b.") {
+			continue
+		}
+		found++
+		if cluster.LbType != lbType {
+			return fmt.Errorf("cluster %s of b has load balancer %q, want %q", name, cluster.LbType, lbType)
+		}
+	}
+	if found == 0 {
+		return fmt.Errorf("pilot serves no cluster of b to a")
+	}
+	return nil
+}
+
+// sample sends the requests from a to b, and returns how many of them each pod of b served.
+func (t *loadBalancing) sample() (map[string]int, error) {
+	resp := t.ClientRequest("a", "http://b/a", loadBalancingSamples, "")
+	if len(resp.Hostname) != loadBalancingSamples {
+		return nil, fmt.Errorf("%d of %d requests from a to b were answered", len(resp.Hostname),
+			loadBalancingSamples)
+	}
+	count := counts(resp.Hostname)
+	log.Infof("request counts %v", count)
+	return count, nil
+}
+
+// verifySpread checks that every pod of b served at least one of the requests.
+func (t *loadBalancing) verifySpread() error {
+	count, err := t.sample()
+	if err != nil {
+		return err
+	}
+	for _, pod := range t.Apps["b"] {
+		if count[pod] == 0 {
+			return fmt.Errorf("no request reached pod %s of b: %v", pod, count)
+		}
+	}
+	return nil
+}
+
+// verifyRoundRobin checks that every pod of b served an even share of the requests, which the other
+// policies only do by chance.
+func (t *loadBalancing) verifyRoundRobin() error {
+	count, err := t.sample()
+	if err != nil {
+		return err
+	}
+	even := loadBalancingSamples / len(t.Apps["b"])
+	for _, pod := range t.Apps["b"] {
+		if count[pod] < even-loadBalancingRoundRobinTolerance || count[pod] > even+loadBalancingRoundRobinTolerance {
+			return fmt.Errorf("pod %s of b served %d requests, want %d±%d: %v", pod, count[pod], even,
+				loadBalancingRoundRobinTolerance, count)
+		}
+	}
+	return nil
+}
+
+// verifyLeastConn keeps a pod of b busy with a streaming request from a, and checks that it served fewer
+// of the other requests than its share: the least request load balancer picks the pod with fewer active
+// requests out of two random ones, so the busy pod only gets the requests for which it is picked twice.
+func (t *loadBalancing) verifyLeastConn() error {
+	done := make(chan tutil.Response, 1)
+	go func() {
+		done <- t.ClientRequest("a", fmt.Sprintf("http://b/a?stream=%v", loadBalancingBusy), 1,
+			fmt.Sprintf("-timeout %v", loadBalancingBusy+time.Minute))
+	}()
+	defer func() { <-done }()
+
+	busy, err := streamingPod(t.Environment, "b")
+	if err != nil {
+		return err
+	}
+	count, err := t.sample()
+	if err != nil {
+		return err
+	}
+	// half of the share of a pod, where random or round robin would give it its full share
+	if limit := loadBalancingSamples / len(t.Apps["b"]) / 2; count[busy] > limit {
+		return fmt.Errorf("busy pod %s of b served %d requests, want at most %d: %v", busy, count[busy], limit,
+			count)
+	}
+	return nil
+}
+
+func (t *loadBalancing) Teardown() {
+	if !t.Config.V1alpha2 {
+		return
+	}
+	log.Info("Cleaning up destination rules...")
+	if err := t.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+	if !t.scaled {
+		return
+	}
+	if err := t.ScaleDeployment("b", 1); err != nil {
+		log.Warna(err)
+	}
+	t.scaled = false
+	if err := t.waitForReplicas(1); err != nil {
+		log.Warna(err)
+	}
+}
+
+// waitForReplicas refreshes the pods of the apps until b has the given number of them.
+func (t *loadBalancing) waitForReplicas(replicas int) error {
+	return tutil.Repeat(func() error {
+		if err := t.RefreshApps(); err != nil {
+			return err
+		}
+		if len(t.Apps["b"]) != replicas {
+			return fmt.Errorf("b has pods %v, want %d", t.Apps["b"], replicas)
+		}
+		return nil
+	}, 30, time.Second)
+}
@@ -0,0 +1,60 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+// load sends -load-qps requests for -load-duration from a to b, through both sidecars, over HTTP
+// and gRPC, and checks the latency percentiles and the error rate against -load-p50, -load-p99 and
+// -load-error-rate.
+type load struct {
+	*tutil.Environment
+}
+
+func (t *load) String() string {
+	return "load"
+}
+
+func (t *load) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelReachability, tutil.LabelSlow}
+}
+
+func (t *load) Setup() error {
+	return nil
+}
+
+func (t *load) Teardown() {}
+
+func (t *load) Run() error {
+	if t.Config.LoadQPS < 1 {
+		return tutil.Skip("no load was requested")
+	}
+	for _, url := range []string{"http://b/a", "grpc://b:70"} {
+		result, err := t.Load("a", url, t.Config.LoadQPS, t.Config.LoadDuration, "")
+		if err != nil {
+			return err
+		}
+		log.Infof("Load from a to %s: %v", url, result)
+		if err = t.CheckSLO(result); err != nil {
+			return fmt.Errorf("load from a to %s: %v", url, err)
+		}
+	}
+	return nil
+}
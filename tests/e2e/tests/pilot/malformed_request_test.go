@@ -0,0 +1,100 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+
+	meshconfig "istio.io/api/mesh/v1alpha1"
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+type malformedRequest struct {
+	*tutil.Environment
+}
+
+func (t *malformedRequest) String() string {
+	return "malformed-request"
+}
+
+func (t *malformedRequest) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelReachability}
+}
+
+func (t *malformedRequest) Setup() error {
+	return nil
+}
+
+func (t *malformedRequest) Teardown() {
+}
+
+// Run writes malformed HTTP/1.1 requests to the sidecars and checks that each one is answered
+// with 400, and that valid requests on new connections are still served afterwards.
+func (t *malformedRequest) Run() error {
+	// messages are Go-escaped by the client, and must not contain spaces
+	cases := []struct {
+		description string
+		msg         string
+	}{
+		{"bad request line", `GARBAGE\r\n\r\n`},
+		{"illegal header name", `GET\x20/\x20HTTP/1.1\r\nHost:\x20b\r\nBad\x20Header:\x20x\r\n\r\n`},
+		{"control character in header value", `GET\x20/\x20HTTP/1.1\r\nHost:\x20b\r\nX-Bad:\x20a\x01b\r\n\r\n`},
+	}
+
+	// a sends through its outbound sidecar, t (no auth only) straight to the inbound sidecar of b
+	srcPods := []string{"a"}
+	if t.Auth == meshconfig.MeshConfig_NONE {
+		srcPods = append(srcPods, "t")
+	}
+
+	funcs := make(map[string]func() tutil.Status)
+	for _, src := range srcPods {
+		for _, cs := range cases {
+			name := fmt.Sprintf("Request with %s from %s to b", cs.description, src)
+			funcs[name] = (func(src, msg string) func() tutil.Status {
+				return func() tutil.Status {
+					resp := t.ClientRequest(src, "raw://b:80", 1, "-msg "+msg)
+					if len(resp.Code) == 0 {
+						return tutil.ErrAgain
+					}
+					if resp.Code[0] != "400" {
+						return fmt.Errorf("malformed request from %s returned %s, want 400", src, resp.Code[0])
+					}
+					return nil
+				}
+			})(src, cs.msg)
+		}
+	}
+	if err := t.Eventually(tutil.DefaultBudget, funcs); err != nil {
+		return err
+	}
+
+	log.Info("Checking that the sidecars still serve valid requests")
+	funcs = make(map[string]func() tutil.Status)
+	for _, src := range srcPods {
+		name := fmt.Sprintf("Valid request from %s to b", src)
+		funcs[name] = (func(src string) func() tutil.Status {
+			return func() tutil.Status {
+				resp := t.ClientRequest(src, "http://b/"+src, 1, "")
+				if resp.IsHTTPOk() {
+					return nil
+				}
+				return tutil.ErrAgain
+			}
+		})(src)
+	}
+	return t.Eventually(tutil.DefaultBudget, funcs)
+}
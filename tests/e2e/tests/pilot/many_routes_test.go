@@ -0,0 +1,123 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"time"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	// number of requests sent in one batch when timing a route
+	manyRoutesBatch = 10
+	// how much slower the last route may be than the first one
+	manyRoutesMaxSlowdown = 2 * time.Second
+)
+
+type manyRoutes struct {
+	*tutil.Environment
+}
+
+// manyRoutesData is the template data: a route per entry, the Last one going to v2
+type manyRoutesData struct {
+	Routes []int
+	Last   int
+}
+
+func (t *manyRoutes) String() string {
+	return "many-routes"
+}
+
+func (t *manyRoutes) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelRouting, tutil.LabelSlow}
+}
+
+func (t *manyRoutes) data() manyRoutesData {
+	data := manyRoutesData{Last: t.Config.ManyRoutes - 1}
+	for i := 0; i < t.Config.ManyRoutes; i++ {
+		data.Routes = append(data.Routes, i)
+	}
+	return data
+}
+
+func (t *manyRoutes) Setup() error {
+	if !t.Config.V1alpha2 || t.Config.ManyRoutes < 1 {
+		return nil
+	}
+	if err := t.ApplyConfig("v1alpha2/destination-rule-c.yaml.tmpl", nil); err != nil {
+		return err
+	}
+	return t.ApplyConfig("v1alpha2/rule-many-routes.yaml.tmpl", t.data())
+}
+
+// Run checks that with a large number of routes for one host a request matching the
+// last route still reaches its destination, and that matching it is not much slower than the first.
+func (t *manyRoutes) Run() error {
+	if !t.Config.V1alpha2 {
+		return tutil.Skip("v1alpha2 routing rules are disabled")
+	}
+	if t.Config.ManyRoutes < 1 {
+		return tutil.Skip("no routes were requested")
+	}
+
+	last := fmt.Sprintf("http://c/many-routes/%d", t.Config.ManyRoutes-1)
+	var lastLatency time.Duration
+	err := tutil.Repeat(func() error {
+		start := time.Now()
+		resp := t.ClientRequest("a", last, manyRoutesBatch, "")
+		lastLatency = time.Since(start)
+		if !resp.IsHTTPOk() || len(resp.Version) != manyRoutesBatch {
+			return fmt.Errorf("request to the last of %d routes failed: %v", t.Config.ManyRoutes, resp.Code)
+		}
+		for _, version := range resp.Version {
+			if version != "v2" {
+				return fmt.Errorf("request to the last of %d routes reached version %s, want v2",
+					t.Config.ManyRoutes, version)
+			}
+		}
+		return nil
+	}, 30, time.Second)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	resp := t.ClientRequest("a", "http://c/many-routes/0", manyRoutesBatch, "")
+	firstLatency := time.Since(start)
+	if !resp.IsHTTPOk() {
+		return fmt.Errorf("request to the first of %d routes failed: %v", t.Config.ManyRoutes, resp.Code)
+	}
+
+	log.Infof("Latency of %d requests with %d routes: first route %v, last route %v",
+		manyRoutesBatch, t.Config.ManyRoutes, firstLatency, lastLatency)
+	if lastLatency-firstLatency > manyRoutesMaxSlowdown {
+		return fmt.Errorf("matching the last of %d routes took %v, %v more than the first route",
+			t.Config.ManyRoutes, lastLatency, lastLatency-firstLatency)
+	}
+	return nil
+}
+
+func (t *manyRoutes) Teardown() {
+	if !t.Config.V1alpha2 {
+		return
+	}
+	log.Info("Cleaning up route rules...")
+	if err := t.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+}
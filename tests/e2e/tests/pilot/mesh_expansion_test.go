@@ -0,0 +1,111 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+
+	meshconfig "istio.io/api/mesh/v1alpha1"
+	"istio.io/istio/pilot/pkg/serviceregistry"
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+// service of the mesh expansion app
+const meshExpansionApp = "vm"
+
+// meshExpansion runs the test app as a workload outside Kubernetes, registered like a VM joining
+// the mesh and with the sidecar of a VM, and checks that the apps of the mesh reach it and that it
+// reaches them, over mTLS when auth is enabled.
+type meshExpansion struct {
+	*tutil.Environment
+	app *tutil.MeshExpansionApp
+}
+
+func (t *meshExpansion) String() string {
+	return "mesh-expansion"
+}
+
+func (t *meshExpansion) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelReachability, tutil.LabelSecurity}
+}
+
+func (t *meshExpansion) Setup() error {
+	if serviceregistry.ServiceRegistry(t.Config.Registry) != serviceregistry.KubernetesRegistry {
+		return nil
+	}
+	t.app = &tutil.MeshExpansionApp{Name: meshExpansionApp, Version: "v1"}
+	return t.DeployMeshExpansionApp(t.app)
+}
+
+func (t *meshExpansion) Teardown() {
+	if t.app == nil {
+		return
+	}
+	if err := t.DeleteMeshExpansionApp(t.app); err != nil {
+		log.Warna(err)
+	}
+	t.app = nil
+}
+
+// Run checks HTTP and TCP from a and b to the app and from the app to a and b. With auth, t, which
+// has no sidecar, must be refused by the sidecar of the app, which only terminates mTLS.
+func (t *meshExpansion) Run() error {
+	if t.app == nil {
+		return tutil.Skip("VMs are registered with the Kubernetes registry, not %s", t.Config.Registry)
+	}
+
+	funcs := make(map[string]func() tutil.Status)
+	for _, pair := range [][2]string{
+		{"a", meshExpansionApp},
+		{"b", meshExpansionApp},
+		{meshExpansionApp, "a"},
+		{meshExpansionApp, "b"},
+	} {
+		src, dst := pair[0], pair[1]
+		for _, port := range []string{"", ":8080", ":90"} {
+			name := fmt.Sprintf("request from %s to %s%s", src, dst, port)
+			funcs[name] = (func(src, dst, port string) func() tutil.Status {
+				url := fmt.Sprintf("http://%s%s/%s", dst, port, src)
+				return func() tutil.Status {
+					resp := t.ClientRequest(src, url, 1, "")
+					if !resp.IsHTTPOk() {
+						return tutil.ErrAgain
+					}
+					if dst == meshExpansionApp && (len(resp.Version) == 0 || resp.Version[0] != t.app.Version) {
+						return fmt.Errorf("request from %s to %s reached version %v, want %s", src, url,
+							resp.Version, t.app.Version)
+					}
+					return nil
+				}
+			})(src, dst, port)
+		}
+	}
+	if t.Auth == meshconfig.MeshConfig_MUTUAL_TLS {
+		for _, port := range []string{"", ":90"} {
+			name := fmt.Sprintf("plaintext request from t to %s%s", meshExpansionApp, port)
+			funcs[name] = (func(port string) func() tutil.Status {
+				url := fmt.Sprintf("http://%s%s/t", meshExpansionApp, port)
+				return func() tutil.Status {
+					if resp := t.ClientRequest("t", url, 1, ""); len(resp.ID) > 0 {
+						return tutil.ErrAgain
+					}
+					return nil
+				}
+			})(port)
+		}
+	}
+	return t.Eventually(tutil.DefaultBudget, funcs)
+}
@@ -0,0 +1,100 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	// number of requests sent from a to b, each mirrored to one of the pods of c
+	mirrorRequests = 10
+)
+
+type mirroring struct {
+	*tutil.Environment
+}
+
+func (t *mirroring) String() string {
+	return "mirroring"
+}
+
+func (t *mirroring) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelRouting}
+}
+
+func (t *mirroring) Setup() error {
+	return nil
+}
+
+// Run mirrors the requests from "a" to "b" onto "c", and checks that the client only gets
+// responses from "b" while the sidecar of a pod of "c" logs the shadow copies, whose authority
+// Envoy suffixes with -shadow.
+func (t *mirroring) Run() error {
+	if !t.Config.V1alpha2 {
+		return tutil.Skip("v1alpha2 routing rules are disabled")
+	}
+	if err := t.ApplyConfig("v1alpha2/rule-mirror-b.yaml.tmpl", nil); err != nil {
+		return err
+	}
+
+	// a path of its own, so that requests of other runs do not match
+	path := fmt.Sprintf("/a?mirror=%d", time.Now().UnixNano())
+	since := time.Now()
+	resp := t.ClientRequest("a", "http://b"+path, mirrorRequests, "")
+	if len(resp.Code) != mirrorRequests {
+		return fmt.Errorf("%d/%d mirrored requests from a to b returned", len(resp.Code), mirrorRequests)
+	}
+	for i, code := range resp.Code {
+		if code != "200" {
+			return fmt.Errorf("mirrored request from a to b returned %s", code)
+		}
+		if i >= len(resp.Hostname) || !strings.HasPrefix(resp.Hostname[i], "b-") {
+			return fmt.Errorf("mirrored requests from a to b were not served by b: %v", resp.Hostname)
+		}
+	}
+
+	matcher := tutil.AccessLogMatcher{
+		Since:      since,
+		Authority:  "b-shadow",
+		PathPrefix: path,
+	}
+	var errs []string
+	for _, pod := range t.Apps["c"] {
+		err := t.AssertAccessLog(pod, matcher)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return fmt.Errorf("no pod of c received the shadow copies of the requests from a to b: %s",
+		strings.Join(errs, "; "))
+}
+
+// Teardown removes the mirror rule, whether or not Run got to apply it.
+func (t *mirroring) Teardown() {
+	if !t.Config.V1alpha2 {
+		return
+	}
+	log.Info("Cleaning up route rules...")
+	if err := t.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+}
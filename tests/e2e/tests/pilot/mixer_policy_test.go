@@ -0,0 +1,171 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	mixerDenyConfig = "mixer-policy-deny.yaml.tmpl"
+	mixerListConfig = "mixer-policy-list.yaml.tmpl"
+	// status Mixer denies the checks with, which Envoy sends as the body of the 403
+	mixerDeniedStatus = "PERMISSION_DENIED"
+)
+
+// mixerPolicy checks that the checks of Mixer deny the requests its policies reject: a denier rule
+// for the requests from a to b, then a listchecker on the source of the requests to b, as a blacklist and
+// as a whitelist.
+type mixerPolicy struct {
+	*tutil.Environment
+	// YAML of the policy applied, if any
+	policy string
+}
+
+func (t *mixerPolicy) String() string {
+	return "mixer-policy"
+}
+
+func (t *mixerPolicy) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelSecurity}
+}
+
+func (t *mixerPolicy) Setup() error {
+	return nil
+}
+
+func (t *mixerPolicy) Teardown() {
+	t.deletePolicy()
+}
+
+// Run applies each policy in turn, checking the requests it denies and the requests it lets through, and
+// that deleting it lets the denied requests through again.
+func (t *mixerPolicy) Run() error {
+	if !t.Config.Mixer {
+		return tutil.Skip("mixer is disabled")
+	}
+	if err := t.verifyAllowed("a", "b"); err != nil {
+		return fmt.Errorf("before any policy: %v", err)
+	}
+
+	tutil.Tlog("Checking mixerPolicy test", "denier rule for the requests from a to b")
+	if err := t.applyPolicy(mixerDenyConfig, map[string]interface{}{"Source": "a", "Destination": "b"}); err != nil {
+		return err
+	}
+	if err := t.verifyDenied("a", "b"); err != nil {
+		return fmt.Errorf("with the denier rule: %v", err)
+	}
+	if err := t.verifyAllowed("a", "c"); err != nil {
+		return fmt.Errorf("with the denier rule of b: %v", err)
+	}
+	t.deletePolicy()
+	if err := t.verifyAllowed("a", "b"); err != nil {
+		return fmt.Errorf("once the denier rule is deleted: %v", err)
+	}
+
+	tutil.Tlog("Checking mixerPolicy test", "blacklist of a for the requests to b")
+	if err := t.applyList(true, "a"); err != nil {
+		return err
+	}
+	if err := t.verifyDenied("a", "b"); err != nil {
+		return fmt.Errorf("with a blacklisted: %v", err)
+	}
+	if err := t.verifyAllowed("c", "b"); err != nil {
+		return fmt.Errorf("with a blacklisted: %v", err)
+	}
+	t.deletePolicy()
+
+	tutil.Tlog("Checking mixerPolicy test", "whitelist of a for the requests to b")
+	if err := t.applyList(false, "a"); err != nil {
+		return err
+	}
+	if err := t.verifyDenied("c", "b"); err != nil {
+		return fmt.Errorf("with a whitelisted: %v", err)
+	}
+	if err := t.verifyAllowed("a", "b"); err != nil {
+		return fmt.Errorf("with a whitelisted: %v", err)
+	}
+	t.deletePolicy()
+	if err := t.verifyAllowed("c", "b"); err != nil {
+		return fmt.Errorf("once the whitelist is deleted: %v", err)
+	}
+	return nil
+}
+
+// applyList applies a listchecker for the requests to b, listing the apps the requests come from.
+func (t *mixerPolicy) applyList(blacklist bool, apps ...string) error {
+	return t.applyPolicy(mixerListConfig, map[string]interface{}{
+		"Destination": "b",
+		"Entries":     apps,
+		"Blacklist":   blacklist,
+	})
+}
+
+// applyPolicy fills the template of the policy for the app namespace and applies it to the Istio
+// namespace, where Mixer reads its rules from.
+func (t *mixerPolicy) applyPolicy(file string, values map[string]interface{}) error {
+	values["Namespace"] = t.Config.Namespace
+	policy, err := t.Fill(file, values)
+	if err != nil {
+		return err
+	}
+	if err = t.KubeApply(policy, t.Config.IstioNamespace); err != nil {
+		return err
+	}
+	t.policy = policy
+	return nil
+}
+
+func (t *mixerPolicy) deletePolicy() {
+	if t.policy == "" {
+		return
+	}
+	if err := t.KubeDelete(t.policy, t.Config.IstioNamespace); err != nil {
+		log.Warna(err)
+	}
+	t.policy = ""
+}
+
+// verifyDenied waits for the requests from the source to the destination to be denied by Mixer, as
+// its rules take a while to reach it.
+func (t *mixerPolicy) verifyDenied(src, dst string) error {
+	return tutil.Repeat(func() error {
+		resp := t.ClientRequest(src, "http://"+dst+"/a", 1, "")
+		if len(resp.Code) == 0 || resp.Code[0] != "403" {
+			return fmt.Errorf("request from %s to %s returned %v, want 403", src, dst, resp.Code)
+		}
+		if !strings.Contains(resp.Body, mixerDeniedStatus) {
+			return fmt.Errorf("request from %s to %s was denied without %s: %s", src, dst, mixerDeniedStatus,
+				resp.Body)
+		}
+		return nil
+	}, 10, time.Second)
+}
+
+// verifyAllowed waits for the requests from the source to the destination to succeed.
+func (t *mixerPolicy) verifyAllowed(src, dst string) error {
+	return tutil.Repeat(func() error {
+		resp := t.ClientRequest(src, "http://"+dst+"/a", 1, "")
+		if !resp.IsHTTPOk() {
+			return fmt.Errorf("request from %s to %s returned %v, want 200", src, dst, resp.Code)
+		}
+		return nil
+	}, 10, time.Second)
+}
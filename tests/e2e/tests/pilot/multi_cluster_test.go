@@ -0,0 +1,224 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/istio/pilot/test/util"
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	// host of the remote backend in the primary cluster
+	remoteHost = "remote.example.com"
+	// maximum number of 1s polls waiting for the load balancer of the remote backend
+	remoteAddressBudget = 300
+	splitAppConfig      = "v1alpha2/rule-cross-cluster.yaml.tmpl"
+	// number of requests sent at once from a to r
+	splitAppBatch = 10
+)
+
+// multiCluster checks the routing from the primary cluster to a backend of the remote one without
+// a sidecar, registered by hand, then, when the apps of the remote cluster are deployed, the discovery
+// of the app r split across the clusters, r-v1 running in the primary cluster and r-v2 in the remote
+// one, and the routing of its clients to either cluster.
+type multiCluster struct {
+	*tutil.Environment
+	// namespace of the backend in the remote cluster
+	remoteNamespace string
+	// pods of the remote backend
+	remotePods []string
+	// load balancer address of the remote backend
+	remoteAddress string
+}
+
+func (t *multiCluster) String() string {
+	return "multi-cluster"
+}
+
+func (t *multiCluster) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelReachability, tutil.LabelRouting, tutil.LabelSlow}
+}
+
+func (t *multiCluster) enabled() bool {
+	return t.RemoteKubeClient != nil && t.Config.V1alpha2
+}
+
+// Setup deploys a backend in the remote cluster behind a load balancer, which stands for the
+// east-west gateway of the remote cluster, and registers its address as an external service.
+func (t *multiCluster) Setup() error {
+	if !t.enabled() {
+		return nil
+	}
+
+	var err error
+	t.remoteNamespace, err = util.CreateNamespaceWithPrefix(t.RemoteKubeClient, "istio-test-remote-", false)
+	if err != nil {
+		return err
+	}
+	yaml, err := t.Fill("remote-app.yaml.tmpl", map[string]string{
+		"AppHub": t.Config.AppImageHub(),
+		"AppTag": t.Config.AppImageTag(),
+	})
+	if err != nil {
+		return err
+	}
+	if err = t.RemoteKubeApply(yaml, t.remoteNamespace); err != nil {
+		return err
+	}
+	pods, err := util.GetAppPods(t.RemoteKubeClient, t.Config.RemoteKubeConfig, []string{t.remoteNamespace})
+	if err != nil {
+		return err
+	}
+	t.remotePods = pods["remote"]
+
+	ip, err := t.waitForRemoteAddress()
+	if err != nil {
+		return err
+	}
+	discovery := "DNS"
+	if ip {
+		discovery = "STATIC"
+	}
+	return t.ApplyConfig("v1alpha2/external-service-remote.yaml.tmpl", map[string]string{
+		"Host":      remoteHost,
+		"Address":   t.remoteAddress,
+		"Discovery": discovery,
+	})
+}
+
+// waitForRemoteAddress waits for the load balancer of the remote backend to have an address,
+// and returns whether it is an IP rather than a hostname.
+func (t *multiCluster) waitForRemoteAddress() (bool, error) {
+	isIP := false
+	err := t.Eventually(tutil.DefaultBudget.Attempting(remoteAddressBudget), map[string]func() tutil.Status{
+		"Load balancer address of the remote backend": func() tutil.Status {
+			svc, err := t.RemoteKubeClient.CoreV1().Services(t.remoteNamespace).Get("remote", metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			for _, ingress := range svc.Status.LoadBalancer.Ingress {
+				if ingress.IP != "" {
+					t.remoteAddress, isIP = ingress.IP, true
+					return nil
+				}
+				if ingress.Hostname != "" {
+					t.remoteAddress = ingress.Hostname
+					return nil
+				}
+			}
+			return tutil.ErrAgain
+		},
+	})
+	return isIP, err
+}
+
+// Run checks that requests from "a" for the remote host are routed by its sidecar to the backend
+// of the remote cluster, then routes the split app r to each cluster.
+func (t *multiCluster) Run() error {
+	if t.RemoteKubeClient == nil {
+		return tutil.Skip("no remote kubeconfig is set")
+	}
+	if !t.Config.V1alpha2 {
+		return tutil.Skip("v1alpha2 routing rules are disabled")
+	}
+
+	cluster := "cluster.out." + remoteHost
+	url := fmt.Sprintf("http://%s/a", t.remoteAddress)
+	err := t.Eventually(tutil.DefaultBudget, map[string]func() tutil.Status{
+		"Request from a to the remote cluster": func() tutil.Status {
+			resp := t.ClientRequest("a", url, 1, "-key Host -val "+remoteHost)
+			if !resp.IsHTTPOk() {
+				return tutil.ErrAgain
+			}
+			if len(resp.Hostname) == 0 || !containsPod(t.remotePods, resp.Hostname[0]) {
+				return fmt.Errorf("request to the remote cluster was served by %v, want one of %v",
+					resp.Hostname, t.remotePods)
+			}
+			// the load balancer is reachable without the mesh, so check that the sidecar routed the request
+			stats, err := t.ProxyStats("a")
+			if err != nil {
+				return err
+			}
+			if sumStats(stats, cluster, ".upstream_rq_total") == 0 {
+				log.Infof("no request recorded yet in the stats of %s", cluster)
+				return tutil.ErrAgain
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if len(t.RemoteApps["r"]) == 0 {
+		log.Info("The apps of the remote cluster are not deployed, skipping the split app r")
+		return nil
+	}
+	return t.checkSplitApp()
+}
+
+// checkSplitApp waits for Pilot to have the endpoints of r in both clusters, then routes r to each
+// version in turn and checks that the requests from a reach the pods of its cluster.
+func (t *multiCluster) checkSplitApp() error {
+	if err := t.WaitForPilotEndpoints("r", "http", len(t.Apps["r"])+len(t.RemoteApps["r"])); err != nil {
+		return err
+	}
+	for _, route := range []struct {
+		subset string
+		pods   []string
+	}{
+		{"v2", t.RemoteApps["r"]},
+		{"v1", t.Apps["r"]},
+	} {
+		if err := t.ApplyConfig(splitAppConfig, map[string]string{"Subset": route.subset}); err != nil {
+			return err
+		}
+		err := tutil.Repeat(func() error {
+			resp := t.ClientRequest("a", "http://r/a", splitAppBatch, "")
+			if len(resp.Hostname) != splitAppBatch {
+				return fmt.Errorf("%d of %d requests from a to r were answered", len(resp.Hostname), splitAppBatch)
+			}
+			for _, hostname := range resp.Hostname {
+				if !containsPod(route.pods, hostname) {
+					return fmt.Errorf("request from a to r %s was served by %s, want one of %v",
+						route.subset, hostname, route.pods)
+				}
+			}
+			return nil
+		}, 10, time.Second)
+		if err != nil {
+			return err
+		}
+		log.Infof("Requests from a to r %s reached %v", route.subset, route.pods)
+	}
+	return nil
+}
+
+func (t *multiCluster) Teardown() {
+	if !t.enabled() {
+		return
+	}
+	log.Info("Cleaning up the configs of the remote cluster...")
+	if err := t.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+	util.DeleteNamespace(t.RemoteKubeClient, t.remoteNamespace)
+	t.remoteNamespace = ""
+}
@@ -0,0 +1,136 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	// traffic from a to c before Pilot is deleted, and after the new one is ready
+	pilotRestartWarmup = 5 * time.Second
+	// number of requests sent at once from a to c
+	pilotRestartBatch = 10
+	// failed requests allowed while Pilot restarts, the sidecars serving from the config they have
+	pilotRestartFailureBudget = 2
+)
+
+// pilotRestart deletes the Pilot pod once while sending traffic from a to c, unlike resilience which
+// restarts it over and over, and checks that a route rule applied to the new Pilot propagates.
+type pilotRestart struct {
+	*tutil.Environment
+}
+
+func (t *pilotRestart) String() string {
+	return "pilot-restart"
+}
+
+func (t *pilotRestart) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelRouting, tutil.LabelSlow}
+}
+
+func (t *pilotRestart) Setup() error {
+	if !t.Config.V1alpha2 {
+		return nil
+	}
+	return t.ApplyConfig("v1alpha2/destination-rule-c.yaml.tmpl", nil)
+}
+
+// Run sends requests from a to c while Pilot is deleted and replaced, checks that no more than
+// pilotRestartFailureBudget of them fail, then routes c to v1 and checks that the route applies.
+func (t *pilotRestart) Run() error {
+	if !t.Config.V1alpha2 {
+		return tutil.Skip("v1alpha2 is disabled")
+	}
+
+	var (
+		mutex              sync.Mutex
+		requests, failures int
+		wg                 sync.WaitGroup
+	)
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			resp := t.ClientRequest("a", "http://c/a", pilotRestartBatch, "")
+			ok := 0
+			for _, code := range resp.Code {
+				if code == "200" {
+					ok++
+				}
+			}
+			mutex.Lock()
+			requests += pilotRestartBatch
+			failures += pilotRestartBatch - ok
+			mutex.Unlock()
+		}
+	}()
+
+	time.Sleep(pilotRestartWarmup)
+	pod, err := t.KillPod(t.PilotTarget())
+	if err == nil {
+		// the new Pilot serves the endpoints of c once it is ready
+		if err = t.WaitForPilotEndpoints("c", "http", 2); err == nil {
+			time.Sleep(pilotRestartWarmup)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+
+	log.Infof("%d/%d requests from a to c failed while pilot pod %s was replaced", failures, requests, pod)
+	if failures > pilotRestartFailureBudget {
+		return fmt.Errorf("%d/%d requests from a to c failed while pilot pod %s was replaced, want at most %d",
+			failures, requests, pod, pilotRestartFailureBudget)
+	}
+
+	if err = t.ApplyConfig("v1alpha2/rule-default-route.yaml.tmpl", nil); err != nil {
+		return err
+	}
+	return tutil.Repeat(func() error {
+		resp := t.ClientRequest("a", "http://c/a", pilotRestartBatch, "")
+		if len(resp.Version) != pilotRestartBatch {
+			return fmt.Errorf("%d of %d requests from a to c were answered", len(resp.Version), pilotRestartBatch)
+		}
+		for _, version := range resp.Version {
+			if version != "v1" {
+				return fmt.Errorf("request from a to c reached %s after the restart of pilot, want v1", version)
+			}
+		}
+		return nil
+	}, 5, time.Second)
+}
+
+func (t *pilotRestart) Teardown() {
+	if !t.Config.V1alpha2 {
+		return
+	}
+	if err := t.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+}
@@ -15,192 +15,102 @@
 package pilot
 
 import (
-	"flag"
-	"fmt"
 	"os"
-	"strconv"
 	"testing"
 
-	"github.com/davecgh/go-spew/spew"
-
-	"istio.io/istio/pkg/log"
 	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
 )
 
-const (
-	authTestName   = "Auth"
-	noAuthTestName = "NoAuth"
-)
-
-// AuthMode is an enumeration for the auth mode flag.
-type authMode string
-
-const (
-	authModeEnable  authMode = "enable"
-	authModeDisable authMode = "disable"
-	authModeBoth    authMode = "both"
-)
-
-var (
-	config = tutil.NewConfig()
-
-	// Enable/disable auth, or run both for the tests.
-	authmode string
-	verbose  bool
-)
+var suite = tutil.NewSuite(pilotTests)
 
 func init() {
-	flag.StringVar(&config.Hub, "hub", config.Hub, "Docker hub")
-	flag.StringVar(&config.Tag, "tag", config.Tag, "Docker tag")
-	flag.StringVar(&config.IstioNamespace, "ns", config.IstioNamespace,
-		"Namespace in which to install Istio components (empty to create/delete temporary one)")
-	flag.StringVar(&config.Namespace, "n", config.Namespace,
-		"Namespace in which to install the applications (empty to create/delete temporary one)")
-	flag.StringVar(&config.Registry, "registry", config.Registry, "Pilot registry")
-	flag.BoolVar(&verbose, "verbose", false, "Debug level noise from proxies")
-	flag.BoolVar(&config.CheckLogs, "logs", config.CheckLogs,
-		"Validate pod logs (expensive in long-running tests)")
-
-	flag.StringVar(&config.KubeConfig, "kubeconfig", config.KubeConfig,
-		"kube config file (missing or empty file makes the test use in-cluster kube config instead)")
-	flag.IntVar(&config.TestCount, "count", config.TestCount, "Number of times to run each test")
-	flag.StringVar(&authmode, "auth", string(authModeBoth),
-		fmt.Sprintf("Auth mode for the tests (Choose from %s, %s, %s)", authModeEnable, authModeDisable, authModeBoth))
-	flag.BoolVar(&config.Mixer, "mixer", config.Mixer, "Enable / disable mixer.")
-	flag.BoolVar(&config.V1alpha1, "v1alpha1", config.V1alpha1, "Enable / disable v1alpha1 routing rules.")
-	flag.BoolVar(&config.V1alpha2, "v1alpha2", config.V1alpha2, "Enable / disable v1alpha2 routing rules.")
-	flag.BoolVar(&config.RDSv2, "rdsv2", false, "Enable RDSv2 for v1alpha2")
-	flag.BoolVar(&config.NoRBAC, "norbac", false, "Disable RBAC YAML")
-	flag.StringVar(&config.ErrorLogsDir, "errorlogsdir", config.ErrorLogsDir,
-		"Store per pod logs as individual files in specific directory instead of writing to stderr.")
-	flag.StringVar(&config.CoreFilesDir, "core-files-dir", config.CoreFilesDir,
-		"Copy core files to this directory on the Kubernetes node machine.")
-
-	// If specified, only run one test
-	flag.StringVar(&config.SelectedTest, "testtype", config.SelectedTest,
-		"Select test to run (default is all tests)")
-
-	flag.BoolVar(&config.UseAutomaticInjection, "use-sidecar-injector", config.UseAutomaticInjection,
-		"Use automatic sidecar injector")
-	flag.BoolVar(&config.UseAdmissionWebhook, "use-admission-webhook", config.UseAdmissionWebhook,
-		"Use k8s external admission webhook for config validation")
-
-	flag.StringVar(&config.AdmissionServiceName, "admission-service-name", config.AdmissionServiceName,
-		"Name of admission webhook service name")
-
-	flag.IntVar(&config.DebugPort, "debugport", config.DebugPort, "Debugging port")
-
-	flag.BoolVar(&config.DebugImagesAndMode, "debug", config.DebugImagesAndMode,
-		"Use debug images and mode (false for prod)")
-	flag.BoolVar(&config.SkipCleanup, "skip-cleanup", config.SkipCleanup,
-		"Debug, skip clean up")
-	flag.BoolVar(&config.SkipCleanupOnFailure, "skip-cleanup-on-failure", config.SkipCleanupOnFailure,
-		"Debug, skip clean up on failure")
-}
-
-func setup(env *tutil.Environment, t *testing.T) {
-	tutil.Tlog("Deploying infrastructure", spew.Sdump(env.Config))
-	if env.Err = env.Setup(); env.Err != nil {
-		t.Fatal(env.Err)
+	suite.ScenarioDir = "testdata/scenarios"
+	// tests that churn routing config, profiled around when -pprof-dir is set
+	suite.ProfiledTests = map[string]bool{
+		"routing-rules":           true,
+		"routing-rules-to-egress": true,
+		"many-routes":             true,
 	}
 }
 
-func teardown(env *tutil.Environment) {
-	env.Teardown()
-}
-
-func TestPilot(t *testing.T) {
-	if verbose {
-		config.Verbosity = 3
-	}
-
-	// Only run the tests if the user has defined the KUBECONFIG environment variable.
-	if config.KubeConfig == "" {
-		t.Skip("Env variable KUBECONFIG not set. Skipping tests")
-	}
-
-	if config.Hub == "" {
-		t.Skip("HUB not specified. Skipping tests")
-	}
-
-	if config.Tag == "" {
-		t.Skip("TAG not specified. Skipping tests")
-	}
-
-	if config.Namespace != "" && authMode(authmode) == authModeBoth {
-		t.Skipf("When namespace(=%s) is specified, auth mode(=%s) must be one of enable or disable. Skipping tests.",
-			config.Namespace, authmode)
-	}
-
-	noAuthConfig := config
-	authConfig := config
-	authConfig.Auth = true
-
-	switch authMode(authmode) {
-	case authModeEnable:
-		doTest(authTestName, authConfig, t)
-	case authModeDisable:
-		doTest(noAuthTestName, noAuthConfig, t)
-	case authModeBoth:
-		doTest(noAuthTestName, noAuthConfig, t)
-		doTest(authTestName, authConfig, t)
-	default:
-		t.Fatalf("Unknown auth mode(=%s).", authmode)
+// pilotTests returns the tests of pilot, in the order they run.
+func pilotTests(env *tutil.Environment, concurrent func(name string) *tutil.Environment) []tutil.Test {
+	return []tutil.Test{
+		&upgrade{Environment: env},
+		&http{Environment: env},
+		&grpc{Environment: env},
+		&grpcHealth{Environment: env},
+		&tcp{Environment: env},
+		&consulRegistry{Environment: env},
+		&headless{Environment: env},
+		&meshExpansion{Environment: env},
+		&ingress{Environment: env},
+		&egressRules{Environment: concurrent("egress-rules")},
+		&routing{Environment: concurrent("routing-rules")},
+		&routingParity{Environment: env},
+		&routePrecedence{Environment: env},
+		&routingToEgress{Environment: env},
+		&zipkin{Environment: env},
+		&prometheusMetrics{Environment: env},
+		&telemetryAttributes{Environment: env},
+		&authExclusion{Environment: env},
+		&kubernetesExternalNameServices{Environment: env},
+		&multiHostVirtualService{Environment: env},
+		&gatewayMultiServer{Environment: env},
+		&gatewayScaling{Environment: env},
+		&ipv6{Environment: env},
+		&ipReuse{Environment: env},
+		&externalServiceDiscovery{Environment: env},
+		&manyRoutes{Environment: env},
+		&rateLimit{Environment: env},
+		&mixerPolicy{Environment: env},
+		&malformedRequest{Environment: env},
+		&singleDestinationWeighted{Environment: env},
+		&authorityRewriteMTLS{Environment: env},
+		&tcpMtls{Environment: env},
+		&certRotation{Environment: env},
+		&admissionWebhook{Environment: env},
+		&sidecarInjection{Environment: env},
+		&interception{Environment: env},
+		&gatewayToExternal{Environment: env},
+		&httpConnect{Environment: env},
+		&gracefulDrain{Environment: env},
+		&sidecarUpgrade{Environment: env},
+		&retryPolicy{Environment: env},
+		&requestTimeout{Environment: env},
+		&headerRouting{Environment: env},
+		&routeMatch{Environment: env},
+		&headerManipulation{Environment: env},
+		&redirectRewrite{Environment: env},
+		&corsPolicy{Environment: env},
+		&loadBalancing{Environment: env},
+		&subsets{Environment: env, versions: 3, replicas: 2},
+		&portProtocols{Environment: env},
+		&appImages{Environment: env},
+		&multiCluster{Environment: env},
+		&authzPolicy{Environment: env},
+		&endUserAuth{Environment: env},
+		&configPropagation{Environment: env},
+		&scale{Environment: env},
+		&load{Environment: env},
+		&resilience{Environment: env},
+		&pilotRestart{Environment: env},
+		&faultInjection{Environment: env},
+		&mirroring{Environment: env},
+		&circuitBreaking{Environment: env},
+		&connectionPool{Environment: env},
+		&idleConnections{Environment: env},
+		&websocketRouting{Environment: env},
+		&tlsOrigination{Environment: env},
+		&gatewayTLS{Environment: env},
 	}
 }
 
-func doTest(testName string, config *tutil.Config, t *testing.T) {
-	t.Run(testName, func(t *testing.T) {
-		env := tutil.NewEnvironment(*config)
-		defer teardown(env)
-		setup(env, t)
-
-		tests := []tutil.Test{
-			&http{Environment: env},
-			&grpc{Environment: env},
-			&tcp{Environment: env},
-			&headless{Environment: env},
-			&ingress{Environment: env},
-			&egressRules{Environment: env},
-			&routing{Environment: env},
-			&routingToEgress{Environment: env},
-			&zipkin{Environment: env},
-			&authExclusion{Environment: env},
-			&kubernetesExternalNameServices{Environment: env},
-		}
-
-		for _, test := range tests {
-			// If the user has specified a test, skip all other tests
-			if len(config.SelectedTest) > 0 && config.SelectedTest != test.String() {
-				continue
-			}
-
-			// Run the test the configured number of times.
-			for i := 0; i < config.TestCount; i++ {
-				testName := test.String()
-				if config.TestCount > 1 {
-					testName = testName + "_attempt_" + strconv.Itoa(i+1)
-				}
-				t.Run(testName, func(t *testing.T) {
-					if env.Err = test.Setup(); env.Err != nil {
-						t.Fatal(env.Err)
-					}
-					defer test.Teardown()
-
-					if env.Err = test.Run(); env.Err != nil {
-						t.Error(env.Err)
-					}
-				})
-			}
-		}
-	})
+func TestPilot(t *testing.T) {
+	suite.Run(t)
 }
 
 // TODO(nmittler): convert individual tests over to pure golang tests
 func TestMain(m *testing.M) {
-	flag.Parse()
-	_ = log.Configure(log.DefaultOptions())
-
-	// Run all tests.
-	os.Exit(m.Run())
+	os.Exit(suite.Main(m))
 }
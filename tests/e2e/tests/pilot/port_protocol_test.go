@@ -0,0 +1,143 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+// app whose service ports are named for another protocol than the one served, or for none
+const portProtocolApp = "protocols"
+
+// portProtocols pins down what the sidecars do with the service ports whose name does not give the
+// protocol served: Pilot takes the protocol from the name prefix, TCP when there is none, and
+// proxies the traffic as such rather than detecting it. HTTP on a TCP port goes through without the
+// HTTP features of the proxy, and raw TCP on an HTTP port does not go through.
+type portProtocols struct {
+	*tutil.Environment
+	deployed string
+}
+
+func (t *portProtocols) String() string {
+	return "port-protocols"
+}
+
+func (t *portProtocols) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelReachability}
+}
+
+// Setup deploys an app serving HTTP on the ports 80, 81 and 82, and raw TCP on 83, with the service
+// ports named http, web, tcp and http-raw.
+func (t *portProtocols) Setup() error {
+	var err error
+	t.deployed, err = t.DeployApp(tutil.AppSpec{
+		Deployment: portProtocolApp,
+		Version:    "v1",
+		Ports: []tutil.AppPort{
+			{Name: "http", Port: 80},
+			{Name: "web", Port: 81},
+			{Name: "tcp", Port: 82},
+			{Name: "http-raw", Port: 83},
+		},
+		Args: []string{"--port", "80", "--port", "81", "--port", "82", "--tcp", "83", "--version", "v1"},
+	})
+	if err != nil {
+		return err
+	}
+	return t.RefreshApps()
+}
+
+func (t *portProtocols) Teardown() {
+	if t.deployed == "" {
+		return
+	}
+	if err := t.KubeDelete(t.deployed, t.Config.Namespace); err != nil {
+		log.Warna(err)
+	}
+	t.deployed = ""
+	if err := t.RefreshApps(); err != nil {
+		log.Warna(err)
+	}
+}
+
+// Run sends the protocol each port of the app serves from a. The HTTP requests proxied as HTTP get
+// the x-request-id header of the proxy, those proxied as TCP reach the app as sent.
+func (t *portProtocols) Run() error {
+	host := portProtocolApp
+	cases := []struct {
+		description string
+		url         string
+		check       func(resp tutil.Response) error
+	}{
+		{
+			description: "HTTP on the http port is proxied as HTTP",
+			url:         fmt.Sprintf("http://%s:80/a", host),
+			check:       func(resp tutil.Response) error { return checkProxiedAs(resp, true) },
+		},
+		{
+			description: "HTTP on the web port, with no protocol prefix, is proxied as TCP",
+			url:         fmt.Sprintf("http://%s:81/a", host),
+			check:       func(resp tutil.Response) error { return checkProxiedAs(resp, false) },
+		},
+		{
+			description: "HTTP on the tcp port is proxied as TCP",
+			url:         fmt.Sprintf("http://%s:82/a", host),
+			check:       func(resp tutil.Response) error { return checkProxiedAs(resp, false) },
+		},
+		{
+			description: "raw TCP on the http-raw port is refused by the HTTP proxy",
+			url:         fmt.Sprintf("tcp://%s:83", host),
+			check: func(resp tutil.Response) error {
+				if strings.Contains(resp.Body, "TCPEchoed=") {
+					return fmt.Errorf("raw TCP went through the HTTP port:\n%s", resp.Body)
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, cs := range cases {
+		tutil.Tlog("Checking port protocols", cs.description)
+		check := cs.check
+		url := cs.url
+		if err := tutil.Repeat(func() error {
+			return check(t.ClientRequest("a", url, 1, ""))
+		}, 5, time.Second); err != nil {
+			return fmt.Errorf("%s: %v", cs.description, err)
+		}
+	}
+	return nil
+}
+
+// checkProxiedAs checks that the HTTP request succeeded, through the HTTP filters of the proxy if
+// http is set, and through its TCP proxy otherwise.
+func checkProxiedAs(resp tutil.Response, http bool) error {
+	if !resp.IsHTTPOk() {
+		return fmt.Errorf("request failed with codes %v:\n%s", resp.Code, resp.Body)
+	}
+	requestID := strings.Contains(strings.ToLower(resp.Body), "x-request-id=")
+	if http && !requestID {
+		return fmt.Errorf("request reached the app without the x-request-id header of the HTTP proxy")
+	}
+	if !http && requestID {
+		return fmt.Errorf("request reached the app with the x-request-id header of the HTTP proxy")
+	}
+	return nil
+}
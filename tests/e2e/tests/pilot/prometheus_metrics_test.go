@@ -0,0 +1,101 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"time"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	// requests from a to b counted by the test
+	metricsRequests = 10
+	// response code of the counted requests, which no other test asks b for, so that their
+	// traffic does not add to the count
+	metricsCode = "418"
+)
+
+// prometheusMetrics checks the request count Mixer exports to Prometheus. The metric is named
+// istio_request_count in this version, istio_requests_total in later ones.
+type prometheusMetrics struct {
+	*tutil.Environment
+	prometheus *tutil.Prometheus
+}
+
+func (t *prometheusMetrics) String() string {
+	return "prometheus-metrics"
+}
+
+func (t *prometheusMetrics) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelTelemetry, tutil.LabelSlow}
+}
+
+func (t *prometheusMetrics) Setup() error {
+	if !t.Config.Mixer || !t.Config.Prometheus {
+		return nil
+	}
+	var err error
+	t.prometheus, err = t.Prometheus()
+	return err
+}
+
+// Run sends requests from a to b answered with a code of their own, and checks that the request
+// count of a to b with that code grows by exactly their number once Prometheus scraped Mixer.
+func (t *prometheusMetrics) Run() error {
+	if !t.Config.Mixer || !t.Config.Prometheus {
+		return tutil.Skip("mixer or prometheus is disabled, see -prometheus")
+	}
+
+	query := fmt.Sprintf(`istio_request_count{source_service="a.%[1]s.svc.cluster.local",`+
+		`destination_service="b.%[1]s.svc.cluster.local",response_code="%[2]s"}`, t.Config.Namespace, metricsCode)
+	before, err := t.prometheus.Query(query)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("http://b/metrics?codes=%s", metricsCode)
+	resp := t.ClientRequest("a", url, metricsRequests, "")
+	if len(resp.Code) != metricsRequests {
+		return fmt.Errorf("%d of %d requests from a to b were answered", len(resp.Code), metricsRequests)
+	}
+	for _, code := range resp.Code {
+		if code != metricsCode {
+			return fmt.Errorf("request from a to %s returned %s, want %s", url, code, metricsCode)
+		}
+	}
+
+	// Mixer batches its reports and Prometheus scrapes it every few seconds
+	return tutil.Repeat(func() error {
+		after, queryErr := t.prometheus.Query(query)
+		if queryErr != nil {
+			return queryErr
+		}
+		log.Infof("%s went from %v to %v", query, before, after)
+		if after-before != metricsRequests {
+			return fmt.Errorf("prometheus counted %v requests from a to b, want %d", after-before, metricsRequests)
+		}
+		return nil
+	}, 12, 5*time.Second)
+}
+
+func (t *prometheusMetrics) Teardown() {
+	if t.prometheus != nil {
+		t.prometheus.Close()
+		t.prometheus = nil
+	}
+}
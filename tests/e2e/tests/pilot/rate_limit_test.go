@@ -0,0 +1,146 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	rateLimitConfig = "mixer-rate-limit.yaml.tmpl"
+	// number of windows of the sustained load, each starting with a burst over the quota
+	rateLimitWindows = 3
+)
+
+type rateLimit struct {
+	*tutil.Environment
+	quota string
+}
+
+func (t *rateLimit) String() string {
+	return "rate-limit"
+}
+
+func (t *rateLimit) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelTelemetry}
+}
+
+func (t *rateLimit) Setup() error {
+	if !t.Config.Mixer {
+		return nil
+	}
+	quota, err := t.Fill(rateLimitConfig, map[string]string{
+		"Namespace":      t.Config.Namespace,
+		"IstioNamespace": t.Config.IstioNamespace,
+		"MaxAmount":      strconv.Itoa(t.Config.RateLimitRequests),
+		"ValidDuration":  t.Config.RateLimitWindow.String(),
+	})
+	if err != nil {
+		return err
+	}
+	if err = t.KubeApply(quota, t.Config.IstioNamespace); err != nil {
+		return err
+	}
+	t.quota = quota
+	return nil
+}
+
+func (t *rateLimit) Teardown() {
+	if t.quota == "" {
+		return
+	}
+	if err := t.KubeDelete(t.quota, t.Config.IstioNamespace); err != nil {
+		log.Warna(err)
+	}
+	t.quota = ""
+}
+
+// Run fires more requests from "a" to "c" than the quota allows in one window and checks
+// that the excess is rejected with 429, then that a new burst succeeds once the window is over,
+// and finally that a load over the quota for several windows is let through at roughly the quota.
+func (t *rateLimit) Run() error {
+	if !t.Config.Mixer {
+		return tutil.Skip("mixer is disabled")
+	}
+
+	allowed := t.Config.RateLimitRequests
+	burst := 2 * allowed
+	// the quota rule takes a while to reach mixer, so keep bursting until it applies
+	err := tutil.Repeat(func() error {
+		ok, limited := t.burst(burst)
+		if limited == 0 {
+			time.Sleep(t.Config.RateLimitWindow)
+			return fmt.Errorf("no request out of %d was rate limited", burst)
+		}
+		if ok > allowed {
+			return fmt.Errorf("%d requests succeeded in one window, want at most %d", ok, allowed)
+		}
+		return nil
+	}, 10, time.Second)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Waiting %v for the quota window to reset", t.Config.RateLimitWindow)
+	time.Sleep(t.Config.RateLimitWindow)
+	if ok, limited := t.burst(allowed); limited > 0 {
+		return fmt.Errorf("%d out of %d requests were rate limited after the quota window reset (%d succeeded)",
+			limited, allowed, ok)
+	}
+	time.Sleep(t.Config.RateLimitWindow)
+	return t.sustained(allowed, burst)
+}
+
+// sustained sends a burst over the quota in each of rateLimitWindows windows, and checks that every burst is
+// rate limited and that the requests let through over all windows are between half the quota and the quota.
+func (t *rateLimit) sustained(allowed, burst int) error {
+	total := 0
+	for window := 1; window <= rateLimitWindows; window++ {
+		ok, limited := t.burst(burst)
+		if limited == 0 {
+			return fmt.Errorf("window %d: no request out of %d was rate limited", window, burst)
+		}
+		total += ok
+		if window < rateLimitWindows {
+			time.Sleep(t.Config.RateLimitWindow)
+		}
+	}
+	if quota := rateLimitWindows * allowed; total < quota/2 || total > quota {
+		return fmt.Errorf("%d requests succeeded over %d windows of %d requests, want between %d and %d",
+			total, rateLimitWindows, allowed, quota/2, quota)
+	}
+	log.Infof("%d requests succeeded over %d windows of %d requests", total, rateLimitWindows, allowed)
+	return nil
+}
+
+// burst sends count requests from "a" to "c" and returns how many succeeded and how many got 429.
+func (t *rateLimit) burst(count int) (ok, limited int) {
+	resp := t.ClientRequest("a", "http://c/a", count, "")
+	for _, code := range resp.Code {
+		switch code {
+		case "200":
+			ok++
+		case "429":
+			limited++
+		}
+	}
+	log.Infof("Burst of %d requests: %d succeeded, %d rate limited", count, ok, limited)
+	return ok, limited
+}
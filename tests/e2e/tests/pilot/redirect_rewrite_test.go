@@ -0,0 +1,143 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	redirectRewriteConfig      = "v1alpha2/rule-redirect-rewrite.yaml.tmpl"
+	redirectRewriteGatewayHost = "redirect.example.com"
+	redirectRewriteAuthority   = "rewritten.example.com"
+)
+
+var locationRex = regexp.MustCompile(`ResponseHeader=Location:(.*)`)
+
+type redirectRewrite struct {
+	*tutil.Environment
+}
+
+func (t *redirectRewrite) String() string {
+	return "redirect-rewrite"
+}
+
+func (t *redirectRewrite) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelRouting, tutil.LabelGateway}
+}
+
+func (t *redirectRewrite) Setup() error {
+	if !t.Config.V1alpha2 {
+		return nil
+	}
+	return t.ApplyConfig(redirectRewriteConfig, map[string]string{
+		"GatewayHost": redirectRewriteGatewayHost,
+		"Authority":   redirectRewriteAuthority,
+	})
+}
+
+// Run checks, from a sidecar and through the gateway, that the redirect route answers 301 with the
+// redirected location, and that the rewrite route reaches c with the rewritten path and authority.
+func (t *redirectRewrite) Run() error {
+	if !t.Config.V1alpha2 {
+		return tutil.Skip("v1alpha2 routing rules are disabled")
+	}
+
+	paths := []struct {
+		name  string
+		src   string
+		base  string
+		extra string
+	}{
+		{"sidecar", "a", "http://c", ""},
+		{"gateway", "t", fmt.Sprintf("http://%s.%s", gatewayServiceName, t.Config.IstioNamespace),
+			"-key Host -val " + redirectRewriteGatewayHost},
+	}
+	funcs := make(map[string]func() tutil.Status)
+	for _, path := range paths {
+		funcs[fmt.Sprintf("Redirect through the %s", path.name)] = (func(src, base, extra string) func() tutil.Status {
+			return func() tutil.Status {
+				return t.verifyRedirect(src, base+"/redirect", extra)
+			}
+		})(path.src, path.base, path.extra)
+		funcs[fmt.Sprintf("Rewrite through the %s", path.name)] = (func(src, base, extra string) func() tutil.Status {
+			return func() tutil.Status {
+				return t.verifyRewrite(src, base+"/rewrite/a?format=json", extra)
+			}
+		})(path.src, path.base, path.extra)
+	}
+	return t.Eventually(tutil.DefaultBudget, funcs)
+}
+
+// verifyRedirect checks that the request is answered with a 301 to http://b/new/path.
+func (t *redirectRewrite) verifyRedirect(src, target, extra string) tutil.Status {
+	resp := t.ClientRequest(src, target, 1, "-follow-redirects=false "+extra)
+	if len(resp.Code) == 0 || resp.Code[0] != "301" {
+		log.Infof("Request from %s to %s returned %v, want 301", src, target, resp.Code)
+		return tutil.ErrAgain
+	}
+	matches := locationRex.FindStringSubmatch(resp.Body)
+	if len(matches) < 2 {
+		return fmt.Errorf("redirect of %s has no Location header", target)
+	}
+	location, err := url.Parse(matches[1])
+	if err != nil {
+		return fmt.Errorf("redirect of %s has an invalid Location %q: %v", target, matches[1], err)
+	}
+	if location.Host != "b" || location.Path != "/new/path" {
+		return fmt.Errorf("redirect of %s has Location %s, want b/new/path", target, location)
+	}
+	return nil
+}
+
+// verifyRewrite checks that c received the request at /new/a, for the rewritten authority.
+func (t *redirectRewrite) verifyRewrite(src, target, extra string) tutil.Status {
+	resp := t.ClientRequest(src, target, 1, extra)
+	if !resp.IsHTTPOk() {
+		log.Infof("Request from %s to %s returned %v", src, target, resp.Code)
+		return tutil.ErrAgain
+	}
+	payloads, err := resp.EchoPayloads()
+	if err != nil {
+		return err
+	}
+	if len(payloads) != 1 {
+		return fmt.Errorf("request to %s got %d echo payloads, want 1", target, len(payloads))
+	}
+	payload := payloads[0]
+	if !containsPod(t.Apps["c"], payload.Hostname) {
+		return fmt.Errorf("rewritten request to %s reached pod %s, want app c", target, payload.Hostname)
+	}
+	if payload.URL != "/new/a?format=json" || payload.Host != redirectRewriteAuthority {
+		return fmt.Errorf("rewritten request to %s reached c at %s%s, want %s/new/a?format=json", target,
+			payload.Host, payload.URL, redirectRewriteAuthority)
+	}
+	return nil
+}
+
+func (t *redirectRewrite) Teardown() {
+	if !t.Config.V1alpha2 {
+		return
+	}
+	log.Info("Cleaning up route rules...")
+	if err := t.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+}
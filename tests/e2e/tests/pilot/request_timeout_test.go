@@ -0,0 +1,167 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	// slack given to the timeout for the round trips through kubectl exec and the sidecars
+	requestTimeoutSlack = 2 * time.Second
+	// number of retries of a request that timed out
+	retryTimeoutAttempts = 2
+	// route timeout, well beyond the time all tries take, so that only the per-try timeout cuts them short
+	retryTimeoutRoute = 30 * time.Second
+)
+
+type requestTimeout struct {
+	*tutil.Environment
+}
+
+func (t *requestTimeout) String() string {
+	return "request-timeout"
+}
+
+func (t *requestTimeout) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelRouting}
+}
+
+func (t *requestTimeout) Setup() error {
+	return nil
+}
+
+// Run checks that a request from "a" to a slow "b" completes without a route timeout, then that
+// it is cut short with a 504 once the route of "b" has a timeout below the response time, and finally
+// that the tries of the request are cut short by a per-try timeout below it, see checkPerTryTimeout.
+func (t *requestTimeout) Run() error {
+	if !t.Config.V1alpha2 {
+		return tutil.Skip("v1alpha2 routing rules are disabled")
+	}
+	if t.Config.RequestSleep <= t.Config.RequestTimeout {
+		return fmt.Errorf("request sleep %v must exceed the request timeout %v",
+			t.Config.RequestSleep, t.Config.RequestTimeout)
+	}
+
+	url := fmt.Sprintf("http://b/a?sleep=%v", t.Config.RequestSleep)
+	// the client must not give up before the backend answers
+	extra := fmt.Sprintf("-timeout %v", t.Config.RequestSleep+time.Minute)
+
+	control := t.ClientRequest("a", url, 1, extra)
+	if !control.IsHTTPOk() {
+		return fmt.Errorf("slow request from a to b failed without a route timeout: %v", control.Code)
+	}
+	if len(control.Latency) == 0 || control.Latency[0] < t.Config.RequestSleep {
+		return fmt.Errorf("slow request from a to b returned before the backend slept %v: %v",
+			t.Config.RequestSleep, control.Latency)
+	}
+
+	// proto durations must be in seconds
+	timeout := fmt.Sprintf("%.3fs", t.Config.RequestTimeout.Seconds())
+	if err := t.ApplyConfig("v1alpha2/rule-timeout-b.yaml.tmpl", map[string]string{"Timeout": timeout}); err != nil {
+		return err
+	}
+	since := time.Now()
+	err := tutil.Repeat(func() error {
+		resp := t.ClientRequest("a", url, 1, extra)
+		if len(resp.Code) == 0 || resp.Code[0] != "504" {
+			return fmt.Errorf("slow request from a to b with a %v timeout returned %v, want 504",
+				t.Config.RequestTimeout, resp.Code)
+		}
+		if len(resp.Latency) == 0 || resp.Latency[0] > t.Config.RequestTimeout+requestTimeoutSlack {
+			return fmt.Errorf("slow request from a to b with a %v timeout took %v",
+				t.Config.RequestTimeout, resp.Latency)
+		}
+		return nil
+	}, 5, time.Second)
+	if err != nil {
+		return err
+	}
+
+	// the sidecar of a, rather than b, must have cut the request short
+	if err = t.AssertAccessLog(t.Apps["a"][0], tutil.AccessLogMatcher{
+		Since:         since,
+		Status:        504,
+		ResponseFlags: "UT",
+		Authority:     "b",
+	}); err != nil {
+		return err
+	}
+	return t.checkPerTryTimeout(url)
+}
+
+// checkPerTryTimeout gives the route of "b" retries with a per-try timeout below the response time,
+// under a route timeout well beyond all the tries, and checks that the request from "a" gets a 504 once
+// every try timed out, with the sidecar of "a" counting the timeouts and retries.
+func (t *requestTimeout) checkPerTryTimeout(url string) error {
+	// proto durations must be in seconds
+	if err := t.ApplyConfig("v1alpha2/rule-timeout-b.yaml.tmpl", map[string]string{
+		"Timeout":       fmt.Sprintf("%.3fs", retryTimeoutRoute.Seconds()),
+		"Attempts":      strconv.Itoa(retryTimeoutAttempts),
+		"PerTryTimeout": fmt.Sprintf("%.3fs", t.Config.RequestTimeout.Seconds()),
+	}); err != nil {
+		return err
+	}
+
+	// the client must not give up before the route timeout
+	extra := fmt.Sprintf("-timeout %v", retryTimeoutRoute+time.Minute)
+	tries := time.Duration(retryTimeoutAttempts+1) * t.Config.RequestTimeout
+	cluster := fmt.Sprintf("cluster.out.b.%s.", t.Config.Namespace)
+	return tutil.Repeat(func() error {
+		before, err := t.ProxyStats("a")
+		if err != nil {
+			return err
+		}
+		resp := t.ClientRequest("a", url, 1, extra)
+		if len(resp.Code) == 0 || resp.Code[0] != "504" {
+			return fmt.Errorf("slow request from a to b with a %v per-try timeout returned %v, want 504",
+				t.Config.RequestTimeout, resp.Code)
+		}
+		if len(resp.Latency) == 0 || resp.Latency[0] < tries || resp.Latency[0] > tries+requestTimeoutSlack {
+			return fmt.Errorf("slow request from a to b with %d tries of %v took %v", retryTimeoutAttempts+1,
+				t.Config.RequestTimeout, resp.Latency)
+		}
+
+		after, err := t.ProxyStats("a")
+		if err != nil {
+			return err
+		}
+		retries := sumStats(after, cluster, ".upstream_rq_retry") - sumStats(before, cluster, ".upstream_rq_retry")
+		timeouts := sumStats(after, cluster, ".upstream_rq_per_try_timeout") -
+			sumStats(before, cluster, ".upstream_rq_per_try_timeout")
+		log.Infof("Slow request from a to b: %d retries, %d per-try timeouts", retries, timeouts)
+		if retries != retryTimeoutAttempts || timeouts != retryTimeoutAttempts+1 {
+			return fmt.Errorf("slow request from a to b was retried %d times after %d per-try timeouts, want %d and %d",
+				retries, timeouts, retryTimeoutAttempts, retryTimeoutAttempts+1)
+		}
+		return nil
+	}, 5, time.Second)
+}
+
+// Teardown removes the timeout rule, whether or not Run got to apply it.
+func (t *requestTimeout) Teardown() {
+	if !t.Config.V1alpha2 {
+		return
+	}
+	log.Info("Cleaning up route rules...")
+	if err := t.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+}
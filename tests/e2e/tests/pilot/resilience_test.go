@@ -0,0 +1,86 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"time"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	// delay between two restarts of Pilot
+	resilienceChaosInterval = 15 * time.Second
+	// number of requests sent at once from a to c
+	resilienceBatch = 10
+)
+
+type resilience struct {
+	*tutil.Environment
+}
+
+func (t *resilience) String() string {
+	return "resilience"
+}
+
+func (t *resilience) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelReachability, tutil.LabelSlow}
+}
+
+func (t *resilience) Setup() error {
+	return nil
+}
+
+func (t *resilience) Teardown() {
+}
+
+// Run restarts Pilot over and over while sending requests from a to c, and checks that the
+// sidecars keep serving them from the config they already have.
+func (t *resilience) Run() error {
+	if t.Config.ResilienceDuration < 2*resilienceChaosInterval {
+		return tutil.Skip("-resilience-duration is below %v, Pilot would not be restarted twice", 2*resilienceChaosInterval)
+	}
+
+	chaos := t.StartChaos(resilienceChaosInterval, t.PilotTarget())
+	requests, ok := 0, 0
+	for deadline := time.Now().Add(t.Config.ResilienceDuration); time.Now().Before(deadline); {
+		resp := t.ClientRequest("a", "http://c/a", resilienceBatch, "")
+		requests += resilienceBatch
+		for _, code := range resp.Code {
+			if code == "200" {
+				ok++
+			}
+		}
+	}
+	kills, err := chaos.Stop()
+	if err != nil {
+		return err
+	}
+	if kills == 0 {
+		return fmt.Errorf("no pilot pod was restarted")
+	}
+
+	ratio := float64(ok) / float64(requests)
+	log.Infof("%d/%d requests from a to c succeeded while pilot was restarted %d times", ok, requests, kills)
+	if ratio < t.Config.ResilienceRatio {
+		return fmt.Errorf("%.1f%% of the requests from a to c succeeded while pilot was restarted %d times, want %.1f%%",
+			100*ratio, kills, 100*t.Config.ResilienceRatio)
+	}
+
+	// the next tests need a running Pilot
+	return t.WaitForPilotEndpoints("c", "http", 2)
+}
@@ -0,0 +1,118 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"time"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	// number of requests sent one after the other, so that b alternates between 503 and 200 for each of them
+	retryRequests = 6
+	// b fails every other request to this URL
+	retryURL = "http://b/a?flaky=503"
+)
+
+type retryPolicy struct {
+	*tutil.Environment
+}
+
+func (t *retryPolicy) String() string {
+	return "retry-policy"
+}
+
+func (t *retryPolicy) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelRouting}
+}
+
+func (t *retryPolicy) Setup() error {
+	if !t.Config.V1alpha2 {
+		return nil
+	}
+	return t.ApplyConfig("v1alpha2/rule-retry-b.yaml.tmpl", nil)
+}
+
+// Run checks that with a retry policy on "b", every request from "a" succeeds although "b" fails
+// every other one, and that the sidecar of "a" counted the retries. Without the policy, the
+// failures must reach the client.
+func (t *retryPolicy) Run() error {
+	if !t.Config.V1alpha2 {
+		return tutil.Skip("v1alpha2 routing rules are disabled")
+	}
+
+	cluster := fmt.Sprintf("cluster.out.b.%s.", t.Config.Namespace)
+	err := tutil.Repeat(func() error {
+		before, err := t.ProxyStats("a")
+		if err != nil {
+			return err
+		}
+		codes := t.sendFlakyRequests()
+		for _, code := range codes {
+			if code != "200" {
+				return fmt.Errorf("requests from a to b with retries returned %v", codes)
+			}
+		}
+		after, err := t.ProxyStats("a")
+		if err != nil {
+			return err
+		}
+		retries := sumStats(after, cluster, ".upstream_rq_retry") - sumStats(before, cluster, ".upstream_rq_retry")
+		if retries == 0 {
+			return fmt.Errorf("no retries recorded in the stats of %s", cluster)
+		}
+		return nil
+	}, 5, time.Second)
+	if err != nil {
+		return err
+	}
+
+	log.Info("Removing the retry policy...")
+	if err = t.DeleteAllConfigs(); err != nil {
+		return err
+	}
+	return tutil.Repeat(func() error {
+		codes := t.sendFlakyRequests()
+		for _, code := range codes {
+			if code == "503" {
+				return nil
+			}
+		}
+		return fmt.Errorf("requests from a to b without retries returned %v, want some 503", codes)
+	}, 5, time.Second)
+}
+
+// sendFlakyRequests sends the requests to the flaky URL of "b" one by one, as concurrent requests
+// could both hit a failure, and returns their status codes.
+func (t *retryPolicy) sendFlakyRequests() []string {
+	var codes []string
+	for i := 0; i < retryRequests; i++ {
+		codes = append(codes, t.ClientRequest("a", retryURL, 1, "").Code...)
+	}
+	return codes
+}
+
+func (t *retryPolicy) Teardown() {
+	if !t.Config.V1alpha2 {
+		return
+	}
+	log.Info("Cleaning up route rules...")
+	if err := t.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+	"istio.io/istio/tests/e2e/tests/pilot/util/builder"
+)
+
+const (
+	// number of requests sent for each case
+	routeMatchSamples = 10
+	// header each header match of the virtual service is on
+	routeMatchHeader = "x-route-match"
+)
+
+// routeMatch routes requests to c by each dimension of the HTTP match requests Pilot supports: the
+// labels of the source, a header matched exactly, by prefix and by regex, and the URI matched by prefix
+// and by regex. Every match routes to c-v2, and anything else to c-v1. Query parameters are not covered:
+// the HTTP match requests of this version of the API have none.
+type routeMatch struct {
+	*tutil.Environment
+}
+
+func (t *routeMatch) String() string {
+	return "route-match"
+}
+
+func (t *routeMatch) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelRouting}
+}
+
+func (t *routeMatch) configs() []builder.Builder {
+	v2 := func(match *builder.MatchBuilder) *builder.HTTPRouteBuilder {
+		return builder.HTTPRoute().Match(match).Route("c", "v2", 0)
+	}
+	return []builder.Builder{
+		builder.DestinationRule("destination-c", "c").VersionSubsets("v1", "v2"),
+		builder.VirtualService("route-match", "c").HTTP(
+			v2(builder.Match().SourceLabel("app", "b")),
+			v2(builder.Match().Header(routeMatchHeader, builder.Exact("exact"))),
+			v2(builder.Match().Header(routeMatchHeader, builder.Prefix("prefix-"))),
+			v2(builder.Match().Header(routeMatchHeader, builder.Regex("regex-[0-9]+"))),
+			v2(builder.Match().URI(builder.Prefix("/match-prefix"))),
+			v2(builder.Match().URI(builder.Regex("/match-[0-9]+/regex"))),
+			builder.HTTPRoute().Route("c", "v1", 0)),
+	}
+}
+
+func (t *routeMatch) Setup() error {
+	if !t.Config.V1alpha2 {
+		return nil
+	}
+	return builder.ApplyAndWait(t.Environment, "v1alpha2/route-match", t.configs()...)
+}
+
+// Run checks that the requests to c matching each route reach c-v2, and that the requests close to
+// matching one without doing so reach c-v1.
+func (t *routeMatch) Run() error {
+	if !t.Config.V1alpha2 {
+		return tutil.Skip("v1alpha2 routing rules are disabled")
+	}
+
+	header := func(value string) string {
+		return fmt.Sprintf("-key %s -val %s", routeMatchHeader, value)
+	}
+	cases := []struct {
+		description string
+		src         string
+		url         string
+		extra       string
+		version     string
+	}{
+		{"from b", "b", "http://c/a", "", "v2"},
+		{"from a", "a", "http://c/a", "", "v1"},
+		{"with the exact header", "a", "http://c/a", header("exact"), "v2"},
+		{"with a header extending the exact one", "a", "http://c/a", header("exact-not"), "v1"},
+		{"with the header prefix", "a", "http://c/a", header("prefix-value"), "v2"},
+		{"with the header prefix not leading", "a", "http://c/a", header("no-prefix-value"), "v1"},
+		{"with a header matching the regex", "a", "http://c/a", header("regex-42"), "v2"},
+		{"with a header not matching the regex", "a", "http://c/a", header("regex-xx"), "v1"},
+		{"to the URI prefix", "a", "http://c/match-prefix/a", "", "v2"},
+		{"to the URI prefix not leading", "a", "http://c/a/match-prefix", "", "v1"},
+		{"to a URI matching the regex", "a", "http://c/match-42/regex", "", "v2"},
+		{"to a URI not matching the regex", "a", "http://c/match-xx/regex", "", "v1"},
+	}
+	funcs := make(map[string]func() tutil.Status)
+	for _, cs := range cases {
+		name := fmt.Sprintf("Request from %s to c %s", cs.src, cs.description)
+		funcs[name] = (func(src, url, extra, version string) func() tutil.Status {
+			return func() tutil.Status {
+				requests, err := t.ClientRequests(src, url, routeMatchSamples, extra)
+				if err != nil {
+					return err
+				}
+				if err = requests.ExpectDistribution(map[string]int{version: 100}, 0); err != nil {
+					log.Infof("%s %s: %v", url, extra, err)
+					return tutil.ErrAgain
+				}
+				return nil
+			}
+		})(cs.src, cs.url, cs.extra, cs.version)
+	}
+	return t.Eventually(tutil.DefaultBudget, funcs)
+}
+
+func (t *routeMatch) Teardown() {
+	if !t.Config.V1alpha2 {
+		return
+	}
+	log.Info("Cleaning up route rules...")
+	if err := t.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+}
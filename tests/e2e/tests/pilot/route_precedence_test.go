@@ -0,0 +1,213 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"time"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	// route rule of c, v1alpha1 or v1alpha2, with a precedence and a match on the header below
+	precedenceConfig = "rule-precedence.yaml.tmpl"
+	// client arguments of the requests the specific rules match
+	precedenceMatched = "-key precedence -val match"
+	// requests from a to c per check
+	precedenceSamples = 10
+)
+
+// precedenceRoute is a route of the virtual service of precedenceConfig.
+type precedenceRoute struct {
+	// Match routes only the requests with the header of precedenceMatched
+	Match   bool
+	Version string
+}
+
+// precedenceCase is a set of overlapping rules of c and the versions they route to.
+type precedenceCase struct {
+	name string
+	api  string
+	// rules are the data of precedenceConfig, applied in order, which is their creation order
+	rules []map[string]interface{}
+	// want and wantMatched are the versions reached by the requests without and with the header of
+	// precedenceMatched, any single version if empty
+	want, wantMatched string
+}
+
+var precedenceCases = []precedenceCase{
+	{
+		// the newer rule loses
+		name: "higher precedence wins",
+		api:  "v1alpha1",
+		rules: []map[string]interface{}{
+			{"Name": "c-precedence-high", "Precedence": 2, "Version": "v2"},
+			{"Name": "c-precedence-low", "Precedence": 1, "Version": "v1"},
+		},
+		want:        "v2",
+		wantMatched: "v2",
+	},
+	{
+		// the rules are ordered by key, and the newer rule comes first
+		name: "equal precedence resolved by name",
+		api:  "v1alpha1",
+		rules: []map[string]interface{}{
+			{"Name": "c-precedence-b", "Precedence": 1, "Version": "v2"},
+			{"Name": "c-precedence-a", "Precedence": 1, "Version": "v1"},
+		},
+		want:        "v1",
+		wantMatched: "v1",
+	},
+	{
+		name: "specific rule of higher precedence",
+		api:  "v1alpha1",
+		rules: []map[string]interface{}{
+			{"Name": "c-precedence-catch-all", "Precedence": 1, "Version": "v1"},
+			{"Name": "c-precedence-match", "Precedence": 2, "Match": true, "Version": "v2"},
+		},
+		want:        "v1",
+		wantMatched: "v2",
+	},
+	{
+		// match specificity does not reorder the rules
+		name: "specific rule shadowed by a catch-all of higher precedence",
+		api:  "v1alpha1",
+		rules: []map[string]interface{}{
+			{"Name": "c-precedence-catch-all", "Precedence": 2, "Version": "v1"},
+			{"Name": "c-precedence-match", "Precedence": 1, "Match": true, "Version": "v2"},
+		},
+		want:        "v1",
+		wantMatched: "v1",
+	},
+	{
+		name: "specific route first",
+		api:  "v1alpha2",
+		rules: []map[string]interface{}{
+			{"Name": "c-precedence", "Routes": []precedenceRoute{{Match: true, Version: "v2"}, {Version: "v1"}}},
+		},
+		want:        "v1",
+		wantMatched: "v2",
+	},
+	{
+		// the routes of a virtual service match in order, whatever their specificity
+		name: "specific route shadowed by a catch-all before it",
+		api:  "v1alpha2",
+		rules: []map[string]interface{}{
+			{"Name": "c-precedence", "Routes": []precedenceRoute{{Version: "v1"}, {Match: true, Version: "v2"}}},
+		},
+		want:        "v1",
+		wantMatched: "v1",
+	},
+	{
+		// Pilot expects a single virtual service per host and picks one of them, in no defined order:
+		// all the requests must follow the same one, the routes are not merged
+		name: "conflicting virtual services",
+		api:  "v1alpha2",
+		rules: []map[string]interface{}{
+			{"Name": "c-precedence-a", "Routes": []precedenceRoute{{Version: "v1"}}},
+			{"Name": "c-precedence-b", "Routes": []precedenceRoute{{Version: "v2"}}},
+		},
+	},
+}
+
+// routePrecedence applies overlapping rules of c, checking which one routes the requests from a, so
+// that the precedence of the rules is pinned: by precedence, then by name, for v1alpha1, and by order
+// in the virtual service for v1alpha2. Neither creation order nor match specificity matters.
+type routePrecedence struct {
+	*tutil.Environment
+}
+
+func (t *routePrecedence) String() string {
+	return "route-precedence"
+}
+
+func (t *routePrecedence) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelRouting}
+}
+
+func (t *routePrecedence) Setup() error {
+	return nil
+}
+
+func (t *routePrecedence) Teardown() {
+	if err := t.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+}
+
+// Run applies the rules of each case enabled by -v1alpha1 and -v1alpha2 and checks the versions reached.
+func (t *routePrecedence) Run() error {
+	if !t.Config.V1alpha1 && !t.Config.V1alpha2 {
+		return tutil.Skip("routing rules are disabled")
+	}
+	if err := t.WaitForPilotEndpoints("c", "http", 2); err != nil {
+		return err
+	}
+
+	for _, tc := range precedenceCases {
+		if (tc.api == "v1alpha1" && !t.Config.V1alpha1) || (tc.api == "v1alpha2" && !t.Config.V1alpha2) {
+			continue
+		}
+		tutil.Tlog("Checking routePrecedence test", fmt.Sprintf("%s (%s)", tc.name, tc.api))
+		if err := t.runCase(tc); err != nil {
+			return fmt.Errorf("%s (%s): %v", tc.name, tc.api, err)
+		}
+	}
+	return nil
+}
+
+func (t *routePrecedence) runCase(tc precedenceCase) error {
+	defer func() {
+		if err := t.DeleteAllConfigs(); err != nil {
+			log.Warna(err)
+		}
+	}()
+	if tc.api == "v1alpha2" {
+		if err := t.ApplyConfig("v1alpha2/destination-rule-c.yaml.tmpl", nil); err != nil {
+			return err
+		}
+	}
+	for _, rule := range tc.rules {
+		if err := t.ApplyConfig(tc.api+"/"+precedenceConfig, rule); err != nil {
+			return err
+		}
+	}
+	return tutil.Repeat(func() error {
+		if err := t.checkVersion("", tc.want); err != nil {
+			return err
+		}
+		return t.checkVersion(precedenceMatched, tc.wantMatched)
+	}, 5, time.Second)
+}
+
+// checkVersion checks that the requests from a to c all reach the version, or a single version if want is
+// empty.
+func (t *routePrecedence) checkVersion(extra, want string) error {
+	resp := t.ClientRequest("a", "http://c/a", precedenceSamples, extra)
+	if len(resp.Version) != precedenceSamples {
+		return fmt.Errorf("%d of %d requests from a to c succeeded", len(resp.Version), precedenceSamples)
+	}
+	if want == "" {
+		want = resp.Version[0]
+	}
+	for _, got := range resp.Version {
+		if got != want {
+			return fmt.Errorf("request from a to c with %q reached %s, want %s", extra, got, want)
+		}
+	}
+	return nil
+}
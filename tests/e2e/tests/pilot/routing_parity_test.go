@@ -0,0 +1,301 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	// requests from a to c observed per scenario and API, unless the scenario has its own count
+	paritySamples = 100
+	// difference of the shares of a status code or a version of c between the APIs reported
+	parityShareTolerance = 0.1
+	// median latency above which the requests of a scenario count as delayed
+	parityDelayed = 2 * time.Second
+	// observations of a scenario taken until two in a row agree, the rules taking a while to apply
+	paritySettleAttempts = 5
+	// name of the report in ReportDir
+	parityReportFile = "routing-parity.json"
+)
+
+var (
+	// request headers echoed by c, and response headers, compared between the APIs
+	parityEchoedRex   = regexp.MustCompile(`\[\d+ body\] (Host|URL|Istio-Custom-Header)=(.*)`)
+	parityResponseRex = regexp.MustCompile(`\[\d+\] ResponseHeader=(Location|Access-Control-Allow-Origin):(.*)`)
+)
+
+// parityScenario is a routing scenario with the v1alpha1 config template of a routing case, built as its
+// routingRules for v1alpha2, and the requests from a to c that observe it.
+type parityScenario struct {
+	config string
+	// client arguments of the requests, such as the header the rule matches
+	extra   string
+	samples int
+}
+
+var parityScenarios = []parityScenario{
+	{config: "rule-default-route.yaml.tmpl"},
+	{config: "rule-weighted-route.yaml.tmpl"},
+	{config: "rule-content-route.yaml.tmpl", extra: "-key version -val v2"},
+	{config: "rule-regex-route.yaml.tmpl", extra: "-key foo -val bar"},
+	{config: "rule-fault-injection.yaml.tmpl", extra: "-key version -val v2", samples: 5},
+	{config: "rule-redirect-injection.yaml.tmpl", extra: "-key testredirect -val enabled", samples: 10},
+	{config: "rule-default-route-append-headers.yaml.tmpl"},
+	{config: "rule-default-route-cors-policy.yaml.tmpl", extra: "-key Origin -val http://example.com"},
+	{config: "rule-default-route-mirrored.yaml.tmpl"},
+}
+
+// routingObservation is the behavior of the requests of a scenario under an API.
+type routingObservation struct {
+	// Codes and Versions are the shares of the status codes and of the versions of c
+	Codes    map[string]float64 `json:"codes"`
+	Versions map[string]float64 `json:"versions"`
+	// Headers are the distinct values of the echoed request headers and of the response headers
+	Headers map[string][]string `json:"headers"`
+	Delayed bool                `json:"delayed"`
+}
+
+// parityResult is the observations of a scenario under both APIs and their divergences.
+type parityResult struct {
+	Scenario    string             `json:"scenario"`
+	V1alpha1    routingObservation `json:"v1alpha1"`
+	V1alpha2    routingObservation `json:"v1alpha2"`
+	Divergences []string           `json:"divergences,omitempty"`
+}
+
+// routingParity runs the routing scenarios that both v1alpha1 and v1alpha2 express, one API after
+// the other in the same environment, and reports where the behavior of the requests differs: the
+// status codes, the versions reached, the headers and the delays. The report, written to ReportDir, is
+// what the migration from v1alpha1 guarantees, or not.
+type routingParity struct {
+	*tutil.Environment
+}
+
+func (t *routingParity) String() string {
+	return "routing-parity"
+}
+
+func (t *routingParity) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelRouting, tutil.LabelSlow}
+}
+
+func (t *routingParity) Setup() error {
+	return nil
+}
+
+func (t *routingParity) Teardown() {
+	if !t.Config.RoutingParity {
+		return
+	}
+	if err := t.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+}
+
+// Run observes each scenario under v1alpha1 and then v1alpha2, and fails with the divergences, once the
+// report is written.
+func (t *routingParity) Run() error {
+	if !t.Config.RoutingParity {
+		return tutil.Skip("routing parity is disabled, see -routing-parity")
+	}
+	if !t.Config.V1alpha1 || !t.Config.V1alpha2 {
+		return tutil.Skip("routing parity needs both v1alpha1 and v1alpha2 routing rules")
+	}
+	if err := t.WaitForPilotEndpoints("c", "http", 2); err != nil {
+		return err
+	}
+
+	var results []parityResult
+	var divergences []string
+	for _, scenario := range parityScenarios {
+		tutil.Tlog("Checking routingParity test", scenario.config)
+		v1alpha1, err := t.observe("v1alpha1", scenario)
+		if err != nil {
+			return err
+		}
+		v1alpha2, err := t.observe("v1alpha2", scenario)
+		if err != nil {
+			return err
+		}
+		result := parityResult{
+			Scenario:    strings.TrimSuffix(scenario.config, ".yaml.tmpl"),
+			V1alpha1:    v1alpha1,
+			V1alpha2:    v1alpha2,
+			Divergences: observationDiff(v1alpha1, v1alpha2),
+		}
+		for _, divergence := range result.Divergences {
+			log.Infof("%s: %s", result.Scenario, divergence)
+			divergences = append(divergences, result.Scenario+": "+divergence)
+		}
+		results = append(results, result)
+	}
+
+	if t.Config.ReportDir != "" {
+		data, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err = ioutil.WriteFile(filepath.Join(t.Config.ReportDir, parityReportFile), data, 0644); err != nil {
+			return err
+		}
+	}
+	if len(divergences) > 0 {
+		return fmt.Errorf("%d divergences between v1alpha1 and v1alpha2 routing:\n%s", len(divergences),
+			strings.Join(divergences, "\n"))
+	}
+	return nil
+}
+
+// observe applies the config of the scenario for the API, with the subsets of c for v1alpha2, and
+// returns the observation once it settles, deleting the configs.
+func (t *routingParity) observe(api string, scenario parityScenario) (routingObservation, error) {
+	defer func() {
+		if err := t.DeleteAllConfigs(); err != nil {
+			log.Warna(err)
+		}
+	}()
+	if api == "v1alpha2" {
+		if err := t.ApplyConfig("v1alpha2/destination-rule-c.yaml.tmpl", nil); err != nil {
+			return routingObservation{}, err
+		}
+	}
+	var err error
+	if api == "v1alpha2" {
+		err = applyRoutingRules(t.Environment, scenario.config)
+	} else {
+		err = t.ApplyConfig(api+"/"+scenario.config, nil)
+	}
+	if err != nil {
+		return routingObservation{}, err
+	}
+
+	samples := scenario.samples
+	if samples == 0 {
+		samples = paritySamples
+	}
+	var previous routingObservation
+	for attempt := 0; attempt < paritySettleAttempts; attempt++ {
+		obs := parseObservation(t.ClientRequest("a", "http://c/a", samples, scenario.extra))
+		if attempt > 0 && len(observationDiff(previous, obs)) == 0 {
+			return obs, nil
+		}
+		previous = obs
+		time.Sleep(time.Second)
+	}
+	log.Infof("%s %s did not settle after %d observations", api, scenario.config, paritySettleAttempts)
+	return previous, nil
+}
+
+// parseObservation returns the observation of the output of the client.
+func parseObservation(resp tutil.Response) routingObservation {
+	obs := routingObservation{
+		Codes:    shares(resp.Code),
+		Versions: shares(resp.Version),
+		Headers:  make(map[string][]string),
+	}
+	for _, rex := range []*regexp.Regexp{parityEchoedRex, parityResponseRex} {
+		for _, match := range rex.FindAllStringSubmatch(resp.Body, -1) {
+			name, value := strings.ToLower(match[1]), strings.TrimSpace(match[2])
+			if !containsString(obs.Headers[name], value) {
+				obs.Headers[name] = append(obs.Headers[name], value)
+			}
+		}
+	}
+	for name := range obs.Headers {
+		sort.Strings(obs.Headers[name])
+	}
+	if len(resp.Latency) > 0 {
+		latencies := append([]time.Duration{}, resp.Latency...)
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		obs.Delayed = latencies[len(latencies)/2] >= parityDelayed
+	}
+	return obs
+}
+
+// observationDiff returns the divergences of two observations, sorted.
+func observationDiff(v1alpha1, v1alpha2 routingObservation) []string {
+	var diff []string
+	diff = append(diff, sharesDiff("status code", v1alpha1.Codes, v1alpha2.Codes)...)
+	diff = append(diff, sharesDiff("version", v1alpha1.Versions, v1alpha2.Versions)...)
+	names := make(map[string]bool)
+	for name := range v1alpha1.Headers {
+		names[name] = true
+	}
+	for name := range v1alpha2.Headers {
+		names[name] = true
+	}
+	for name := range names {
+		if !reflect.DeepEqual(v1alpha1.Headers[name], v1alpha2.Headers[name]) {
+			diff = append(diff, fmt.Sprintf("header %s is %q with v1alpha1, %q with v1alpha2", name,
+				v1alpha1.Headers[name], v1alpha2.Headers[name]))
+		}
+	}
+	if v1alpha1.Delayed != v1alpha2.Delayed {
+		diff = append(diff, fmt.Sprintf("delayed %t with v1alpha1, %t with v1alpha2", v1alpha1.Delayed,
+			v1alpha2.Delayed))
+	}
+	sort.Strings(diff)
+	return diff
+}
+
+// sharesDiff returns the keys whose shares differ by more than parityShareTolerance.
+func sharesDiff(kind string, v1alpha1, v1alpha2 map[string]float64) []string {
+	keys := make(map[string]bool)
+	for key := range v1alpha1 {
+		keys[key] = true
+	}
+	for key := range v1alpha2 {
+		keys[key] = true
+	}
+	var diff []string
+	for key := range keys {
+		if math.Abs(v1alpha1[key]-v1alpha2[key]) > parityShareTolerance {
+			diff = append(diff, fmt.Sprintf("%s %s is %.2f of the requests with v1alpha1, %.2f with v1alpha2", kind,
+				key, v1alpha1[key], v1alpha2[key]))
+		}
+	}
+	return diff
+}
+
+// shares returns the share of each distinct element.
+func shares(elts []string) map[string]float64 {
+	out := make(map[string]float64)
+	for elt, count := range counts(elts) {
+		out[elt] = float64(count) / float64(len(elts))
+	}
+	return out
+}
+
+func containsString(list []string, s string) bool {
+	for _, elt := range list {
+		if elt == s {
+			return true
+		}
+	}
+	return false
+}
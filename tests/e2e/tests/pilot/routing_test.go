@@ -27,8 +27,23 @@ import (
 
 	"istio.io/istio/pkg/log"
 	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+	"istio.io/istio/tests/e2e/tests/pilot/util/builder"
 )
 
+const (
+	// traffic the routing checks send from their source, with two clients for the requests to be
+	// concurrent like real traffic
+	routingQPS         = 50
+	routingConcurrency = 2
+	routingWarmup      = time.Second
+)
+
+// weightedSplits are the weights of c-v1 and c-v2 checked over a large sample when WeightSamples is set.
+var weightedSplits = []map[string]int{
+	{"v1": 90, "v2": 10},
+	{"v1": 50, "v2": 50},
+}
+
 type routing struct {
 	*tutil.Environment
 }
@@ -37,6 +52,10 @@ func (t *routing) String() string {
 	return "routing-rules"
 }
 
+func (t *routing) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelRouting}
+}
+
 func (t *routing) Setup() error {
 	return nil
 }
@@ -135,17 +154,24 @@ func (t *routing) Run() error {
 		},
 	}
 
+	// the weighted cases split traffic between both versions of c, which Pilot must know first
+	if err := t.WaitForPilotEndpoints("c", "http", 2); err != nil {
+		return err
+	}
+
 	var errs error
 	for _, version := range versions {
 		if version == "v1alpha2" {
-			if err := t.ApplyConfig("v1alpha2/destination-rule-c.yaml.tmpl", nil); err != nil {
+			err := builder.ApplyAndWait(t.Environment, "v1alpha2/destination-rule-c",
+				builder.DestinationRule("destination-rule-c", "c").VersionSubsets("v1", "v2"))
+			if err != nil {
 				errs = multierror.Append(errs, err)
 				continue
 			}
 		}
 		for _, cs := range cases {
 			tutil.Tlog("Checking "+version+" routing test", cs.description)
-			if err := t.ApplyConfig(version+"/"+cs.config, nil); err != nil {
+			if err := t.apply(version, cs.config); err != nil {
 				return err
 			}
 
@@ -156,6 +182,11 @@ func (t *routing) Run() error {
 				log.Info("Success!")
 			}
 		}
+		if t.Config.WeightSamples > 0 {
+			if err := t.verifyWeightedSplits(version); err != nil {
+				errs = multierror.Append(errs, err)
+			}
+		}
 		log.Infof("Cleaning up %s route rules...", version)
 		if err := t.DeleteAllConfigs(); err != nil {
 			log.Warna(err)
@@ -167,6 +198,59 @@ func (t *routing) Run() error {
 func (t *routing) Teardown() {
 }
 
+// apply applies the config template of v1alpha1, or builds and applies the routingRules in its place
+// with v1alpha2.
+func (t *routing) apply(version, config string) error {
+	if version == "v1alpha2" {
+		return applyRoutingRules(t.Environment, config)
+	}
+	return t.ApplyConfig(version+"/"+config, nil)
+}
+
+// routingRules returns the v1alpha2 configs built in place of each v1alpha1 config template of the
+// routing cases, which routingParity compares them with.
+func routingRules() map[string][]builder.Builder {
+	route := func() *builder.HTTPRouteBuilder { return builder.HTTPRoute() }
+	defaultRoute := func(routes ...*builder.HTTPRouteBuilder) []builder.Builder {
+		return []builder.Builder{builder.VirtualService("default-route", "c").HTTP(routes...)}
+	}
+	return map[string][]builder.Builder{
+		"rule-default-route.yaml.tmpl":  defaultRoute(route().Route("c", "v1", 100)),
+		"rule-weighted-route.yaml.tmpl": defaultRoute(route().Route("c", "v1", 75).Route("c", "v2", 25)),
+		"rule-content-route.yaml.tmpl": defaultRoute(
+			route().Match(builder.Match().Header("version", builder.Exact("v2")).SourceLabel("version", "v1")).
+				Route("c", "v2", 100),
+			route().Route("c", "v1", 100)),
+		"rule-regex-route.yaml.tmpl": defaultRoute(
+			route().Match(builder.Match().Header("foo", builder.Regex("b.*")).SourceLabel("version", "v1")).
+				Route("c", "v2", 100),
+			route().Route("c", "v1", 100)),
+		"rule-fault-injection.yaml.tmpl": defaultRoute(
+			route().Match(builder.Match().Header("version", builder.Exact("v2")).SourceLabel("version", "v1")).
+				Route("c", "v2", 100).Delay(100, 5*time.Second).Abort(100, 503)),
+		"rule-redirect-injection.yaml.tmpl": defaultRoute(
+			route().Match(builder.Match().Header("testredirect", builder.Exact("enabled"))).Redirect("/new/path", "b")),
+		"rule-websocket-route.yaml.tmpl": defaultRoute(
+			route().Match(builder.Match().Header("testwebsocket", builder.Exact("enabled"))).Route("c", "v1", 0).
+				WebsocketUpgrade()),
+		"rule-default-route-append-headers.yaml.tmpl": defaultRoute(
+			route().Route("c", "v1", 100).AppendHeader("istio-custom-header", "user-defined-value")),
+		"rule-default-route-cors-policy.yaml.tmpl": defaultRoute(route().Route("c", "v1", 100).CorsPolicy(
+			[]string{"http://foo.example"}, []string{"POST", "GET", "OPTIONS"}, []string{"content-type"},
+			[]string{"x-custom-header"}, 300*time.Second, true)),
+		"rule-default-route-mirrored.yaml.tmpl": defaultRoute(route().Mirror("b", "")),
+	}
+}
+
+// applyRoutingRules builds and applies the routingRules of the v1alpha1 config template.
+func applyRoutingRules(env *tutil.Environment, config string) error {
+	configs, ok := routingRules()[config]
+	if !ok {
+		return fmt.Errorf("no v1alpha2 routing rules in place of %s", config)
+	}
+	return builder.ApplyAndWait(env, "v1alpha2/"+strings.TrimSuffix(config, ".yaml.tmpl"), configs...)
+}
+
 func counts(elts []string) map[string]int {
 	out := make(map[string]int)
 	for _, elt := range elts {
@@ -178,21 +262,20 @@ func counts(elts []string) map[string]int {
 // verifyRouting verifies if the traffic is split as specified across different deployments in a service
 func (t *routing) verifyRouting(scheme, src, dst, headerKey, headerVal string,
 	samples int, expectedCount map[string]int, operation string) error {
-	url := fmt.Sprintf("%s://%s/%s", scheme, dst, src)
-	log.Infof("Making %d requests (%s) from %s...\n", samples, url, src)
-
-	resp := t.ClientRequest(src, url, samples, fmt.Sprintf("-key %s -val %s", headerKey, headerVal))
-	count := counts(resp.Version)
-	log.Infof("request counts %v", count)
-	epsilon := 10
-
-	var errs error
-	for version, expected := range expectedCount {
-		if count[version] > expected+epsilon || count[version] < expected-epsilon {
-			errs = multierror.Append(errs, fmt.Errorf("expected %v requests (+/-%v) to reach %s => Got %v",
-				expected, epsilon, version, count[version]))
-		}
+	requests, err := t.DriveTraffic(tutil.Traffic{
+		Sources:     []string{src},
+		URL:         fmt.Sprintf("%s://%s/%s", scheme, dst, src),
+		QPS:         routingQPS,
+		Concurrency: routingConcurrency,
+		Requests:    samples,
+		Warmup:      routingWarmup,
+		Extra:       fmt.Sprintf("-key %s -val %s", headerKey, headerVal),
+	})
+	if err != nil {
+		return err
 	}
+	// the expected counts are out of 100 samples, and may be off by 10
+	errs := requests.ExpectDistribution(expectedCount, 0.1)
 
 	if operation != "" {
 		if err := t.verifyDecorator(operation); err != nil {
@@ -203,9 +286,66 @@ func (t *routing) verifyRouting(scheme, src, dst, headerKey, headerVal string,
 	return errs
 }
 
+// verifyWeightedSplits applies each of the weightedSplits in turn, and checks the share of the
+// WeightSamples requests from a that each version of c serves.
+func (t *routing) verifyWeightedSplits(version string) error {
+	var errs error
+	for _, split := range weightedSplits {
+		description := fmt.Sprintf("routing %d percent to c-v1, %d percent to c-v2 over %d requests",
+			split["v1"], split["v2"], t.Config.WeightSamples)
+		tutil.Tlog("Checking "+version+" routing test", description)
+		var err error
+		if version == "v1alpha2" {
+			err = builder.ApplyAndWait(t.Environment,
+				fmt.Sprintf("v1alpha2/rule-weighted-split-%d-%d", split["v1"], split["v2"]),
+				builder.VirtualService("default-route", "c").HTTP(builder.HTTPRoute().
+					Route("c", "v1", int32(split["v1"])).Route("c", "v2", int32(split["v2"]))))
+		} else {
+			err = t.ApplyConfig(version+"/rule-weighted-split.yaml.tmpl", map[string]string{
+				"V1Weight": strconv.Itoa(split["v1"]),
+				"V2Weight": strconv.Itoa(split["v2"]),
+			})
+		}
+		if err != nil {
+			return err
+		}
+		weights := split
+		if err = tutil.Repeat(func() error {
+			return t.verifyWeights("a", "c", t.Config.WeightSamples, weights)
+		}, 3, time.Second); err != nil {
+			log.Infof("Failed the test with %v", err)
+			errs = multierror.Append(errs, multierror.Prefix(err, version+" "+description))
+		} else {
+			log.Info("Success!")
+		}
+	}
+	return errs
+}
+
+// verifyWeights checks that the share of the requests served by each version is within
+// WeightTolerance of its weight, in percent.
+func (t *routing) verifyWeights(src, dst string, samples int, weights map[string]int) error {
+	requests, err := t.DriveTraffic(tutil.Traffic{
+		Sources:     []string{src},
+		URL:         fmt.Sprintf("http://%s/%s", dst, src),
+		QPS:         routingQPS,
+		Concurrency: routingConcurrency,
+		Requests:    samples,
+		Warmup:      routingWarmup,
+	})
+	if err != nil {
+		return err
+	}
+	if err = requests.ExpectReachable(); err != nil {
+		return err
+	}
+	return requests.ExpectDistribution(weights, t.Config.WeightTolerance)
+}
+
 // verify that the traces were picked up by Zipkin and decorator has been applied
 func (t *routing) verifyDecorator(operation string) error {
-	if !t.Config.Zipkin {
+	// the operations are counted in the Zipkin API, which Jaeger only implements for collecting spans
+	if !t.Config.Zipkin || t.Config.TraceBackend != tutil.TraceBackendZipkin {
 		return nil
 	}
 
@@ -38,6 +38,10 @@ func (t *routingToEgress) String() string {
 	return "routing-rules-to-egress"
 }
 
+func (t *routingToEgress) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelRouting, tutil.LabelEgress}
+}
+
 func (t *routingToEgress) Setup() error {
 	return nil
 }
@@ -0,0 +1,124 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const scaleConfig = "v1alpha2/scale-services.yaml.tmpl"
+
+// scale pushes -scale-services synthetic external services to the sidecars, and checks that Pilot
+// pushes them within -scale-push-ceiling and that no sidecar then allocates more than
+// -scale-memory-ceiling.
+type scale struct {
+	*tutil.Environment
+	// time from applying the services to the last one being in the config of a sidecar, by sidecar
+	pushTimes []time.Duration
+}
+
+// scaleService is the template data of one synthetic service.
+type scaleService struct {
+	Index   int
+	Address string
+}
+
+func (t *scale) String() string {
+	return "scale"
+}
+
+func (t *scale) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelRouting, tutil.LabelSlow}
+}
+
+func (t *scale) Setup() error {
+	return nil
+}
+
+func (t *scale) Teardown() {
+	if !t.Config.V1alpha2 || t.Config.ScaleServices < 1 {
+		return
+	}
+	if err := t.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+}
+
+// Run applies the services at once, times their push to the sidecars of a and b from the last
+// service showing up in their config_dump, then reads the memory the sidecars allocate.
+func (t *scale) Run() error {
+	if !t.Config.V1alpha2 {
+		return tutil.Skip("v1alpha2 is disabled")
+	}
+	if t.Config.ScaleServices < 1 {
+		return tutil.Skip("no services were requested")
+	}
+
+	pods := append(append([]string(nil), t.Apps["a"]...), t.Apps["b"]...)
+	before := make(map[string]int, len(pods))
+	for _, pod := range pods {
+		memory, err := t.PodProxyMemory(pod)
+		if err != nil {
+			return err
+		}
+		before[pod] = memory
+	}
+
+	var services []scaleService
+	for i := 0; i < t.Config.ScaleServices; i++ {
+		// in 10.255.0.0/16, so that up to 65536 services get distinct addresses
+		services = append(services, scaleService{Index: i, Address: fmt.Sprintf("10.255.%d.%d", i/256%256, i%256)})
+	}
+	marker := fmt.Sprintf("scale-%d.scale.test", t.Config.ScaleServices-1)
+	latencies, err := t.TimeConfigPropagation(scaleConfig, map[string][]scaleService{"Services": services}, pods,
+		marker, t.Config.ScalePushCeiling)
+	if err == tutil.ErrNoConfigDump {
+		return tutil.Skip("the sidecars do not serve /config_dump")
+	}
+	if err != nil {
+		return fmt.Errorf("%d services were not pushed to every sidecar within %v: %v",
+			t.Config.ScaleServices, t.Config.ScalePushCeiling, err)
+	}
+	t.pushTimes = nil
+	for _, latency := range latencies {
+		t.pushTimes = append(t.pushTimes, latency)
+	}
+	log.Infof("Push time of %d services: %v", t.Config.ScaleServices, tutil.NewDistribution(t.pushTimes))
+
+	sort.Strings(pods)
+	ceiling := t.Config.ScaleMemoryCeiling * 1024 * 1024
+	for _, pod := range pods {
+		memory, memErr := t.PodProxyMemory(pod)
+		if memErr != nil {
+			return memErr
+		}
+		log.Infof("Sidecar of %s allocates %d MiB with %d services, %d MiB before",
+			pod, memory/1024/1024, t.Config.ScaleServices, before[pod]/1024/1024)
+		if memory > ceiling {
+			return fmt.Errorf("the sidecar of %s allocates %d MiB with %d services, want at most %d MiB",
+				pod, memory/1024/1024, t.Config.ScaleServices, t.Config.ScaleMemoryCeiling)
+		}
+	}
+	return nil
+}
+
+func (t *scale) Measurements() map[string]tutil.Distribution {
+	return map[string]tutil.Distribution{"push": tutil.NewDistribution(t.pushTimes)}
+}
@@ -0,0 +1,237 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	meshconfig "istio.io/api/mesh/v1alpha1"
+	"istio.io/istio/pilot/pkg/kube/inject"
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	// app of the namespace opted out of injection, <app namespace>-opt-out
+	injectionOptOutApp = "inject-opt-out"
+	// app of the app namespace with the annotation disabling injection
+	injectionAnnotatedApp = "inject-annotated"
+	// app injected with the custom template
+	injectionCustomApp = "inject-custom"
+	// resources the custom template requests for the sidecar
+	injectionProxyCPU    = "15m"
+	injectionProxyMemory = "45Mi"
+	// name of the init container of the template
+	injectionInitContainer = "istio-init"
+)
+
+// sidecarInjection covers the cases of automatic injection besides the default one: a namespace
+// opted out by its label, a pod opted out by its annotation, and a custom template setting the
+// resources of the sidecar and intercepting the outbound traffic to b only. It checks the pod specs
+// the injector leaves, and the traffic of the pods.
+type sidecarInjection struct {
+	*tutil.Environment
+	optOutNamespace string
+	// applied YAML of the apps of the app namespace
+	deployed []string
+	// ClusterIP of b, the only outbound destination the custom template intercepts
+	interceptedAddress string
+}
+
+func (t *sidecarInjection) String() string {
+	return "sidecar-injection"
+}
+
+func (t *sidecarInjection) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelReachability, tutil.LabelSlow}
+}
+
+// Setup deploys the apps opted out of injection, and the one of the custom template.
+func (t *sidecarInjection) Setup() error {
+	if !t.Config.UseAutomaticInjection {
+		return nil
+	}
+	t.optOutNamespace = t.Config.Namespace + "-opt-out"
+	if _, err := t.KubeClient.CoreV1().Namespaces().Create(&v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   t.optOutNamespace,
+			Labels: map[string]string{"istio-injection": "disabled"},
+		},
+	}); err != nil {
+		return err
+	}
+	if _, err := t.DeployApp(tutil.AppSpec{Deployment: injectionOptOutApp, Namespace: t.optOutNamespace}); err != nil {
+		return err
+	}
+	yaml, err := t.DeployApp(tutil.AppSpec{Deployment: injectionAnnotatedApp, NoSidecar: true})
+	if err != nil {
+		return err
+	}
+	t.deployed = append(t.deployed, yaml)
+
+	svc, err := t.KubeClient.CoreV1().Services(t.Config.Namespace).Get("b", metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	t.interceptedAddress = svc.Spec.ClusterIP
+	params := t.SidecarTemplateParams(t.Config.InstallTag())
+	params.IncludeIPRanges = tutil.HostCIDR(t.interceptedAddress)
+	template, err := inject.GenerateTemplateFromParams(params)
+	if err != nil {
+		return err
+	}
+	if template, err = withProxyResources(template); err != nil {
+		return err
+	}
+	if yaml, err = t.DeployApp(tutil.AppSpec{Deployment: injectionCustomApp, SidecarTemplate: template}); err != nil {
+		return err
+	}
+	t.deployed = append(t.deployed, yaml)
+	return t.RefreshApps()
+}
+
+// withProxyResources adds injectionProxyCPU and injectionProxyMemory as the requests of the sidecar
+// of the template.
+func withProxyResources(template string) (string, error) {
+	container := "- name: " + inject.ProxyContainerName + "\n"
+	start := strings.Index(template, container)
+	if start < 0 {
+		return "", fmt.Errorf("no %s container in the sidecar template", inject.ProxyContainerName)
+	}
+	start += len(container)
+	resources := fmt.Sprintf("  resources:\n    requests:\n      cpu: %s\n      memory: %s\n",
+		injectionProxyCPU, injectionProxyMemory)
+	return template[:start] + resources + template[start:], nil
+}
+
+func (t *sidecarInjection) Run() error {
+	if !t.Config.UseAutomaticInjection {
+		return tutil.Skip("sidecars are injected manually")
+	}
+
+	for _, app := range []struct {
+		namespace, name string
+	}{
+		{t.optOutNamespace, injectionOptOutApp},
+		{t.Config.Namespace, injectionAnnotatedApp},
+	} {
+		pod, err := appPod(t.Environment, app.namespace, app.name)
+		if err != nil {
+			return err
+		}
+		for _, container := range append(pod.Spec.InitContainers, pod.Spec.Containers...) {
+			if container.Name == inject.ProxyContainerName || container.Name == injectionInitContainer {
+				return fmt.Errorf("pod %s of %s has the container %s, want no injection", pod.Name,
+					app.name, container.Name)
+			}
+		}
+	}
+	if err := t.verifyCustomPod(); err != nil {
+		return err
+	}
+
+	funcs := make(map[string]func() tutil.Status)
+	request := func(name, src, url string, reachable bool) {
+		funcs[name] = func() tutil.Status {
+			resp := t.ClientRequest(src, url, 1, "")
+			if resp.IsHTTPOk() != reachable {
+				log.Infof("%s returned %v", name, resp.Code)
+				return tutil.ErrAgain
+			}
+			return nil
+		}
+	}
+	// the injected sidecars only talk mTLS with the others
+	plain := t.Auth == meshconfig.MeshConfig_NONE
+	if plain {
+		request("Request from a to the app of the opted out namespace", "a",
+			fmt.Sprintf("http://%s.%s/a", injectionOptOutApp, t.optOutNamespace), true)
+		request("Request from a to the annotated app", "a", "http://"+injectionAnnotatedApp+"/a", true)
+	}
+	request("Request to b, intercepted by the sidecar of the custom template", injectionCustomApp, "http://b/a", true)
+	// without interception, c is reached without mTLS
+	request("Request to c, not intercepted by the sidecar of the custom template", injectionCustomApp,
+		"http://c/a", plain)
+	return t.Eventually(tutil.DefaultBudget, funcs)
+}
+
+// verifyCustomPod checks that the sidecar of the custom template has its resources, and that its init
+// container only redirects the traffic to b.
+func (t *sidecarInjection) verifyCustomPod() error {
+	pod, err := appPod(t.Environment, t.Config.Namespace, injectionCustomApp)
+	if err != nil {
+		return err
+	}
+	var proxy, initContainer *v1.Container
+	for i := range pod.Spec.Containers {
+		if pod.Spec.Containers[i].Name == inject.ProxyContainerName {
+			proxy = &pod.Spec.Containers[i]
+		}
+	}
+	for i := range pod.Spec.InitContainers {
+		if pod.Spec.InitContainers[i].Name == injectionInitContainer {
+			initContainer = &pod.Spec.InitContainers[i]
+		}
+	}
+	if proxy == nil || initContainer == nil {
+		return fmt.Errorf("pod %s of %s was not injected", pod.Name, injectionCustomApp)
+	}
+	cpu, memory := proxy.Resources.Requests[v1.ResourceCPU], proxy.Resources.Requests[v1.ResourceMemory]
+	if cpu.String() != injectionProxyCPU || memory.String() != injectionProxyMemory {
+		return fmt.Errorf("sidecar of %s requests cpu %s and memory %s, want %s and %s", pod.Name,
+			cpu.String(), memory.String(), injectionProxyCPU, injectionProxyMemory)
+	}
+	args := strings.Join(initContainer.Args, " ")
+	if want := tutil.HostCIDR(t.interceptedAddress); !strings.Contains(args, "-i "+want) {
+		return fmt.Errorf("init container of %s has the arguments %q, want -i %s", pod.Name, args, want)
+	}
+	return nil
+}
+
+// appPod returns the pod of the app in the namespace.
+func appPod(t *tutil.Environment, namespace, app string) (*v1.Pod, error) {
+	pods, err := t.KubeClient.CoreV1().Pods(namespace).List(metav1.ListOptions{LabelSelector: "app=" + app})
+	if err != nil {
+		return nil, err
+	}
+	if len(pods.Items) != 1 {
+		return nil, fmt.Errorf("%d pods of %s in %s, want 1", len(pods.Items), app, namespace)
+	}
+	return &pods.Items[0], nil
+}
+
+func (t *sidecarInjection) Teardown() {
+	if !t.Config.UseAutomaticInjection {
+		return
+	}
+	for _, yaml := range t.deployed {
+		if err := t.KubeDelete(yaml, t.Config.Namespace); err != nil {
+			log.Warna(err)
+		}
+	}
+	if t.optOutNamespace != "" {
+		if err := t.KubeClient.CoreV1().Namespaces().Delete(t.optOutNamespace, &metav1.DeleteOptions{}); err != nil {
+			log.Warna(err)
+		}
+	}
+	t.deployed = nil
+	if err := t.RefreshApps(); err != nil {
+		log.Warna(err)
+	}
+}
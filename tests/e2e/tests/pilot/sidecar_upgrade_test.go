@@ -0,0 +1,168 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	// traffic from a to b before the rollout, and after the old pods are gone
+	sidecarUpgradeWarmup = 5 * time.Second
+	// maximum number of 1s polls waiting for the old pods of b to be gone
+	sidecarUpgradeRolloutBudget = 180
+	// number of HTTP requests sent at once from a to b
+	sidecarUpgradeBatch = 10
+	// failed HTTP requests allowed during the rollout
+	sidecarUpgradeFailureBudget = 2
+)
+
+// sidecarUpgrade rolls the pods of b to a new sidecar, the proxy image of -sidecar-upgrade-tag or the
+// same one, while gRPC streams and HTTP requests from a to b are in flight.
+type sidecarUpgrade struct {
+	*tutil.Environment
+}
+
+func (t *sidecarUpgrade) String() string {
+	return "sidecar-upgrade"
+}
+
+func (t *sidecarUpgrade) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelReachability, tutil.LabelSlow}
+}
+
+func (t *sidecarUpgrade) Setup() error {
+	return nil
+}
+
+// Teardown refreshes the pods of the apps, those of b being new.
+func (t *sidecarUpgrade) Teardown() {
+	if err := t.RefreshApps(); err != nil {
+		log.Warna(err)
+	}
+}
+
+// Run opens gRPC bidi streams from a to b one after the other, each lasting half of -drain-wait so
+// that the stream in flight when an old sidecar of b starts draining has the time to complete, and
+// sends steady HTTP traffic from a to b, while b rolls out. Every stream must complete, and no more
+// than sidecarUpgradeFailureBudget HTTP requests may fail.
+func (t *sidecarUpgrade) Run() error {
+	old := append([]string(nil), t.Apps["b"]...)
+	if len(old) == 0 {
+		return fmt.Errorf("missing pods for app b")
+	}
+	messages := int(t.Config.DrainWait / 2 / time.Second)
+	if messages < 2 {
+		messages = 2
+	}
+	streamArgs := fmt.Sprintf("-stream bidi -messages %d -interval 1s -timeout %v", messages, t.Config.DrainWait)
+
+	var (
+		mutex                  sync.Mutex
+		requests, failures     int
+		streams, brokenStreams int
+		firstBroken            string
+		wg                     sync.WaitGroup
+	)
+	stop := make(chan struct{})
+	loop := func(f func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				f()
+			}
+		}()
+	}
+	loop(func() {
+		resp := t.ClientRequest("a", "grpc://b:70", 1, streamArgs)
+		mutex.Lock()
+		defer mutex.Unlock()
+		streams++
+		if !strings.Contains(resp.Body, fmt.Sprintf("StreamMessages=%d", messages)) {
+			brokenStreams++
+			if firstBroken == "" {
+				firstBroken = resp.Body
+			}
+		}
+	})
+	loop(func() {
+		resp := t.ClientRequest("a", "http://b/a", sidecarUpgradeBatch, "")
+		ok := 0
+		for _, code := range resp.Code {
+			if code == "200" {
+				ok++
+			}
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		requests += sidecarUpgradeBatch
+		failures += sidecarUpgradeBatch - ok
+	})
+
+	time.Sleep(sidecarUpgradeWarmup)
+	log.Infof("Rolling out b with the proxy tag %q", t.Config.SidecarUpgradeTag)
+	err := t.RollSidecar("b", t.Config.SidecarUpgradeTag)
+	if err == nil {
+		err = t.waitForPodsGone(old)
+	}
+	if err == nil {
+		time.Sleep(sidecarUpgradeWarmup)
+	}
+	close(stop)
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+
+	log.Infof("%d/%d streams broken and %d/%d requests failed from a to b during the rollout",
+		brokenStreams, streams, failures, requests)
+	if brokenStreams > 0 {
+		return fmt.Errorf("%d/%d streams from a to b broke during the rollout of b, the first one:\n%s",
+			brokenStreams, streams, firstBroken)
+	}
+	if failures > sidecarUpgradeFailureBudget {
+		return fmt.Errorf("%d/%d requests from a to b failed during the rollout of b, want at most %d",
+			failures, requests, sidecarUpgradeFailureBudget)
+	}
+	return nil
+}
+
+// waitForPodsGone waits for the pods of the app namespace to be deleted.
+func (t *sidecarUpgrade) waitForPodsGone(pods []string) error {
+	return t.Eventually(tutil.DefaultBudget.Attempting(sidecarUpgradeRolloutBudget), map[string]func() tutil.Status{
+		fmt.Sprintf("Pods %v of b gone after the rollout", pods): func() tutil.Status {
+			for _, pod := range pods {
+				if _, err := t.KubeClient.CoreV1().Pods(t.Config.Namespace).Get(pod, metav1.GetOptions{}); err == nil {
+					return tutil.ErrAgain
+				}
+			}
+			return nil
+		},
+	})
+}
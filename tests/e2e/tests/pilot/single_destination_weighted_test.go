@@ -0,0 +1,105 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	singleDestinationSamples = 20
+	singleDestinationHeader  = "Istio-Single-Destination=weighted"
+)
+
+type singleDestinationWeighted struct {
+	*tutil.Environment
+}
+
+func (t *singleDestinationWeighted) String() string {
+	return "single-destination-weighted"
+}
+
+func (t *singleDestinationWeighted) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelRouting}
+}
+
+func (t *singleDestinationWeighted) Setup() error {
+	if !t.Config.V1alpha2 {
+		return nil
+	}
+	if err := t.ApplyConfig("v1alpha2/destination-rule-c.yaml.tmpl", nil); err != nil {
+		return err
+	}
+	return t.ApplyConfig("v1alpha2/rule-single-destination-weighted.yaml.tmpl", nil)
+}
+
+// Run checks that routes with a single 100% weighted destination send all traffic to it,
+// while still matching on headers and appending headers to the request.
+func (t *singleDestinationWeighted) Run() error {
+	if !t.Config.V1alpha2 {
+		return tutil.Skip("v1alpha2 routing rules are disabled")
+	}
+
+	cases := []struct {
+		description string
+		extra       string
+		version     string
+	}{
+		{"default route", "", "v1"},
+		{"header matched route", "-key single-destination -val v2", "v2"},
+	}
+	for _, cs := range cases {
+		tutil.Tlog("Checking singleDestinationWeighted test", cs.description)
+		err := tutil.Repeat(func() error {
+			return t.verify(cs.extra, cs.version)
+		}, 5, time.Second)
+		if err != nil {
+			return fmt.Errorf("%s: %v", cs.description, err)
+		}
+	}
+	return nil
+}
+
+// verify checks that every request reached the expected version with the appended header.
+func (t *singleDestinationWeighted) verify(extra, version string) error {
+	resp := t.ClientRequest("a", "http://c/a", singleDestinationSamples, extra)
+	if len(resp.Version) != singleDestinationSamples {
+		return fmt.Errorf("%d out of %d requests succeeded", len(resp.Version), singleDestinationSamples)
+	}
+	count := counts(resp.Version)
+	if count[version] != singleDestinationSamples {
+		return fmt.Errorf("expected all %d requests to reach %s => Got %v", singleDestinationSamples, version, count)
+	}
+	if n := strings.Count(resp.Body, singleDestinationHeader); n != singleDestinationSamples {
+		return fmt.Errorf("appended header %q found in %d out of %d requests",
+			singleDestinationHeader, n, singleDestinationSamples)
+	}
+	return nil
+}
+
+func (t *singleDestinationWeighted) Teardown() {
+	if !t.Config.V1alpha2 {
+		return
+	}
+	log.Info("Cleaning up route rules...")
+	if err := t.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+}
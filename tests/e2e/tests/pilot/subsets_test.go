@@ -0,0 +1,190 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	subsetsService = "m"
+	subsetsConfig  = "v1alpha2/rule-subsets.yaml.tmpl"
+	// requests from a to m checking the weights, when -weight-samples is not set
+	subsetsSamples = 200
+)
+
+// subsetWeight is the weight of a subset of m in the virtual service of subsetsConfig.
+type subsetWeight struct {
+	Name   string
+	Weight int
+}
+
+// subsets shifts the traffic from a across the versions of m, more than the two of c, each with
+// several replicas, and checks that the weights hold and that every replica of a subset serves.
+type subsets struct {
+	*tutil.Environment
+	// versions of m, and replicas of each
+	versions int
+	replicas int32
+	deployed []string
+}
+
+func (t *subsets) String() string {
+	return "subsets"
+}
+
+func (t *subsets) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelRouting}
+}
+
+func (t *subsets) Setup() error {
+	if !t.Config.V1alpha2 {
+		return nil
+	}
+	for _, spec := range tutil.AppVersions(subsetsService, t.versions, t.replicas) {
+		yaml, err := t.DeployApp(spec)
+		if err != nil {
+			return err
+		}
+		t.deployed = append(t.deployed, yaml)
+	}
+	return t.RefreshApps()
+}
+
+// Run splits the traffic of m across all its versions, then sends it all to the last version and
+// checks that each of its replicas serves some of it.
+func (t *subsets) Run() error {
+	if !t.Config.V1alpha2 {
+		return tutil.Skip("v1alpha2 routing rules are disabled")
+	}
+	if err := t.WaitForPilotEndpoints(subsetsService, "http", t.versions*int(t.replicas)); err != nil {
+		return err
+	}
+
+	weights := t.splitWeights()
+	if err := t.ApplyConfig(subsetsConfig, map[string]interface{}{
+		"Service": subsetsService,
+		"Subsets": weights,
+	}); err != nil {
+		return err
+	}
+	samples := t.Config.WeightSamples
+	if samples == 0 {
+		samples = subsetsSamples
+	}
+	if err := tutil.Repeat(func() error { return t.verifySplit(weights, samples) }, 3, time.Second); err != nil {
+		return err
+	}
+
+	last := fmt.Sprintf("v%d", t.versions)
+	if err := t.ApplyConfig(subsetsConfig, map[string]interface{}{
+		"Service": subsetsService,
+		"Subsets": []subsetWeight{{Name: last, Weight: 100}},
+	}); err != nil {
+		return err
+	}
+	return tutil.Repeat(func() error { return t.verifyReplicas(last) }, 5, time.Second)
+}
+
+// splitWeights returns weights of the versions of m adding up to 100, the first version taking the
+// remainder.
+func (t *subsets) splitWeights() []subsetWeight {
+	weights := make([]subsetWeight, 0, t.versions)
+	share := 100 / t.versions
+	for i := 1; i <= t.versions; i++ {
+		weights = append(weights, subsetWeight{Name: fmt.Sprintf("v%d", i), Weight: share})
+	}
+	weights[0].Weight += 100 - share*t.versions
+	return weights
+}
+
+// verifySplit checks that the share of the requests from a served by each version of m is within
+// WeightTolerance of its weight.
+func (t *subsets) verifySplit(weights []subsetWeight, samples int) error {
+	resp := t.ClientRequest("a", "http://"+subsetsService+"/a", samples, "")
+	if len(resp.Version) != samples {
+		return fmt.Errorf("%d of %d requests from a to %s were answered", len(resp.Version), samples, subsetsService)
+	}
+	count := counts(resp.Version)
+	log.Infof("request counts %v", count)
+	for _, weight := range weights {
+		share := float64(count[weight.Name]) / float64(samples)
+		expected := float64(weight.Weight) / 100
+		if math.Abs(share-expected) > t.Config.WeightTolerance {
+			return fmt.Errorf("%.3f of the requests from a reached %s %s, want %.2f (+/-%.2f)",
+				share, subsetsService, weight.Name, expected, t.Config.WeightTolerance)
+		}
+	}
+	return nil
+}
+
+// verifyReplicas checks that the requests from a to m all reach the version, and every one of its
+// replicas.
+func (t *subsets) verifyReplicas(version string) error {
+	prefix := subsetsService + "-" + version + "-"
+	var pods []string
+	for _, pod := range t.Apps[subsetsService] {
+		if strings.HasPrefix(pod, prefix) {
+			pods = append(pods, pod)
+		}
+	}
+	if len(pods) != int(t.replicas) {
+		return fmt.Errorf("%d pods of %s %s, want %d: %v", len(pods), subsetsService, version, t.replicas, pods)
+	}
+
+	batch := 10 * len(pods)
+	resp := t.ClientRequest("a", "http://"+subsetsService+"/a", batch, "")
+	if len(resp.Hostname) != batch {
+		return fmt.Errorf("%d of %d requests from a to %s were answered", len(resp.Hostname), batch, subsetsService)
+	}
+	served := counts(resp.Hostname)
+	for _, hostname := range resp.Hostname {
+		if !containsPod(pods, hostname) {
+			return fmt.Errorf("request from a to %s %s was served by %s, want one of %v",
+				subsetsService, version, hostname, pods)
+		}
+	}
+	for _, pod := range pods {
+		if served[pod] == 0 {
+			return fmt.Errorf("no request from a to %s %s reached the replica %s: %v",
+				subsetsService, version, pod, served)
+		}
+	}
+	return nil
+}
+
+func (t *subsets) Teardown() {
+	if !t.Config.V1alpha2 {
+		return
+	}
+	if err := t.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+	for _, yaml := range t.deployed {
+		if err := t.KubeDelete(yaml, t.Config.Namespace); err != nil {
+			log.Warna(err)
+		}
+	}
+	t.deployed = nil
+	if err := t.RefreshApps(); err != nil {
+		log.Warna(err)
+	}
+}
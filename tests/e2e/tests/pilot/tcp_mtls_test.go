@@ -0,0 +1,81 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"time"
+
+	"istio.io/istio/pilot/pkg/serviceregistry"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+type tcpMtls struct {
+	*tutil.Environment
+}
+
+func (t *tcpMtls) String() string {
+	return "tcp-mtls"
+}
+
+func (t *tcpMtls) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelSecurity}
+}
+
+func (t *tcpMtls) Setup() error {
+	return nil
+}
+
+func (t *tcpMtls) Teardown() {
+}
+
+// Run opens TCP connections from "a" to the TCP port of "b" and checks in the Envoy stats of "a"
+// that they were wrapped in mTLS, while a plain connection from "t", which has no sidecar, is refused.
+func (t *tcpMtls) Run() error {
+	if !t.Config.Auth {
+		return tutil.Skip("auth is disabled")
+	}
+	// TCP in Eureka is tested by the headless service test.
+	if serviceregistry.ServiceRegistry(t.Config.Registry) == serviceregistry.EurekaRegistry {
+		return tutil.Skip("TCP services are not registered in Eureka")
+	}
+
+	// the tcp test servers speak HTTP on their TCP port, the proxies only see a TCP stream
+	url := "http://b:90/a"
+	cluster := fmt.Sprintf("cluster.out.b.%s.svc.cluster.local|tcp.", t.Config.Namespace)
+	err := tutil.Repeat(func() error {
+		resp := t.ClientRequest("a", url, 1, "")
+		if !resp.IsHTTPOk() {
+			return fmt.Errorf("TCP connection from a to b failed: %v", resp.Code)
+		}
+		stats, err := t.ProxyStats("a")
+		if err != nil {
+			return err
+		}
+		if sumStats(stats, cluster, ".ssl.handshake") == 0 {
+			return fmt.Errorf("no mTLS handshake recorded in the stats of %s", cluster)
+		}
+		return nil
+	}, 5, time.Second)
+	if err != nil {
+		return err
+	}
+
+	resp := t.ClientRequest("t", url, 1, "")
+	if resp.IsHTTPOk() {
+		return fmt.Errorf("plain TCP connection from t to b succeeded although b requires mTLS")
+	}
+	return nil
+}
@@ -16,12 +16,21 @@ package pilot
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	meshconfig "istio.io/api/mesh/v1alpha1"
 	"istio.io/istio/pilot/pkg/serviceregistry"
 	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
 )
 
+const (
+	// random bytes sent on each raw TCP connection, echoed by the app
+	tcpPayload = 1 << 20
+	// idle time of each raw TCP connection halfway through the payload
+	tcpIdle = 5 * time.Second
+)
+
 type tcp struct {
 	*tutil.Environment
 }
@@ -30,6 +39,10 @@ func (t *tcp) String() string {
 	return "tcp-reachability"
 }
 
+func (t *tcp) Labels() []string {
+	return []string{tutil.LabelSmoke, tutil.LabelFull, tutil.LabelReachability}
+}
+
 func (t *tcp) Setup() error {
 	return nil
 }
@@ -82,5 +95,37 @@ func (t *tcp) Run() error {
 			}
 		}
 	}
-	return tutil.Parallel(funcs)
+	if err := t.Eventually(tutil.DefaultBudget, funcs); err != nil {
+		return err
+	}
+	return t.verifyRawTCP()
+}
+
+// verifyRawTCP sends binary payloads on raw TCP connections between the apps with a sidecar, and t
+// without mTLS. Each connection is idle for tcpIdle halfway through, then half-closed by the client,
+// which must still read the echo of the whole payload and the answer the app writes after it.
+func (t *tcp) verifyRawTCP() error {
+	dstPods := []string{"a", "b"}
+	if t.Auth == meshconfig.MeshConfig_NONE {
+		dstPods = append(dstPods, "t")
+	}
+	args := fmt.Sprintf("-payload %d -idle %v", tcpPayload, tcpIdle)
+	funcs := make(map[string]func() tutil.Status)
+	for _, src := range []string{"a", "b"} {
+		for _, dst := range dstPods {
+			name := fmt.Sprintf("raw TCP connection from %s to %s:9070", src, dst)
+			funcs[name] = (func(src, dst string) func() tutil.Status {
+				url := fmt.Sprintf("tcp://%s:9070", dst)
+				return func() tutil.Status {
+					resp := t.ClientRequest(src, url, 1, args)
+					if strings.Contains(resp.Body, fmt.Sprintf("TCPEchoed=%d", tcpPayload)) &&
+						strings.Contains(resp.Body, fmt.Sprintf("TCPReceived=%d", tcpPayload)) {
+						return nil
+					}
+					return tutil.ErrAgain
+				}
+			})(src, dst)
+		}
+	}
+	return t.Eventually(tutil.DefaultBudget, funcs)
 }
@@ -0,0 +1,158 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	telemetryAttributesConfig = "mixer-telemetry-attributes.yaml.tmpl"
+	// metric of the config, which the Prometheus adapter exports with the prefix istio_
+	telemetryAttributesMetric = "e2e_request_attributes"
+	// requests from a to b sent by the test
+	telemetryAttributesRequests = 3
+	// response code of the requests, other than that of prometheusMetrics for them not to add to its count
+	telemetryAttributesCode = "417"
+)
+
+// telemetryAttributes exports a metric whose labels are the attributes of the requests, such as their
+// path, code and source and destination labels, and checks that the series of requests with a path of
+// their own has exactly the attributes of these requests, so that a regression in how the proxy reports
+// the attributes to Mixer, or how Mixer maps them, fails the run.
+type telemetryAttributes struct {
+	*tutil.Environment
+	prometheus *tutil.Prometheus
+	// YAML of the metric, its handler and its rule
+	config string
+}
+
+func (t *telemetryAttributes) String() string {
+	return "telemetry-attributes"
+}
+
+func (t *telemetryAttributes) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelTelemetry, tutil.LabelSlow}
+}
+
+func (t *telemetryAttributes) Setup() error {
+	if !t.Config.Mixer || !t.Config.Prometheus {
+		return nil
+	}
+	config, err := t.Fill(telemetryAttributesConfig, map[string]string{
+		"Namespace":      t.Config.Namespace,
+		"IstioNamespace": t.Config.IstioNamespace,
+		"Metric":         telemetryAttributesMetric,
+	})
+	if err != nil {
+		return err
+	}
+	if err = t.KubeApply(config, t.Config.IstioNamespace); err != nil {
+		return err
+	}
+	t.config = config
+	t.prometheus, err = t.Prometheus()
+	return err
+}
+
+// Run sends requests from a to b on a path unique to the run, answered with a code of their own, and
+// checks that Prometheus has a single series for the path, with the labels of these requests, counting
+// them all.
+func (t *telemetryAttributes) Run() error {
+	if !t.Config.Mixer || !t.Config.Prometheus {
+		return tutil.Skip("mixer or prometheus is disabled, see -prometheus")
+	}
+
+	path := fmt.Sprintf("/telemetry-attributes/%d?codes=%s", time.Now().UnixNano(), telemetryAttributesCode)
+	want := map[string]string{
+		"request_path":          path,
+		"request_method":        "GET",
+		"response_code":         telemetryAttributesCode,
+		"source_app":            "a",
+		"source_version":        "v1",
+		"destination_app":       "b",
+		"destination_version":   "unversioned",
+		"destination_namespace": t.Config.Namespace,
+		"connection_mtls":       strconv.FormatBool(t.Config.Auth),
+	}
+
+	// the rule takes a while to reach Mixer, which drops the reports of the requests before it
+	query := fmt.Sprintf(`istio_%s{request_path=%q}`, telemetryAttributesMetric, path)
+	return tutil.Repeat(func() error {
+		resp := t.ClientRequest("a", "http://b"+path, telemetryAttributesRequests, "")
+		if len(resp.Code) != telemetryAttributesRequests {
+			return fmt.Errorf("%d of %d requests from a to b were answered", len(resp.Code), telemetryAttributesRequests)
+		}
+		for _, code := range resp.Code {
+			if code != telemetryAttributesCode {
+				return fmt.Errorf("request from a to %s returned %s, want %s", path, code, telemetryAttributesCode)
+			}
+		}
+
+		// Mixer batches its reports and Prometheus scrapes it every few seconds
+		return tutil.Repeat(func() error {
+			samples, err := t.prometheus.QuerySamples(query)
+			if err != nil {
+				return err
+			}
+			if len(samples) != 1 {
+				return fmt.Errorf("prometheus has %d series for %s, want 1: %v", len(samples), query, samples)
+			}
+			if diff := labelsDiff(samples[0].Labels, want); len(diff) > 0 {
+				return fmt.Errorf("attributes of the requests from a to b: %s", strings.Join(diff, ", "))
+			}
+			if int(samples[0].Value) < telemetryAttributesRequests {
+				return fmt.Errorf("prometheus counted %v requests from a to b, want %d", samples[0].Value,
+					telemetryAttributesRequests)
+			}
+			log.Infof("%s counted %v requests", query, samples[0].Value)
+			return nil
+		}, 12, 5*time.Second)
+	}, 3, time.Second)
+}
+
+func (t *telemetryAttributes) Teardown() {
+	if t.prometheus != nil {
+		t.prometheus.Close()
+		t.prometheus = nil
+	}
+	if t.config == "" {
+		return
+	}
+	if err := t.KubeDelete(t.config, t.Config.IstioNamespace); err != nil {
+		log.Warna(err)
+	}
+	t.config = ""
+}
+
+// labelsDiff returns the labels of the series that differ from the wanted ones, sorted, ignoring the
+// labels Prometheus adds, such as job and instance.
+func labelsDiff(got, want map[string]string) []string {
+	var diff []string
+	for name, value := range want {
+		if got[name] != value {
+			diff = append(diff, fmt.Sprintf("%s=%q, want %q", name, got[name], value))
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
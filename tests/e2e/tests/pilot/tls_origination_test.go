@@ -0,0 +1,84 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"time"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	tlsOriginationHost = "httpbin.org"
+	// cluster of the egress rule of port 443 of the host
+	tlsOriginationCluster = "out." + tlsOriginationHost + "|external-HTTPS-443"
+)
+
+type tlsOrigination struct {
+	*tutil.Environment
+}
+
+func (t *tlsOrigination) String() string {
+	return "tls-origination"
+}
+
+func (t *tlsOrigination) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelEgress, tutil.LabelSecurity}
+}
+
+func (t *tlsOrigination) Setup() error {
+	return t.ApplyConfig("v1alpha1/egress-rule-https-httpbin.yaml.tmpl", nil)
+}
+
+// Run sends plain HTTP requests to port 443 of an external host allowed by an https egress rule,
+// and checks that the sidecars get a response over the TLS connection they originate, presenting
+// the host as SNI.
+func (t *tlsOrigination) Run() error {
+	err := tutil.Repeat(func() error {
+		clusters, err := t.PilotClusters("a")
+		if err != nil {
+			return err
+		}
+		cluster, ok := clusters[tlsOriginationCluster]
+		if !ok {
+			return fmt.Errorf("pilot serves no cluster %s to a", tlsOriginationCluster)
+		}
+		ssl, ok := cluster.SSLContext.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("cluster %s does not originate TLS: %v", tlsOriginationCluster, cluster.SSLContext)
+		}
+		if ssl["sni"] != tlsOriginationHost {
+			return fmt.Errorf("cluster %s presents SNI %v, want %s", tlsOriginationCluster, ssl["sni"],
+				tlsOriginationHost)
+		}
+		return nil
+	}, 5, time.Second)
+	if err != nil {
+		return err
+	}
+
+	return tutil.Repeat(func() error {
+		return verifyReachable(t.Environment, fmt.Sprintf("http://%s:443/headers", tlsOriginationHost), true)
+	}, 3, time.Second)
+}
+
+func (t *tlsOrigination) Teardown() {
+	log.Info("Cleaning up egress rules...")
+	if err := t.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+}
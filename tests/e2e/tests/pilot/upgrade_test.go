@@ -0,0 +1,163 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	// traffic from a to b and c before the upgrade, and between its steps
+	upgradeWarmup = 5 * time.Second
+	// number of requests sent at once from a to b and to c
+	upgradeBatch = 10
+	// failed requests allowed while the control plane and the sidecars are upgraded, and downgraded
+	upgradeFailureBudget = 5
+)
+
+// upgrade moves the mesh installed from -base-tag to the current Hub/Tag in place, the control plane
+// first and then the sidecars, while a sends requests to b and to c. With -downgrade, the control plane
+// is then rolled back to the base tag. A rule routing c to v1, applied with the base release, must hold
+// throughout. It runs first, so that the other tests run against the upgraded mesh.
+type upgrade struct {
+	*tutil.Environment
+}
+
+func (t *upgrade) String() string {
+	return "upgrade"
+}
+
+func (t *upgrade) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelReachability, tutil.LabelRouting, tutil.LabelSlow}
+}
+
+func (t *upgrade) Setup() error {
+	return nil
+}
+
+// Teardown deletes the rule of c, the apps having been refreshed by the upgrade of the sidecars.
+func (t *upgrade) Teardown() {
+	if err := t.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+}
+
+// Run checks the rule of c with the base release, then upgrades the control plane and the sidecars in
+// turn, and possibly downgrades the control plane, while the requests from a are in flight. No more
+// than upgradeFailureBudget requests may fail, and none of c may reach v2.
+func (t *upgrade) Run() error {
+	base := t.Config.BaseTag
+	if base == "" {
+		return tutil.Skip("no -base-tag to upgrade from")
+	}
+	version := "v1alpha2"
+	if !t.Config.V1alpha2 {
+		if !t.Config.V1alpha1 {
+			return tutil.Skip("routing rules are disabled")
+		}
+		version = "v1alpha1"
+	}
+	if version == "v1alpha2" {
+		if err := t.ApplyConfig("v1alpha2/destination-rule-c.yaml.tmpl", nil); err != nil {
+			return err
+		}
+	}
+	if err := t.ApplyConfig(version+"/rule-default-route.yaml.tmpl", nil); err != nil {
+		return err
+	}
+	err := tutil.Repeat(func() error {
+		resp := t.ClientRequest("a", "http://c/a", upgradeBatch, "")
+		if count := counts(resp.Version)["v1"]; count != upgradeBatch {
+			return fmt.Errorf("%d of %d requests from a to c reached v1 with the base release", count, upgradeBatch)
+		}
+		return nil
+	}, 10, time.Second)
+	if err != nil {
+		return err
+	}
+
+	var (
+		mutex                        sync.Mutex
+		requests, failures, misroute int
+		wg                           sync.WaitGroup
+	)
+	stop := make(chan struct{})
+	send := func(url string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				resp := t.ClientRequest("a", url, upgradeBatch, "")
+				ok := 0
+				for _, code := range resp.Code {
+					if code == "200" {
+						ok++
+					}
+				}
+				mutex.Lock()
+				requests += upgradeBatch
+				failures += upgradeBatch - ok
+				misroute += counts(resp.Version)["v2"]
+				mutex.Unlock()
+			}
+		}()
+	}
+	send("http://b/a")
+	send("http://c/a")
+
+	time.Sleep(upgradeWarmup)
+	err = t.UpgradeControlPlane()
+	if err == nil {
+		time.Sleep(upgradeWarmup)
+		err = t.UpgradeSidecars()
+	}
+	if err == nil && t.Config.Downgrade {
+		time.Sleep(upgradeWarmup)
+		err = t.DowngradeControlPlane(base)
+	}
+	if err == nil {
+		time.Sleep(upgradeWarmup)
+	}
+	close(stop)
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+
+	step := "the upgrade"
+	if t.Config.Downgrade {
+		step = "the upgrade and the downgrade"
+	}
+	log.Infof("%d/%d requests from a failed and %d reached c v2 during %s", failures, requests, misroute, step)
+	if misroute > 0 {
+		return fmt.Errorf("%d requests from a to c reached v2 during %s, the rule routing to v1 broke",
+			misroute, step)
+	}
+	if failures > upgradeFailureBudget {
+		return fmt.Errorf("%d/%d requests from a failed during %s, want at most %d",
+			failures, requests, step, upgradeFailureBudget)
+	}
+	return nil
+}
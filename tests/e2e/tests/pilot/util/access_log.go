@@ -0,0 +1,151 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"istio.io/istio/pilot/pkg/kube/inject"
+	"istio.io/istio/pilot/test/util"
+	"istio.io/istio/pkg/log"
+)
+
+const (
+	// how long AssertAccessLog waits for a matching line
+	accessLogTimeout = 30 * time.Second
+	// delay between two reads of the access log
+	accessLogPollInterval = 2 * time.Second
+	// number of lines of the access log shown when no line matches
+	accessLogTailLines = 20
+)
+
+// accessLogRex parses the default Envoy access log format:
+// [START_TIME] "METHOD PATH PROTOCOL" CODE FLAGS RECEIVED SENT DURATION UPSTREAM_TIME "X-FORWARDED-FOR"
+// "USER-AGENT" "X-REQUEST-ID" "AUTHORITY" "UPSTREAM_HOST"
+var accessLogRex = regexp.MustCompile(`^\[([^\]]+)\] "(\S+) (\S+) (\S+)" (\d+) (\S+) \d+ \d+ \d+ \S+ ` +
+	`"[^"]*" "[^"]*" "([^"]*)" "([^"]*)" "([^"]*)"`)
+
+// AccessLogEntry is one request logged by a sidecar.
+type AccessLogEntry struct {
+	Method        string
+	Path          string
+	Protocol      string
+	Status        int
+	ResponseFlags string
+	RequestID     string
+	Authority     string
+	UpstreamHost  string
+}
+
+// ParseAccessLogLine parses a line of the default Envoy access log format, and returns false
+// for other lines, such as the logs of the proxy itself.
+func ParseAccessLogLine(line string) (AccessLogEntry, bool) {
+	m := accessLogRex.FindStringSubmatch(line)
+	if m == nil {
+		return AccessLogEntry{}, false
+	}
+	status, err := strconv.Atoi(m[5])
+	if err != nil {
+		return AccessLogEntry{}, false
+	}
+	return AccessLogEntry{
+		Method:        m[2],
+		Path:          m[3],
+		Protocol:      m[4],
+		Status:        status,
+		ResponseFlags: m[6],
+		RequestID:     m[7],
+		Authority:     m[8],
+		UpstreamHost:  m[9],
+	}, true
+}
+
+// AccessLogMatcher selects access log entries. Zero fields match any entry.
+type AccessLogMatcher struct {
+	// Since ignores the lines logged before, so that earlier requests do not match.
+	Since time.Time
+	// Status is the response code.
+	Status int
+	// ResponseFlags are the Envoy response flags, such as "UT" for an upstream timeout, or "-" for none.
+	ResponseFlags string
+	// Authority is the host the request was sent to.
+	Authority string
+	// PathPrefix is a prefix of the request path.
+	PathPrefix string
+}
+
+// Matches returns true if the entry has all the properties set in the matcher.
+func (m AccessLogMatcher) Matches(entry AccessLogEntry) bool {
+	return (m.Status == 0 || m.Status == entry.Status) &&
+		(m.ResponseFlags == "" || m.ResponseFlags == entry.ResponseFlags) &&
+		(m.Authority == "" || m.Authority == entry.Authority) &&
+		strings.HasPrefix(entry.Path, m.PathPrefix)
+}
+
+func (m AccessLogMatcher) String() string {
+	return fmt.Sprintf("status=%d flags=%q authority=%q path=%q*", m.Status, m.ResponseFlags, m.Authority, m.PathPrefix)
+}
+
+// AssertAccessLog waits for the sidecar of the given pod to log a request that matches, and
+// returns an error with the last lines of its access log if none does before the timeout.
+//
+// Every read fetches all the lines since the matcher's start time again, rather than following
+// an offset, so that lines are not missed when the container log is rotated between two reads.
+// The last line is only parsed once it is terminated, as it may still be being written.
+func (e *Environment) AssertAccessLog(pod string, matcher AccessLogMatcher) error {
+	cmd := fmt.Sprintf("kubectl logs %s --kubeconfig %s -n %s -c %s",
+		pod, e.Config.KubeConfig, e.Config.Namespace, inject.ProxyContainerName)
+	if !matcher.Since.IsZero() {
+		cmd += " --since-time=" + matcher.Since.UTC().Format(time.RFC3339)
+	}
+
+	var tail []string
+	deadline := time.Now().Add(accessLogTimeout)
+	for {
+		out, err := util.Shell(cmd)
+		if err != nil {
+			log.Infof("could not read the access log of %s: %v", pod, err)
+		} else {
+			lines := strings.Split(out, "\n")
+			// the last element is empty after a terminated line, and partial otherwise
+			lines = lines[:len(lines)-1]
+			tail = nil
+			for _, line := range lines {
+				entry, ok := ParseAccessLogLine(line)
+				if !ok {
+					continue
+				}
+				if matcher.Matches(entry) {
+					return nil
+				}
+				tail = append(tail, line)
+			}
+		}
+		if time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(accessLogPollInterval)
+	}
+
+	if len(tail) > accessLogTailLines {
+		tail = tail[len(tail)-accessLogTailLines:]
+	}
+	return fmt.Errorf("no request matching %v in the access log of %s after %v, last lines:\n%s",
+		matcher, pod, accessLogTimeout, strings.Join(tail, "\n"))
+}
@@ -0,0 +1,210 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/istio/pilot/pkg/kube/inject"
+)
+
+// seconds DeployApp waits, with a sidecar template and automatic injection, for the injector to
+// create the pods
+const podCreationBudget = 60
+
+// AppPort is a port of the service of an app.
+type AppPort struct {
+	// Name of the service port, its prefix giving the protocol to Istio (http, grpc, tcp and so on)
+	Name string
+	// Port of the service
+	Port int
+	// TargetPort is the port of the container, Port if zero
+	TargetPort int
+	// Protocol is the Kubernetes protocol of the port, TCP if empty
+	Protocol string
+}
+
+// AppSpec describes an app a test deploys with DeployApp, a service and a deployment or a stateful
+// set. The fields left empty get those of the test app.
+type AppSpec struct {
+	// Deployment is the name of the deployment or of the stateful set
+	Deployment string
+	// Service is the name of the service, the app label of the pods, Deployment if empty
+	Service string
+	// Version is the version label of the pods, "unversioned" if empty
+	Version string
+	// Labels are the labels of the pods besides app and version
+	Labels map[string]string
+	// ServiceAnnotations are the annotations of the service
+	ServiceAnnotations map[string]string
+	// ServiceAccount runs the pods, the namespace default if empty
+	ServiceAccount string
+	// Replicas is the number of pods of the deployment, 1 if zero
+	Replicas int32
+	// Image is the image of the app container, the test app of AppHub and AppTag if empty
+	Image string
+	// Args are the arguments of the app container. For the test app, the default ones serve the
+	// ports and answer with the version
+	Args []string
+	// Ports are the ports of the service, DefaultAppPorts if empty
+	Ports []AppPort
+	// NoSidecar deploys the app without a sidecar
+	NoSidecar bool
+	// SidecarTemplate is the injection template of the sidecar, that of the other apps if empty. With
+	// automatic injection, it is the template of the injector until the pods are created
+	SidecarTemplate string
+	// Namespace is the namespace of the app, which must exist, the app namespace if empty. The apps of
+	// the app namespace only are in Apps
+	Namespace string
+	// StatefulSet deploys the pods with a stateful set governed by a headless service, each pod having
+	// a DNS name of the service, <Deployment>-<ordinal>.<Service>
+	StatefulSet bool
+}
+
+// DefaultAppPorts are the ports of the extra apps, those of b.
+var DefaultAppPorts = []AppPort{
+	{Name: "http", Port: 80},
+	{Name: "http-two", Port: 8080},
+	{Name: "tcp", Port: 90},
+	{Name: "https", Port: 9090},
+	{Name: "http2-example", Port: 70},
+	{Name: "grpc", Port: 7070},
+}
+
+// AppVersions returns the specs of the deployments <service>-v1 to <service>-v<versions> of the test
+// app behind the service, each of the replicas and with the version of its name, for the tests of
+// subsets. The specs can be changed before DeployApp deploys them.
+func AppVersions(service string, versions int, replicas int32) []AppSpec {
+	specs := make([]AppSpec, 0, versions)
+	for i := 1; i <= versions; i++ {
+		version := fmt.Sprintf("v%d", i)
+		specs = append(specs, AppSpec{
+			Deployment: service + "-" + version,
+			Service:    service,
+			Version:    version,
+			Replicas:   replicas,
+		})
+	}
+	return specs
+}
+
+// DeployApp deploys an extra app, with a sidecar unless spec.NoSidecar is set. It returns the applied
+// YAML so that the caller can delete the app with KubeDelete in spec.Namespace, and does not wait for
+// the pods to run, see RefreshApps.
+func (e *Environment) DeployApp(spec AppSpec) (string, error) {
+	if spec.Deployment == "" {
+		return "", fmt.Errorf("the app has no deployment name")
+	}
+	if spec.Service == "" {
+		spec.Service = spec.Deployment
+	}
+	if spec.Version == "" {
+		spec.Version = "unversioned"
+	}
+	if spec.Namespace == "" {
+		spec.Namespace = e.Config.Namespace
+	}
+	if spec.Replicas == 0 {
+		spec.Replicas = 1
+	}
+	if len(spec.Ports) == 0 {
+		spec.Ports = DefaultAppPorts
+	}
+	ports := make([]AppPort, 0, len(spec.Ports))
+	for _, port := range spec.Ports {
+		if port.TargetPort == 0 {
+			port.TargetPort = port.Port
+		}
+		if port.Protocol == "" {
+			port.Protocol = "TCP"
+		}
+		ports = append(ports, port)
+	}
+	spec.Ports = ports
+	if spec.Image == "" {
+		spec.Image = fmt.Sprintf("%s/app:%s", e.Config.AppImageHub(), e.Config.AppImageTag())
+		if spec.Args == nil {
+			spec.Args = testAppArgs(spec.Ports, spec.Version)
+		}
+	}
+
+	yaml, err := e.Fill("custom-app.yaml.tmpl", spec)
+	if err != nil {
+		return "", err
+	}
+	if spec.SidecarTemplate == "" || spec.NoSidecar {
+		return e.applyApp(yaml, spec.Namespace, !spec.NoSidecar)
+	}
+	if !e.Config.UseAutomaticInjection {
+		writer := new(bytes.Buffer)
+		if err = inject.IntoResourceFile(spec.SidecarTemplate, e.meshConfig, strings.NewReader(yaml), writer); err != nil {
+			return "", err
+		}
+		return e.applyApp(writer.String(), spec.Namespace, false)
+	}
+
+	if err = e.UpdateSidecarInjectorTemplate(spec.SidecarTemplate); err != nil {
+		return "", err
+	}
+	applied, err := e.applyApp(yaml, spec.Namespace, true)
+	if err == nil {
+		err = e.waitForPods(spec.Namespace, fmt.Sprintf("app=%s,version=%s", spec.Service, spec.Version),
+			int(spec.Replicas))
+	}
+	if restoreErr := e.UpdateSidecarInjectorTemplate(e.Config.SidecarTemplate); restoreErr != nil && err == nil {
+		err = restoreErr
+	}
+	return applied, err
+}
+
+// waitForPods waits until count pods of the selector exist in the namespace, running or not.
+func (e *Environment) waitForPods(namespace, selector string, count int) error {
+	if e.Config.DryRun {
+		return nil
+	}
+	for i := 0; i < podCreationBudget; i++ {
+		pods, err := e.KubeClient.CoreV1().Pods(namespace).List(meta_v1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return err
+		}
+		if len(pods.Items) >= count {
+			return nil
+		}
+		time.Sleep(time.Second)
+	}
+	return fmt.Errorf("%s pods of %s not created in %ds", selector, namespace, podCreationBudget)
+}
+
+// testAppArgs returns the arguments of the test app serving the ports, gRPC on the grpc and http2
+// ones like the apps of Setup.
+func testAppArgs(ports []AppPort, version string) []string {
+	var args []string
+	for _, port := range ports {
+		flag := "--port"
+		switch {
+		case strings.HasPrefix(port.Name, "grpc"), strings.HasPrefix(port.Name, "http2"):
+			flag = "--grpc"
+		}
+		args = append(args, flag, strconv.Itoa(port.TargetPort))
+	}
+	// below the default termination grace period of 30s
+	return append(args, "--version", version, "--drain", "25s")
+}
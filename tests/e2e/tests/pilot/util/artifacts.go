@@ -0,0 +1,243 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"istio.io/istio/pkg/log"
+)
+
+const (
+	// prefix of the staging directory and of the bundle of a run in ArtifactsDir
+	artifactsPrefix = "pilot-e2e-"
+	// file of the bundle describing the run
+	artifactsMetadataFile = "metadata.json"
+)
+
+// appliedMu serializes the appends to the files of AppliedYAMLDir, written by concurrent environments.
+var appliedMu sync.Mutex
+
+// Artifacts stages the outputs of a run in a directory of ArtifactsDir and packages them, once the
+// run is done, into a single timestamped tar.gz that CI archives in one step.
+type Artifacts struct {
+	config  *Config
+	staging string
+	bundle  string
+	started time.Time
+}
+
+// RunMetadata describes the run in the metadata file of the bundle.
+type RunMetadata struct {
+	Started   time.Time `json:"started"`
+	Finished  time.Time `json:"finished"`
+	ExitCode  int       `json:"exit_code"`
+	Passed    int       `json:"passed"`
+	Flaky     int       `json:"flaky"`
+	Failed    int       `json:"failed"`
+	Skipped   int       `json:"skipped"`
+	GoVersion string    `json:"go_version"`
+	Host      string    `json:"host"`
+	Args      []string  `json:"args"`
+	Config    *Config   `json:"config"`
+}
+
+// artifactOutput is an output of the config packaged in the bundle.
+type artifactOutput struct {
+	// name is the directory or the file in the bundle
+	name  string
+	value *string
+	dir   bool
+	// staged is true if the output is staged when not set; the others enable more work than writing
+	// their output, such as profiling Pilot or following the logs of every container
+	staged bool
+}
+
+// artifactOutputs returns the outputs of the config packaged in the bundle.
+func artifactOutputs(config *Config) []artifactOutput {
+	return []artifactOutput{
+		{name: "report", value: &config.ReportDir, dir: true, staged: true},
+		{name: "logs", value: &config.ErrorLogsDir, dir: true, staged: true},
+		{name: "cores", value: &config.CoreFilesDir, dir: true, staged: true},
+		{name: "applied", value: &config.AppliedYAMLDir, dir: true, staged: true},
+		{name: "events.jsonl", value: &config.JSONLOutput, staged: true},
+		{name: "stream-logs", value: &config.StreamLogsDir, dir: true},
+		{name: "pprof", value: &config.PprofDir, dir: true},
+		{name: filepath.Base(config.BenchmarkFile), value: &config.BenchmarkFile},
+	}
+}
+
+// StageArtifacts creates the staging directory of the run in config.ArtifactsDir and points the staged
+// outputs of the config that are not set there, so that the report, the logs of the pods, the
+// diagnostics of the sidecars and the applied YAML end up in the bundle, as do the periodic profiles of
+// Pilot written to the report directory. The outputs set elsewhere are copied into the bundle by
+// Package. It returns nil if ArtifactsDir is not set.
+func StageArtifacts(config *Config) (*Artifacts, error) {
+	if config.ArtifactsDir == "" {
+		return nil, nil
+	}
+	started := time.Now()
+	name := artifactsPrefix + started.UTC().Format("20060102-150405")
+	a := &Artifacts{
+		config:  config,
+		staging: filepath.Join(config.ArtifactsDir, name),
+		bundle:  filepath.Join(config.ArtifactsDir, name+".tar.gz"),
+		started: started,
+	}
+	for _, output := range artifactOutputs(config) {
+		if *output.value != "" || !output.staged {
+			continue
+		}
+		*output.value = filepath.Join(a.staging, output.name)
+		dir := *output.value
+		if !output.dir {
+			dir = a.staging
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	log.Infof("Staging the artifacts of the run in %s", a.staging)
+	return a, nil
+}
+
+// Package writes the metadata of the run to the staging directory and packages it into the bundle,
+// with the outputs set outside of it, then removes the staging directory. It returns the path of the
+// bundle.
+func (a *Artifacts) Package(metadata RunMetadata) (string, error) {
+	metadata.Started = a.started
+	metadata.Finished = time.Now()
+	metadata.GoVersion = runtime.Version()
+	metadata.Host, _ = os.Hostname()
+	metadata.Args = os.Args[1:]
+	metadata.Config = a.config
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err = ioutil.WriteFile(filepath.Join(a.staging, artifactsMetadataFile), data, 0644); err != nil {
+		return "", err
+	}
+
+	f, err := os.Create(a.bundle)
+	if err != nil {
+		return "", err
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	err = addToTar(tw, a.staging, "")
+	for _, output := range artifactOutputs(a.config) {
+		if err == nil && *output.value != "" && !a.staged(*output.value) {
+			err = addToTar(tw, *output.value, output.name)
+		}
+	}
+	for _, closer := range []io.Closer{tw, gz, f} {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot package the artifacts to %s: %v", a.bundle, err)
+	}
+	if err = os.RemoveAll(a.staging); err != nil {
+		log.Warna(err)
+	}
+	return a.bundle, nil
+}
+
+// staged returns true if the path is in the staging directory.
+func (a *Artifacts) staged(path string) bool {
+	return path == a.staging || strings.HasPrefix(path, a.staging+string(filepath.Separator))
+}
+
+// addToTar adds the file or the tree of the path to the tar under the name, the root if empty. A missing
+// path, an output the run did not get to write, is left out.
+func addToTar(tw *tar.Writer, path, name string) error {
+	return filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(path, file)
+		if err != nil {
+			return err
+		}
+		entry := filepath.ToSlash(filepath.Join(name, rel))
+		if entry == "." || !(info.IsDir() || info.Mode().IsRegular()) {
+			return nil
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = entry
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err = tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		in, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = in.Close() }()
+		_, err = io.Copy(tw, in)
+		return err
+	})
+}
+
+// recordApplied appends the YAML applied to the namespace to <namespace>.yaml in AppliedYAMLDir, if
+// set, after a comment saying when and what. Failures are only logged.
+func (e *Environment) recordApplied(what, namespace, yaml string) {
+	if e.Config.AppliedYAMLDir == "" || e.Config.DryRun {
+		return
+	}
+	appliedMu.Lock()
+	defer appliedMu.Unlock()
+	if err := os.MkdirAll(e.Config.AppliedYAMLDir, 0755); err != nil {
+		log.Warna(err)
+		return
+	}
+	file := filepath.Join(e.Config.AppliedYAMLDir, namespace+".yaml")
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Warna(err)
+		return
+	}
+	_, err = fmt.Fprintf(f, "# %s %s\n%s\n---\n", time.Now().UTC().Format(time.RFC3339), what, yaml)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Warna(err)
+	}
+}
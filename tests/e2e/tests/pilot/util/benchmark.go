@@ -0,0 +1,90 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Benchmark is implemented by tests that can measure request latency instead of checking behavior.
+// With -benchmark, the harness calls BenchmarkRun in place of Run; tests that do not implement it
+// are a no-op in that mode.
+type Benchmark interface {
+	// BenchmarkRun sends a fixed number of requests and returns the measured latencies by target.
+	BenchmarkRun() (map[string][]time.Duration, error)
+}
+
+// BenchmarkResult holds the latency percentiles of the requests sent by a test to one target.
+type BenchmarkResult struct {
+	Auth    string  `json:"auth"`
+	Test    string  `json:"test"`
+	Target  string  `json:"target"`
+	Samples int     `json:"samples"`
+	P50     float64 `json:"p50_ms"`
+	P90     float64 `json:"p90_ms"`
+	P99     float64 `json:"p99_ms"`
+}
+
+// BenchmarkResults collects benchmark results across auth modes. It is safe for concurrent use.
+type BenchmarkResults struct {
+	mu      sync.Mutex
+	results []BenchmarkResult
+}
+
+// Record computes the latency percentiles of each target and adds them to the results.
+func (b *BenchmarkResults) Record(auth, test string, latencies map[string][]time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for target, samples := range latencies {
+		if len(samples) == 0 {
+			continue
+		}
+		sorted := append([]time.Duration(nil), samples...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		b.results = append(b.results, BenchmarkResult{
+			Auth:    auth,
+			Test:    test,
+			Target:  target,
+			Samples: len(sorted),
+			P50:     percentile(sorted, 50),
+			P90:     percentile(sorted, 90),
+			P99:     percentile(sorted, 99),
+		})
+	}
+}
+
+// WriteFile writes all results as a JSON array.
+func (b *BenchmarkResults) WriteFile(path string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data, err := json.MarshalIndent(b.results, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// percentile returns the nearest-rank percentile of sorted samples, in milliseconds.
+func percentile(sorted []time.Duration, p int) float64 {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return float64(sorted[rank-1]) / float64(time.Millisecond)
+}
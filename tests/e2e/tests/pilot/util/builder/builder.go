@@ -0,0 +1,114 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package builder builds the v1alpha2 networking configs of the e2e tests in Go, in place of the
+// templates of testdata: virtual services, destination rules, gateways and external services, the
+// predecessors of service entries in this version. The configs are validated like Pilot validates
+// them when they are built, so that a mistake fails the test before anything is applied.
+package builder
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gogo/protobuf/types"
+	"github.com/golang/protobuf/proto"
+
+	networking "istio.io/api/networking/v1alpha3"
+	"istio.io/istio/pilot/pkg/config/kube/crd"
+	"istio.io/istio/pilot/pkg/model"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+// Builder builds one config.
+type Builder interface {
+	// Build returns the config, or an error if it is invalid.
+	Build() (model.Config, error)
+}
+
+// ApplyAndWait builds the configs and applies them to the environment, then waits for them to
+// propagate like ApplyConfig. The name stands for the template the configs replace, in the applied
+// YAML and in the golden files.
+func ApplyAndWait(env *tutil.Environment, name string, builders ...Builder) error {
+	configs, err := buildAll(builders)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return env.ApplyConfigs(name, configs)
+}
+
+// Delete builds the configs and deletes them from the environment.
+func Delete(env *tutil.Environment, builders ...Builder) error {
+	configs, err := buildAll(builders)
+	if err != nil {
+		return err
+	}
+	return env.DeleteConfigs(configs)
+}
+
+// buildAll builds the configs in order.
+func buildAll(builders []Builder) ([]model.Config, error) {
+	configs := make([]model.Config, 0, len(builders))
+	for _, b := range builders {
+		config, err := b.Build()
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, config)
+	}
+	return configs, nil
+}
+
+// build validates the spec and returns the config of the schema holding it, which shares the spec with
+// the builder.
+func build(schema model.ProtoSchema, name string, spec proto.Message) (model.Config, error) {
+	if err := schema.Validate(spec); err != nil {
+		return model.Config{}, fmt.Errorf("%s %s is invalid: %v", schema.Type, name, err)
+	}
+	return model.Config{
+		ConfigMeta: model.ConfigMeta{
+			Type:    schema.Type,
+			Group:   crd.ResourceGroup(&schema),
+			Version: schema.Version,
+			Name:    name,
+		},
+		Spec: spec,
+	}, nil
+}
+
+// Port returns the port of a gateway server or of an external service.
+func Port(number uint32, name, protocol string) *networking.Port {
+	return &networking.Port{Number: number, Name: name, Protocol: protocol}
+}
+
+// Exact returns a match of the string.
+func Exact(value string) *networking.StringMatch {
+	return &networking.StringMatch{MatchType: &networking.StringMatch_Exact{Exact: value}}
+}
+
+// Prefix returns a match of the strings starting with the prefix.
+func Prefix(prefix string) *networking.StringMatch {
+	return &networking.StringMatch{MatchType: &networking.StringMatch_Prefix{Prefix: prefix}}
+}
+
+// Regex returns a match of the strings matching the regular expression, in the ECMAScript grammar
+// of Envoy.
+func Regex(regex string) *networking.StringMatch {
+	return &networking.StringMatch{MatchType: &networking.StringMatch_Regex{Regex: regex}}
+}
+
+// duration returns the duration as a proto.
+func duration(d time.Duration) *types.Duration {
+	return types.DurationProto(d)
+}
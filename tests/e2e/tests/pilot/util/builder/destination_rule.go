@@ -0,0 +1,56 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	networking "istio.io/api/networking/v1alpha3"
+	"istio.io/istio/pilot/pkg/model"
+)
+
+// DestinationRuleBuilder builds a destination rule.
+type DestinationRuleBuilder struct {
+	name string
+	spec networking.DestinationRule
+}
+
+// DestinationRule returns the builder of the destination rule of the host.
+func DestinationRule(name, host string) *DestinationRuleBuilder {
+	return &DestinationRuleBuilder{name: name, spec: networking.DestinationRule{Name: host}}
+}
+
+// Subset adds the subset of the endpoints of the host with the labels.
+func (b *DestinationRuleBuilder) Subset(name string, labels map[string]string) *DestinationRuleBuilder {
+	b.spec.Subsets = append(b.spec.Subsets, &networking.Subset{Name: name, Labels: labels})
+	return b
+}
+
+// VersionSubsets adds a subset per version, named after it, of the endpoints with the version label.
+func (b *DestinationRuleBuilder) VersionSubsets(versions ...string) *DestinationRuleBuilder {
+	for _, version := range versions {
+		b.Subset(version, map[string]string{"version": version})
+	}
+	return b
+}
+
+// TrafficPolicy sets the load balancing, connection pool and outlier detection of the host.
+func (b *DestinationRuleBuilder) TrafficPolicy(policy *networking.TrafficPolicy) *DestinationRuleBuilder {
+	b.spec.TrafficPolicy = policy
+	return b
+}
+
+// Build returns the destination rule.
+func (b *DestinationRuleBuilder) Build() (model.Config, error) {
+	return build(model.DestinationRule, b.name, &b.spec)
+}
@@ -0,0 +1,51 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	networking "istio.io/api/networking/v1alpha3"
+	"istio.io/istio/pilot/pkg/model"
+)
+
+// ExternalServiceBuilder builds an external service, which adds hosts out of the mesh to its registry.
+type ExternalServiceBuilder struct {
+	name string
+	spec networking.ExternalService
+}
+
+// ExternalService returns the builder of the external service of the hosts, resolved with the discovery
+// mode.
+func ExternalService(name string, discovery networking.ExternalService_Discovery,
+	hosts ...string) *ExternalServiceBuilder {
+	return &ExternalServiceBuilder{name: name, spec: networking.ExternalService{Hosts: hosts, Discovery: discovery}}
+}
+
+// Port adds a port of the hosts.
+func (b *ExternalServiceBuilder) Port(port *networking.Port) *ExternalServiceBuilder {
+	b.spec.Ports = append(b.spec.Ports, port)
+	return b
+}
+
+// Endpoint adds an endpoint of the hosts at the address, an IP with the STATIC discovery mode and a
+// name with DNS, listening on the given number of each port name, if not on the port itself.
+func (b *ExternalServiceBuilder) Endpoint(address string, ports map[string]uint32) *ExternalServiceBuilder {
+	b.spec.Endpoints = append(b.spec.Endpoints, &networking.ExternalService_Endpoint{Address: address, Ports: ports})
+	return b
+}
+
+// Build returns the external service.
+func (b *ExternalServiceBuilder) Build() (model.Config, error) {
+	return build(model.ExternalService, b.name, &b.spec)
+}
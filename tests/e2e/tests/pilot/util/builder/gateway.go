@@ -0,0 +1,59 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	networking "istio.io/api/networking/v1alpha3"
+	"istio.io/istio/pilot/pkg/model"
+)
+
+// GatewayBuilder builds a gateway.
+type GatewayBuilder struct {
+	name string
+	spec networking.Gateway
+}
+
+// Gateway returns the builder of a gateway, which needs at least one server.
+func Gateway(name string) *GatewayBuilder {
+	return &GatewayBuilder{name: name}
+}
+
+// Server adds a server of the hosts on the port.
+func (b *GatewayBuilder) Server(port *networking.Port, hosts ...string) *GatewayBuilder {
+	b.spec.Servers = append(b.spec.Servers, &networking.Server{Port: port, Hosts: hosts})
+	return b
+}
+
+// TLSServer adds a server of the hosts on the port, terminating TLS with the options.
+func (b *GatewayBuilder) TLSServer(port *networking.Port, tls *networking.Server_TLSOptions,
+	hosts ...string) *GatewayBuilder {
+	b.spec.Servers = append(b.spec.Servers, &networking.Server{Port: port, Hosts: hosts, Tls: tls})
+	return b
+}
+
+// SimpleTLS returns the options of a server terminating TLS with the certificate and its private key,
+// files of the gateway pod.
+func SimpleTLS(certificate, key string) *networking.Server_TLSOptions {
+	return &networking.Server_TLSOptions{
+		Mode:              networking.Server_TLSOptions_SIMPLE,
+		ServerCertificate: certificate,
+		PrivateKey:        key,
+	}
+}
+
+// Build returns the gateway.
+func (b *GatewayBuilder) Build() (model.Config, error) {
+	return build(model.Gateway, b.name, &b.spec)
+}
@@ -0,0 +1,205 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"time"
+
+	"github.com/gogo/protobuf/types"
+
+	networking "istio.io/api/networking/v1alpha3"
+	"istio.io/istio/pilot/pkg/model"
+)
+
+// VirtualServiceBuilder builds a virtual service.
+type VirtualServiceBuilder struct {
+	name string
+	spec networking.VirtualService
+}
+
+// VirtualService returns the builder of the virtual service of the hosts.
+func VirtualService(name string, hosts ...string) *VirtualServiceBuilder {
+	return &VirtualServiceBuilder{name: name, spec: networking.VirtualService{Hosts: hosts}}
+}
+
+// Gateways binds the virtual service to the gateways rather than to the sidecars.
+func (b *VirtualServiceBuilder) Gateways(gateways ...string) *VirtualServiceBuilder {
+	b.spec.Gateways = append(b.spec.Gateways, gateways...)
+	return b
+}
+
+// HTTP adds the HTTP routes, tried in order.
+func (b *VirtualServiceBuilder) HTTP(routes ...*HTTPRouteBuilder) *VirtualServiceBuilder {
+	for _, route := range routes {
+		b.spec.Http = append(b.spec.Http, route.route)
+	}
+	return b
+}
+
+// Build returns the virtual service.
+func (b *VirtualServiceBuilder) Build() (model.Config, error) {
+	return build(model.VirtualService, b.name, &b.spec)
+}
+
+// HTTPRouteBuilder builds an HTTP route of a virtual service.
+type HTTPRouteBuilder struct {
+	route *networking.HTTPRoute
+}
+
+// HTTPRoute returns the builder of an HTTP route, matching every request until Match is called.
+func HTTPRoute() *HTTPRouteBuilder {
+	return &HTTPRouteBuilder{route: &networking.HTTPRoute{}}
+}
+
+// Match adds the matches of the route, any of which selects a request.
+func (r *HTTPRouteBuilder) Match(matches ...*MatchBuilder) *HTTPRouteBuilder {
+	for _, match := range matches {
+		r.route.Match = append(r.route.Match, match.match)
+	}
+	return r
+}
+
+// Route adds a destination of the route. The weight, in percent, is left unset if 0, which is only
+// valid for the single destination of a route.
+func (r *HTTPRouteBuilder) Route(host, subset string, weight int32) *HTTPRouteBuilder {
+	r.route.Route = append(r.route.Route, &networking.DestinationWeight{
+		Destination: &networking.Destination{Name: host, Subset: subset},
+		Weight:      weight,
+	})
+	return r
+}
+
+// Redirect answers the requests with a redirect to the URI and the authority, each left as is if empty.
+func (r *HTTPRouteBuilder) Redirect(uri, authority string) *HTTPRouteBuilder {
+	r.route.Redirect = &networking.HTTPRedirect{Uri: uri, Authority: authority}
+	return r
+}
+
+// Rewrite rewrites the URI and the authority of the requests, each left as is if empty.
+func (r *HTTPRouteBuilder) Rewrite(uri, authority string) *HTTPRouteBuilder {
+	r.route.Rewrite = &networking.HTTPRewrite{Uri: uri, Authority: authority}
+	return r
+}
+
+// Mirror sends a copy of the requests to the subset of the host, the whole host if subset is empty.
+func (r *HTTPRouteBuilder) Mirror(host, subset string) *HTTPRouteBuilder {
+	r.route.Mirror = &networking.Destination{Name: host, Subset: subset}
+	return r
+}
+
+// Delay delays the percent of the requests by the delay.
+func (r *HTTPRouteBuilder) Delay(percent int32, delay time.Duration) *HTTPRouteBuilder {
+	r.fault().Delay = &networking.HTTPFaultInjection_Delay{
+		Percent:       percent,
+		HttpDelayType: &networking.HTTPFaultInjection_Delay_FixedDelay{FixedDelay: duration(delay)},
+	}
+	return r
+}
+
+// Abort answers the percent of the requests with the HTTP status.
+func (r *HTTPRouteBuilder) Abort(percent, status int32) *HTTPRouteBuilder {
+	r.fault().Abort = &networking.HTTPFaultInjection_Abort{
+		Percent:   percent,
+		ErrorType: &networking.HTTPFaultInjection_Abort_HttpStatus{HttpStatus: status},
+	}
+	return r
+}
+
+// fault returns the fault injection of the route, adding it if needed.
+func (r *HTTPRouteBuilder) fault() *networking.HTTPFaultInjection {
+	if r.route.Fault == nil {
+		r.route.Fault = &networking.HTTPFaultInjection{}
+	}
+	return r.route.Fault
+}
+
+// Timeout sets the timeout of the requests.
+func (r *HTTPRouteBuilder) Timeout(timeout time.Duration) *HTTPRouteBuilder {
+	r.route.Timeout = duration(timeout)
+	return r
+}
+
+// Retries retries the failed requests up to attempts times, each try timing out after perTryTimeout if
+// not 0.
+func (r *HTTPRouteBuilder) Retries(attempts int32, perTryTimeout time.Duration) *HTTPRouteBuilder {
+	r.route.Retries = &networking.HTTPRetry{Attempts: attempts}
+	if perTryTimeout > 0 {
+		r.route.Retries.PerTryTimeout = duration(perTryTimeout)
+	}
+	return r
+}
+
+// WebsocketUpgrade lets the requests upgrade to websockets.
+func (r *HTTPRouteBuilder) WebsocketUpgrade() *HTTPRouteBuilder {
+	r.route.WebsocketUpgrade = true
+	return r
+}
+
+// AppendHeader adds the header to the requests.
+func (r *HTTPRouteBuilder) AppendHeader(name, value string) *HTTPRouteBuilder {
+	if r.route.AppendHeaders == nil {
+		r.route.AppendHeaders = make(map[string]string)
+	}
+	r.route.AppendHeaders[name] = value
+	return r
+}
+
+// CorsPolicy sets the CORS policy of the route.
+func (r *HTTPRouteBuilder) CorsPolicy(origins, methods, headers, exposed []string, maxAge time.Duration,
+	credentials bool) *HTTPRouteBuilder {
+	r.route.CorsPolicy = &networking.CorsPolicy{
+		AllowOrigin:      origins,
+		AllowMethods:     methods,
+		AllowHeaders:     headers,
+		ExposeHeaders:    exposed,
+		MaxAge:           duration(maxAge),
+		AllowCredentials: &types.BoolValue{Value: credentials},
+	}
+	return r
+}
+
+// MatchBuilder builds a match of an HTTP route, which selects the requests meeting all its conditions.
+type MatchBuilder struct {
+	match *networking.HTTPMatchRequest
+}
+
+// Match returns the builder of a match, selecting every request until a condition is added.
+func Match() *MatchBuilder {
+	return &MatchBuilder{match: &networking.HTTPMatchRequest{}}
+}
+
+// URI selects the requests whose URI matches.
+func (m *MatchBuilder) URI(match *networking.StringMatch) *MatchBuilder {
+	m.match.Uri = match
+	return m
+}
+
+// Header selects the requests whose header matches.
+func (m *MatchBuilder) Header(name string, match *networking.StringMatch) *MatchBuilder {
+	if m.match.Headers == nil {
+		m.match.Headers = make(map[string]*networking.StringMatch)
+	}
+	m.match.Headers[name] = match
+	return m
+}
+
+// SourceLabel selects the requests from the workloads with the label.
+func (m *MatchBuilder) SourceLabel(name, value string) *MatchBuilder {
+	if m.match.SourceLabels == nil {
+		m.match.SourceLabels = make(map[string]string)
+	}
+	m.match.SourceLabels[name] = value
+	return m
+}
@@ -0,0 +1,152 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/istio/pkg/log"
+)
+
+// ChaosTarget selects the pods a Chaos deletes: the pods of the namespace whose name starts
+// with Prefix and whose labels match Selector, either of which may be empty.
+type ChaosTarget struct {
+	Name      string
+	Namespace string
+	Prefix    string
+	Selector  string
+}
+
+// PilotTarget selects the Pilot pods.
+func (e *Environment) PilotTarget() ChaosTarget {
+	return ChaosTarget{Name: "pilot", Namespace: e.Config.IstioNamespace, Prefix: "istio-pilot"}
+}
+
+// IngressTarget selects the ingress pods.
+func (e *Environment) IngressTarget() ChaosTarget {
+	return ChaosTarget{Name: "ingress", Namespace: e.Config.IstioNamespace, Prefix: "istio-ingress"}
+}
+
+// AppTarget selects the pods of the app, with their sidecar. The pod names in Apps are stale once
+// one of them is deleted, so tests sending traffic from the app must call RefreshApps.
+func (e *Environment) AppTarget(app string) ChaosTarget {
+	return ChaosTarget{Name: app, Namespace: e.Config.Namespace, Selector: "app=" + app}
+}
+
+// Chaos deletes one pod of each of its targets on a schedule, leaving their deployment to
+// replace them, until it is stopped.
+type Chaos struct {
+	env      *Environment
+	targets  []ChaosTarget
+	interval time.Duration
+
+	mu    sync.Mutex
+	kills int
+	errs  []string
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// StartChaos starts deleting a pod of every target each interval, the first ones after one interval.
+func (e *Environment) StartChaos(interval time.Duration, targets ...ChaosTarget) *Chaos {
+	c := &Chaos{
+		env:      e,
+		targets:  targets,
+		interval: interval,
+		stop:     make(chan struct{}),
+	}
+	c.done.Add(1)
+	go func() {
+		defer c.done.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C:
+				for _, target := range c.targets {
+					c.kill(target)
+				}
+			}
+		}
+	}()
+	return c
+}
+
+// Stop ends the chaos and returns the number of pods deleted, with an error if a target had
+// no pod or a deletion failed. It may only be called once.
+func (c *Chaos) Stop() (int, error) {
+	close(c.stop)
+	c.done.Wait()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.errs) > 0 {
+		return c.kills, fmt.Errorf("chaos failed: %s", strings.Join(c.errs, "; "))
+	}
+	return c.kills, nil
+}
+
+// kill deletes the oldest running pod of the target, so that the pods replaced earlier get to start.
+func (c *Chaos) kill(target ChaosTarget) {
+	_, err := c.env.KillPod(target)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		log.Warnf("Chaos: cannot delete a %s pod: %v", target.Name, err)
+		c.errs = append(c.errs, fmt.Sprintf("%s: %v", target.Name, err))
+		return
+	}
+	c.kills++
+}
+
+// KillPod deletes the oldest running pod of the target once, and returns its name. The deployment of
+// the pod replaces it.
+func (e *Environment) KillPod(target ChaosTarget) (string, error) {
+	pod, err := e.victim(target)
+	if err != nil {
+		return "", err
+	}
+	log.Infof("Chaos: deleting %s pod %s", target.Name, pod)
+	return pod, e.KubeClient.CoreV1().Pods(target.Namespace).Delete(pod, &meta_v1.DeleteOptions{})
+}
+
+func (e *Environment) victim(target ChaosTarget) (string, error) {
+	list, err := e.KubeClient.CoreV1().Pods(target.Namespace).List(meta_v1.ListOptions{LabelSelector: target.Selector})
+	if err != nil {
+		return "", err
+	}
+	var pod string
+	var oldest time.Time
+	for _, p := range list.Items {
+		if !strings.HasPrefix(p.Name, target.Prefix) || p.DeletionTimestamp != nil || p.Status.Phase != "Running" {
+			continue
+		}
+		if pod == "" || p.CreationTimestamp.Time.Before(oldest) {
+			pod, oldest = p.Name, p.CreationTimestamp.Time
+		}
+	}
+	if pod == "" {
+		return "", fmt.Errorf("no running pod in namespace %s", target.Namespace)
+	}
+	return pod, nil
+}
@@ -0,0 +1,190 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/istio/pkg/log"
+)
+
+const (
+	// delay between two checks for leftover resources
+	cleanupPollInterval = 5 * time.Second
+)
+
+const (
+	// LeakCheckOff does not look for the configs a test leaves after its teardown.
+	LeakCheckOff = "off"
+	// LeakCheckWarn logs the configs a test leaves after its teardown.
+	LeakCheckWarn = "warn"
+	// LeakCheckFail fails the test that leaves configs after its teardown.
+	LeakCheckFail = "fail"
+)
+
+// cleanupTargets records what Teardown removed, so that it can be verified afterwards.
+type cleanupTargets struct {
+	// namespaces created, and deleted, by the environment
+	namespaces []string
+	// namespace of the routing rules applied by the tests
+	configNamespace string
+	// mutating webhook configurations deleted by the environment
+	webhooks []string
+}
+
+// ConfigSnapshot is the set of the keys of the configs of an environment at some point, see
+// SnapshotConfigs.
+type ConfigSnapshot map[string]bool
+
+// SnapshotConfigs returns the configs of the namespace of the environment whose names have its prefix,
+// for CheckLeaks to compare with after the teardown of a test. It returns nil with LeakCheckOff.
+func (e *Environment) SnapshotConfigs() ConfigSnapshot {
+	if e.Config.TestLeakCheck == LeakCheckOff || e.config == nil {
+		return nil
+	}
+	snapshot, err := e.configKeys()
+	if err != nil {
+		log.Warna(err)
+		return nil
+	}
+	return snapshot
+}
+
+// CheckLeaks looks for the configs of the environment that were not in the snapshot taken before the
+// setup of the test, and so were leaked by its teardown. Leaked configs change the routing of the
+// tests that come after, so they are logged, or returned as an error with LeakCheckFail.
+func (e *Environment) CheckLeaks(before ConfigSnapshot, test string) error {
+	if before == nil {
+		return nil
+	}
+	after, err := e.configKeys()
+	if err != nil {
+		log.Warna(err)
+		return nil
+	}
+	var leaked []string
+	for key := range after {
+		if !before[key] {
+			leaked = append(leaked, key)
+		}
+	}
+	if len(leaked) == 0 {
+		return nil
+	}
+	sort.Strings(leaked)
+	err = fmt.Errorf("configs left after the teardown of %s: %s", test, strings.Join(leaked, ", "))
+	if e.Config.TestLeakCheck == LeakCheckFail {
+		return err
+	}
+	log.Warna(err)
+	return nil
+}
+
+// configKeys returns the keys of the configs of the namespace of the environment whose names have
+// its prefix.
+func (e *Environment) configKeys() (ConfigSnapshot, error) {
+	keys := make(ConfigSnapshot)
+	for _, desc := range e.config.ConfigDescriptor() {
+		configs, err := e.config.List(desc.Type, e.Config.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		for _, config := range configs {
+			if strings.HasPrefix(config.Name, e.configPrefix) {
+				keys[config.Key()] = true
+			}
+		}
+	}
+	return keys, nil
+}
+
+// VerifyCleanup waits for the resources removed by Teardown to be gone, and returns an error
+// listing the ones that are still around after the timeout. It does nothing if Teardown
+// kept the resources, as with SkipCleanup.
+func (e *Environment) VerifyCleanup(timeout time.Duration) error {
+	// nothing was created under -dry-run
+	if e.cleanup == nil || e.Config.DryRun {
+		return nil
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		leftovers := e.leftovers()
+		if len(leftovers) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("resources left after teardown for %v:\n%s", timeout, strings.Join(leftovers, "\n"))
+		}
+		log.Infof("waiting for %d resources to be deleted", len(leftovers))
+		time.Sleep(cleanupPollInterval)
+	}
+}
+
+// leftovers lists the removed namespaces, their deployments and services, the webhooks and the routing
+// rules that still exist.
+func (e *Environment) leftovers() []string {
+	var out []string
+	for _, ns := range e.cleanup.namespaces {
+		namespace, err := e.KubeClient.CoreV1().Namespaces().Get(ns, meta_v1.GetOptions{})
+		if err != nil {
+			// gone, or not reachable, in which case there is nothing more to learn from it
+			continue
+		}
+		out = append(out, fmt.Sprintf("namespace %s (%s)", ns, namespace.Status.Phase))
+		deployments, err := e.KubeClient.ExtensionsV1beta1().Deployments(ns).List(meta_v1.ListOptions{})
+		if err != nil {
+			log.Warna(err)
+			continue
+		}
+		for _, deployment := range deployments.Items {
+			out = append(out, fmt.Sprintf("deployment %s/%s", ns, deployment.Name))
+		}
+		services, err := e.KubeClient.CoreV1().Services(ns).List(meta_v1.ListOptions{})
+		if err != nil {
+			log.Warna(err)
+			continue
+		}
+		for _, service := range services.Items {
+			out = append(out, fmt.Sprintf("service %s/%s", ns, service.Name))
+		}
+	}
+	for _, name := range e.cleanup.webhooks {
+		webhooks := e.KubeClient.AdmissionregistrationV1beta1().MutatingWebhookConfigurations()
+		if _, err := webhooks.Get(name, meta_v1.GetOptions{}); err == nil {
+			out = append(out, "mutating webhook configuration "+name)
+		}
+	}
+
+	if e.config == nil {
+		return out
+	}
+	for _, desc := range e.config.ConfigDescriptor() {
+		configs, err := e.config.List(desc.Type, e.cleanup.configNamespace)
+		if err != nil {
+			log.Warna(err)
+			continue
+		}
+		for _, config := range configs {
+			out = append(out, "config "+config.Key())
+		}
+	}
+	return out
+}
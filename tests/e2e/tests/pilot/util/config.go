@@ -16,6 +16,9 @@ package util
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"istio.io/istio/pilot/pkg/serviceregistry"
 )
@@ -25,38 +28,162 @@ const (
 	defaultRegistry             = string(serviceregistry.KubernetesRegistry)
 	defaultAdmissionServiceName = "istio-pilot"
 	defaultVerbosity            = 2
+	defaultManyRoutes           = 500
+	defaultPropagationRounds    = 5
+	defaultRateLimitRequests    = 5
+	defaultRateLimitWindow      = 10 * time.Second
+	defaultBenchmarkFile        = "pilot-benchmark.json"
+	defaultCleanupTimeout       = 2 * time.Minute
+	defaultDrainWait            = 10 * time.Second
+	defaultRequestTimeout       = time.Second
+	defaultRequestSleep         = 3 * time.Second
+	defaultSoakWindow           = time.Minute
+	defaultResilienceDuration   = time.Minute
+	defaultResilienceRatio      = 0.95
+	defaultTraceSampling        = 1.0
+	defaultWeightTolerance      = 0.05
+	defaultScalePushCeiling     = 30 * time.Second
+	defaultEndpointUpdateSLO    = 10 * time.Second
+	defaultConfigSyncTimeout    = 2 * time.Minute
+	defaultDrainBlipBudget      = 2 * time.Second
+	defaultScaleMemoryCeiling   = 256
+	defaultResourceInterval     = 10 * time.Second
+	defaultLockTTL              = 10 * time.Minute
+	defaultLoadQPS              = 50
+	defaultLoadDuration         = 30 * time.Second
+	defaultLoadP50              = 50 * time.Millisecond
+	defaultLoadP99              = 500 * time.Millisecond
+	defaultLoadErrorRate        = 0.01
+	defaultHelmChart            = "../../../../install/kubernetes/helm/istio"
+	defaultTestDataDir          = "testdata/"
+	defaultCertDir              = "../../../../pilot/docker/certs/"
+
+	// TraceBackendZipkin collects the traces of the proxies with Zipkin
+	TraceBackendZipkin = "zipkin"
+	// TraceBackendJaeger collects them with Jaeger, which takes the Zipkin spans of the proxies
+	TraceBackendJaeger = "jaeger"
+
+	// InstallMethodYAML installs the control plane from the templates of testdata
+	InstallMethodYAML = "yaml"
+	// InstallMethodHelm installs it from the Istio Helm chart, rendered with the values of the run
+	InstallMethodHelm = "helm"
 )
 
 // Config defines the configuration for the test environment.
 type Config struct {
 	KubeConfig            string
+	RemoteKubeConfig      string
 	Hub                   string
+	AppHub                string
 	Tag                   string
+	AppTag                string
+	SidecarUpgradeTag     string
+	BaseTag               string
+	Downgrade             bool
 	Namespace             string
 	IstioNamespace        string
+	NamespacePool         string
 	Registry              string
 	ErrorLogsDir          string
+	StreamLogsDir         string
 	CoreFilesDir          string
 	SelectedTest          string
+	TestFilter            string
+	Labels                string
 	SidecarTemplate       string
+	IstioManifest         string
+	ExtraManifests        string
+	InstallMethod         string
+	HelmChart             string
+	TestDataDir           string
+	CertDir               string
+	PprofDir              string
+	BenchmarkFile         string
+	JSONLOutput           string
+	ReportDir             string
+	ArtifactsDir          string
+	AppliedYAMLDir        string
+	StateFile             string
+	RunID                 string
+	CleanupRun            string
+	CleanupTest           string
+	CoverageDir           string
 	AdmissionServiceName  string
+	ImagePullPolicy       string
+	ImagePullSecret       string
+	IPFamily              string
+	TraceBackend          string
+	TestLeakCheck         string
 	Verbosity             int
 	DebugPort             int
 	TestCount             int
+	Retries               int
+	Parallel              int
+	ManyRoutes            int
+	ScaleServices         int
+	ScaleMemoryCeiling    int
+	ProxyCPUCeiling       int
+	ProxyMemoryCeiling    int
+	LoadQPS               int
+	PropagationRounds     int
+	RateLimitRequests     int
+	WeightSamples         int
+	RateLimitWindow       time.Duration
+	SuiteDeadline         time.Duration
+	PprofInterval         time.Duration
+	TestDeadline          time.Duration
+	SetupDeadline         time.Duration
+	RunDeadline           time.Duration
+	TeardownDeadline      time.Duration
+	CleanupTimeout        time.Duration
+	DrainWait             time.Duration
+	ScalePushCeiling      time.Duration
+	EndpointUpdateSLO     time.Duration
+	ConfigSyncTimeout     time.Duration
+	DrainBlipBudget       time.Duration
+	ResourceInterval      time.Duration
+	LockTTL               time.Duration
+	LoadDuration          time.Duration
+	LoadP50               time.Duration
+	LoadP99               time.Duration
+	RequestTimeout        time.Duration
+	RequestSleep          time.Duration
+	ResilienceDuration    time.Duration
+	SoakDuration          time.Duration
+	SoakWindow            time.Duration
+	ResilienceRatio       float64
+	TraceSampling         float64
+	WeightTolerance       float64
+	LoadErrorRate         float64
 	Auth                  bool
 	Mixer                 bool
 	Ingress               bool
 	Zipkin                bool
+	Prometheus            bool
 	SkipCleanup           bool
 	SkipCleanupOnFailure  bool
 	CheckLogs             bool
 	DebugImagesAndMode    bool
 	UseAutomaticInjection bool
+	UseExistingIstio      bool
 	V1alpha1              bool
 	V1alpha2              bool
+	RoutingParity         bool
 	RDSv2                 bool
 	NoRBAC                bool
+	Benchmark             bool
+	FailOnSkip            bool
+	VerifyCleanup         bool
 	UseAdmissionWebhook   bool
+	Golden                bool
+	UpdateGolden          bool
+	Resume                bool
+	DryRun                bool
+	PauseOnFailure        bool
+	CapturePcap           bool
+	PortForward           bool
+	ResourceWarnOnly      bool
+	ForceLock             bool
 	APIVersions           []string
 }
 
@@ -74,6 +201,12 @@ func NewConfig() *Config {
 		Mixer:                 true,
 		Ingress:               true,
 		Zipkin:                true,
+		TraceBackend:          TraceBackendZipkin,
+		InstallMethod:         InstallMethodYAML,
+		HelmChart:             defaultHelmChart,
+		TestDataDir:           defaultTestDataDir,
+		CertDir:               defaultCertDir,
+		TestLeakCheck:         LeakCheckWarn,
 		DebugPort:             0,
 		SkipCleanup:           false,
 		SkipCleanupOnFailure:  false,
@@ -81,6 +214,27 @@ func NewConfig() *Config {
 		ErrorLogsDir:          "",
 		CoreFilesDir:          "",
 		TestCount:             1,
+		Parallel:              1,
+		ManyRoutes:            defaultManyRoutes,
+		ScalePushCeiling:      defaultScalePushCeiling,
+		EndpointUpdateSLO:     defaultEndpointUpdateSLO,
+		ConfigSyncTimeout:     defaultConfigSyncTimeout,
+		DrainBlipBudget:       defaultDrainBlipBudget,
+		ScaleMemoryCeiling:    defaultScaleMemoryCeiling,
+		ResourceInterval:      defaultResourceInterval,
+		LockTTL:               defaultLockTTL,
+		LoadQPS:               defaultLoadQPS,
+		LoadDuration:          defaultLoadDuration,
+		LoadP50:               defaultLoadP50,
+		LoadP99:               defaultLoadP99,
+		LoadErrorRate:         defaultLoadErrorRate,
+		PropagationRounds:     defaultPropagationRounds,
+		RateLimitRequests:     defaultRateLimitRequests,
+		RateLimitWindow:       defaultRateLimitWindow,
+		SoakWindow:            defaultSoakWindow,
+		ResilienceDuration:    defaultResilienceDuration,
+		ResilienceRatio:       defaultResilienceRatio,
+		TraceSampling:         defaultTraceSampling,
 		SelectedTest:          "",
 		DebugImagesAndMode:    true,
 		UseAutomaticInjection: false,
@@ -88,5 +242,55 @@ func NewConfig() *Config {
 		AdmissionServiceName:  defaultAdmissionServiceName,
 		V1alpha1:              false,
 		V1alpha2:              true,
+		BenchmarkFile:         defaultBenchmarkFile,
+		StateFile:             filepath.Join(os.TempDir(), "pilot-e2e-state.json"),
+		CleanupTimeout:        defaultCleanupTimeout,
+		DrainWait:             defaultDrainWait,
+		RequestTimeout:        defaultRequestTimeout,
+		RequestSleep:          defaultRequestSleep,
+		IPFamily:              IPFamilyIPv4,
+		WeightTolerance:       defaultWeightTolerance,
+	}
+}
+
+// AppImageHub returns the hub of the test app images, AppHub if set and Hub otherwise.
+func (c *Config) AppImageHub() string {
+	if c.AppHub != "" {
+		return c.AppHub
+	}
+	return c.Hub
+}
+
+// AppImageTag returns the tag of the test app images, AppTag if set and Tag otherwise.
+func (c *Config) AppImageTag() string {
+	if c.AppTag != "" {
+		return c.AppTag
+	}
+	return c.Tag
+}
+
+// InstallTag returns the tag of the images of the control plane and of the sidecars installed by Setup,
+// BaseTag until the upgrade test moves them to Tag.
+func (c *Config) InstallTag() string {
+	if c.BaseTag != "" {
+		return c.BaseTag
+	}
+	return c.Tag
+}
+
+// Selection returns the tests selected by SelectedTest, a comma-separated list of test names,
+// TestFilter and Labels, a comma-separated list of labels.
+func (c *Config) Selection() Selection {
+	return Selection{Tests: splitList(c.SelectedTest), Filter: c.TestFilter, Labels: splitList(c.Labels)}
+}
+
+// splitList returns the non-empty trimmed items of the comma-separated list.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
+	return items
 }
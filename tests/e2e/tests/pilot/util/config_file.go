@@ -0,0 +1,133 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// LoadConfigFile sets the flags of the set from a YAML file mapping flag names to values, such as
+//
+//	hub: gcr.io/istio-testing
+//	auth: enable
+//	skip-cleanup: true
+//	labels: [routing, "!slow"]
+//
+// so that the flags of an environment can be checked in as a profile. The flags already set, on the
+// command line or by LoadConfigEnv, keep their value, the file only filling in the others. Lists are
+// joined with commas. It must be called after the flags are parsed.
+func LoadConfigFile(path string, flags *flag.FlagSet) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var values map[string]interface{}
+	if err = yaml.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("cannot parse config file %s: %v", path, err)
+	}
+
+	passed := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		passed[f.Name] = true
+	})
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if flags.Lookup(name) == nil {
+			return fmt.Errorf("config file %s sets the unknown flag %q", path, name)
+		}
+		if passed[name] {
+			continue
+		}
+		value, convErr := flagValue(values[name])
+		if convErr != nil {
+			return fmt.Errorf("config file %s: flag %q: %v", path, name, convErr)
+		}
+		if err = flags.Set(name, value); err != nil {
+			return fmt.Errorf("config file %s: flag %q: %v", path, name, err)
+		}
+	}
+	return nil
+}
+
+// configEnvPrefix prefixes the environment variables of LoadConfigEnv.
+const configEnvPrefix = "E2E_"
+
+// LoadConfigEnv sets the flags of the set that were not passed on the command line from the
+// environment variables named after them, E2E_ followed by the flag name in upper case with dashes
+// as underscores, such as E2E_HUB for -hub, E2E_NS for -ns or E2E_SKIP_CLEANUP for -skip-cleanup,
+// so that a CI job can configure a run without rewriting its go test arguments. It must be called
+// after the flags are parsed and before LoadConfigFile, so that a value is taken from the command
+// line first, then from the environment, then from the config file.
+func LoadConfigEnv(flags *flag.FlagSet) error {
+	passed := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		passed[f.Name] = true
+	})
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		name := configEnvName(f.Name)
+		value, ok := os.LookupEnv(name)
+		if !ok || passed[f.Name] || err != nil {
+			return
+		}
+		if setErr := flags.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("environment variable %s: %v", name, setErr)
+		}
+	})
+	return err
+}
+
+// configEnvName returns the environment variable of LoadConfigEnv for the flag.
+func configEnvName(flagName string) string {
+	return configEnvPrefix + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// flagValue returns the command line form of a YAML value.
+func flagValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		// YAML numbers come as float64, integers included
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := flagValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	case nil:
+		return "", nil
+	}
+	return "", fmt.Errorf("unsupported value %v", value)
+}
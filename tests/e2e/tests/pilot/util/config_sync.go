@@ -0,0 +1,228 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	multierror "github.com/hashicorp/go-multierror"
+	"k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/istio/pilot/pkg/kube/inject"
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pkg/log"
+)
+
+const (
+	// delay between two checks of the environment while waiting for it to be in sync
+	configSyncPollInterval = 2 * time.Second
+	// annotation of the Ingresses naming the class of the controller programming them
+	ingressClassAnnotation = "kubernetes.io/ingress.class"
+	// service cluster of the ingress proxy, which keeps the default of the proxy agent
+	ingressServiceCluster = "istio-proxy"
+)
+
+// WaitForConfigSync waits until the environment is in sync, or returns the reasons it is not after the
+// timeout:
+//   - the pods of the app namespace are all ready, leaving out the terminating ones;
+//   - the sidecar of each of them, and the ingress proxies with Ingress, have every cluster and every
+//     listener Pilot serves them: the proxies poll Pilot rather than acknowledge a version, so having
+//     what Pilot currently serves is the closest to it;
+//   - the Ingresses of the Istio class in the app namespace have an address.
+func (e *Environment) WaitForConfigSync(timeout time.Duration) error {
+	if e.Config.DryRun {
+		return nil
+	}
+	start := time.Now()
+	deadline := start.Add(timeout)
+	for {
+		err := e.checkConfigSync()
+		if err == nil {
+			log.Infof("Environment in sync after %v", time.Since(start))
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("environment not in sync after %v: %v", timeout, err)
+		}
+		log.Infof("Waiting for the environment to be in sync: %v", err)
+		time.Sleep(configSyncPollInterval)
+	}
+}
+
+// checkConfigSync returns the reasons the environment is not in sync, nil if it is.
+func (e *Environment) checkConfigSync() error {
+	pods, err := e.KubeClient.CoreV1().Pods(e.Config.Namespace).List(meta_v1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	var errs error
+	var proxies []v1.Pod
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp != nil || pod.Status.Phase == v1.PodSucceeded {
+			continue
+		}
+		if !podReady(&pod) {
+			errs = multierror.Append(errs, fmt.Errorf("pod %s is not ready", pod.Name))
+		} else if hasContainer(&pod, inject.ProxyContainerName) {
+			proxies = append(proxies, pod)
+		}
+	}
+	// the sync of the proxies is only worth checking once they all run
+	if errs != nil {
+		return errs
+	}
+	for i := range proxies {
+		cluster := proxies[i].Labels["app"]
+		if err = e.checkProxySync(&proxies[i], model.Sidecar, cluster); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	if e.Config.Ingress {
+		if err = e.checkIngressSync(); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	return errs
+}
+
+// checkIngressSync returns an error if an ingress proxy is not ready or not in sync, or if an Ingress of
+// the Istio class in the app namespace has no address yet.
+func (e *Environment) checkIngressSync() error {
+	pods, err := e.KubeClient.CoreV1().Pods(e.Config.IstioNamespace).List(meta_v1.ListOptions{
+		LabelSelector: "app=ingress",
+	})
+	if err != nil {
+		return err
+	}
+	var errs error
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		if !podReady(pod) {
+			errs = multierror.Append(errs, fmt.Errorf("ingress pod %s is not ready", pod.Name))
+		} else if err = e.checkProxySync(pod, model.Ingress, ingressServiceCluster); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+
+	ingresses, err := e.KubeClient.ExtensionsV1beta1().Ingresses(e.Config.Namespace).List(meta_v1.ListOptions{})
+	if err != nil {
+		return multierror.Append(errs, err)
+	}
+	for _, ingress := range ingresses.Items {
+		if ingress.Annotations[ingressClassAnnotation] != e.Config.IstioNamespace {
+			continue
+		}
+		addressed := false
+		for _, status := range ingress.Status.LoadBalancer.Ingress {
+			addressed = addressed || status.IP != "" || status.Hostname != ""
+		}
+		if !addressed {
+			errs = multierror.Append(errs, fmt.Errorf("ingress %s has no address", ingress.Name))
+		}
+	}
+	return errs
+}
+
+// checkProxySync returns an error listing the clusters and the listeners Pilot serves to the proxy of
+// the pod that the proxy does not have.
+func (e *Environment) checkProxySync(pod *v1.Pod, nodeType model.NodeType, serviceCluster string) error {
+	node := serviceNode(pod, nodeType)
+	clusters, err := e.pilotClusters(serviceCluster, node)
+	if err != nil {
+		return fmt.Errorf("%s: %v", pod.Name, err)
+	}
+	listeners, err := e.pilotListeners(serviceCluster, node)
+	if err != nil {
+		return fmt.Errorf("%s: %v", pod.Name, err)
+	}
+
+	out, err := e.proxyAdmin(pod.Namespace, pod.Name, "/clusters")
+	if err != nil {
+		return fmt.Errorf("%s: %v", pod.Name, err)
+	}
+	// each line of the output starts with the name of a cluster
+	have := make(map[string]bool)
+	for _, line := range strings.Split(out, "\n") {
+		have[strings.SplitN(line, "::", 2)[0]] = true
+	}
+	var missing []string
+	for name := range clusters {
+		if !have[name] {
+			missing = append(missing, "cluster "+name)
+		}
+	}
+
+	out, err = e.proxyAdmin(pod.Namespace, pod.Name, "/listeners")
+	if err != nil {
+		return fmt.Errorf("%s: %v", pod.Name, err)
+	}
+	var addresses []string
+	if err = json.Unmarshal([]byte(out), &addresses); err != nil {
+		return fmt.Errorf("cannot parse the listeners of %s: %v", pod.Name, err)
+	}
+	for _, address := range listeners {
+		if !containsString(addresses, address) {
+			missing = append(missing, "listener "+address)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("the proxy of %s is missing %s", pod.Name, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// pilotListeners returns the addresses of the listeners Pilot serves to the proxy of the service cluster
+// and node, as host:port like the admin API of Envoy lists them.
+func (e *Environment) pilotListeners(serviceCluster, node string) ([]string, error) {
+	out, err := e.PilotDebug(fmt.Sprintf("/v1/listeners/%s/%s", serviceCluster, node))
+	if err != nil {
+		return nil, err
+	}
+	var lds struct {
+		Listeners []struct {
+			Address string `json:"address"`
+		} `json:"listeners"`
+	}
+	if err = json.Unmarshal([]byte(out), &lds); err != nil {
+		return nil, fmt.Errorf("cannot parse the pilot listeners of %s: %v", serviceCluster, err)
+	}
+	addresses := make([]string, 0, len(lds.Listeners))
+	for _, listener := range lds.Listeners {
+		addresses = append(addresses, strings.TrimPrefix(listener.Address, "tcp://"))
+	}
+	return addresses, nil
+}
+
+// podReady returns true if the pod runs and all its containers are ready.
+func podReady(pod *v1.Pod) bool {
+	if pod.Status.Phase != v1.PodRunning {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
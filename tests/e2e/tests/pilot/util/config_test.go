@@ -0,0 +1,161 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newConfigFlags returns a flag set with the kinds of flags the config is loaded into.
+func newConfigFlags() *flag.FlagSet {
+	flags := flag.NewFlagSet("e2e", flag.ContinueOnError)
+	flags.String("hub", "default-hub", "")
+	flags.String("ns", "", "")
+	flags.Bool("skip-cleanup", false, "")
+	flags.Int("retries", 1, "")
+	flags.String("labels", "", "")
+	return flags
+}
+
+func TestLoadConfig(t *testing.T) {
+	cases := []struct {
+		name string
+		args []string
+		env  map[string]string
+		file string
+		want map[string]string
+		err  string
+	}{
+		{
+			name: "keeps the defaults",
+			want: map[string]string{"hub": "default-hub", "ns": "", "skip-cleanup": "false", "retries": "1"},
+		},
+		{
+			name: "takes the file",
+			file: "hub: file-hub\nns: file-ns\nskip-cleanup: true\nretries: 3\nlabels: [routing, \"!slow\"]\n",
+			want: map[string]string{
+				"hub": "file-hub", "ns": "file-ns", "skip-cleanup": "true", "retries": "3", "labels": "routing,!slow",
+			},
+		},
+		{
+			name: "takes the environment",
+			env:  map[string]string{"E2E_HUB": "env-hub", "E2E_SKIP_CLEANUP": "true"},
+			want: map[string]string{"hub": "env-hub", "ns": "", "skip-cleanup": "true"},
+		},
+		{
+			name: "prefers the environment to the file",
+			env:  map[string]string{"E2E_HUB": "env-hub"},
+			file: "hub: file-hub\nns: file-ns\n",
+			want: map[string]string{"hub": "env-hub", "ns": "file-ns"},
+		},
+		{
+			name: "prefers the flags to the environment and the file",
+			args: []string{"-hub", "flag-hub", "-retries", "2"},
+			env:  map[string]string{"E2E_HUB": "env-hub", "E2E_NS": "env-ns", "E2E_RETRIES": "4"},
+			file: "hub: file-hub\nns: file-ns\nretries: 5\nskip-cleanup: true\n",
+			want: map[string]string{"hub": "flag-hub", "ns": "env-ns", "retries": "2", "skip-cleanup": "true"},
+		},
+		{
+			name: "prefers a flag set to its default to the environment and the file",
+			args: []string{"-hub", "default-hub"},
+			env:  map[string]string{"E2E_HUB": "env-hub"},
+			file: "hub: file-hub\n",
+			want: map[string]string{"hub": "default-hub"},
+		},
+		{
+			name: "fails on an unknown flag in the file",
+			file: "tag: latest\n",
+			err:  `sets the unknown flag "tag"`,
+		},
+		{
+			name: "fails on an invalid value in the file",
+			file: "retries: many\n",
+			err:  `flag "retries"`,
+		},
+		{
+			name: "fails on an invalid value in the environment",
+			env:  map[string]string{"E2E_RETRIES": "many"},
+			err:  "environment variable E2E_RETRIES",
+		},
+		{
+			name: "fails on an unparsable file",
+			file: "hub: [\n",
+			err:  "cannot parse config file",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for name, value := range c.env {
+				if err := os.Setenv(name, value); err != nil {
+					t.Fatal(err)
+				}
+				defer os.Unsetenv(name) // nolint: errcheck
+			}
+			flags := newConfigFlags()
+			if err := flags.Parse(c.args); err != nil {
+				t.Fatal(err)
+			}
+			err := LoadConfigEnv(flags)
+			if err == nil && c.file != "" {
+				dir, tmpErr := ioutil.TempDir("", "e2e-config")
+				if tmpErr != nil {
+					t.Fatal(tmpErr)
+				}
+				defer os.RemoveAll(dir) // nolint: errcheck
+				path := filepath.Join(dir, "config.yaml")
+				if tmpErr = ioutil.WriteFile(path, []byte(c.file), 0644); tmpErr != nil {
+					t.Fatal(tmpErr)
+				}
+				err = LoadConfigFile(path, flags)
+			}
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Fatalf("got error %v, want %q", err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			got := make(map[string]string, len(c.want))
+			for name := range c.want {
+				got[name] = flags.Lookup(name).Value.String()
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got flags %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestConfigEnvName(t *testing.T) {
+	cases := map[string]string{
+		"hub":                "E2E_HUB",
+		"ns":                 "E2E_NS",
+		"skip-cleanup":       "E2E_SKIP_CLEANUP",
+		"use-existing-istio": "E2E_USE_EXISTING_ISTIO",
+	}
+	for flagName, want := range cases {
+		if got := configEnvName(flagName); got != want {
+			t.Errorf("configEnvName(%q) got %q, want %q", flagName, got, want)
+		}
+	}
+}
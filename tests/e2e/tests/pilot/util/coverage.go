@@ -0,0 +1,166 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/istio/pilot/pkg/kube/inject"
+	"istio.io/istio/pkg/log"
+)
+
+const (
+	// volume of the pilot pod shared by the discovery container and its sidecar, see pilot.yaml.tmpl
+	coverageFlushFile = "/var/istio-coverage/flush"
+	coverageProfile   = "/var/istio-coverage/pilot-discovery.out"
+	// seconds to wait for the discovery container to write its profile once flushed
+	coverageFlushBudget = 60
+	// file of CoverageDir the profiles of the pods are merged into
+	mergedCoverageProfile = "coverage.out"
+)
+
+// CollectCoverage makes the discovery containers of the Pilot pods, running the binary instrumented
+// by make pilot-discovery-coverage, write their coverage profile, and copies it to
+// CoverageDir/<auth mode>-<pod>.out. The containers restart afterwards. Failures are only logged.
+func (e *Environment) CollectCoverage() {
+	if e.Config.CoverageDir == "" || e.KubeClient == nil {
+		return
+	}
+	if err := os.MkdirAll(e.Config.CoverageDir, 0755); err != nil {
+		log.Warna(err)
+		return
+	}
+	pods, err := e.KubeClient.CoreV1().Pods(e.Config.IstioNamespace).
+		List(meta_v1.ListOptions{LabelSelector: "infra=pilot"})
+	if err != nil {
+		log.Warnf("Cannot list the pilot pods to collect the coverage of: %v", err)
+		return
+	}
+	mode := "noauth"
+	if e.Config.Auth {
+		mode = "auth"
+	}
+	for _, pod := range pods.Items {
+		profile, flushErr := e.flushCoverage(pod.Name)
+		if flushErr != nil {
+			log.Warnf("Cannot collect the coverage of %s: %v", pod.Name, flushErr)
+			continue
+		}
+		file := filepath.Join(e.Config.CoverageDir, fmt.Sprintf("%s-%s.out", mode, pod.Name))
+		if err = ioutil.WriteFile(file, profile, 0644); err != nil {
+			log.Warna(err)
+			continue
+		}
+		log.Infof("Wrote the coverage profile of %s to %s", pod.Name, file)
+	}
+}
+
+// flushCoverage creates the flush file and waits for the discovery container of the pod to write its
+// profile, both through the sidecar of the pod sharing the volume.
+func (e *Environment) flushCoverage(pod string) ([]byte, error) {
+	run := func(command ...string) ([]byte, error) {
+		args := append([]string{"exec", pod, "--kubeconfig", e.Config.KubeConfig, "-n", e.Config.IstioNamespace,
+			"-c", inject.ProxyContainerName, "--"}, command...)
+		return exec.Command("kubectl", args...).Output() // #nosec
+	}
+	// a profile of a previous flush would be taken for this one
+	if _, err := run("rm", "-f", coverageProfile); err != nil {
+		return nil, err
+	}
+	if _, err := run("touch", coverageFlushFile); err != nil {
+		return nil, err
+	}
+	for i := 0; i < coverageFlushBudget; i++ {
+		time.Sleep(time.Second)
+		// the flush file is removed before the profile is written
+		if _, err := run("test", "-e", coverageFlushFile); err == nil {
+			continue
+		}
+		if profile, err := run("cat", coverageProfile); err == nil && len(profile) > 0 {
+			return profile, nil
+		}
+	}
+	return nil, fmt.Errorf("no coverage profile written in %ds", coverageFlushBudget)
+}
+
+// MergeCoverage merges the profiles CollectCoverage wrote to the directory into coverage.out,
+// adding up the counts of the blocks, for go tool cover to report on the coverage of the whole run.
+func MergeCoverage(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.out"))
+	if err != nil {
+		return err
+	}
+	mode := ""
+	merged := 0
+	counts := make(map[string]int)
+	for _, file := range files {
+		if filepath.Base(file) == mergedCoverageProfile {
+			continue
+		}
+		merged++
+		data, readErr := ioutil.ReadFile(file)
+		if readErr != nil {
+			return readErr
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if strings.HasPrefix(line, "mode: ") {
+				mode = line
+				continue
+			}
+			// file:startline.col,endline.col statements count
+			i := strings.LastIndex(line, " ")
+			if i < 0 {
+				continue
+			}
+			count, atoiErr := strconv.Atoi(line[i+1:])
+			if atoiErr != nil {
+				return fmt.Errorf("malformed line of %s: %q", file, line)
+			}
+			if mode == "mode: set" && count > 0 {
+				counts[line[:i]] = 1
+			} else {
+				counts[line[:i]] += count
+			}
+		}
+	}
+	if mode == "" {
+		return fmt.Errorf("no coverage profile in %s", dir)
+	}
+	blocks := make([]string, 0, len(counts))
+	for block := range counts {
+		blocks = append(blocks, block)
+	}
+	sort.Strings(blocks)
+	lines := []string{mode}
+	for _, block := range blocks {
+		lines = append(lines, fmt.Sprintf("%s %d", block, counts[block]))
+	}
+	out := filepath.Join(dir, mergedCoverageProfile)
+	if err = ioutil.WriteFile(out, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	log.Infof("Merged %d coverage profiles into %s", merged, out)
+	return nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DeadlineError is returned by RunWithDeadline when the function outlived its deadline, such as a
+// setup stuck waiting on kubectl.
+type DeadlineError struct {
+	// What timed out, such as "setup of routing"
+	What     string
+	Deadline time.Duration
+}
+
+func (e *DeadlineError) Error() string {
+	return fmt.Sprintf("%s exceeded its deadline of %v", e.What, e.Deadline)
+}
+
+// IsDeadline returns whether err is a DeadlineError.
+func IsDeadline(err error) bool {
+	_, ok := err.(*DeadlineError)
+	return ok
+}
+
+// RunWithDeadline runs f and returns its error, or a DeadlineError once the deadline is over, and the
+// error of ctx if it is done first. A zero deadline only waits for ctx. The function cannot be stopped
+// and keeps running after it is given up on, so the state it leaves must be torn down by the caller.
+func RunWithDeadline(ctx context.Context, what string, deadline time.Duration, f func() error) error {
+	phaseCtx := ctx
+	if deadline > 0 {
+		var cancel context.CancelFunc
+		phaseCtx, cancel = context.WithTimeout(ctx, deadline)
+		defer cancel()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-phaseCtx.Done():
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return &DeadlineError{What: what, Deadline: deadline}
+	}
+}
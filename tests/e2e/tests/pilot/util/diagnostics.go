@@ -0,0 +1,166 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime/pprof"
+	"strings"
+
+	"istio.io/istio/pilot/pkg/inject"
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pilot/test/util"
+	"istio.io/istio/pkg/log"
+)
+
+// serialNumberRegex matches the serial numbers of the certificates in the /certs output of Envoy, in
+// its text and JSON formats.
+var serialNumberRegex = regexp.MustCompile(`[Ss]erial[ _][Nn]umber"?:\s*"?([0-9a-fA-F]+)`)
+
+// proxyDiagnostics are the paths of the Envoy admin API dumped by DumpProxyDiagnostics,
+// with the file each of them is written to.
+var proxyDiagnostics = []struct {
+	path string
+	file string
+}{
+	{"/config_dump", "config_dump.json"},
+	{"/clusters", "clusters.txt"},
+	{"/stats", "stats.txt"},
+}
+
+// DumpProxyDiagnostics writes the configuration, clusters and stats of the sidecar of every pod in
+// the app namespace to ErrorLogsDir/<name>/<pod>/, so that a failure can be debugged from the
+// logs directory alone. It does nothing if ErrorLogsDir is not set, and skips the pods without a
+// sidecar. Failures are only logged.
+func (e *Environment) DumpProxyDiagnostics(name string) {
+	if e.Config.ErrorLogsDir == "" || e.KubeClient == nil || e.meshConfig == nil {
+		return
+	}
+	for _, pod := range util.GetPods(e.KubeClient, e.Config.Namespace) {
+		dir := filepath.Join(e.Config.ErrorLogsDir, name, pod)
+		for _, diagnostic := range proxyDiagnostics {
+			out, err := e.PodProxyAdmin(pod, diagnostic.path)
+			if err != nil {
+				// pods without a sidecar have no admin port
+				log.Infof("Cannot read %s from the sidecar of %s: %v", diagnostic.path, pod, err)
+				break
+			}
+			if err = os.MkdirAll(dir, 0755); err != nil {
+				log.Warna(err)
+				return
+			}
+			if err = ioutil.WriteFile(filepath.Join(dir, diagnostic.file), []byte(out), 0644); err != nil {
+				log.Warna(err)
+			}
+		}
+	}
+	log.Infof("Sidecar diagnostics written to %s", filepath.Join(e.Config.ErrorLogsDir, name))
+}
+
+// DumpGoroutines writes the stacks of the goroutines of the harness to ErrorLogsDir/<name>/goroutines.txt,
+// showing where a test that exceeded its deadline is stuck. It does nothing if ErrorLogsDir is not set.
+func (e *Environment) DumpGoroutines(name string) {
+	if e.Config.ErrorLogsDir == "" {
+		return
+	}
+	dir := filepath.Join(e.Config.ErrorLogsDir, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Warna(err)
+		return
+	}
+	f, err := os.Create(filepath.Join(dir, "goroutines.txt"))
+	if err != nil {
+		log.Warna(err)
+		return
+	}
+	defer func() { _ = f.Close() }()
+	if err = pprof.Lookup("goroutine").WriteTo(f, 2); err != nil {
+		log.Warna(err)
+	}
+}
+
+// PodProxyMemory returns the bytes of memory allocated by the sidecar of the given pod, from the
+// server.memory_allocated gauge of its admin port.
+func (e *Environment) PodProxyMemory(pod string) (int, error) {
+	stats, err := e.PodProxyStats(pod)
+	if err != nil {
+		return 0, err
+	}
+	allocated, ok := stats["server.memory_allocated"]
+	if !ok {
+		return 0, fmt.Errorf("the sidecar of %s has no server.memory_allocated stat", pod)
+	}
+	return allocated, nil
+}
+
+// PodProxyCert returns the workload certificate of the sidecar of the given pod, the way a TLS client
+// would check it: the leaf of the mounted chain must be signed by the mounted root, and be the one
+// Envoy serves, as listed by its /certs admin endpoint.
+func (e *Environment) PodProxyCert(pod string) (*x509.Certificate, error) {
+	chain, err := e.podProxyFile(pod, model.AuthCertsPath+model.CertChainFilename)
+	if err != nil {
+		return nil, err
+	}
+	root, err := e.podProxyFile(pod, model.AuthCertsPath+model.RootCertFilename)
+	if err != nil {
+		return nil, err
+	}
+	block, rest := pem.Decode([]byte(chain))
+	if block == nil {
+		return nil, fmt.Errorf("no certificate in the chain of the sidecar of %s", pod)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(root)) {
+		return nil, fmt.Errorf("no root certificate in the sidecar of %s", pod)
+	}
+	intermediates := x509.NewCertPool()
+	intermediates.AppendCertsFromPEM(rest)
+	if _, err = cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return nil, fmt.Errorf("certificate of the sidecar of %s: %v", pod, err)
+	}
+
+	certs, err := e.PodProxyAdmin(pod, "/certs")
+	if err != nil {
+		return nil, err
+	}
+	serial := strings.TrimLeft(cert.SerialNumber.Text(16), "0")
+	for _, match := range serialNumberRegex.FindAllStringSubmatch(certs, -1) {
+		if strings.TrimLeft(strings.ToLower(match[1]), "0") == serial {
+			return cert, nil
+		}
+	}
+	return nil, fmt.Errorf("the sidecar of %s does not serve the certificate %s it mounts", pod, serial)
+}
+
+// podProxyFile returns the content of a file of the sidecar container of the given pod.
+func (e *Environment) podProxyFile(pod, path string) (string, error) {
+	cmd := fmt.Sprintf("kubectl exec %s --kubeconfig %s -n %s -c %s -- cat %s",
+		pod, e.Config.KubeConfig, e.Config.Namespace, inject.ProxyContainerName, path)
+	return util.Shell(cmd)
+}
@@ -0,0 +1,29 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package util is the harness of the pilot e2e tests, which other packages import to run their own tests
+// against an Istio deployment, such as a distribution of their own, without forking tests/e2e.
+//
+// A Test is set up, run and torn down in an Environment, which deploys the control plane and the test apps
+// of its Config and applies configs and sends requests between the apps on behalf of the test. A Suite
+// defines the flags of the Config, runs the tests of a package with the retries, deadlines and diagnostics
+// they ask for, and reports their outcome; see tests/e2e/tests/pilot/example for a package running its own
+// test.
+//
+// The API the packages outside of tests/e2e rely on is kept stable: Suite, TestFactory, Test and its
+// optional interfaces, Environment, Config, NewConfig, Status, ErrAgain, ErrorBudget and Skip. The
+// environment reads its templates from Config.TestDataDir, testdata/ relative to the package being tested
+// by default, so another package points -testdata-dir at the testdata of tests/e2e/tests/pilot, or at its
+// own copy of it, and -cert-dir and -helm-chart likewise.
+package util
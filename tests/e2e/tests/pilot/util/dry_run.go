@@ -0,0 +1,108 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"istio.io/istio/pilot/pkg/model"
+)
+
+// suffix of the names of the namespaces created with a generated name under -dry-run
+const dryRunNameSuffix = "dry-run"
+
+// dryRunOut receives the mutations planned under -dry-run.
+var dryRunOut io.Writer = os.Stdout
+
+// DryRunf prints a line of the plan of a run with -dry-run.
+func DryRunf(format string, args ...interface{}) {
+	fmt.Fprintf(dryRunOut, "[dry-run] "+format+"\n", args...)
+}
+
+// dryRunYAML prints a mutation of the plan, followed by the YAML it applies, indented.
+func dryRunYAML(header, content string) {
+	DryRunf("%s:", header)
+	for _, line := range strings.Split(strings.TrimRight(content, "\n"), "\n") {
+		fmt.Fprintln(dryRunOut, "    "+line)
+	}
+}
+
+// dryRunClient returns a clientset standing in for the cluster under -dry-run. It keeps the objects
+// in memory, and prints the creations, updates and deletions instead of sending them to the cluster.
+// The existing namespaces are known to it from the start.
+func dryRunClient(cluster string, namespaces ...string) *fake.Clientset {
+	var objects []runtime.Object
+	for _, namespace := range namespaces {
+		if namespace != "" {
+			objects = append(objects, &v1.Namespace{ObjectMeta: meta_v1.ObjectMeta{Name: namespace}})
+		}
+	}
+	client := fake.NewSimpleClientset(objects...)
+	client.PrependReactor("*", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		resource := action.GetResource().Resource
+		where := fmt.Sprintf("%s cluster", cluster)
+		if action.GetNamespace() != "" {
+			where = fmt.Sprintf("namespace %s of the %s cluster", action.GetNamespace(), cluster)
+		}
+		switch action.GetVerb() {
+		case "create", "update":
+			obj := action.(interface {
+				GetObject() runtime.Object
+			}).GetObject()
+			// the fake clientset does not generate names
+			if ns, ok := obj.(*v1.Namespace); ok && ns.Name == "" {
+				ns.Name = ns.GenerateName + dryRunNameSuffix
+			}
+			out, err := yaml.Marshal(obj)
+			if err != nil {
+				return true, nil, err
+			}
+			dryRunYAML(fmt.Sprintf("%s %s in the %s", action.GetVerb(), resource, where), string(out))
+		case "patch":
+			patch := action.(k8stesting.PatchAction)
+			DryRunf("patch %s %s in the %s with %s", resource, patch.GetName(), where, patch.GetPatch())
+		case "delete":
+			DryRunf("delete %s %s in the %s", resource, action.(k8stesting.DeleteAction).GetName(), where)
+		case "delete-collection":
+			DryRunf("delete all %s in the %s", resource, where)
+		}
+		return false, nil, nil
+	})
+	return client
+}
+
+// seedDryRunMesh adds the mesh config map the control plane would come with to the clientset of
+// -dry-run, holding the default mesh config.
+func seedDryRunMesh(client *fake.Clientset, namespace string) error {
+	mesh := model.DefaultMeshConfig()
+	out, err := model.ToYAML(&mesh)
+	if err != nil {
+		return err
+	}
+	return client.Tracker().Add(&v1.ConfigMap{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "istio", Namespace: namespace},
+		Data:       map[string]string{ConfigMapKey: out},
+	})
+}
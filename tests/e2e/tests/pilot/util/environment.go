@@ -22,11 +22,14 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/base64"
+	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"math/big"
+	"os/exec"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -38,9 +41,11 @@ import (
 	"k8s.io/api/core/v1"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
 
 	meshconfig "istio.io/api/mesh/v1alpha1"
 	"istio.io/istio/pilot/pkg/config/kube/crd"
+	"istio.io/istio/pilot/pkg/config/memory"
 	"istio.io/istio/pilot/pkg/kube/inject"
 	"istio.io/istio/pilot/pkg/model"
 	"istio.io/istio/pilot/pkg/serviceregistry"
@@ -51,6 +56,7 @@ import (
 
 const (
 	ingressSecretName      = "istio-ingress-certs"
+	gatewaySecretName      = "istio-gateway-certs"
 	sidecarInjectorService = "istio-sidecar-injector"
 	mixerConfigFile        = "/etc/istio/proxy/envoy_mixer.json"
 	mixerConfigAuthFile    = "/etc/istio/proxy/envoy_mixer_auth.json"
@@ -65,6 +71,10 @@ type Environment struct {
 	Config Config
 
 	KubeClient kubernetes.Interface
+	// client of the remote cluster, nil unless RemoteKubeConfig is set
+	RemoteKubeClient kubernetes.Interface
+	// map from app to pods in the remote cluster, empty unless the remote components are deployed
+	RemoteApps map[string][]string
 
 	// Directory where test data files are located.
 	testDataDir string
@@ -82,11 +92,41 @@ type Environment struct {
 
 	namespaceCreated      bool
 	istioNamespaceCreated bool
+	// app namespace claimed from the NamespacePool, if any
+	pooledNamespace string
+	// whether the app namespace was created in the remote cluster by deployRemote
+	remoteNamespaceCreated bool
+	// YAML of the app deployed to the remote cluster by deployRemote, if any
+	remoteApp string
 
 	meshConfig *meshconfig.MeshConfig
 	CABundle   string
 
+	// Istio manifest applied in place of the Hub/Tag control plane, if any.
+	istioManifest string
+	// files of -extra-manifests applied to the app namespace, in order
+	extraManifests []extraManifest
+
 	config model.IstioConfigStore
+	// prefix of the names of the configs applied through this environment, set by ForTest
+	configPrefix string
+	// test the resources applied through this environment are labeled with, set by LabelTest
+	testLabel string
+
+	// resources removed by the last Teardown, nil if it kept them around
+	cleanup *cleanupTargets
+	// callbacks following the progress of the suite, see Hooks
+	hooks []Hooks
+	// follower of the logs of the pods written under StreamLogsDir, if set
+	logStreamer *logStreamer
+	// collector of the periodic Pilot profiles, if started
+	profiler *PilotProfiler
+	// port-forwards kept open until the teardown
+	portForwards *portForwards
+	// timing of the assertions checked by Eventually since the harness last took them
+	assertions *assertionLog
+	// lease of the Istio namespace given to the environment, held until the teardown
+	lock *namespaceLock
 
 	Err error
 }
@@ -117,6 +157,10 @@ type TemplateData struct {
 	PilotCustomConfigFile  string
 	MixerCustomConfigFile  string
 	CABundle               string
+	// whether Pilot also discovers the services of the remote cluster, see createClusterRegistries
+	ClusterRegistries bool
+	// whether Pilot runs the binary instrumented for coverage, see CollectCoverage
+	Coverage bool
 }
 
 // NewEnvironment creates a new test environment based on the configuration.
@@ -124,11 +168,14 @@ func NewEnvironment(config Config) *Environment {
 	e := Environment{
 		Config:      config,
 		Name:        "(no-auth environment)",
-		testDataDir: "testdata/",
-		certDir:     "../../../../pilot/docker/certs/",
+		testDataDir: config.TestDataDir,
+		certDir:     config.CertDir,
 		Auth:        meshconfig.MeshConfig_NONE,
 		MixerCustomConfigFile: mixerConfigFile,
 		PilotCustomConfigFile: pilotConfigFile,
+		hooks:                 registeredHooks(),
+		portForwards:          &portForwards{},
+		assertions:            &assertionLog{},
 	}
 
 	if config.Auth {
@@ -166,7 +213,7 @@ func GetMeshConfig(kube kubernetes.Interface, namespace, name string) (*v1.Confi
 func (e *Environment) ToTemplateData() TemplateData {
 	return TemplateData{
 		Hub:                    e.Config.Hub,
-		Tag:                    e.Config.Tag,
+		Tag:                    e.Config.InstallTag(),
 		IstioNamespace:         e.Config.IstioNamespace,
 		Auth:                   e.Auth,
 		Zipkin:                 e.Config.Zipkin,
@@ -182,35 +229,77 @@ func (e *Environment) ToTemplateData() TemplateData {
 		MixerCustomConfigFile:  e.MixerCustomConfigFile,
 		CABundle:               e.CABundle,
 		RDSv2:                  e.Config.RDSv2,
+		ClusterRegistries:      e.Config.RemoteKubeConfig != "",
+		Coverage:               e.Config.CoverageDir != "",
 	}
 }
 
 // Setup creates the k8s environment and deploys the test apps
 func (e *Environment) Setup() error {
+	e.notifySetupStart()
 	if e.Config.KubeConfig == "" {
 		e.Config.KubeConfig = "pilot/pkg/kube/config"
 		log.Info("Using linked in kube config. Set KUBECONFIG env before running the test.")
 	}
-	var err error
-	if _, e.KubeClient, err = kube.CreateInterface(e.Config.KubeConfig); err != nil {
-		return err
+	if e.Config.UseExistingIstio {
+		if e.Config.IstioNamespace == "" {
+			return fmt.Errorf("the namespace of the existing Istio installation is not set")
+		}
+		if e.Config.IstioManifest != "" {
+			return fmt.Errorf("cannot both use an existing Istio installation and install %s", e.Config.IstioManifest)
+		}
+		if e.Config.InstallMethod == InstallMethodHelm {
+			return fmt.Errorf("cannot both use an existing Istio installation and install the Helm chart")
+		}
 	}
-
-	crdclient, crderr := crd.NewClient(e.Config.KubeConfig, model.IstioConfigTypes, "")
-	if crderr != nil {
-		return crderr
+	if e.Config.BaseTag != "" && e.Config.IstioManifest != "" {
+		return fmt.Errorf("cannot both install the base tag %s and %s", e.Config.BaseTag, e.Config.IstioManifest)
 	}
-	if err = crdclient.RegisterResources(); err != nil {
+	switch e.Config.InstallMethod {
+	case InstallMethodYAML:
+	case InstallMethodHelm:
+		if e.Config.IstioManifest != "" {
+			return fmt.Errorf("cannot both install the Helm chart and %s", e.Config.IstioManifest)
+		}
+	default:
+		return fmt.Errorf("unknown install method %q", e.Config.InstallMethod)
+	}
+	if err := validateIPFamily(e.Config.IPFamily); err != nil {
 		return err
 	}
+	if err := validateImagePullPolicy(e.Config.ImagePullPolicy); err != nil {
+		return err
+	}
+	if e.Config.LockTTL <= 0 {
+		return fmt.Errorf("the TTL of the namespace lock must be positive, got %v", e.Config.LockTTL)
+	}
+	var err error
+	if e.Config.DryRun {
+		if err = e.setupDryRunClients(); err != nil {
+			return err
+		}
+	} else {
+		if err = e.setupClients(); err != nil {
+			return err
+		}
+	}
 
-	e.config = model.MakeIstioStore(crdclient)
-
+	if e.Config.NamespacePool != "" && e.Config.Namespace == "" && e.Config.IstioNamespace == "" && !e.Config.DryRun {
+		if err = e.claimPooledNamespaces(); err != nil {
+			return err
+		}
+		if err = e.resetPooledConfigs(); err != nil {
+			return err
+		}
+	}
 	if e.Config.Namespace == "" {
 		if e.Config.Namespace, err = util.CreateNamespaceWithPrefix(e.KubeClient, "istio-test-app-", e.Config.UseAutomaticInjection); err != nil { // nolint: lll
 			return err
 		}
 		e.namespaceCreated = true
+		if err = e.labelNamespace(e.Config.Namespace); err != nil {
+			return err
+		}
 	} else {
 		if _, err = e.KubeClient.CoreV1().Namespaces().Get(e.Config.Namespace, meta_v1.GetOptions{}); err != nil {
 			return err
@@ -222,11 +311,35 @@ func (e *Environment) Setup() error {
 			return err
 		}
 		e.istioNamespaceCreated = true
+		if err = e.labelNamespace(e.Config.IstioNamespace); err != nil {
+			return err
+		}
 	} else {
 		if _, err = e.KubeClient.CoreV1().Namespaces().Get(e.Config.IstioNamespace, meta_v1.GetOptions{}); err != nil {
 			return err
 		}
 	}
+	// before deploying anything to the Istio namespace
+	if err = e.acquireLock(); err != nil {
+		return err
+	}
+	if !e.Config.DryRun {
+		for _, namespace := range []string{e.Config.IstioNamespace, e.Config.Namespace} {
+			if err = e.copyImagePullSecret(namespace); err != nil {
+				return err
+			}
+		}
+	}
+	if dryRun, ok := e.KubeClient.(*fake.Clientset); ok {
+		// the config map of the templates, never applied
+		if err = seedDryRunMesh(dryRun, e.Config.IstioNamespace); err != nil {
+			return err
+		}
+	}
+	// before the deployments, for the logs of the pods that do not start
+	if err = e.startLogStreaming(); err != nil {
+		return err
+	}
 
 	deploy := func(name, namespace string) error {
 		var filledYaml string
@@ -239,96 +352,116 @@ func (e *Environment) Setup() error {
 		return nil
 	}
 
-	if !e.Config.NoRBAC {
-		if err = deploy("rbac-beta.yaml.tmpl", e.Config.IstioNamespace); err != nil {
+	switch {
+	case e.Config.UseExistingIstio:
+		if err = e.discoverIstio(); err != nil {
 			return err
 		}
-	}
+	case e.Config.IstioManifest != "":
+		if err = e.deployIstioManifest(); err != nil {
+			return err
+		}
+	case e.Config.InstallMethod == InstallMethodHelm:
+		if err = e.deployHelmChart(); err != nil {
+			return err
+		}
+	default:
+		if !e.Config.NoRBAC {
+			if err = deploy("rbac-beta.yaml.tmpl", e.Config.IstioNamespace); err != nil {
+				return err
+			}
+		}
 
-	if err = deploy("config.yaml.tmpl", e.Config.IstioNamespace); err != nil {
-		return err
+		if err = deploy("config.yaml.tmpl", e.Config.IstioNamespace); err != nil {
+			return err
+		}
 	}
+	// the components of the control plane are deployed from the templates
+	deployTemplates := e.istioManifest == "" && !e.Config.UseExistingIstio
 
 	if _, e.meshConfig, err = GetMeshConfig(e.KubeClient, e.Config.IstioNamespace, "istio"); err != nil {
 		return err
 	}
-	debugMode := e.Config.DebugImagesAndMode
 	log.Infof("mesh %s", spew.Sdump(e.meshConfig))
 
-	e.Config.SidecarTemplate, err = inject.GenerateTemplateFromParams(&inject.Params{
-		InitImage:       inject.InitImageName(e.Config.Hub, e.Config.Tag, debugMode),
-		ProxyImage:      inject.ProxyImageName(e.Config.Hub, e.Config.Tag, debugMode),
-		Verbosity:       e.Config.Verbosity,
-		SidecarProxyUID: inject.DefaultSidecarProxyUID,
-		EnableCoreDump:  true,
-		Version:         "integration-test",
-		Mesh:            e.meshConfig,
-		DebugMode:       debugMode,
-	})
-	if err != nil {
+	if e.Config.SidecarTemplate, err = e.generateSidecarTemplate(e.Config.InstallTag()); err != nil {
 		return err
 	}
 
-	if e.Config.UseAutomaticInjection {
+	// an existing installation comes with its own webhooks
+	if e.Config.UseAutomaticInjection && !e.Config.UseExistingIstio {
 		if err = e.createSidecarInjector(); err != nil {
 			return err
 		}
 	}
 
-	if e.Config.UseAdmissionWebhook {
+	if e.Config.UseAdmissionWebhook && !e.Config.UseExistingIstio {
 		if err = e.createAdmissionWebhookSecret(); err != nil {
 			return err
 		}
 	}
 
-	if err = deploy("pilot.yaml.tmpl", e.Config.IstioNamespace); err != nil {
-		return err
-	}
-	if e.Config.Mixer {
-		if err = deploy("mixer.yaml.tmpl", e.Config.IstioNamespace); err != nil {
+	if deployTemplates {
+		if e.Config.RemoteKubeConfig != "" {
+			if err = e.createClusterRegistries(); err != nil {
+				return err
+			}
+		}
+		if err = deploy("pilot.yaml.tmpl", e.Config.IstioNamespace); err != nil {
 			return err
 		}
+		if e.Config.Mixer {
+			if err = deploy("mixer.yaml.tmpl", e.Config.IstioNamespace); err != nil {
+				return err
+			}
+		}
 	}
-	if serviceregistry.ServiceRegistry(e.Config.Registry) == serviceregistry.EurekaRegistry {
+	switch serviceregistry.ServiceRegistry(e.Config.Registry) {
+	case serviceregistry.EurekaRegistry:
 		if err = deploy("eureka.yaml.tmpl", e.Config.IstioNamespace); err != nil {
 			return err
 		}
+	case serviceregistry.ConsulRegistry:
+		if err = deploy("consul.yaml.tmpl", e.Config.IstioNamespace); err != nil {
+			return err
+		}
 	}
 
-	if err = deploy("ca.yaml.tmpl", e.Config.IstioNamespace); err != nil {
-		return err
+	if deployTemplates {
+		if err = deploy("ca.yaml.tmpl", e.Config.IstioNamespace); err != nil {
+			return err
+		}
 	}
 	if err = deploy("headless.yaml.tmpl", e.Config.Namespace); err != nil {
 		return err
 	}
-	if e.Config.Ingress {
-		if err = deploy("ingress-proxy.yaml.tmpl", e.Config.IstioNamespace); err != nil {
-			return err
+	if e.Config.Ingress && !e.Config.UseExistingIstio {
+		if deployTemplates {
+			if err = deploy("ingress-proxy.yaml.tmpl", e.Config.IstioNamespace); err != nil {
+				return err
+			}
 		}
 		// Create ingress key/cert in secret
-		var key []byte
-		key, err = ioutil.ReadFile(e.certDir + "cert.key")
-		if err != nil {
+		if err = e.createCertSecret(ingressSecretName); err != nil {
 			return err
 		}
-		var crt []byte
-		crt, err = ioutil.ReadFile(e.certDir + "cert.crt")
-		if err != nil {
+	}
+	if e.Config.V1alpha2 && !e.Config.UseExistingIstio {
+		// Create the key/cert of the HTTPS gateway servers in secret, before the gateway mounts it
+		if err = e.createCertSecret(gatewaySecretName); err != nil {
 			return err
 		}
-		_, err = e.KubeClient.CoreV1().Secrets(e.Config.IstioNamespace).Create(&v1.Secret{
-			ObjectMeta: meta_v1.ObjectMeta{Name: ingressSecretName},
-			Data: map[string][]byte{
-				"tls.key": key,
-				"tls.crt": crt,
-			},
-		})
-		if err != nil {
-			log.Warn("Secret already exists")
+		if err = deploy("gateway.yaml.tmpl", e.Config.IstioNamespace); err != nil {
+			return err
+		}
+	}
+	if e.Config.Zipkin && !e.Config.UseExistingIstio {
+		if err = deploy(e.Config.TraceBackend+".yaml", e.Config.IstioNamespace); err != nil {
+			return err
 		}
 	}
-	if e.Config.Zipkin {
-		if err = deploy("zipkin.yaml", e.Config.IstioNamespace); err != nil {
+	if e.Config.Prometheus && e.Config.Mixer && !e.Config.UseExistingIstio {
+		if err = deploy("prometheus.yaml", e.Config.IstioNamespace); err != nil {
 			return err
 		}
 	}
@@ -343,48 +476,321 @@ func (e *Environment) Setup() error {
 		return err
 	}
 
+	// the remote sidecars connect to the Pilot of the templates
+	if deployTemplates && e.Config.RemoteKubeConfig != "" {
+		if err = e.deployRemote(); err != nil {
+			return err
+		}
+	}
+
 	nslist := []string{e.Config.IstioNamespace, e.Config.Namespace}
-	e.Apps, err = util.GetAppPods(e.KubeClient, e.Config.KubeConfig, nslist)
+	if e.Apps, err = util.GetAppPods(e.KubeClient, e.Config.KubeConfig, nslist); err != nil {
+		return err
+	}
+	return e.applyExtraManifests()
+}
+
+// setupClients creates the clients of the clusters, and registers the Istio config types.
+func (e *Environment) setupClients() error {
+	var err error
+	if _, e.KubeClient, err = kube.CreateInterface(e.Config.KubeConfig); err != nil {
+		return err
+	}
+	if e.Config.RemoteKubeConfig != "" {
+		if _, e.RemoteKubeClient, err = kube.CreateInterface(e.Config.RemoteKubeConfig); err != nil {
+			return err
+		}
+	}
+
+	crdclient, err := crd.NewClient(e.Config.KubeConfig, model.IstioConfigTypes, "")
+	if err != nil {
+		return err
+	}
+	if err = crdclient.RegisterResources(); err != nil {
+		return err
+	}
+	e.config = model.MakeIstioStore(crdclient)
+	return nil
+}
+
+// setupDryRunClients stands in for the clients of the clusters under -dry-run, keeping the Istio configs
+// in memory and printing the mutations of the run.
+func (e *Environment) setupDryRunClients() error {
+	if e.Config.UseExistingIstio {
+		return fmt.Errorf("cannot dry-run against an existing Istio installation, whose state is unknown")
+	}
+	log.Info("Dry run, printing the mutations of the clusters instead of making them")
+	e.KubeClient = dryRunClient("local", e.Config.Namespace, e.Config.IstioNamespace)
+	if e.Config.RemoteKubeConfig != "" {
+		e.RemoteKubeClient = dryRunClient("remote")
+	}
+	e.config = model.MakeIstioStore(memory.Make(model.IstioConfigTypes))
+	return nil
+}
+
+// discoverIstio checks that the control plane of an existing installation runs in IstioNamespace,
+// and turns off the optional components of the configuration that it does not run.
+func (e *Environment) discoverIstio() error {
+	deployments, err := e.KubeClient.ExtensionsV1beta1().Deployments(e.Config.IstioNamespace).List(meta_v1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	running := func(prefixes ...string) bool {
+		for _, deployment := range deployments.Items {
+			for _, prefix := range prefixes {
+				if strings.HasPrefix(deployment.Name, prefix) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	if !running("istio-pilot") {
+		return fmt.Errorf("no istio-pilot deployment in namespace %s", e.Config.IstioNamespace)
+	}
+	if e.Config.Mixer && !running("istio-mixer", "istio-policy") {
+		log.Warnf("No mixer in namespace %s, running the tests without mixer", e.Config.IstioNamespace)
+		e.Config.Mixer = false
+	}
+	if e.Config.Ingress && !running("istio-ingress") {
+		log.Warnf("No ingress in namespace %s, running the tests without ingress", e.Config.IstioNamespace)
+		e.Config.Ingress = false
+	}
+	if e.Config.Zipkin && !running(e.Config.TraceBackend) {
+		log.Warnf("No %s in namespace %s, running the tests without tracing", e.Config.TraceBackend,
+			e.Config.IstioNamespace)
+		e.Config.Zipkin = false
+	}
+	if e.Config.Prometheus && !running("prometheus") {
+		log.Warnf("No prometheus in namespace %s, running the tests without prometheus", e.Config.IstioNamespace)
+		e.Config.Prometheus = false
+	}
+	log.Infof("Using the existing Istio installation in namespace %s", e.Config.IstioNamespace)
+	return nil
+}
+
+// deployIstioManifest installs the control plane from the configured manifest instead of the Hub/Tag templates.
+func (e *Environment) deployIstioManifest() error {
+	if e.Config.Tag != "" {
+		log.Warnf("Both tag %q and manifest %s are set, installing the control plane from the manifest",
+			e.Config.Tag, e.Config.IstioManifest)
+	}
+	manifest, err := loadManifest(e.Config.IstioManifest)
+	if err != nil {
+		return err
+	}
+	if err = e.validateManifest(e.Config.IstioManifest, manifest); err != nil {
+		return err
+	}
+	if err = e.KubeApply(manifest, e.Config.IstioNamespace); err != nil {
+		return err
+	}
+	e.istioManifest = manifest
+	return nil
+}
+
+// generateSidecarTemplate returns the injection template of the sidecars with the images of the tag.
+func (e *Environment) generateSidecarTemplate(tag string) (string, error) {
+	return inject.GenerateTemplateFromParams(e.SidecarTemplateParams(tag))
+}
+
+// SidecarTemplateParams returns the parameters of the injection template of the sidecars with the
+// images of the tag, which the tests of injection change before generating their own template.
+func (e *Environment) SidecarTemplateParams(tag string) *inject.Params {
+	debugMode := e.Config.DebugImagesAndMode
+	return &inject.Params{
+		InitImage:       inject.InitImageName(e.Config.Hub, tag, debugMode),
+		ProxyImage:      inject.ProxyImageName(e.Config.Hub, tag, debugMode),
+		Verbosity:       e.Config.Verbosity,
+		SidecarProxyUID: inject.DefaultSidecarProxyUID,
+		EnableCoreDump:  true,
+		Version:         "integration-test",
+		Mesh:            e.meshConfig,
+		DebugMode:       debugMode,
+		ImagePullPolicy: e.Config.ImagePullPolicy,
+		EnableIPv6:      e.Config.HasIPv6(),
+	}
+}
+
+// RefreshApps waits for all pods in the test namespaces to be running and updates the app to pods mapping.
+// Tests that delete or restart pods call it so that later requests target live pods.
+func (e *Environment) RefreshApps() error {
+	apps, err := util.GetAppPods(e.KubeClient, e.Config.KubeConfig, []string{e.Config.IstioNamespace, e.Config.Namespace})
+	if err != nil {
+		return err
+	}
+	e.Apps = apps
+	return nil
+}
+
+// ScaleDeployment sets the number of replicas of the deployment in the app namespace. It does not
+// wait for the pods, see RefreshApps.
+func (e *Environment) ScaleDeployment(name string, replicas int32) error {
+	deployments := e.KubeClient.ExtensionsV1beta1().Deployments(e.Config.Namespace)
+	deployment, err := deployments.Get(name, meta_v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	deployment.Spec.Replicas = &replicas
+	_, err = deployments.Update(deployment)
 	return err
 }
 
+// annotation of the pod template of a deployment set by RollSidecar
+const rolloutAnnotation = "istio-test/rolled-out-at"
+
+// RollSidecar rolls out the pods of the deployment in the app namespace with the proxy image of the tag,
+// or the same image if the tag is empty, in which case the pods are only replaced. With automatic
+// injection the sidecar is not part of the deployment, so the tag is ignored. It does not wait for the
+// rollout, see RefreshApps.
+func (e *Environment) RollSidecar(name, tag string) error {
+	deployments := e.KubeClient.ExtensionsV1beta1().Deployments(e.Config.Namespace)
+	deployment, err := deployments.Get(name, meta_v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	template := &deployment.Spec.Template
+	if tag != "" {
+		for i := range template.Spec.Containers {
+			if container := &template.Spec.Containers[i]; container.Name == inject.ProxyContainerName {
+				container.Image = inject.ProxyImageName(e.Config.Hub, tag, e.Config.DebugImagesAndMode)
+			}
+		}
+	}
+	// a change of the pod template rolls the pods out even when the image is the same
+	if template.Annotations == nil {
+		template.Annotations = make(map[string]string)
+	}
+	template.Annotations[rolloutAnnotation] = time.Now().UTC().Format(time.RFC3339Nano)
+	_, err = deployments.Update(deployment)
+	return err
+}
+
+// UpdateMeshConfig replaces the mesh configuration stored in the istio ConfigMap and restarts Pilot,
+// which only reads it on startup. It returns the previous configuration so that callers can restore it.
+func (e *Environment) UpdateMeshConfig(mesh string) (string, error) {
+	config, _, err := GetMeshConfig(e.KubeClient, e.Config.IstioNamespace, "istio")
+	if err != nil {
+		return "", err
+	}
+	meshConfig, err := model.ApplyMeshConfigDefaults(mesh)
+	if err != nil {
+		return "", err
+	}
+
+	previous := config.Data[ConfigMapKey]
+	config.Data[ConfigMapKey] = mesh
+	if _, err = e.KubeClient.CoreV1().ConfigMaps(e.Config.IstioNamespace).Update(config); err != nil {
+		return "", err
+	}
+	e.meshConfig = meshConfig
+
+	selector, err := e.pilotSelector()
+	if err != nil {
+		return previous, err
+	}
+	if err = e.KubeClient.CoreV1().Pods(e.Config.IstioNamespace).DeleteCollection(&meta_v1.DeleteOptions{},
+		meta_v1.ListOptions{LabelSelector: selector}); err != nil {
+		return previous, err
+	}
+	return previous, e.RefreshApps()
+}
+
+// pilotSelector returns the selector of the pods of the istio-pilot deployment, whose labels differ between
+// the templates of testdata, the manifests and the Helm chart of a control plane installed otherwise.
+func (e *Environment) pilotSelector() (string, error) {
+	deployment, err := e.KubeClient.ExtensionsV1beta1().Deployments(e.Config.IstioNamespace).Get("istio-pilot",
+		meta_v1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	// an empty selector would restart all the pods of the namespace
+	if deployment.Spec.Selector == nil || len(deployment.Spec.Selector.MatchLabels)+
+		len(deployment.Spec.Selector.MatchExpressions) == 0 {
+		return "", fmt.Errorf("the istio-pilot deployment of namespace %s has no selector", e.Config.IstioNamespace)
+	}
+	selector, err := meta_v1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		return "", err
+	}
+	return selector.String(), nil
+}
+
 func (e *Environment) deployApps() error {
 	// deploy a healthy mix of apps, with and without proxy
-	if err := e.deployApp("t", "t", 8080, 80, 9090, 90, 7070, 70, "unversioned", false, false); err != nil {
+	if err := e.deployApp("t", "t", 8080, 80, 9090, 90, 7070, 70, "unversioned", false, false, ""); err != nil {
 		return err
 	}
-	if err := e.deployApp("a", "a", 8080, 80, 9090, 90, 7070, 70, "v1", true, false); err != nil {
+	if err := e.deployApp("a", "a", 8080, 80, 9090, 90, 7070, 70, "v1", true, false, ""); err != nil {
 		return err
 	}
-	if err := e.deployApp("b", "b", 80, 8080, 90, 9090, 70, 7070, "unversioned", true, false); err != nil {
+	if err := e.deployApp("b", "b", 80, 8080, 90, 9090, 70, 7070, "unversioned", true, false, ""); err != nil {
 		return err
 	}
-	if err := e.deployApp("c-v1", "c", 80, 8080, 90, 9090, 70, 7070, "v1", true, false); err != nil {
+	if err := e.deployApp("c-v1", "c", 80, 8080, 90, 9090, 70, 7070, "v1", true, false, ""); err != nil {
 		return err
 	}
-	if err := e.deployApp("c-v2", "c", 80, 8080, 90, 9090, 70, 7070, "v2", true, false); err != nil {
+	if err := e.deployApp("c-v2", "c", 80, 8080, 90, 9090, 70, 7070, "v2", true, false, ""); err != nil {
 		return err
 	}
-	if err := e.deployApp("d", "d", 80, 8080, 90, 9090, 70, 7070, "per-svc-auth", true, true); err != nil {
+	if err := e.deployApp("d", "d", 80, 8080, 90, 9090, 70, 7070, "per-svc-auth", true, true, ""); err != nil {
 		return err
 	}
 	// Add another service without sidecar to test mTLS blacklisting (as in the e2e test
 	// environment, pilot can see only services in the test namespaces). This service
 	// will be listed in mtlsExcludedServices in the mesh config.
-	return e.deployApp("e", "fake-control", 80, 8080, 90, 9090, 70, 7070, "fake-control", false, false)
+	return e.deployApp("e", "fake-control", 80, 8080, 90, 9090, 70, 7070, "fake-control", false, false, "")
 }
 
 func (e *Environment) deployApp(deployment, svcName string, port1, port2, port3, port4, port5, port6 int,
-	version string, injectProxy bool, perServiceAuth bool) error {
-	// Eureka does not support management ports
+	version string, injectProxy bool, perServiceAuth bool, serviceAccount string) error {
+	_, err := e.deployAppYAML(deployment, svcName, port1, port2, port3, port4, port5, port6,
+		version, injectProxy, perServiceAuth, serviceAccount)
+	return err
+}
+
+func (e *Environment) deployAppYAML(deployment, svcName string, port1, port2, port3, port4, port5, port6 int,
+	version string, injectProxy bool, perServiceAuth bool, serviceAccount string) (string, error) {
+	w, err := e.fillApp(deployment, svcName, port1, port2, port3, port4, port5, port6,
+		version, injectProxy, perServiceAuth, serviceAccount)
+	if err != nil {
+		return "", err
+	}
+	return e.applyApp(w, e.Config.Namespace, injectProxy)
+}
+
+// applyApp applies the YAML of an app to the namespace, injecting the sidecar unless the injector
+// does it, and returns the applied YAML.
+func (e *Environment) applyApp(w, namespace string, injectProxy bool) (string, error) {
+	writer := new(bytes.Buffer)
+
+	if injectProxy && !e.Config.UseAutomaticInjection {
+		if err := inject.IntoResourceFile(e.Config.SidecarTemplate, e.meshConfig, strings.NewReader(w), writer); err != nil {
+			return "", err
+		}
+	} else {
+		if _, err := io.Copy(writer, strings.NewReader(w)); err != nil {
+			return "", err
+		}
+	}
+
+	return writer.String(), e.KubeApply(writer.String(), namespace)
+}
+
+// fillApp returns the YAML of an app, without its sidecar.
+func (e *Environment) fillApp(deployment, svcName string, port1, port2, port3, port4, port5, port6 int,
+	version string, injectProxy bool, perServiceAuth bool, serviceAccount string) (string, error) {
+	// Eureka and Consul do not support management ports
 	healthPort := "true"
-	if serviceregistry.ServiceRegistry(e.Config.Registry) == serviceregistry.EurekaRegistry {
+	switch serviceregistry.ServiceRegistry(e.Config.Registry) {
+	case serviceregistry.EurekaRegistry, serviceregistry.ConsulRegistry:
 		healthPort = "false"
 	}
 
-	w, err := e.Fill("app.yaml.tmpl", map[string]string{
-		"Hub":            e.Config.Hub,
-		"Tag":            e.Config.Tag,
+	return e.Fill("app.yaml.tmpl", map[string]string{
+		"AppHub":         e.Config.AppImageHub(),
+		"AppTag":         e.Config.AppImageTag(),
 		"service":        svcName,
 		"perServiceAuth": strconv.FormatBool(perServiceAuth),
 		"deployment":     deployment,
@@ -398,24 +804,9 @@ func (e *Environment) deployApp(deployment, svcName string, port1, port2, port3,
 		"istioNamespace": e.Config.IstioNamespace,
 		"injectProxy":    strconv.FormatBool(injectProxy),
 		"healthPort":     healthPort,
+		"serviceAccount": serviceAccount,
+		"ipFamily":       e.Config.serviceIPFamily(svcName),
 	})
-	if err != nil {
-		return err
-	}
-
-	writer := new(bytes.Buffer)
-
-	if injectProxy && !e.Config.UseAutomaticInjection {
-		if err := inject.IntoResourceFile(e.Config.SidecarTemplate, e.meshConfig, strings.NewReader(w), writer); err != nil {
-			return err
-		}
-	} else {
-		if _, err := io.Copy(writer, strings.NewReader(w)); err != nil {
-			return err
-		}
-	}
-
-	return e.KubeApply(writer.String(), e.Config.Namespace)
 }
 
 // Teardown cleans up the k8s environment, removing any resources that were created by the tests.
@@ -423,11 +814,18 @@ func (e *Environment) Teardown() {
 	if e.KubeClient == nil {
 		return
 	}
+	e.notifyTeardown()
+	e.stopLogStreaming()
+	e.closePortForwards()
+	// the run is over, also with SkipCleanup
+	defer e.releaseLock()
+	// while Pilot still runs, also with SkipCleanup
+	e.CollectCoverage()
 
 	needToTeardown := !e.Config.SkipCleanup
 
 	// spill all logs on error
-	if e.Err != nil {
+	if e.Err != nil && !e.Config.DryRun {
 		if e.Config.SkipCleanupOnFailure {
 			needToTeardown = false
 		}
@@ -440,23 +838,44 @@ func (e *Environment) Teardown() {
 	if !needToTeardown {
 		return
 	}
+	e.teardownRemote()
+	// the namespaces of a pool keep their deployments for the next run
+	if e.pooledNamespace != "" {
+		e.deleteExtraManifests()
+		e.releasePooledNamespaces()
+		return
+	}
+	e.cleanup = &cleanupTargets{configNamespace: e.Config.Namespace}
+
+	e.deleteExtraManifests()
 
-	if e.Config.UseAdmissionWebhook {
+	if e.Config.UseAdmissionWebhook && !e.Config.UseExistingIstio {
 		if err := e.deleteAdmissionWebhookSecret(); err != nil {
 			log.Infof("Could not delete admission webhook secret: %v", err)
 		}
 	}
 
 	// automatic injection webhook is not namespaced.
-	if e.Config.UseAutomaticInjection {
+	if e.Config.UseAutomaticInjection && !e.Config.UseExistingIstio {
 		e.deleteSidecarInjector()
+		e.cleanup.webhooks = append(e.cleanup.webhooks, sidecarInjectorService)
 	}
 
-	if filledYaml, err := e.Fill("rbac-beta.yaml.tmpl", e.ToTemplateData()); err != nil {
-		log.Infof("RBAC template could could not be processed, please delete stale ClusterRoleBindings: %v",
-			err)
-	} else if err = e.kubeDelete(filledYaml, e.Config.IstioNamespace); err != nil {
-		log.Infof("RBAC config could could not be deleted: %v", err)
+	switch {
+	case e.Config.UseExistingIstio:
+		// the existing installation is left running
+	case e.istioManifest != "":
+		if err := e.KubeDelete(e.istioManifest, e.Config.IstioNamespace); err != nil {
+			log.Infof("Istio manifest could not be deleted: %v", err)
+		}
+		e.istioManifest = ""
+	default:
+		if filledYaml, err := e.Fill("rbac-beta.yaml.tmpl", e.ToTemplateData()); err != nil {
+			log.Infof("RBAC template could could not be processed, please delete stale ClusterRoleBindings: %v",
+				err)
+		} else if err = e.KubeDelete(filledYaml, e.Config.IstioNamespace); err != nil {
+			log.Infof("RBAC config could could not be deleted: %v", err)
+		}
 	}
 
 	if e.Config.Ingress {
@@ -470,12 +889,21 @@ func (e *Environment) Teardown() {
 		}
 	}
 
+	if e.Config.V1alpha2 && !e.Config.UseExistingIstio {
+		if err := e.KubeClient.CoreV1().Secrets(e.Config.IstioNamespace).
+			Delete(gatewaySecretName, &meta_v1.DeleteOptions{}); err != nil {
+			log.Warna(err)
+		}
+	}
+
 	if e.namespaceCreated {
 		util.DeleteNamespace(e.KubeClient, e.Config.Namespace)
+		e.cleanup.namespaces = append(e.cleanup.namespaces, e.Config.Namespace)
 		e.Config.Namespace = ""
 	}
 	if e.istioNamespaceCreated {
 		util.DeleteNamespace(e.KubeClient, e.Config.IstioNamespace)
+		e.cleanup.namespaces = append(e.cleanup.namespaces, e.Config.IstioNamespace)
 		e.Config.IstioNamespace = ""
 	}
 }
@@ -512,17 +940,80 @@ func (e *Environment) dumpErrorLogs() {
 	}
 }
 
-// KubeApply runs kubectl apply with the given yaml and namespace.
+// KubeApply runs kubectl apply with the given yaml and namespace, labeling the resources with the run
+// and the test, and setting the image pull policy and secret of the config on the pods.
 func (e *Environment) KubeApply(yaml, namespace string) error {
+	yaml, err := e.prepareYAML(yaml)
+	if err != nil {
+		return err
+	}
+	if e.Config.DryRun {
+		dryRunYAML(fmt.Sprintf("apply to namespace %s of the local cluster", namespace), yaml)
+		return nil
+	}
+	e.recordApplied("kubectl apply", namespace, yaml)
 	return util.RunInput(fmt.Sprintf("kubectl apply --kubeconfig %s -n %s -f -",
 		e.Config.KubeConfig, namespace), yaml)
 }
 
-func (e *Environment) kubeDelete(yaml, namespace string) error {
+// KubeApplyOutput runs kubectl apply with the given yaml and namespace like KubeApply, returning the
+// output of kubectl, which holds the reason the API server rejected the yaml.
+func (e *Environment) KubeApplyOutput(yaml, namespace string) (string, error) {
+	if e.Config.DryRun {
+		return "", e.KubeApply(yaml, namespace)
+	}
+	// YAML that cannot be parsed goes as is, for kubectl to tell what is wrong with it
+	if labeled, err := e.prepareYAML(yaml); err == nil {
+		yaml = labeled
+	}
+	cmd := exec.Command("kubectl", "apply", "--kubeconfig", e.Config.KubeConfig, "-n", namespace, "-f", "-") // #nosec
+	cmd.Stdin = strings.NewReader(yaml)
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+// KubeDelete runs kubectl delete with the given yaml and namespace.
+func (e *Environment) KubeDelete(yaml, namespace string) error {
+	if e.Config.DryRun {
+		dryRunYAML(fmt.Sprintf("delete from namespace %s of the local cluster", namespace), yaml)
+		return nil
+	}
 	return util.RunInput(fmt.Sprintf("kubectl delete --kubeconfig %s -n %s -f -",
 		e.Config.KubeConfig, namespace), yaml)
 }
 
+// RemoteKubeApply runs kubectl apply with the given yaml and namespace in the remote cluster, labeling
+// the resources like KubeApply.
+func (e *Environment) RemoteKubeApply(yaml, namespace string) error {
+	yaml, err := e.prepareYAML(yaml)
+	if err != nil {
+		return err
+	}
+	if e.Config.DryRun {
+		dryRunYAML(fmt.Sprintf("apply to namespace %s of the remote cluster", namespace), yaml)
+		return nil
+	}
+	e.recordApplied("kubectl apply to the remote cluster", "remote-"+namespace, yaml)
+	return util.RunInput(fmt.Sprintf("kubectl apply --kubeconfig %s -n %s -f -",
+		e.Config.RemoteKubeConfig, namespace), yaml)
+}
+
+// RemoteKubeDelete runs kubectl delete with the given yaml and namespace in the remote cluster.
+func (e *Environment) RemoteKubeDelete(yaml, namespace string) error {
+	if e.Config.DryRun {
+		dryRunYAML(fmt.Sprintf("delete from namespace %s of the remote cluster", namespace), yaml)
+		return nil
+	}
+	return util.RunInput(fmt.Sprintf("kubectl delete --kubeconfig %s -n %s -f -",
+		e.Config.RemoteKubeConfig, namespace), yaml)
+}
+
+// HasCRD returns true if the named custom resource definition is installed in the cluster.
+func (e *Environment) HasCRD(name string) bool {
+	_, err := util.Shell(fmt.Sprintf("kubectl get crd %s --kubeconfig %s", name, e.Config.KubeConfig))
+	return err == nil
+}
+
 // Response represents a response to a client request.
 type Response struct {
 	// Body is the body of the response
@@ -535,6 +1026,10 @@ type Response struct {
 	Port []string
 	// Code is the response code
 	Code []string
+	// Hostname is the name of the pod that served the request
+	Hostname []string
+	// Latency is the time each request took, as measured by the client
+	Latency []time.Duration
 }
 
 const httpOk = "200"
@@ -544,11 +1039,51 @@ func (r *Response) IsHTTPOk() bool {
 	return len(r.Code) > 0 && r.Code[0] == httpOk
 }
 
+// EchoPayload is the JSON payload the echo app answers requests for ?format=json with.
+type EchoPayload struct {
+	ServiceVersion string `json:"service_version"`
+	ServicePort    int    `json:"service_port"`
+	Method         string `json:"method"`
+	URL            string `json:"url"`
+	Host           string `json:"host"`
+	Hostname       string `json:"hostname"`
+	// every value of every request header the app received, by canonical name
+	Headers map[string][]string `json:"headers"`
+}
+
+// EchoPayloads returns the JSON payloads in the body of the response, one per request asking for ?format=json.
+func (r *Response) EchoPayloads() ([]EchoPayload, error) {
+	var payloads []EchoPayload
+	for _, match := range jsonBodyRex.FindAllStringSubmatch(r.Body, -1) {
+		var payload EchoPayload
+		if err := json.Unmarshal([]byte(match[1]), &payload); err != nil {
+			return nil, fmt.Errorf("cannot parse the echo payload %q: %v", match[1], err)
+		}
+		payloads = append(payloads, payload)
+	}
+	return payloads, nil
+}
+
+// BodyJSON parses the body of the first request, a JSON document on a single line such as the replies
+// of the trace backends, into v.
+func (r *Response) BodyJSON(v interface{}) error {
+	match := bodyLineRex.FindStringSubmatch(r.Body)
+	if match == nil {
+		return fmt.Errorf("the response has no body")
+	}
+	return json.Unmarshal([]byte(match[1]), v)
+}
+
 var (
-	idRex      = regexp.MustCompile("(?i)X-Request-Id=(.*)")
-	versionRex = regexp.MustCompile("ServiceVersion=(.*)")
-	portRex    = regexp.MustCompile("ServicePort=(.*)")
-	codeRex    = regexp.MustCompile("StatusCode=(.*)")
+	idRex       = regexp.MustCompile("(?i)X-Request-Id=(.*)")
+	versionRex  = regexp.MustCompile("ServiceVersion=(.*)")
+	portRex     = regexp.MustCompile("ServicePort=(.*)")
+	codeRex     = regexp.MustCompile("StatusCode=(.*)")
+	hostnameRex = regexp.MustCompile("Hostname=(.*)")
+	latencyRex  = regexp.MustCompile("Latency=(.*)")
+	jsonBodyRex = regexp.MustCompile(`\[\d+ body\] (\{.*\})`)
+	// the first body line of the first request
+	bodyLineRex = regexp.MustCompile(`\[0 body\] (.*)`)
 )
 
 // ClientRequest makes the given request from within the k8s environment.
@@ -591,11 +1126,114 @@ func (e *Environment) ClientRequest(app, url string, count int, extra string) Re
 		out.Code = append(out.Code, code[1])
 	}
 
+	hostnames := hostnameRex.FindAllStringSubmatch(request, -1)
+	for _, hostname := range hostnames {
+		out.Hostname = append(out.Hostname, hostname[1])
+	}
+
+	latencies := latencyRex.FindAllStringSubmatch(request, -1)
+	for _, latency := range latencies {
+		if d, parseErr := time.ParseDuration(latency[1]); parseErr == nil {
+			out.Latency = append(out.Latency, d)
+		}
+	}
+
 	return out
 }
 
+// ProxyAdmin returns the output of the given path of the Envoy admin API of the sidecar of the given app.
+func (e *Environment) ProxyAdmin(app, path string) (string, error) {
+	if len(e.Apps[app]) == 0 {
+		return "", fmt.Errorf("missing pod names for app %q", app)
+	}
+	return e.PodProxyAdmin(e.Apps[app][0], path)
+}
+
+// PodProxyAdmin returns the output of the given path of the Envoy admin API of the sidecar of the given pod.
+func (e *Environment) PodProxyAdmin(pod, path string) (string, error) {
+	return e.proxyAdmin(e.Config.Namespace, pod, path)
+}
+
+// proxyAdmin returns the output of the given path of the Envoy admin API of the proxy of the pod in
+// the namespace.
+func (e *Environment) proxyAdmin(namespace, pod, path string) (string, error) {
+	if e.Config.PortForward {
+		f, err := e.forward(namespace, pod, int(e.meshConfig.DefaultConfig.ProxyAdminPort))
+		if err != nil {
+			return "", err
+		}
+		_, body, err := f.Do(path)
+		return body, err
+	}
+	cmd := fmt.Sprintf("kubectl exec %s --kubeconfig %s -n %s -c %s -- curl -s localhost:%d%s",
+		pod, e.Config.KubeConfig, namespace, inject.ProxyContainerName,
+		e.meshConfig.DefaultConfig.ProxyAdminPort, path)
+	return util.Shell(cmd)
+}
+
+// ProxyStats returns the Envoy counters and gauges of the sidecar of the given app, read from its admin port.
+func (e *Environment) ProxyStats(app string) (map[string]int, error) {
+	if len(e.Apps[app]) == 0 {
+		return nil, fmt.Errorf("missing pod names for app %q", app)
+	}
+	return e.PodProxyStats(e.Apps[app][0])
+}
+
+// PodProxyStats returns the Envoy counters and gauges of the sidecar of the given pod.
+func (e *Environment) PodProxyStats(pod string) (map[string]int, error) {
+	out, err := e.PodProxyAdmin(pod, "/stats")
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make(map[string]int)
+	for _, line := range strings.Split(out, "\n") {
+		parts := strings.SplitN(line, ": ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		// histograms are not plain integers
+		if value, convErr := strconv.Atoi(strings.TrimSpace(parts[1])); convErr == nil {
+			stats[parts[0]] = value
+		}
+	}
+	return stats, nil
+}
+
+// ForTest returns a copy of the environment for a test that runs concurrently with other tests.
+// The copy shares the clusters, apps and config store, but prefixes the names of the configs it
+// applies with the given name, and its DeleteAllConfigs only deletes those configs.
+func (e *Environment) ForTest(name string) *Environment {
+	test := *e
+	test.configPrefix = name + "-"
+	test.assertions = &assertionLog{}
+	// the lease stays with the environment of the suite
+	test.lock = nil
+	test.Err = nil
+	return &test
+}
+
 // ApplyConfig fills in the given template file (if necessary) and applies the configuration.
-func (e *Environment) ApplyConfig(inFile string, data map[string]string) error {
+func (e *Environment) ApplyConfig(inFile string, data interface{}) error {
+	if err := e.applyConfig(inFile, data); err != nil {
+		return err
+	}
+	if e.Config.DryRun {
+		return nil
+	}
+
+	sleepTime := time.Second * 3
+	log.Infof("Sleeping %v for the config to propagate", sleepTime)
+	time.Sleep(sleepTime)
+	if e.Config.Golden || e.Config.UpdateGolden {
+		return e.compareGolden(inFile, data)
+	}
+	return nil
+}
+
+// applyConfig fills in the given template file (if necessary) and applies the configuration,
+// without waiting for it to propagate.
+func (e *Environment) applyConfig(inFile string, data interface{}) error {
 	config, err := e.Fill(inFile, data)
 	if err != nil {
 		return err
@@ -605,11 +1243,63 @@ func (e *Environment) ApplyConfig(inFile string, data map[string]string) error {
 	if err != nil {
 		return err
 	}
+	e.recordApplied(inFile, e.Config.Namespace, config)
+	return e.applyConfigs(vs)
+}
+
+// ApplyConfigs applies configurations built in Go rather than filled from a template, then waits for
+// them to propagate like ApplyConfig. The name stands for a template file in the applied YAML and
+// in the golden files.
+func (e *Environment) ApplyConfigs(name string, configs []model.Config) error {
+	text, err := configsYAML(configs, e.Config.Namespace)
+	if err != nil {
+		return err
+	}
+	e.recordApplied(name, e.Config.Namespace, text)
+	if err = e.applyConfigs(configs); err != nil {
+		return err
+	}
+	if e.Config.DryRun {
+		return nil
+	}
+
+	sleepTime := time.Second * 3
+	log.Infof("Sleeping %v for the config to propagate", sleepTime)
+	time.Sleep(sleepTime)
+	if e.Config.Golden || e.Config.UpdateGolden {
+		return e.compareGoldenConfigs(name, e.goldenFile(name, nil), configs)
+	}
+	return nil
+}
 
-	for _, v := range vs {
+// applyConfigs creates or updates the configurations in the app namespace, prefixing their names and
+// labeling them with the run and the test.
+func (e *Environment) applyConfigs(configs []model.Config) error {
+	resourceLabels := e.resourceLabels()
+	for _, v := range configs {
 		// fill up namespace for the config
 		v.Namespace = e.Config.Namespace
+		v.Name = e.configPrefix + v.Name
+		if len(resourceLabels) > 0 {
+			// a copy, the labels of the given config being shared with the caller
+			configLabels := make(map[string]string, len(v.Labels)+len(resourceLabels))
+			for key, value := range v.Labels {
+				configLabels[key] = value
+			}
+			for key, value := range resourceLabels {
+				configLabels[key] = value
+			}
+			v.Labels = configLabels
+		}
 
+		if e.Config.DryRun {
+			spec, err := model.ToYAML(v.Spec)
+			if err != nil {
+				return err
+			}
+			dryRunYAML(fmt.Sprintf("apply %s %s to namespace %s", v.Type, v.Name, v.Namespace), spec)
+		}
+		var err error
 		old, exists := e.config.Get(v.Type, v.Name, v.Namespace)
 		if exists {
 			v.ResourceVersion = old.ResourceVersion
@@ -621,15 +1311,11 @@ func (e *Environment) ApplyConfig(inFile string, data map[string]string) error {
 			return err
 		}
 	}
-
-	sleepTime := time.Second * 3
-	log.Infof("Sleeping %v for the config to propagate", sleepTime)
-	time.Sleep(sleepTime)
 	return nil
 }
 
 // DeleteConfig deletes the given configuration from the k8s environment
-func (e *Environment) DeleteConfig(inFile string, data map[string]string) error {
+func (e *Environment) DeleteConfig(inFile string, data interface{}) error {
 	config, err := e.Fill(inFile, data)
 	if err != nil {
 		return err
@@ -639,16 +1325,28 @@ func (e *Environment) DeleteConfig(inFile string, data map[string]string) error
 	if err != nil {
 		return err
 	}
+	return e.DeleteConfigs(vs)
+}
 
-	for _, v := range vs {
+// DeleteConfigs deletes the given configurations, built in Go or parsed from a template, from the k8s
+// environment and waits for the deletion to propagate.
+func (e *Environment) DeleteConfigs(configs []model.Config) error {
+	for _, v := range configs {
 		// fill up namespace for the config
 		v.Namespace = e.Config.Namespace
+		v.Name = e.configPrefix + v.Name
 
 		log.Infof("Delete config %s", v.Key())
-		if err = e.config.Delete(v.Type, v.Name, v.Namespace); err != nil {
+		if e.Config.DryRun {
+			DryRunf("delete %s %s from namespace %s", v.Type, v.Name, v.Namespace)
+		}
+		if err := e.config.Delete(v.Type, v.Name, v.Namespace); err != nil {
 			return err
 		}
 	}
+	if e.Config.DryRun {
+		return nil
+	}
 
 	sleepTime := time.Second * 3
 	log.Infof("Sleeping %v for the config to propagate", sleepTime)
@@ -656,7 +1354,30 @@ func (e *Environment) DeleteConfig(inFile string, data map[string]string) error
 	return nil
 }
 
-// DeleteAllConfigs deletes any config resources that were installed by the tests.
+// configsYAML returns the YAML stream of the configurations in the namespace, as kubectl would apply them.
+func configsYAML(configs []model.Config, namespace string) (string, error) {
+	docs := make([]string, 0, len(configs))
+	for _, config := range configs {
+		config.Namespace = namespace
+		schema, ok := model.IstioConfigTypes.GetByType(config.Type)
+		if !ok {
+			return "", fmt.Errorf("unknown config type %s", config.Type)
+		}
+		object, err := crd.ConvertConfig(schema, config)
+		if err != nil {
+			return "", err
+		}
+		doc, err := yaml.Marshal(object)
+		if err != nil {
+			return "", err
+		}
+		docs = append(docs, string(doc))
+	}
+	return strings.Join(docs, "---\n"), nil
+}
+
+// DeleteAllConfigs deletes any config resources that were installed by the tests, or only
+// those installed through this environment if it comes from ForTest.
 func (e *Environment) DeleteAllConfigs() error {
 	for _, desc := range e.config.ConfigDescriptor() {
 		configs, err := e.config.List(desc.Type, e.Config.Namespace)
@@ -664,7 +1385,13 @@ func (e *Environment) DeleteAllConfigs() error {
 			return err
 		}
 		for _, config := range configs {
+			if !strings.HasPrefix(config.Name, e.configPrefix) {
+				continue
+			}
 			log.Infof("Delete config %s", config.Key())
+			if e.Config.DryRun {
+				DryRunf("delete %s %s from namespace %s", desc.Type, config.Name, config.Namespace)
+			}
 			if err = e.config.Delete(desc.Type, config.Name, config.Namespace); err != nil {
 				return err
 			}
@@ -673,6 +1400,29 @@ func (e *Environment) DeleteAllConfigs() error {
 	return nil
 }
 
+// createCertSecret creates a TLS secret in the Istio namespace holding the test key/cert, as tls.key and tls.crt.
+func (e *Environment) createCertSecret(name string) error {
+	key, err := ioutil.ReadFile(filepath.Join(e.certDir, "cert.key"))
+	if err != nil {
+		return err
+	}
+	crt, err := ioutil.ReadFile(filepath.Join(e.certDir, "cert.crt"))
+	if err != nil {
+		return err
+	}
+	_, err = e.KubeClient.CoreV1().Secrets(e.Config.IstioNamespace).Create(&v1.Secret{
+		ObjectMeta: meta_v1.ObjectMeta{Name: name},
+		Data: map[string][]byte{
+			"tls.key": key,
+			"tls.crt": crt,
+		},
+	})
+	if err != nil {
+		log.Warnf("Secret %s already exists", name)
+	}
+	return nil
+}
+
 func createWebhookCerts(service, namespace string) (caCertPEM, serverCertPEM, serverKeyPEM []byte, err error) { // nolint: lll
 	var (
 		webhookCertValidFor = 365 * 24 * time.Hour
@@ -816,7 +1566,7 @@ func (e *Environment) deleteSidecarInjector() {
 	if filledYaml, err := e.Fill("sidecar-injector.yaml.tmpl", e.ToTemplateData()); err != nil {
 		log.Infof("Sidecar injector template could not be processed, please delete stale injector webhook: %v",
 			err)
-	} else if err = e.kubeDelete(filledYaml, e.Config.IstioNamespace); err != nil {
+	} else if err = e.KubeDelete(filledYaml, e.Config.IstioNamespace); err != nil {
 		log.Infof("Sidecar injector could not be deleted: %v", err)
 	}
 }
@@ -826,7 +1576,7 @@ func (e *Environment) Fill(inFile string, values interface{}) (string, error) {
 	var out bytes.Buffer
 	w := bufio.NewWriter(&out)
 
-	tmpl, err := template.ParseFiles(e.testDataDir + inFile)
+	tmpl, err := template.ParseFiles(filepath.Join(e.testDataDir, inFile))
 	if err != nil {
 		return "", err
 	}
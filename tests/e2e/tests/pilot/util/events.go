@@ -0,0 +1,121 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"istio.io/istio/pkg/log"
+)
+
+// EventVersion is the version of the Event record format. It only changes when existing fields
+// change meaning or go away, new fields may be added within a version.
+const EventVersion = 1
+
+// EventType is the lifecycle step of a test attempt an Event reports.
+type EventType string
+
+const (
+	// EventTestStarted is emitted before the setup of a test attempt.
+	EventTestStarted EventType = "test-started"
+	// EventSetupDone is emitted once the setup of a test attempt succeeded.
+	EventSetupDone EventType = "setup-done"
+	// EventRunPassed is emitted once a test attempt passed.
+	EventRunPassed EventType = "run-passed"
+	// EventRunFailed is emitted once the setup or run of a test attempt failed, with the error.
+	EventRunFailed EventType = "run-failed"
+	// EventRunRetried is emitted in place of EventRunFailed when the failed try of an attempt
+	// is retried, with the error.
+	EventRunRetried EventType = "run-retried"
+	// EventRunSkipped is emitted once a test attempt was skipped, with the reason as error.
+	EventRunSkipped EventType = "run-skipped"
+	// EventTeardownDone is emitted once the teardown of a test attempt returned.
+	EventTeardownDone EventType = "teardown-done"
+)
+
+// Event is one record of the JSON lines output.
+type Event struct {
+	Version int       `json:"version"`
+	Time    time.Time `json:"time"`
+	Type    EventType `json:"type"`
+	Auth    string    `json:"auth"`
+	Test    string    `json:"test"`
+	Attempt int       `json:"attempt"`
+	Retry   int       `json:"retry"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// EventLog appends events to a file, one JSON object per line, as they happen. A nil EventLog
+// drops all events. It is safe for concurrent use.
+type EventLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// OpenEventLog opens the file at path for appending events, or returns a nil EventLog if path is empty.
+func OpenEventLog(path string) (*EventLog, error) {
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &EventLog{file: file}, nil
+}
+
+// Emit writes an event for the given try of an attempt of a test. Files are not buffered, so the event
+// can be read as soon as Emit returns. Failures to write are only logged.
+func (l *EventLog) Emit(typ EventType, auth, test string, attempt, retry int, err error) {
+	if l == nil {
+		return
+	}
+	event := Event{
+		Version: EventVersion,
+		Time:    time.Now().UTC(),
+		Type:    typ,
+		Auth:    auth,
+		Test:    test,
+		Attempt: attempt,
+		Retry:   retry,
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	data, marshalErr := json.Marshal(event)
+	if marshalErr != nil {
+		log.Warna(marshalErr)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, writeErr := l.file.Write(append(data, '\n')); writeErr != nil {
+		log.Warna(writeErr)
+	}
+}
+
+// Close closes the file of the event log.
+func (l *EventLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
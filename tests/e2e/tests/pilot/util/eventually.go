@@ -0,0 +1,178 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/sync/errgroup"
+
+	"istio.io/istio/pkg/log"
+)
+
+// ErrorBudget bounds how long an assertion may take to hold, as the mesh converges to the state it checks.
+// The check of the assertion returns nil once the state holds, ErrAgain while it does not yet, and any other
+// error when it fails. The assertion passes once the check succeeds Successes times in a row, and fails
+// once its failures exceed Failures, or once it ran out of attempts or time.
+type ErrorBudget struct {
+	// maximum time for the assertion to pass, none if zero
+	Timeout time.Duration
+	// maximum number of checks, none if zero
+	Attempts int
+	// time between two checks
+	Interval time.Duration
+	// failures tolerated as transient, besides the ErrAgain of a state not reached yet
+	Failures int
+	// consecutive successes for the assertion to pass, at least one
+	Successes int
+}
+
+// DefaultBudget is the budget of Parallel: 90 checks a second apart, each failure being final.
+var DefaultBudget = ErrorBudget{Attempts: budget, Interval: time.Second, Successes: 1}
+
+// Within returns the budget limited to the timeout, with no limit on the number of checks.
+func (b ErrorBudget) Within(timeout time.Duration) ErrorBudget {
+	b.Timeout, b.Attempts = timeout, 0
+	return b
+}
+
+// Attempting returns the budget limited to the number of checks, with no limit on time.
+func (b ErrorBudget) Attempting(attempts int) ErrorBudget {
+	b.Timeout, b.Attempts = 0, attempts
+	return b
+}
+
+// Consecutive returns the budget requiring the number of consecutive successes.
+func (b ErrorBudget) Consecutive(successes int) ErrorBudget {
+	b.Successes = successes
+	return b
+}
+
+// AssertionReport is the timing of an assertion checked by Eventually.
+type AssertionReport struct {
+	Name string
+	// time from the first check to the pass or the failure of the assertion
+	Duration time.Duration
+	// checks run, and those of them that failed with another error than ErrAgain
+	Attempts int
+	Failures int
+	Passed   bool
+}
+
+// assertionLog collects the reports of the assertions of an environment, until the harness takes them.
+type assertionLog struct {
+	mu      sync.Mutex
+	reports []AssertionReport
+}
+
+// Eventually checks the assertions in parallel, each within the budget, and records their timing into the
+// report of the running test. All assertions must pass for it to succeed; once one fails, the others stop.
+func (e *Environment) Eventually(budget ErrorBudget, assertions map[string]func() Status) error {
+	return eventually(budget, assertions, e.recordAssertion)
+}
+
+// TakeAssertions returns the reports of the assertions checked since the last call, in the order they
+// ended.
+func (e *Environment) TakeAssertions() []AssertionReport {
+	if e.assertions == nil {
+		return nil
+	}
+	e.assertions.mu.Lock()
+	defer e.assertions.mu.Unlock()
+	reports := e.assertions.reports
+	e.assertions.reports = nil
+	return reports
+}
+
+func (e *Environment) recordAssertion(report AssertionReport) {
+	if e.assertions == nil {
+		return
+	}
+	e.assertions.mu.Lock()
+	e.assertions.reports = append(e.assertions.reports, report)
+	e.assertions.mu.Unlock()
+}
+
+// eventually checks the assertions in parallel within the budget, passing the report of each assertion
+// that passed or failed to record, if not nil.
+func eventually(budget ErrorBudget, assertions map[string]func() Status, record func(AssertionReport)) error {
+	g, ctx := errgroup.WithContext(context.Background())
+	for name, f := range assertions {
+		name, f := name, f
+		g.Go(func() error {
+			report, err := budget.check(ctx, name, f)
+			// an assertion stopped by the failure of another one neither passed nor failed
+			if record != nil && (report.Passed || err != nil) {
+				record(report)
+			}
+			return err
+		})
+	}
+	return g.Wait()
+}
+
+// check runs f until the assertion passes or fails within the budget, or until ctx is done, in which case
+// it returns no error.
+func (b ErrorBudget) check(ctx context.Context, name string, f func() Status) (AssertionReport, error) {
+	report := AssertionReport{Name: name}
+	start := time.Now()
+	var deadline <-chan time.Time
+	if b.Timeout > 0 {
+		timer := time.NewTimer(b.Timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	successes := 0
+	for {
+		log.Infof("%s (attempt %d)", name, report.Attempts)
+		err := f()
+		report.Attempts++
+		report.Duration = time.Since(start)
+		switch err {
+		case nil:
+			successes++
+			if successes >= b.Successes {
+				report.Passed = true
+				log.Infof("%s passed after %v (%d attempts)", name, report.Duration, report.Attempts)
+				return report, nil
+			}
+		case ErrAgain:
+			successes = 0
+		default:
+			successes = 0
+			report.Failures++
+			if report.Failures > b.Failures {
+				return report, fmt.Errorf("failed %s at attempt %d: %v", name, report.Attempts-1, err)
+			}
+			log.Infof("%s failed at attempt %d (%d/%d transient failures): %v", name, report.Attempts-1,
+				report.Failures, b.Failures, err)
+		}
+		if b.Attempts > 0 && report.Attempts >= b.Attempts {
+			return report, fmt.Errorf("failed all %d attempts for %s", report.Attempts, name)
+		}
+		select {
+		case <-time.After(b.Interval):
+			// try again
+		case <-deadline:
+			report.Duration = time.Since(start)
+			return report, fmt.Errorf("failed %s within %v (%d attempts)", name, b.Timeout, report.Attempts)
+		case <-ctx.Done():
+			return report, nil
+		}
+	}
+}
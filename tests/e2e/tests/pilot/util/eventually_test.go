@@ -0,0 +1,191 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// checks returns an assertion returning the statuses in order, then the last one for good.
+func checks(statuses ...Status) func() Status {
+	var mu sync.Mutex
+	i := 0
+	return func() Status {
+		mu.Lock()
+		defer mu.Unlock()
+		status := statuses[i]
+		if i < len(statuses)-1 {
+			i++
+		}
+		return status
+	}
+}
+
+func TestErrorBudget(t *testing.T) {
+	errFailed := errors.New("failed")
+	budget := ErrorBudget{Attempts: 5, Interval: time.Millisecond, Successes: 1}
+	cases := []struct {
+		name   string
+		budget ErrorBudget
+		check  func() Status
+		want   AssertionReport
+		err    string
+	}{
+		{
+			name:   "passes at once",
+			budget: budget,
+			check:  checks(nil),
+			want:   AssertionReport{Attempts: 1, Passed: true},
+		},
+		{
+			name:   "passes once the state is reached",
+			budget: budget,
+			check:  checks(ErrAgain, ErrAgain, nil),
+			want:   AssertionReport{Attempts: 3, Passed: true},
+		},
+		{
+			name:   "fails at the first failure",
+			budget: budget,
+			check:  checks(ErrAgain, errFailed, nil),
+			want:   AssertionReport{Attempts: 2, Failures: 1},
+			err:    "failed check at attempt 1: failed",
+		},
+		{
+			name:   "tolerates transient failures",
+			budget: ErrorBudget{Attempts: 5, Interval: time.Millisecond, Failures: 2, Successes: 1},
+			check:  checks(errFailed, ErrAgain, errFailed, nil),
+			want:   AssertionReport{Attempts: 4, Failures: 2, Passed: true},
+		},
+		{
+			name:   "fails past the transient failures",
+			budget: ErrorBudget{Attempts: 5, Interval: time.Millisecond, Failures: 1, Successes: 1},
+			check:  checks(errFailed, errFailed, nil),
+			want:   AssertionReport{Attempts: 2, Failures: 2},
+			err:    "failed check at attempt 1: failed",
+		},
+		{
+			name:   "fails out of attempts",
+			budget: budget,
+			check:  checks(ErrAgain),
+			want:   AssertionReport{Attempts: 5},
+			err:    "failed all 5 attempts for check",
+		},
+		{
+			name:   "needs consecutive successes",
+			budget: budget.Consecutive(2),
+			check:  checks(nil, ErrAgain, nil, nil),
+			want:   AssertionReport{Attempts: 4, Passed: true},
+		},
+		{
+			name:   "fails without enough consecutive successes",
+			budget: budget.Consecutive(3),
+			check:  checks(nil, nil, ErrAgain, nil, nil),
+			want:   AssertionReport{Attempts: 5},
+			err:    "failed all 5 attempts for check",
+		},
+		{
+			name:   "fails out of time",
+			budget: budget.Within(20 * time.Millisecond),
+			check:  checks(ErrAgain),
+			err:    "failed check within 20ms",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var reports []AssertionReport
+			err := eventually(c.budget, map[string]func() Status{"check": c.check}, func(report AssertionReport) {
+				reports = append(reports, report)
+			})
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Fatalf("got error %v, want %q", err, c.err)
+				}
+			} else if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			if len(reports) != 1 {
+				t.Fatalf("got reports %+v, want one", reports)
+			}
+			got := reports[0]
+			if got.Name != "check" || got.Duration <= 0 {
+				t.Errorf("got report %+v, want a named and timed one", got)
+			}
+			// the number of checks within a timeout depends on the scheduling
+			if c.want.Attempts == 0 {
+				return
+			}
+			got.Name, got.Duration = "", 0
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got report %+v, want %+v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestEventually(t *testing.T) {
+	budget := ErrorBudget{Attempts: 100, Interval: time.Millisecond, Successes: 1}
+
+	var mu sync.Mutex
+	var names []string
+	record := func(report AssertionReport) {
+		mu.Lock()
+		names = append(names, report.Name)
+		mu.Unlock()
+	}
+	err := eventually(budget, map[string]func() Status{
+		"a": checks(nil),
+		"b": checks(ErrAgain, ErrAgain, nil),
+	}, record)
+	if err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Errorf("recorded %v, want both assertions", names)
+	}
+
+	// once an assertion fails, the others stop and, neither passed nor failed, are not recorded
+	names = nil
+	err = eventually(budget, map[string]func() Status{
+		"failing": checks(errors.New("failed")),
+		"pending": checks(ErrAgain),
+	}, record)
+	if err == nil || !strings.Contains(err.Error(), "failed failing at attempt 0") {
+		t.Fatalf("got error %v, want the failure of the failing assertion", err)
+	}
+	if !reflect.DeepEqual(names, []string{"failing"}) {
+		t.Errorf("recorded %v, want only the failing assertion", names)
+	}
+}
+
+func TestErrorBudgetBuilders(t *testing.T) {
+	b := DefaultBudget.Within(time.Minute)
+	if b.Timeout != time.Minute || b.Attempts != 0 || b.Interval != DefaultBudget.Interval {
+		t.Errorf("Within() got %+v", b)
+	}
+	b = b.Attempting(3)
+	if b.Timeout != 0 || b.Attempts != 3 {
+		t.Errorf("Attempting() got %+v", b)
+	}
+	if b = b.Consecutive(2); b.Successes != 2 || b.Attempts != 3 {
+		t.Errorf("Consecutive() got %+v", b)
+	}
+}
@@ -0,0 +1,282 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"flag"
+	"fmt"
+)
+
+// RegisterFlags defines the flags of the config on the flag set, each defaulting to the current value of its
+// field, so that a package running its own tests with the harness can change the defaults before parsing.
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.TestDataDir, "testdata-dir", c.TestDataDir,
+		"Directory of the templates, scenarios and golden files of the harness")
+	fs.StringVar(&c.CertDir, "cert-dir", c.CertDir,
+		"Directory of the certificate and key of the ingress")
+	fs.StringVar(&c.Hub, "hub", c.Hub, "Docker hub")
+	fs.StringVar(&c.Tag, "tag", c.Tag, "Docker tag")
+	fs.StringVar(&c.AppHub, "app-hub", c.AppHub, "Docker hub of the test app images (defaults to -hub)")
+	fs.StringVar(&c.AppTag, "app-tag", c.AppTag, "Docker tag of the test app images (defaults to -tag)")
+	fs.StringVar(&c.SidecarUpgradeTag, "sidecar-upgrade-tag", c.SidecarUpgradeTag,
+		"Docker tag of the proxy image the sidecar upgrade test rolls b to (defaults to rolling the same image)")
+	fs.StringVar(&c.BaseTag, "base-tag", c.BaseTag,
+		"Install the control plane and the sidecars from the images of this previous release tag, for the upgrade "+
+			"test to upgrade them to -tag before the other tests")
+	fs.BoolVar(&c.Downgrade, "downgrade", c.Downgrade,
+		"Roll the control plane back to -base-tag at the end of the upgrade test, the sidecars keeping -tag")
+	fs.StringVar(&c.IstioNamespace, "ns", c.IstioNamespace,
+		"Namespace in which to install Istio components (empty to create/delete temporary one)")
+	fs.StringVar(&c.Namespace, "n", c.Namespace,
+		"Namespace in which to install the applications (empty to create/delete temporary one)")
+	fs.StringVar(&c.NamespacePool, "namespace-pool", c.NamespacePool,
+		"Claim the namespaces from this pool when -n and -ns are empty, keeping Istio and the apps deployed in "+
+			"them for the next run instead of deleting them")
+	fs.StringVar(&c.Registry, "registry", c.Registry,
+		"Pilot registry, Kubernetes, Eureka or Consul, which are mirrored from the Kubernetes endpoints of the apps "+
+			"(see testdata/profiles/consul.yaml for the tests to run against Consul)")
+	fs.BoolVar(&c.UseExistingIstio, "use-existing-istio", c.UseExistingIstio,
+		"Run the tests against the control plane already running in the -ns namespace, only deploying the apps")
+	fs.StringVar(&c.IstioManifest, "istio-manifest", c.IstioManifest,
+		"Install the control plane from this manifest URL or file instead of Hub/Tag")
+	fs.StringVar(&c.InstallMethod, "install-method", c.InstallMethod,
+		fmt.Sprintf("Install the control plane from the templates of testdata (%s) or from the Helm chart (%s)",
+			InstallMethodYAML, InstallMethodHelm))
+	fs.StringVar(&c.HelmChart, "helm-chart", c.HelmChart,
+		"Istio Helm chart installed with -install-method=helm")
+	fs.StringVar(&c.ExtraManifests, "extra-manifests", c.ExtraManifests,
+		"Apply this YAML file, or the YAML files of this directory in filename order, to the app namespace before the tests")
+	fs.BoolVar(&c.CheckLogs, "logs", c.CheckLogs,
+		"Validate pod logs (expensive in long-running tests)")
+
+	fs.StringVar(&c.KubeConfig, "kubeconfig", c.KubeConfig,
+		"kube config file (missing or empty file makes the test use in-cluster kube config instead)")
+	fs.StringVar(&c.RemoteKubeConfig, "remote-kubeconfig", c.RemoteKubeConfig,
+		"kube config file of a second cluster, whose services Pilot also discovers, for the multi-cluster and "+
+			"cross-cluster tests (skipped if empty)")
+	fs.IntVar(&c.TestCount, "count", c.TestCount,
+		"Number of times to run each test, each with a fresh setup, reporting the pass rate, durations and "+
+			"common failures of the tests run more than once (flake analysis)")
+	fs.DurationVar(&c.SoakDuration, "soak-duration", c.SoakDuration,
+		"Run the selected tests over and over for this long instead of -count times, while sending traffic and "+
+			"churning route rules in the background (0 to disable)")
+	fs.DurationVar(&c.SoakWindow, "soak-window", c.SoakWindow,
+		"Length of the windows the background error rate of a -soak-duration run is reported over")
+	fs.IntVar(&c.Retries, "retries", c.Retries,
+		"Number of times a failed test attempt is retried before it is reported as failed")
+	fs.IntVar(&c.Parallel, "parallel", c.Parallel,
+		"Number of tests run at once, among those that can run concurrently (pass after -args with go test)")
+	fs.IntVar(&c.ManyRoutes, "many-routes", c.ManyRoutes, "Number of routes created by the many-routes test")
+	fs.IntVar(&c.ScaleServices, "scale-services", c.ScaleServices,
+		"Number of synthetic external services created by the scale test, which is skipped when 0")
+	fs.DurationVar(&c.ScalePushCeiling, "scale-push-ceiling", c.ScalePushCeiling,
+		"How long the services of the scale test may take to reach every sidecar")
+	fs.IntVar(&c.ScaleMemoryCeiling, "scale-memory-ceiling", c.ScaleMemoryCeiling,
+		"Memory in MiB a sidecar may allocate once it has the services of the scale test")
+	fs.IntVar(&c.ProxyCPUCeiling, "proxy-cpu-ceiling", c.ProxyCPUCeiling,
+		"CPU in millicores the proxy of a sidecar or gateway may use during a test, sampled from the metrics API "+
+			"(0 for no ceiling)")
+	fs.IntVar(&c.ProxyMemoryCeiling, "proxy-memory-ceiling", c.ProxyMemoryCeiling,
+		"Memory in MiB the proxy of a sidecar or gateway may use during a test, sampled from the metrics API "+
+			"(0 for no ceiling)")
+	fs.DurationVar(&c.ResourceInterval, "resource-interval", c.ResourceInterval,
+		"Interval between two samples of the proxies against -proxy-cpu-ceiling and -proxy-memory-ceiling")
+	fs.BoolVar(&c.ResourceWarnOnly, "resource-warn-only", c.ResourceWarnOnly,
+		"Only log the proxies over -proxy-cpu-ceiling or -proxy-memory-ceiling, without failing the test")
+	fs.DurationVar(&c.ConfigSyncTimeout, "config-sync-timeout", c.ConfigSyncTimeout,
+		"How long to wait after the setup of each test for the app pods to be ready and the proxies to have "+
+			"the config Pilot serves them, failing the setup after it (0 to run the tests right away)")
+	fs.DurationVar(&c.EndpointUpdateSLO, "endpoint-update-slo", c.EndpointUpdateSLO,
+		"How long the sidecars may keep sending new requests to a deleted pod, for the graceful-drain test")
+	fs.DurationVar(&c.DrainBlipBudget, "drain-blip-budget", c.DrainBlipBudget,
+		"How long requests may fail while a pod is deleted, for the graceful-drain test")
+	fs.IntVar(&c.LoadQPS, "load-qps", c.LoadQPS, "Rate of the requests sent by the load test")
+	fs.DurationVar(&c.LoadDuration, "load-duration", c.LoadDuration,
+		"How long the load test sends requests, at -load-qps")
+	fs.DurationVar(&c.LoadP50, "load-p50", c.LoadP50, "Median latency allowed under load")
+	fs.DurationVar(&c.LoadP99, "load-p99", c.LoadP99, "99th percentile latency allowed under load")
+	fs.Float64Var(&c.LoadErrorRate, "load-error-rate", c.LoadErrorRate,
+		"Ratio of the requests allowed to fail under load")
+	fs.IntVar(&c.PropagationRounds, "propagation-rounds", c.PropagationRounds,
+		"Number of route rule updates timed by the config propagation test")
+	fs.IntVar(&c.RateLimitRequests, "rate-limit-requests", c.RateLimitRequests,
+		"Number of requests allowed per window by the rate-limit test")
+	fs.DurationVar(&c.RateLimitWindow, "rate-limit-window", c.RateLimitWindow,
+		"Quota window of the rate-limit test")
+	fs.DurationVar(&c.ResilienceDuration, "resilience-duration", c.ResilienceDuration,
+		"How long the resilience test sends traffic while restarting pilot")
+	fs.Float64Var(&c.ResilienceRatio, "resilience-ratio", c.ResilienceRatio,
+		"Minimum share of the requests that succeed while pilot is restarted in the resilience test")
+	fs.BoolVar(&c.Mixer, "mixer", c.Mixer, "Enable / disable mixer.")
+	fs.StringVar(&c.TraceBackend, "trace-backend", c.TraceBackend,
+		fmt.Sprintf("Tracing backend collecting the spans of the proxies, when zipkin is enabled (%s or %s)",
+			TraceBackendZipkin, TraceBackendJaeger))
+	fs.Float64Var(&c.TraceSampling, "trace-sampling", c.TraceSampling,
+		"Share of the requests the proxies sample, the Envoy default of all of them unless the Istio deployment "+
+			"changes it")
+	fs.BoolVar(&c.Prometheus, "prometheus", c.Prometheus,
+		"Deploy Prometheus scraping mixer, for the metrics test")
+	fs.BoolVar(&c.V1alpha1, "v1alpha1", c.V1alpha1, "Enable / disable v1alpha1 routing rules.")
+	fs.BoolVar(&c.V1alpha2, "v1alpha2", c.V1alpha2, "Enable / disable v1alpha2 routing rules.")
+	fs.BoolVar(&c.RoutingParity, "routing-parity", c.RoutingParity,
+		"Run the routing scenarios under both v1alpha1 and v1alpha2 and report the divergences, with -v1alpha1")
+	fs.BoolVar(&c.RDSv2, "rdsv2", false, "Enable RDSv2 for v1alpha2")
+	fs.BoolVar(&c.NoRBAC, "norbac", false, "Disable RBAC YAML")
+	fs.StringVar(&c.StreamLogsDir, "stream-logs-dir", c.StreamLogsDir,
+		"Write the logs of all containers of the test namespaces to rotating files in this directory as they "+
+			"come, keeping the logs of the containers restarted during the run")
+	fs.StringVar(&c.ErrorLogsDir, "errorlogsdir", c.ErrorLogsDir,
+		"Store per pod logs as individual files in specific directory instead of writing to stderr, "+
+			"and the config_dump, clusters and stats of every sidecar when a test fails.")
+	fs.StringVar(&c.CoreFilesDir, "core-files-dir", c.CoreFilesDir,
+		"Copy core files to this directory on the Kubernetes node machine.")
+
+	fs.BoolVar(&c.FailOnSkip, "fail-on-skip", c.FailOnSkip,
+		"Fail the run if any test is skipped, listing why")
+	fs.BoolVar(&c.Benchmark, "benchmark", c.Benchmark,
+		"Measure request latency percentiles in the tests that support it instead of checking behavior")
+	fs.StringVar(&c.BenchmarkFile, "benchmark-file", c.BenchmarkFile,
+		"File the latency percentiles measured with -benchmark are written to, as JSON")
+
+	// If specified, only run these tests
+	fs.StringVar(&c.SelectedTest, "testtype", c.SelectedTest,
+		"Select the comma-separated tests to run (default is all tests)")
+	fs.StringVar(&c.TestFilter, "testfilter", c.TestFilter,
+		"Only run the tests whose name matches this regular expression, or does not match it if prefixed with !")
+	fs.StringVar(&c.Labels, "labels", c.Labels,
+		"Only run the tests with one of these comma-separated labels, e.g. routing,security, and none of those "+
+			"prefixed with !, e.g. !slow (default is all tests)")
+	// the categories of the tests, such as smoke, are labels now
+	fs.Var(labelsAlias{&c.Labels}, "category", "Alias of -labels, e.g. -category smoke")
+
+	fs.BoolVar(&c.UseAutomaticInjection, "use-sidecar-injector", c.UseAutomaticInjection,
+		"Use automatic sidecar injector")
+	fs.BoolVar(&c.UseAdmissionWebhook, "use-admission-webhook", c.UseAdmissionWebhook,
+		"Use k8s external admission webhook for config validation")
+
+	fs.StringVar(&c.AdmissionServiceName, "admission-service-name", c.AdmissionServiceName,
+		"Name of admission webhook service name")
+
+	fs.IntVar(&c.DebugPort, "debugport", c.DebugPort, "Debugging port")
+	fs.StringVar(&c.PprofDir, "pprof-dir", c.PprofDir,
+		"Write pilot CPU and heap profiles taken from the debug port around the routing tests to this directory")
+	fs.DurationVar(&c.PprofInterval, "pprof-interval", c.PprofInterval,
+		"Also take pilot CPU and heap profiles this often during the whole run, written to -pprof-dir or "+
+			"the pprof directory of -report-dir (0 to disable)")
+
+	fs.BoolVar(&c.DebugImagesAndMode, "debug", c.DebugImagesAndMode,
+		"Use debug images and mode (false for prod)")
+	fs.BoolVar(&c.SkipCleanup, "skip-cleanup", c.SkipCleanup,
+		"Debug, skip clean up")
+	fs.BoolVar(&c.SkipCleanupOnFailure, "skip-cleanup-on-failure", c.SkipCleanupOnFailure,
+		"Debug, skip clean up on failure")
+	fs.BoolVar(&c.CapturePcap, "capture-pcap", c.CapturePcap,
+		"Capture the packets of the sidecars during the run of each test, written under -errorlogsdir when it fails")
+	fs.BoolVar(&c.PortForward, "port-forward", c.PortForward,
+		"Make the requests of the tests to the sidecar admin ports and to Pilot through kubectl port-forwards "+
+			"rather than kubectl exec")
+	fs.BoolVar(&c.PauseOnFailure, "pause-on-failure", c.PauseOnFailure,
+		"When a test fails, print how to inspect its environment and wait for enter before its teardown")
+	fs.BoolVar(&c.DryRun, "dry-run", c.DryRun,
+		"Print the resources the environment and the test setups and teardowns would apply and delete, "+
+			"with their YAML, without touching the clusters or running the tests")
+	fs.DurationVar(&c.SuiteDeadline, "suite-deadline", c.SuiteDeadline,
+		"Abort the whole run, tearing down all environments, once it has taken this long (0 for no deadline)")
+	fs.DurationVar(&c.TestDeadline, "test-deadline", c.TestDeadline,
+		"Fail a test whose setup, run and retries take longer than this, dumping diagnostics (0 for no deadline)")
+	fs.DurationVar(&c.SetupDeadline, "setup-deadline", c.SetupDeadline,
+		"Fail a try of a test whose setup takes longer than this (0 for no deadline)")
+	fs.DurationVar(&c.RunDeadline, "run-deadline", c.RunDeadline,
+		"Fail a try of a test whose run takes longer than this (0 for no deadline)")
+	fs.DurationVar(&c.TeardownDeadline, "teardown-deadline", c.TeardownDeadline,
+		"Fail a test whose teardown takes longer than this, moving on to the next test (0 for no deadline)")
+	fs.StringVar(&c.TestLeakCheck, "test-leak-check", c.TestLeakCheck,
+		fmt.Sprintf("Look for the configs a test leaves after its teardown, to %s about them, %s the test or not (%s)",
+			LeakCheckWarn, LeakCheckFail, LeakCheckOff))
+	fs.BoolVar(&c.VerifyCleanup, "verify-cleanup", c.VerifyCleanup,
+		"Fail the run if the resources removed on teardown are not gone within -verify-cleanup-timeout")
+	fs.DurationVar(&c.CleanupTimeout, "verify-cleanup-timeout", c.CleanupTimeout,
+		"How long to wait for the resources removed on teardown to be gone")
+	fs.DurationVar(&c.DrainWait, "drain-wait", c.DrainWait,
+		"How long requests stay in flight after their backend is deleted in the graceful drain test (below 25s)")
+	fs.DurationVar(&c.RequestTimeout, "request-timeout", c.RequestTimeout,
+		"Route timeout applied by the request timeout test")
+	fs.StringVar(&c.ReportDir, "report-dir", c.ReportDir,
+		"Write JUnit XML and JSON reports of all test attempts to this directory when all tests are done")
+	fs.StringVar(&c.ArtifactsDir, "artifacts-dir", c.ArtifactsDir,
+		"Package the report, pod logs, sidecar diagnostics, profiles, applied YAML and metadata of the run "+
+			"into one timestamped tar.gz in this directory when all tests are done, staging there the outputs "+
+			"whose directory is not set")
+	fs.StringVar(&c.AppliedYAMLDir, "applied-yaml-dir", c.AppliedYAMLDir,
+		"Append the YAML applied by the run to one file per namespace in this directory")
+	fs.StringVar(&c.CoverageDir, "coverage-dir", c.CoverageDir,
+		"Run the pilot binary of make pilot-discovery-coverage, and write its coverage profiles to this "+
+			"directory on teardown, merged into coverage.out when all tests are done")
+	fs.StringVar(&c.StateFile, "state-file", c.StateFile,
+		"Record the tests that pass to this file, for a later run of the same environment to -resume")
+	fs.BoolVar(&c.Resume, "resume", c.Resume,
+		"Skip the tests that passed in the last runs of the same environment, recorded to -state-file")
+	fs.StringVar(&c.ImagePullPolicy, "image-pull-policy", c.ImagePullPolicy,
+		"Image pull policy of all the containers deployed, Always, IfNotPresent or Never "+
+			"(empty to keep those of the templates and manifests)")
+	fs.StringVar(&c.ImagePullSecret, "image-pull-secret", c.ImagePullSecret,
+		"Docker registry secret, as namespace/name (in the default namespace if no namespace), copied to the "+
+			"Istio and app namespaces and used by all the pods deployed to pull their images")
+	fs.BoolVar(&c.ForceLock, "force", c.ForceLock,
+		"Take over the lease of the Istio namespace from the run holding it, if any")
+	fs.DurationVar(&c.LockTTL, "lock-ttl", c.LockTTL,
+		"How long the lease of a given Istio namespace lasts without being renewed, such as after a killed run, "+
+			"before another run may take it")
+	fs.StringVar(&c.RunID, "run-id", c.RunID,
+		"ID of the run, which the resources it applies carry in their "+RunLabel+" label (generated if empty)")
+	fs.StringVar(&c.CleanupRun, "cleanup-run", c.CleanupRun,
+		"Delete the resources labeled with this run ID, such as those left by an aborted run or by -skip-cleanup, "+
+			"then exit without running the tests")
+	fs.StringVar(&c.CleanupTest, "cleanup-test", c.CleanupTest,
+		"With -cleanup-run, only delete the resources applied during this test")
+	fs.StringVar(&c.JSONLOutput, "jsonl-output", c.JSONLOutput,
+		"Append one JSON object per test lifecycle event to this file as the run progresses")
+	fs.DurationVar(&c.RequestSleep, "request-sleep", c.RequestSleep,
+		"How long the backend takes to answer in the request timeout test, must exceed -request-timeout")
+	fs.StringVar(&c.IPFamily, "ip-family", c.IPFamily,
+		fmt.Sprintf("IP family of the cluster (%s, %s or %s), which the interception ranges and the addresses the "+
+			"tests send requests to follow. In a dual-stack cluster, the service of b gets an IPv6 cluster IP",
+			IPFamilyIPv4, IPFamilyIPv6, IPFamilyDual))
+	fs.IntVar(&c.WeightSamples, "weight-samples", c.WeightSamples,
+		"Also check the 90/10 and 50/50 weighted routes of the routing test over this many requests (0 to skip)")
+	fs.Float64Var(&c.WeightTolerance, "weight-tolerance", c.WeightTolerance,
+		"Largest difference between the observed and configured share of each version in the weighted routes")
+	fs.BoolVar(&c.Golden, "golden", c.Golden,
+		"Compare the routes and clusters pilot serves to the sidecar of a after each config with testdata/golden")
+	fs.BoolVar(&c.UpdateGolden, "update-golden", c.UpdateGolden,
+		"Write the routes and clusters pilot serves after each config to testdata/golden instead of comparing them")
+}
+
+// labelsAlias is the value of a flag setting the comma-separated labels of the config, like -labels.
+type labelsAlias struct {
+	labels *string
+}
+
+func (a labelsAlias) String() string {
+	if a.labels == nil {
+		return ""
+	}
+	return *a.labels
+}
+
+func (a labelsAlias) Set(value string) error {
+	*a.labels = value
+	return nil
+}
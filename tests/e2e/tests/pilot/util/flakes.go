@@ -0,0 +1,128 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	// podSuffixRegex matches the generated suffixes of the pod names in the error messages, such as
+	// -5d4f8b9c6-xk2lp in a-v1-5d4f8b9c6-xk2lp.
+	podSuffixRegex = regexp.MustCompile(`-[0-9a-z]{6,10}-[0-9a-z]{5}\b`)
+	// numberRegex matches the numbers in the error messages, such as counts, durations and addresses.
+	numberRegex = regexp.MustCompile(`[0-9]+(\.[0-9]+)*`)
+)
+
+// FlakeSummary summarizes the attempts of a test run several times with -count, to tell how flaky
+// it is.
+type FlakeSummary struct {
+	Attempts int `json:"attempts"`
+	Passed   int `json:"passed"`
+	Flaky    int `json:"flaky"`
+	Failed   int `json:"failed"`
+	Skipped  int `json:"skipped"`
+	// share of the attempts that were not skipped which passed on their first try
+	PassRate float64 `json:"pass_rate"`
+	// time spent in the attempts that were not skipped
+	MeanDuration float64      `json:"mean_ms"`
+	Duration     Distribution `json:"duration"`
+	// errors of the failed tries, retried or not, by decreasing count
+	Failures []FailureSignature `json:"failure_signatures,omitempty"`
+}
+
+// FailureSignature counts the failed tries with the same error, once the pod names and numbers are
+// masked out of it.
+type FailureSignature struct {
+	Signature string `json:"signature"`
+	Count     int    `json:"count"`
+}
+
+// Summary returns the flake summary of the attempts of the test.
+func (t *TestReport) Summary() FlakeSummary {
+	s := FlakeSummary{Attempts: len(t.Attempts)}
+	var durations []time.Duration
+	signatures := make(map[string]int)
+	for _, attempt := range t.Attempts {
+		switch attempt.Outcome {
+		case OutcomeSkipped:
+			s.Skipped++
+			continue
+		case OutcomePassed:
+			s.Passed++
+		case OutcomeFlaky:
+			s.Flaky++
+		case OutcomeFailed:
+			s.Failed++
+			signatures[failureSignature(attempt.Message)]++
+		}
+		for _, failure := range attempt.RetryFailures {
+			signatures[failureSignature(failure)]++
+		}
+		durations = append(durations, attempt.Duration())
+	}
+	if len(durations) == 0 {
+		return s
+	}
+	s.PassRate = float64(s.Passed) / float64(len(durations))
+	var total time.Duration
+	for _, d := range durations {
+		total += d
+	}
+	s.MeanDuration = float64(total/time.Duration(len(durations))) / float64(time.Millisecond)
+	s.Duration = NewDistribution(durations)
+	for signature, count := range signatures {
+		s.Failures = append(s.Failures, FailureSignature{Signature: signature, Count: count})
+	}
+	sort.Slice(s.Failures, func(i, j int) bool {
+		if s.Failures[i].Count != s.Failures[j].Count {
+			return s.Failures[i].Count > s.Failures[j].Count
+		}
+		return s.Failures[i].Signature < s.Failures[j].Signature
+	})
+	return s
+}
+
+// failureSignature returns the first line of the error message, with the pod suffixes and numbers
+// masked out, so that the same failure of different attempts has the same signature.
+func failureSignature(message string) string {
+	signature := podSuffixRegex.ReplaceAllString(firstLine(message), "-*")
+	return numberRegex.ReplaceAllString(signature, "N")
+}
+
+// PrintFlakes writes a table of the flake summary of every test attempted more than once, with its
+// most common failure.
+func (r *Reports) PrintFlakes(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "AUTH\tTEST\tATTEMPTS\tPASS RATE\tFLAKY\tFAILED\tP50\tP90\tTOP FAILURE")
+	for _, test := range r.Tests() {
+		if len(test.Attempts) < 2 {
+			continue
+		}
+		s := test.Summary()
+		top := ""
+		if len(s.Failures) > 0 {
+			top = fmt.Sprintf("%s (%d)", s.Failures[0].Signature, s.Failures[0].Count)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%.1f%%\t%d\t%d\t%.0fms\t%.0fms\t%s\n", test.Auth, test.Test, s.Attempts,
+			100*s.PassRate, s.Flaky, s.Failed, s.Duration.P50, s.Duration.P90, top)
+	}
+	return w.Flush()
+}
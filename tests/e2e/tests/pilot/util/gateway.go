@@ -0,0 +1,83 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"sort"
+	"time"
+
+	"k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// deployment of the standalone gateway proxy, deployed in the Istio namespace
+	gatewayDeployment = "istio-gateway"
+	gatewaySelector   = "app=gateway"
+)
+
+// ScaleGateway sets the number of replicas of the gateway, and waits for them to be available.
+func (e *Environment) ScaleGateway(replicas int32) error {
+	deployments := e.KubeClient.ExtensionsV1beta1().Deployments(e.Config.IstioNamespace)
+	deployment, err := deployments.Get(gatewayDeployment, meta_v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	deployment.Spec.Replicas = &replicas
+	if _, err = deployments.Update(deployment); err != nil {
+		return err
+	}
+	return e.WaitForGatewayRollout()
+}
+
+// RollGateway replaces the pods of the gateway with a rolling update of the deployment. It does not
+// wait for the rollout, see WaitForGatewayRollout.
+func (e *Environment) RollGateway() error {
+	deployments := e.KubeClient.ExtensionsV1beta1().Deployments(e.Config.IstioNamespace)
+	deployment, err := deployments.Get(gatewayDeployment, meta_v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	template := &deployment.Spec.Template
+	if template.Annotations == nil {
+		template.Annotations = make(map[string]string)
+	}
+	template.Annotations[rolloutAnnotation] = time.Now().UTC().Format(time.RFC3339Nano)
+	_, err = deployments.Update(deployment)
+	return err
+}
+
+// WaitForGatewayRollout waits for the gateway to run only available pods of its latest template.
+func (e *Environment) WaitForGatewayRollout() error {
+	return e.waitForRollout(e.Config.IstioNamespace, []string{gatewayDeployment})
+}
+
+// GatewayPods returns the ready pods of the gateway, leaving out the terminating ones, sorted by name.
+func (e *Environment) GatewayPods() ([]v1.Pod, error) {
+	pods, err := e.KubeClient.CoreV1().Pods(e.Config.IstioNamespace).List(meta_v1.ListOptions{
+		LabelSelector: gatewaySelector,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var ready []v1.Pod
+	for i := range pods.Items {
+		if pod := &pods.Items[i]; pod.DeletionTimestamp == nil && podReady(pod) {
+			ready = append(ready, *pod)
+		}
+	}
+	sort.Slice(ready, func(i, j int) bool { return ready[i].Name < ready[j].Name })
+	return ready, nil
+}
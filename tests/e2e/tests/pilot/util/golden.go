@@ -0,0 +1,203 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	networking "istio.io/api/networking/v1alpha3"
+	routing "istio.io/api/routing/v1alpha1"
+	"istio.io/istio/pilot/pkg/config/kube/crd"
+	"istio.io/istio/pilot/pkg/model"
+	envoyv1 "istio.io/istio/pilot/pkg/proxy/envoy/v1"
+	"istio.io/istio/pilot/test/util"
+	"istio.io/istio/pkg/log"
+)
+
+const (
+	// directory of the golden files under testdata, with a subdirectory per auth mode
+	goldenDir = "golden"
+	// sidecar whose routes and clusters are compared, on the HTTP port most configs route
+	goldenApp  = "a"
+	goldenPort = 80
+)
+
+// ipRex matches the IP addresses of the pods and services, which change from a run to the next.
+var ipRex = regexp.MustCompile(`\b\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3}\b`)
+
+// goldenSnapshot is the part of the config Pilot serves to a sidecar that a config changes: the virtual
+// hosts of the services the config is about, and the clusters their routes send traffic to.
+type goldenSnapshot struct {
+	VirtualHosts []*envoyv1.VirtualHost `json:"virtual_hosts"`
+	Clusters     []*envoyv1.Cluster     `json:"clusters"`
+}
+
+// compareGolden compares the routes and clusters Pilot serves to the sidecar of goldenApp for the services
+// of the applied config with its golden file, or writes the golden file with -update-golden. The sidecars
+// of this version take their config from the v1 discovery API and do not all serve a config_dump, so
+// the config is read from the debug views of Pilot, which serve the same JSON.
+func (e *Environment) compareGolden(inFile string, data interface{}) error {
+	config, err := e.Fill(inFile, data)
+	if err != nil {
+		return err
+	}
+	configs, _, err := crd.ParseInputs(config)
+	if err != nil {
+		return err
+	}
+	return e.compareGoldenConfigs(inFile, e.goldenFile(inFile, data), configs)
+}
+
+// compareGoldenConfigs compares the routes and clusters Pilot serves for the services of the configs,
+// applied from inFile, with the golden file.
+func (e *Environment) compareGoldenConfigs(inFile, file string, configs []model.Config) error {
+	hosts := configHosts(configs)
+	if len(hosts) == 0 {
+		return nil
+	}
+	snapshot, err := e.goldenSnapshot(hosts)
+	if err != nil {
+		return err
+	}
+
+	if e.Config.UpdateGolden {
+		log.Infof("Updating the golden file %s", file)
+		if err = os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(file, snapshot, 0644)
+	}
+	golden, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("cannot read the golden file of %s, see -update-golden: %v", inFile, err)
+	}
+	if err = util.Compare(snapshot, golden); err != nil {
+		return fmt.Errorf("the config Pilot serves to %s for %s differs from %s:\n%v", goldenApp, inFile, file, err)
+	}
+	return nil
+}
+
+// goldenFile returns the golden file of the config template filled with the data. Templates filled with
+// different data have different golden files.
+func (e *Environment) goldenFile(inFile string, data interface{}) string {
+	name := strings.TrimSuffix(strings.TrimSuffix(inFile, ".tmpl"), ".yaml")
+	name = strings.Replace(name, "/", "-", -1)
+	if data != nil {
+		sum := sha256.Sum256([]byte(e.normalizeGolden(fmt.Sprintf("%v", data))))
+		name = fmt.Sprintf("%s-%x", name, sum[:4])
+	}
+	return filepath.Join(e.testDataDir, goldenDir, strings.ToLower(e.Auth.String()), name+".json")
+}
+
+// goldenSnapshot returns the normalized JSON of the virtual hosts of the sidecar of goldenApp for the
+// hosts, with the clusters they route to.
+func (e *Environment) goldenSnapshot(hosts []string) ([]byte, error) {
+	routes, err := e.PilotRoutes(goldenApp, goldenPort)
+	if err != nil {
+		return nil, err
+	}
+	clusters, err := e.PilotClusters(goldenApp)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot goldenSnapshot
+	names := make(map[string]bool)
+	for _, host := range routes.VirtualHosts {
+		if !matchesAny(host.Domains, hosts) {
+			continue
+		}
+		snapshot.VirtualHosts = append(snapshot.VirtualHosts, host)
+		for _, route := range host.Routes {
+			names[route.Cluster] = true
+			if route.WeightedClusters != nil {
+				for _, weighted := range route.WeightedClusters.Clusters {
+					names[weighted.Name] = true
+				}
+			}
+		}
+	}
+	sort.Slice(snapshot.VirtualHosts, func(i, j int) bool {
+		return snapshot.VirtualHosts[i].Name < snapshot.VirtualHosts[j].Name
+	})
+	for name := range names {
+		if cluster, ok := clusters[name]; ok {
+			snapshot.Clusters = append(snapshot.Clusters, cluster)
+		}
+	}
+	sort.Slice(snapshot.Clusters, func(i, j int) bool { return snapshot.Clusters[i].Name < snapshot.Clusters[j].Name })
+
+	out, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return []byte(e.normalizeGolden(string(out)) + "\n"), nil
+}
+
+// normalizeGolden replaces the parts of the config that change from a run to the next: the namespaces,
+// the prefix of the config names and the IP addresses.
+func (e *Environment) normalizeGolden(text string) string {
+	if e.configPrefix != "" {
+		text = strings.Replace(text, e.configPrefix, "", -1)
+	}
+	// the longer namespace first, in case it contains the other one
+	namespaces := [][2]string{{e.Config.IstioNamespace, "ISTIO_NAMESPACE"}, {e.Config.Namespace, "NAMESPACE"}}
+	if len(e.Config.Namespace) > len(e.Config.IstioNamespace) {
+		namespaces[0], namespaces[1] = namespaces[1], namespaces[0]
+	}
+	for _, namespace := range namespaces {
+		if namespace[0] != "" {
+			text = strings.Replace(text, namespace[0], namespace[1], -1)
+		}
+	}
+	return ipRex.ReplaceAllString(text, "IP")
+}
+
+// configHosts returns the services the route rules, virtual services and destination rules of the configs
+// are about.
+func configHosts(configs []model.Config) []string {
+	var hosts []string
+	for _, c := range configs {
+		switch spec := c.Spec.(type) {
+		case *routing.RouteRule:
+			if spec.Destination != nil {
+				hosts = append(hosts, spec.Destination.Name)
+			}
+		case *networking.VirtualService:
+			hosts = append(hosts, spec.Hosts...)
+		case *networking.DestinationRule:
+			hosts = append(hosts, spec.Name)
+		}
+	}
+	return hosts
+}
+
+// matchesAny returns true if one of the domains is one of the hosts.
+func matchesAny(domains, hosts []string) bool {
+	for _, host := range hosts {
+		if containsString(domains, host) {
+			return true
+		}
+	}
+	return false
+}
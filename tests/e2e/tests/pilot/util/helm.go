@@ -0,0 +1,86 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"istio.io/istio/pkg/log"
+)
+
+// helmRelease is the name of the release the chart is rendered for, in the labels of its objects.
+const helmRelease = "istio"
+
+// helmValues returns the values overriding the defaults of the chart for the configuration of the run.
+// The sidecar injector, Zipkin and Prometheus are left out, Setup deploying them like with the templates.
+// The control plane always uses mutual TLS, the chart tying it to Citadel.
+func (e *Environment) helmValues() []string {
+	proxyImage := "proxy"
+	if e.Config.DebugImagesAndMode {
+		proxyImage = "proxy_debug"
+	}
+	values := []string{
+		"global.hub=" + e.Config.Hub,
+		"global.proxy.image=" + proxyImage,
+		fmt.Sprintf("global.mtls.enabled=%t", e.Config.Auth),
+		fmt.Sprintf("global.rbacEnabled=%t", !e.Config.NoRBAC),
+		fmt.Sprintf("mixer.enabled=%t", e.Config.Mixer),
+		fmt.Sprintf("ingress.enabled=%t", e.Config.Ingress),
+		"sidecar-injector.enabled=false",
+		"zipkin.enabled=false",
+		"prometheus.enabled=false",
+	}
+	if tag := e.Config.InstallTag(); tag != "" {
+		values = append(values, "global.tag="+tag)
+	}
+	return values
+}
+
+// renderHelmChart renders the chart with helm template, for the Istio namespace.
+func (e *Environment) renderHelmChart() (string, error) {
+	args := []string{"template", e.Config.HelmChart, "--name", helmRelease, "--namespace", e.Config.IstioNamespace}
+	for _, value := range e.helmValues() {
+		args = append(args, "--set", value)
+	}
+	log.Infof("Running helm %s", strings.Join(args, " "))
+	// the warnings of helm go to stderr, apart from the manifest
+	out, err := exec.Command("helm", args...).Output() // #nosec
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return "", fmt.Errorf("cannot render Helm chart %s: %v: %s", e.Config.HelmChart, err, exitErr.Stderr)
+		}
+		return "", fmt.Errorf("cannot render Helm chart %s: %v", e.Config.HelmChart, err)
+	}
+	return string(out), nil
+}
+
+// deployHelmChart installs the control plane from the rendered chart, which is then deleted at teardown
+// like a manifest of -istio-manifest.
+func (e *Environment) deployHelmChart() error {
+	manifest, err := e.renderHelmChart()
+	if err != nil {
+		return err
+	}
+	if err = e.validateManifest(e.Config.HelmChart, manifest); err != nil {
+		return err
+	}
+	if err = e.KubeApply(manifest, e.Config.IstioNamespace); err != nil {
+		return err
+	}
+	e.istioManifest = manifest
+	return nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"sync"
+)
+
+// Hooks are callbacks following the progress of the suite in an environment, for tooling such as a
+// dashboard showing the status of a long run live. Nil callbacks are ignored. The callbacks are
+// called synchronously from the goroutine of the environment or of the test, possibly concurrently
+// when tests run in parallel, so they must be safe for concurrent use and return quickly.
+type Hooks struct {
+	// OnSetupStart is called when the environment starts deploying the infrastructure.
+	OnSetupStart func(env *Environment)
+	// OnTestFinish is called with the report of every test attempt once its outcome is known.
+	OnTestFinish func(env *Environment, report AttemptReport)
+	// OnTeardown is called when the environment starts tearing down the infrastructure.
+	OnTeardown func(env *Environment)
+	// OnError is called with the error of a failed setup of the environment, test name empty, or of
+	// a failed try of a test, retried or not.
+	OnError func(env *Environment, test string, err error)
+}
+
+// registered are the hooks of RegisterHooks, added to every new environment.
+var registered struct {
+	sync.Mutex
+	hooks []Hooks
+}
+
+// RegisterHooks adds the hooks to the environments created after it returns. Tooling built into the
+// test binary calls it from an init function, before the environments are created.
+func RegisterHooks(hooks Hooks) {
+	registered.Lock()
+	defer registered.Unlock()
+	registered.hooks = append(registered.hooks, hooks)
+}
+
+// registeredHooks returns a copy of the hooks of RegisterHooks.
+func registeredHooks() []Hooks {
+	registered.Lock()
+	defer registered.Unlock()
+	return append([]Hooks(nil), registered.hooks...)
+}
+
+// AddHooks adds the hooks to the environment and to the environments ForTest returns afterwards.
+func (e *Environment) AddHooks(hooks Hooks) {
+	e.hooks = append(e.hooks, hooks)
+}
+
+// NotifyTestFinish calls the OnTestFinish hooks with the report of a finished test attempt.
+func (e *Environment) NotifyTestFinish(report AttemptReport) {
+	for _, h := range e.hooks {
+		if h.OnTestFinish != nil {
+			h.OnTestFinish(e, report)
+		}
+	}
+}
+
+// NotifyError calls the OnError hooks with the error of the setup of the environment, if the test is
+// empty, or of a try of the test.
+func (e *Environment) NotifyError(test string, err error) {
+	for _, h := range e.hooks {
+		if h.OnError != nil {
+			h.OnError(e, test, err)
+		}
+	}
+}
+
+func (e *Environment) notifySetupStart() {
+	for _, h := range e.hooks {
+		if h.OnSetupStart != nil {
+			h.OnSetupStart(e)
+		}
+	}
+}
+
+func (e *Environment) notifyTeardown() {
+	for _, h := range e.hooks {
+		if h.OnTeardown != nil {
+			h.OnTeardown(e)
+		}
+	}
+}
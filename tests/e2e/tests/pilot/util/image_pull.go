@@ -0,0 +1,120 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/istio/pkg/log"
+)
+
+// validateImagePullPolicy returns an error if the policy is not one of Kubernetes, empty keeping the policies
+// of the YAML.
+func validateImagePullPolicy(policy string) error {
+	switch v1.PullPolicy(policy) {
+	case "", v1.PullAlways, v1.PullIfNotPresent, v1.PullNever:
+		return nil
+	}
+	return fmt.Errorf("unknown image pull policy %q, want %s, %s or %s", policy, v1.PullAlways, v1.PullIfNotPresent,
+		v1.PullNever)
+}
+
+// imagePullSecret returns the namespace and the name of the secret of -image-pull-secret, in the default
+// namespace unless it is given as namespace/name.
+func (c *Config) imagePullSecret() (namespace, name string) {
+	if i := strings.Index(c.ImagePullSecret, "/"); i >= 0 {
+		return c.ImagePullSecret[:i], c.ImagePullSecret[i+1:]
+	}
+	return meta_v1.NamespaceDefault, c.ImagePullSecret
+}
+
+// copyImagePullSecret copies the secret of -image-pull-secret to the namespace, for its pods to pull their
+// images with it.
+func (e *Environment) copyImagePullSecret(namespace string) error {
+	if e.Config.ImagePullSecret == "" {
+		return nil
+	}
+	sourceNamespace, name := e.Config.imagePullSecret()
+	if namespace == sourceNamespace {
+		return nil
+	}
+	source, err := e.KubeClient.CoreV1().Secrets(sourceNamespace).Get(name, meta_v1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("cannot get the image pull secret %s: %v", e.Config.ImagePullSecret, err)
+	}
+	secret := &v1.Secret{
+		ObjectMeta: meta_v1.ObjectMeta{Name: name, Labels: e.resourceLabels()},
+		Type:       source.Type,
+		Data:       source.Data,
+	}
+	log.Infof("Copy the image pull secret %s to namespace %s", e.Config.ImagePullSecret, namespace)
+	secrets := e.KubeClient.CoreV1().Secrets(namespace)
+	if _, err = secrets.Create(secret); errors.IsAlreadyExists(err) {
+		_, err = secrets.Update(secret)
+	}
+	return err
+}
+
+// setImagePull sets the image pull policy of the config on the containers of the pod or of the pod template
+// of the resource, and adds the image pull secret to its pod spec. Resources without pods are left as is.
+func (e *Environment) setImagePull(object map[string]interface{}) {
+	spec := podSpec(object)
+	if spec == nil {
+		return
+	}
+	if policy := e.Config.ImagePullPolicy; policy != "" {
+		for _, key := range []string{"initContainers", "containers"} {
+			containers, _ := spec[key].([]interface{})
+			for _, container := range containers {
+				if container, ok := container.(map[string]interface{}); ok {
+					container["imagePullPolicy"] = policy
+				}
+			}
+		}
+	}
+	if e.Config.ImagePullSecret == "" {
+		return
+	}
+	_, name := e.Config.imagePullSecret()
+	secrets, _ := spec["imagePullSecrets"].([]interface{})
+	for _, secret := range secrets {
+		if secret, ok := secret.(map[string]interface{}); ok && secret["name"] == name {
+			return
+		}
+	}
+	spec["imagePullSecrets"] = append(secrets, map[string]interface{}{"name": name})
+}
+
+// podSpec returns the spec of the pod or of the pod template of the resource, nil for other kinds.
+func podSpec(object map[string]interface{}) map[string]interface{} {
+	spec, _ := object["spec"].(map[string]interface{})
+	if spec == nil {
+		return nil
+	}
+	switch object["kind"] {
+	case "Pod":
+		return spec
+	case "Deployment", "StatefulSet", "DaemonSet", "ReplicaSet", "ReplicationController", "Job":
+		template, _ := spec["template"].(map[string]interface{})
+		podSpec, _ := template["spec"].(map[string]interface{})
+		return podSpec
+	}
+	return nil
+}
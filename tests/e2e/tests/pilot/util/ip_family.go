@@ -0,0 +1,74 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"net"
+)
+
+const (
+	// IPFamilyIPv4 is an IPv4-only cluster, the default
+	IPFamilyIPv4 = "ipv4"
+	// IPFamilyIPv6 is an IPv6-only cluster, whose pods and services only have IPv6 addresses
+	IPFamilyIPv6 = "ipv6"
+	// IPFamilyDual is a dual-stack cluster, whose pods have both families and whose services have the
+	// IPv4 cluster IPs unless they ask for IPv6, as the service of b does
+	IPFamilyDual = "dual"
+
+	// dualStackIPv6App is the app whose service asks for an IPv6 cluster IP in a dual-stack cluster
+	dualStackIPv6App = "b"
+)
+
+// HasIPv4 returns whether the cluster of the run has IPv4 addresses.
+func (c *Config) HasIPv4() bool {
+	return c.IPFamily != IPFamilyIPv6
+}
+
+// HasIPv6 returns whether the cluster of the run has IPv6 addresses.
+func (c *Config) HasIPv6() bool {
+	return c.IPFamily == IPFamilyIPv6 || c.IPFamily == IPFamilyDual
+}
+
+// serviceIPFamily returns the ipFamily of the service of the app, empty for the default family of the
+// cluster.
+func (c *Config) serviceIPFamily(app string) string {
+	if c.IPFamily == IPFamilyDual && app == dualStackIPv6App {
+		return "IPv6"
+	}
+	return ""
+}
+
+func validateIPFamily(family string) error {
+	switch family {
+	case IPFamilyIPv4, IPFamilyIPv6, IPFamilyDual:
+		return nil
+	}
+	return fmt.Errorf("unknown IP family %q, want %s, %s or %s", family, IPFamilyIPv4, IPFamilyIPv6, IPFamilyDual)
+}
+
+// IsIPv6 returns whether the address is an IPv6 one.
+func IsIPv6(addr string) bool {
+	ip := net.ParseIP(addr)
+	return ip != nil && ip.To4() == nil
+}
+
+// HostCIDR returns the range of the address alone, /32 for IPv4 and /128 for IPv6.
+func HostCIDR(addr string) string {
+	if IsIPv6(addr) {
+		return addr + "/128"
+	}
+	return addr + "/32"
+}
@@ -0,0 +1,96 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"time"
+)
+
+const (
+	// size of the RSA key the test tokens are signed with
+	jwtKeyBits = 2048
+	// key ID of the signing key in the JWKS and in the token headers
+	jwtKeyID = "e2e"
+)
+
+// JWTSigner signs test tokens with an RSA key generated for the run, and publishes its public key as a JWKS.
+type JWTSigner struct {
+	key *rsa.PrivateKey
+}
+
+// NewJWTSigner returns a signer with a new key.
+func NewJWTSigner() (*JWTSigner, error) {
+	key, err := rsa.GenerateKey(rand.Reader, jwtKeyBits)
+	if err != nil {
+		return nil, err
+	}
+	return &JWTSigner{key: key}, nil
+}
+
+// JWKS returns the JSON Web Key Set holding the public key of the signer.
+func (s *JWTSigner) JWKS() (string, error) {
+	jwks := map[string][]map[string]string{
+		"keys": {{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": jwtKeyID,
+			"n":   jwtEncode(s.key.N.Bytes()),
+			"e":   jwtEncode(big.NewInt(int64(s.key.E)).Bytes()),
+		}},
+	}
+	data, err := json.Marshal(jwks)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Token returns an RS256 token from the issuer for the audience, which expires at the given time.
+func (s *JWTSigner) Token(issuer, audience string, expiry time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": jwtKeyID})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss": issuer,
+		"sub": issuer,
+		"aud": audience,
+		"iat": expiry.Add(-time.Hour).Unix(),
+		"exp": expiry.Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := jwtEncode(header) + "." + jwtEncode(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + jwtEncode(signature), nil
+}
+
+// jwtEncode encodes the data in unpadded base64url, as JWTs and JWKs expect.
+func jwtEncode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,113 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"istio.io/istio/pilot/test/util"
+)
+
+// loadRex matches the summary lines logged by the client under -qps.
+var loadRex = regexp.MustCompile(`Load(Requests|Errors|QPS|P50|P90|P99)=(\S+)`)
+
+// LoadResult is the summary of the requests sent by Load.
+type LoadResult struct {
+	Requests int
+	Errors   int
+	// rate actually reached, below the requested one when the client cannot keep up
+	QPS float64
+	P50 time.Duration
+	P90 time.Duration
+	P99 time.Duration
+}
+
+// ErrorRate returns the ratio of the requests that failed.
+func (r LoadResult) ErrorRate() float64 {
+	if r.Requests == 0 {
+		return 0
+	}
+	return float64(r.Errors) / float64(r.Requests)
+}
+
+func (r LoadResult) String() string {
+	return fmt.Sprintf("%d requests at %.1f qps, %d failed, p50 %v, p90 %v, p99 %v",
+		r.Requests, r.QPS, r.Errors, r.P50, r.P90, r.P99)
+}
+
+// Load sends requests from the client of the app to the url at qps for the duration, each
+// request starting on time whether the previous ones completed or not, so that slow responses
+// show up in the latency instead of lowering the rate. Unlike ClientRequest, failed requests are
+// counted instead of failing the whole command.
+func (e *Environment) Load(app, url string, qps int, duration time.Duration, extra string) (LoadResult, error) {
+	if len(e.Apps[app]) == 0 {
+		return LoadResult{}, fmt.Errorf("missing pod names for app %q", app)
+	}
+	cmd := fmt.Sprintf("kubectl exec %s --kubeconfig %s -n %s -c app -- client -url %s -qps %d -duration %v %s",
+		e.Apps[app][0], e.Config.KubeConfig, e.Config.Namespace, url, qps, duration, extra)
+	out, err := util.Shell(cmd)
+	if err != nil {
+		return LoadResult{}, err
+	}
+
+	var result LoadResult
+	found := 0
+	for _, match := range loadRex.FindAllStringSubmatch(out, -1) {
+		found++
+		switch match[1] {
+		case "Requests":
+			result.Requests, err = strconv.Atoi(match[2])
+		case "Errors":
+			result.Errors, err = strconv.Atoi(match[2])
+		case "QPS":
+			result.QPS, err = strconv.ParseFloat(match[2], 64)
+		case "P50":
+			result.P50, err = time.ParseDuration(match[2])
+		case "P90":
+			result.P90, err = time.ParseDuration(match[2])
+		case "P99":
+			result.P99, err = time.ParseDuration(match[2])
+		}
+		if err != nil {
+			return LoadResult{}, fmt.Errorf("cannot parse the load summary %q: %v", match[0], err)
+		}
+	}
+	if found == 0 {
+		return LoadResult{}, fmt.Errorf("the client of %s logged no load summary, it may predate -qps:\n%s", app, out)
+	}
+	return result, nil
+}
+
+// CheckSLO returns an error if the result misses the latency or error rate objectives of the
+// config, LoadP50, LoadP99 and LoadErrorRate.
+func (e *Environment) CheckSLO(result LoadResult) error {
+	if result.Requests == 0 {
+		return fmt.Errorf("no requests were sent")
+	}
+	if rate := result.ErrorRate(); rate > e.Config.LoadErrorRate {
+		return fmt.Errorf("%d of %d requests failed, a rate of %.3f above %.3f",
+			result.Errors, result.Requests, rate, e.Config.LoadErrorRate)
+	}
+	if result.P50 > e.Config.LoadP50 {
+		return fmt.Errorf("the median latency is %v, above %v", result.P50, e.Config.LoadP50)
+	}
+	if result.P99 > e.Config.LoadP99 {
+		return fmt.Errorf("the 99th percentile latency is %v, above %v", result.P99, e.Config.LoadP99)
+	}
+	return nil
+}
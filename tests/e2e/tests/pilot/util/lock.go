@@ -0,0 +1,168 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/istio/pkg/log"
+)
+
+const (
+	// config map of the Istio namespace holding the lease of the run using it
+	lockConfigMap = "istio-test-lock"
+	// keys of the lease: the run and the process holding it, when it was acquired and last renewed
+	lockRunKey      = "run"
+	lockHolderKey   = "holder"
+	lockAcquiredKey = "acquired-at"
+	lockRenewedKey  = "renewed-at"
+)
+
+// namespaceLock is the lease of the Istio namespace held by the environment, renewed until released.
+type namespaceLock struct {
+	namespace string
+	holder    string
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// acquireLock takes the lease of the Istio namespace given to the environment, so that no other run uses
+// it at the same time, and renews it every third of LockTTL until releaseLock. A lease not renewed for
+// LockTTL, such as that of a killed run, expires; -force takes over a lease that has not. The namespaces
+// the environment created and those of a pool, which are claimed instead, are not locked.
+func (e *Environment) acquireLock() error {
+	if e.istioNamespaceCreated || e.pooledNamespace != "" {
+		return nil
+	}
+	holder := poolOwner()
+	configMaps := e.KubeClient.CoreV1().ConfigMaps(e.Config.IstioNamespace)
+	now := time.Now().UTC().Format(time.RFC3339)
+	lease := &v1.ConfigMap{
+		ObjectMeta: meta_v1.ObjectMeta{Name: lockConfigMap},
+		Data: map[string]string{
+			lockRunKey:      e.Config.RunID,
+			lockHolderKey:   holder,
+			lockAcquiredKey: now,
+			lockRenewedKey:  now,
+		},
+	}
+	_, err := configMaps.Create(lease)
+	if errors.IsAlreadyExists(err) {
+		var existing *v1.ConfigMap
+		if existing, err = configMaps.Get(lockConfigMap, meta_v1.GetOptions{}); err != nil {
+			return err
+		}
+		if err = e.checkLease(existing); err != nil {
+			return err
+		}
+		// the update fails with a conflict if another run took the lease since the get
+		lease.ResourceVersion = existing.ResourceVersion
+		_, err = configMaps.Update(lease)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot lock namespace %s: %v", e.Config.IstioNamespace, err)
+	}
+	log.Infof("Locked namespace %s for run %s (%s)", e.Config.IstioNamespace, e.Config.RunID, holder)
+	e.lock = &namespaceLock{
+		namespace: e.Config.IstioNamespace,
+		holder:    holder,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go e.renewLock(e.lock)
+	return nil
+}
+
+// checkLease returns an error if the lease is held by another run, unless it expired or -force is set.
+func (e *Environment) checkLease(lease *v1.ConfigMap) error {
+	run, holder := lease.Data[lockRunKey], lease.Data[lockHolderKey]
+	renewed, err := time.Parse(time.RFC3339, lease.Data[lockRenewedKey])
+	switch {
+	case err != nil:
+		log.Warnf("Taking over the lease of namespace %s by run %s (%s), renewed at %q: %v",
+			e.Config.IstioNamespace, run, holder, lease.Data[lockRenewedKey], err)
+	case time.Since(renewed) > e.Config.LockTTL:
+		log.Warnf("The lease of namespace %s by run %s (%s) expired, not renewed since %v",
+			e.Config.IstioNamespace, run, holder, renewed)
+	case e.Config.ForceLock:
+		log.Warnf("Forcing the lease of namespace %s held by run %s (%s) since %s", e.Config.IstioNamespace,
+			run, holder, lease.Data[lockAcquiredKey])
+	default:
+		return fmt.Errorf("namespace %s is locked by run %s (%s) since %s, renewed at %s; wait for it to end, "+
+			"or for its lease to expire after %v, or pass -force", e.Config.IstioNamespace, run, holder,
+			lease.Data[lockAcquiredKey], lease.Data[lockRenewedKey], e.Config.LockTTL)
+	}
+	return nil
+}
+
+// renewLock renews the lease every third of LockTTL until the lock is released, stopping if another run
+// took it over.
+func (e *Environment) renewLock(lock *namespaceLock) {
+	defer close(lock.done)
+	ticker := time.NewTicker(e.Config.LockTTL / 3)
+	defer ticker.Stop()
+	configMaps := e.KubeClient.CoreV1().ConfigMaps(lock.namespace)
+	for {
+		select {
+		case <-lock.stop:
+			return
+		case <-ticker.C:
+		}
+		lease, err := configMaps.Get(lockConfigMap, meta_v1.GetOptions{})
+		if err == nil && lease.Data[lockHolderKey] != lock.holder {
+			log.Errorf("The lease of namespace %s was taken over by run %s (%s)", lock.namespace,
+				lease.Data[lockRunKey], lease.Data[lockHolderKey])
+			return
+		}
+		if err == nil {
+			lease.Data[lockRenewedKey] = time.Now().UTC().Format(time.RFC3339)
+			_, err = configMaps.Update(lease)
+		}
+		if err != nil {
+			log.Warnf("Cannot renew the lease of namespace %s: %v", lock.namespace, err)
+		}
+	}
+}
+
+// releaseLock stops renewing the lease of the Istio namespace and deletes it, unless another run took it
+// over.
+func (e *Environment) releaseLock() {
+	lock := e.lock
+	if lock == nil {
+		return
+	}
+	e.lock = nil
+	close(lock.stop)
+	<-lock.done
+	configMaps := e.KubeClient.CoreV1().ConfigMaps(lock.namespace)
+	lease, err := configMaps.Get(lockConfigMap, meta_v1.GetOptions{})
+	if err != nil {
+		log.Warnf("Cannot release the lease of namespace %s: %v", lock.namespace, err)
+		return
+	}
+	if lease.Data[lockHolderKey] != lock.holder {
+		return
+	}
+	if err = configMaps.Delete(lockConfigMap, &meta_v1.DeleteOptions{}); err != nil {
+		log.Warnf("Cannot release the lease of namespace %s: %v", lock.namespace, err)
+		return
+	}
+	log.Infof("Released the lease of namespace %s", lock.namespace)
+}
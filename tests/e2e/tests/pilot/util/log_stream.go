@@ -0,0 +1,255 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/istio/pkg/log"
+)
+
+const (
+	// size of a log file after which it is rotated
+	logStreamMaxSize = 10 * 1024 * 1024
+	// rotated log files kept per container, as .1 to .N, .1 being the most recent
+	logStreamKeep = 3
+	// delay between two lookups of new pods in the test namespaces
+	logStreamRefresh = 10 * time.Second
+	// delay before following the logs of a container again once kubectl logs -f exited
+	logStreamRetry = 2 * time.Second
+)
+
+// logStreamer follows the logs of the containers of all pods of the test namespaces with kubectl logs -f,
+// writing them under StreamLogsDir to one rotating file per container as they come. The logs of a
+// container restarted or killed mid-test are so kept, while the logs dumped on teardown are only those
+// of the containers still running then.
+type logStreamer struct {
+	env  *Environment
+	dir  string
+	stop chan struct{}
+	wg   sync.WaitGroup
+
+	mu sync.Mutex
+	// containers followed, by namespace/pod/container
+	followed map[string]bool
+	// kubectl logs -f commands running, killed on stop
+	cmds map[*exec.Cmd]bool
+}
+
+// startLogStreaming starts following the logs of the pods of the test namespaces, including the pods
+// created afterwards, if StreamLogsDir is set.
+func (e *Environment) startLogStreaming() error {
+	if e.Config.StreamLogsDir == "" || e.logStreamer != nil || e.Config.DryRun {
+		return nil
+	}
+	if err := os.MkdirAll(e.Config.StreamLogsDir, 0755); err != nil {
+		return err
+	}
+	s := &logStreamer{
+		env:      e,
+		dir:      e.Config.StreamLogsDir,
+		stop:     make(chan struct{}),
+		followed: make(map[string]bool),
+		cmds:     make(map[*exec.Cmd]bool),
+	}
+	log.Infof("Streaming the logs of the pods of %s and %s to %s", e.Config.Namespace, e.Config.IstioNamespace, s.dir)
+	s.wg.Add(1)
+	go s.watch()
+	e.logStreamer = s
+	return nil
+}
+
+// stopLogStreaming stops following the logs and closes the log files.
+func (e *Environment) stopLogStreaming() {
+	if e.logStreamer == nil {
+		return
+	}
+	s := e.logStreamer
+	close(s.stop)
+	s.mu.Lock()
+	for cmd := range s.cmds {
+		if cmd.Process != nil {
+			_ = cmd.Process.Kill()
+		}
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	e.logStreamer = nil
+}
+
+// watch looks for new containers to follow until stopped.
+func (s *logStreamer) watch() {
+	defer s.wg.Done()
+	for {
+		for _, namespace := range []string{s.env.Config.IstioNamespace, s.env.Config.Namespace} {
+			pods, err := s.env.KubeClient.CoreV1().Pods(namespace).List(meta_v1.ListOptions{})
+			if err != nil {
+				log.Warna(err)
+				continue
+			}
+			for _, pod := range pods.Items {
+				for _, container := range pod.Spec.Containers {
+					s.startFollower(namespace, pod.Name, container.Name)
+				}
+			}
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(logStreamRefresh):
+		}
+	}
+}
+
+func (s *logStreamer) startFollower(namespace, pod, container string) {
+	key := namespace + "/" + pod + "/" + container
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.followed[key] {
+		return
+	}
+	s.followed[key] = true
+	s.wg.Add(1)
+	go s.follow(namespace, pod, container)
+}
+
+// follow writes the logs of the container to its file until the pod is gone or the streamer stopped.
+// When kubectl logs -f exits, because the container restarted or the connection dropped, it follows
+// the logs again from when it exited.
+func (s *logStreamer) follow(namespace, pod, container string) {
+	defer s.wg.Done()
+	out := &rotatingFile{path: filepath.Join(s.dir, fmt.Sprintf("%s_%s_%s.log", namespace, pod, container))}
+	defer func() {
+		if err := out.Close(); err != nil {
+			log.Warna(err)
+		}
+	}()
+
+	since := ""
+	for {
+		args := []string{"logs", "-f", "--kubeconfig", s.env.Config.KubeConfig, "-n", namespace, pod, "-c", container}
+		if since != "" {
+			args = append(args, "--since-time="+since)
+		}
+		cmd := exec.Command("kubectl", args...) // #nosec
+		cmd.Stdout, cmd.Stderr = out, out
+		written := out.written
+		if !s.run(cmd) {
+			return
+		}
+		// only once some logs came, so that the logs of a container still starting are not skipped
+		if out.written > written {
+			since = time.Now().UTC().Format(time.RFC3339)
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(logStreamRetry):
+		}
+		if p, err := s.env.KubeClient.CoreV1().Pods(namespace).Get(pod, meta_v1.GetOptions{}); err != nil ||
+			p.Status.Phase == v1.PodSucceeded || p.Status.Phase == v1.PodFailed {
+			return
+		}
+	}
+}
+
+// run runs the command, unless the streamer is stopped, in which case it returns false.
+func (s *logStreamer) run(cmd *exec.Cmd) bool {
+	s.mu.Lock()
+	select {
+	case <-s.stop:
+		s.mu.Unlock()
+		return false
+	default:
+	}
+	if err := cmd.Start(); err != nil {
+		s.mu.Unlock()
+		log.Warna(err)
+		return true
+	}
+	s.cmds[cmd] = true
+	s.mu.Unlock()
+
+	_ = cmd.Wait()
+	s.mu.Lock()
+	delete(s.cmds, cmd)
+	s.mu.Unlock()
+	return true
+}
+
+// rotatingFile is a log file renamed to <path>.1 once it reaches logStreamMaxSize, shifting the older
+// ones up to <path>.<logStreamKeep>. It is opened on the first write.
+type rotatingFile struct {
+	path string
+	file *os.File
+	size int64
+	// bytes written since it was created, across rotations
+	written int64
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	if r.file != nil && r.size+int64(len(p)) > logStreamMaxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	if r.file == nil {
+		file, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return 0, err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			_ = file.Close()
+			return 0, err
+		}
+		r.file, r.size = file, info.Size()
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	r.written += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	r.file = nil
+	for i := logStreamKeep - 1; i > 0; i-- {
+		// the older files may not exist yet
+		_ = os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	return os.Rename(r.path, r.path+".1")
+}
+
+// Close closes the current file, if any.
+func (r *rotatingFile) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
@@ -0,0 +1,184 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ghodss/yaml"
+
+	"istio.io/istio/pkg/log"
+)
+
+// manifestObject holds the fields of a Kubernetes object needed to validate an Istio manifest.
+type manifestObject struct {
+	Kind     string `json:"kind"`
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+}
+
+// loadManifest reads an Istio install manifest from an http(s) URL or a local file.
+func loadManifest(location string) (string, error) {
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		data, err := ioutil.ReadFile(location)
+		if err != nil {
+			return "", fmt.Errorf("cannot read Istio manifest %s: %v", location, err)
+		}
+		return string(data), nil
+	}
+
+	resp, err := http.Get(location) // nolint: gosec
+	if err != nil {
+		return "", fmt.Errorf("cannot fetch Istio manifest %s: %v", location, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("cannot fetch Istio manifest %s: status %s", location, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("cannot read Istio manifest %s: %v", location, err)
+	}
+	return string(data), nil
+}
+
+// requiredComponents returns the "Kind/name" keys the control plane must define for the configured tests,
+// each with the other names the component goes by in some releases, such as istio-citadel for istio-ca.
+func (e *Environment) requiredComponents() [][]string {
+	required := [][]string{
+		{"ConfigMap/istio"},
+		{"Deployment/istio-pilot"},
+		{"Deployment/istio-ca", "Deployment/istio-citadel"},
+	}
+	if e.Config.Mixer {
+		required = append(required, []string{"Deployment/istio-mixer", "Deployment/istio-policy"})
+	}
+	if e.Config.Ingress {
+		required = append(required, []string{"Deployment/istio-ingress"})
+	}
+	return required
+}
+
+// validateManifest checks that the manifest defines all the control plane components used by the tests,
+// and no object in a namespace other than IstioNamespace, which it is applied to, source naming where it
+// comes from in the errors.
+func (e *Environment) validateManifest(source, manifest string) error {
+	defined := make(map[string]bool)
+	for _, doc := range strings.Split(manifest, "\n---") {
+		if strings.TrimSpace(doc) == "" {
+			continue
+		}
+		var obj manifestObject
+		if err := yaml.Unmarshal([]byte(doc), &obj); err != nil {
+			return fmt.Errorf("cannot parse Istio manifest %s: %v", source, err)
+		}
+		if ns := obj.Metadata.Namespace; ns != "" && ns != e.Config.IstioNamespace {
+			return fmt.Errorf("istio manifest %s puts %s/%s in namespace %s, want the Istio namespace %s "+
+				"(see -ns)", source, obj.Kind, obj.Metadata.Name, ns, e.Config.IstioNamespace)
+		}
+		defined[obj.Kind+"/"+obj.Metadata.Name] = true
+	}
+
+	var missing []string
+	for _, names := range e.requiredComponents() {
+		found := false
+		for _, name := range names {
+			found = found || defined[name]
+		}
+		if !found {
+			missing = append(missing, strings.Join(names, " or "))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("istio manifest %s is missing components: %s",
+			source, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// extraManifest is a file of -extra-manifests, as it was applied.
+type extraManifest struct {
+	file string
+	yaml string
+}
+
+// extraManifestFiles returns the location if it is a file, or the YAML files of the directory
+// in filename order.
+func extraManifestFiles(location string) ([]string, error) {
+	info, err := os.Stat(location)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{location}, nil
+	}
+	entries, err := ioutil.ReadDir(location)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	// ReadDir sorts by filename
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if !entry.IsDir() && (ext == ".yaml" || ext == ".yml") {
+			files = append(files, filepath.Join(location, entry.Name()))
+		}
+	}
+	return files, nil
+}
+
+// applyExtraManifests applies the files of -extra-manifests to the app namespace, in order,
+// stopping at the first one that fails.
+func (e *Environment) applyExtraManifests() error {
+	if e.Config.ExtraManifests == "" {
+		return nil
+	}
+	files, err := extraManifestFiles(e.Config.ExtraManifests)
+	if err != nil {
+		return fmt.Errorf("cannot list extra manifests %s: %v", e.Config.ExtraManifests, err)
+	}
+	for _, file := range files {
+		data, readErr := ioutil.ReadFile(file)
+		if readErr != nil {
+			return fmt.Errorf("cannot read extra manifest %s: %v", file, readErr)
+		}
+		log.Infof("Applying extra manifest %s", file)
+		if err = e.KubeApply(string(data), e.Config.Namespace); err != nil {
+			return fmt.Errorf("cannot apply extra manifest %s: %v", file, err)
+		}
+		e.extraManifests = append(e.extraManifests, extraManifest{file: file, yaml: string(data)})
+	}
+	return nil
+}
+
+// deleteExtraManifests deletes the applied extra manifests, in reverse order.
+func (e *Environment) deleteExtraManifests() {
+	for i := len(e.extraManifests) - 1; i >= 0; i-- {
+		manifest := e.extraManifests[i]
+		if err := e.KubeDelete(manifest.yaml, e.Config.Namespace); err != nil {
+			log.Infof("Extra manifest %s could not be deleted: %v", manifest.file, err)
+		}
+	}
+	e.extraManifests = nil
+}
@@ -0,0 +1,152 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/istio/pilot/pkg/kube/inject"
+	"istio.io/istio/pilot/pkg/serviceregistry/kube"
+	"istio.io/istio/pkg/log"
+)
+
+// MeshExpansionApp is the test app run as a workload outside Kubernetes would be, such as a VM
+// joining the mesh: no service selects its pod, which is registered with the endpoints of its
+// service by the code of istioctl register, and its sidecar is bootstrapped with the flags of
+// tools/deb/istio-start.sh instead of being injected. The workload still being a pod, Pilot finds
+// its labels and service account as for the other apps.
+type MeshExpansionApp struct {
+	// Name is the name of the deployment and of the service the pod is registered with
+	Name string
+	// Version is the version label of the pod, "unversioned" if empty
+	Version string
+	// ServiceAccount is the service account whose certificate the sidecar mounts, default if empty
+	ServiceAccount string
+	// Ports are the ports of the service, DefaultAppPorts if empty
+	Ports []AppPort
+	// IP is the address registered for the app, set by DeployMeshExpansionApp
+	IP string
+
+	// YAML of the deployment
+	deployed string
+}
+
+// meshExpansionTemplateData is the data of mesh-expansion-app.yaml.tmpl.
+type meshExpansionTemplateData struct {
+	*MeshExpansionApp
+	AppImage               string
+	Args                   []string
+	InitImage              string
+	ProxyImage             string
+	ProxyUID               uint64
+	ProxyListenPort        int32
+	DiscoveryAddress       string
+	ControlPlaneAuthPolicy string
+	Debug                  bool
+}
+
+// DeployMeshExpansionApp deploys the app in the app namespace, waits for its pod to run and registers
+// the address of the pod with the service of the app, creating the service without a selector.
+// The app is in Apps once registered, see DeleteMeshExpansionApp.
+func (e *Environment) DeployMeshExpansionApp(app *MeshExpansionApp) error {
+	if app.Version == "" {
+		app.Version = "unversioned"
+	}
+	if app.ServiceAccount == "" {
+		app.ServiceAccount = "default"
+	}
+	if len(app.Ports) == 0 {
+		app.Ports = DefaultAppPorts
+	}
+	ports := make([]AppPort, 0, len(app.Ports))
+	for _, port := range app.Ports {
+		if port.TargetPort == 0 {
+			port.TargetPort = port.Port
+		}
+		if port.Protocol == "" {
+			port.Protocol = "TCP"
+		}
+		ports = append(ports, port)
+	}
+	app.Ports = ports
+
+	debug := e.Config.DebugImagesAndMode
+	yaml, err := e.Fill("mesh-expansion-app.yaml.tmpl", meshExpansionTemplateData{
+		MeshExpansionApp:       app,
+		AppImage:               fmt.Sprintf("%s/app:%s", e.Config.AppImageHub(), e.Config.AppImageTag()),
+		Args:                   testAppArgs(app.Ports, app.Version),
+		InitImage:              inject.InitImageName(e.Config.Hub, e.Config.Tag, debug),
+		ProxyImage:             inject.ProxyImageName(e.Config.Hub, e.Config.Tag, debug),
+		ProxyUID:               inject.DefaultSidecarProxyUID,
+		ProxyListenPort:        e.meshConfig.ProxyListenPort,
+		DiscoveryAddress:       e.meshConfig.DefaultConfig.DiscoveryAddress,
+		ControlPlaneAuthPolicy: e.meshConfig.DefaultConfig.ControlPlaneAuthPolicy.String(),
+		Debug:                  debug,
+	})
+	if err != nil {
+		return err
+	}
+	if err = e.KubeApply(yaml, e.Config.Namespace); err != nil {
+		return err
+	}
+	app.deployed = yaml
+	if err = e.RefreshApps(); err != nil {
+		return err
+	}
+	if len(e.Apps[app.Name]) == 0 {
+		return fmt.Errorf("missing pod for the mesh expansion app %q", app.Name)
+	}
+	pod, err := e.KubeClient.CoreV1().Pods(e.Config.Namespace).Get(e.Apps[app.Name][0], meta_v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	app.IP = pod.Status.PodIP
+
+	namedPorts := make([]kube.NamedPort, 0, len(app.Ports))
+	for _, port := range app.Ports {
+		namedPorts = append(namedPorts, kube.NamedPort{Name: port.Name, Port: int32(port.Port)})
+	}
+	annotations := []string{fmt.Sprintf("%s=%s", kube.KubeServiceAccountsOnVMAnnotation, app.ServiceAccount)}
+	log.Infof("Registering %s of the mesh expansion app %s", app.IP, app.Name)
+	return kube.RegisterEndpoint(e.KubeClient, e.Config.Namespace, app.Name, app.IP, namedPorts, nil, annotations)
+}
+
+// DeleteMeshExpansionApp deregisters the app, deletes its service and its deployment and refreshes
+// Apps.
+func (e *Environment) DeleteMeshExpansionApp(app *MeshExpansionApp) error {
+	if app.IP != "" {
+		if err := kube.DeRegisterEndpoint(e.KubeClient, e.Config.Namespace, app.Name, app.IP); err != nil {
+			log.Warna(err)
+		}
+		app.IP = ""
+	}
+	// the service has no selector, so Kubernetes leaves its endpoints alone
+	if err := e.KubeClient.CoreV1().Services(e.Config.Namespace).Delete(app.Name, &meta_v1.DeleteOptions{}); err != nil {
+		log.Warna(err)
+	}
+	if err := e.KubeClient.CoreV1().Endpoints(e.Config.Namespace).Delete(app.Name, &meta_v1.DeleteOptions{}); err != nil {
+		log.Warna(err)
+	}
+	if app.deployed == "" {
+		return nil
+	}
+	if err := e.KubeDelete(app.deployed, e.Config.Namespace); err != nil {
+		return err
+	}
+	app.deployed = ""
+	return e.RefreshApps()
+}
@@ -0,0 +1,241 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	meshconfig "istio.io/api/mesh/v1alpha1"
+	"istio.io/istio/pilot/pkg/kube/inject"
+	"istio.io/istio/pilot/test/util"
+	"istio.io/istio/pkg/log"
+)
+
+const (
+	// secret of the cluster registry mounted by Pilot, with the kubeconfigs of both clusters
+	clusterRegistriesSecret = "istio-test-clusters"
+	// LoadBalancer service exposing Pilot to the sidecars of the remote cluster
+	remotePilotService = "istio-pilot-remote"
+	// Citadel secret of the default service account, copied to the remote cluster in auth mode
+	remoteCertsSecret = "istio.default"
+	// maximum number of 1s polls waiting for the address of remotePilotService or for remoteCertsSecret
+	remoteWaitBudget = 300
+)
+
+// createClusterRegistries creates the secret of the cluster registry that Pilot reads with
+// --clusterRegistriesDir, so that it discovers the services of the app namespace of the remote
+// cluster along with those of the primary one. The registry holds the kubeconfig files of the run,
+// which must therefore work from inside a pod, with a token or a client certificate rather than an
+// authentication plugin.
+func (e *Environment) createClusterRegistries() error {
+	clusters, err := e.Fill("clusters.yaml.tmpl", e.ToTemplateData())
+	if err != nil {
+		return err
+	}
+	primary, err := ioutil.ReadFile(e.Config.KubeConfig)
+	if err != nil {
+		return err
+	}
+	remote, err := ioutil.ReadFile(e.Config.RemoteKubeConfig)
+	if err != nil {
+		return err
+	}
+	secret := &v1.Secret{
+		ObjectMeta: meta_v1.ObjectMeta{Name: clusterRegistriesSecret},
+		Data: map[string][]byte{
+			"clusters.yaml":      []byte(clusters),
+			"primary.kubeconfig": primary,
+			"remote.kubeconfig":  remote,
+		},
+	}
+	secrets := e.KubeClient.CoreV1().Secrets(e.Config.IstioNamespace)
+	// left by a killed run in a kept Istio namespace
+	if _, err = secrets.Create(secret); apierrors.IsAlreadyExists(err) {
+		_, err = secrets.Update(secret)
+	}
+	return err
+}
+
+// deployRemote deploys the app r split across the clusters, r-v1 to the primary cluster and r-v2 to
+// the remote one, in a namespace named like the app namespace since Pilot watches the same namespace
+// in both clusters. The sidecar of r-v2 connects to Pilot through a load balancer, and in auth mode
+// uses the certificates Citadel issued to the default service account of the primary cluster, the
+// identity being the same. The pod IPs of the clusters must be routable from one another, and the
+// remote sidecars cannot reach Mixer.
+func (e *Environment) deployRemote() error {
+	// r-v1 also gives r a cluster IP in the primary cluster, for the DNS of its clients
+	if err := e.deployApp("r-v1", "r", 80, 8080, 90, 9090, 70, 7070, "v1", true, false, ""); err != nil {
+		return err
+	}
+
+	namespaces := e.RemoteKubeClient.CoreV1().Namespaces()
+	if _, err := namespaces.Get(e.Config.Namespace, meta_v1.GetOptions{}); apierrors.IsNotFound(err) {
+		if _, err = namespaces.Create(&v1.Namespace{
+			ObjectMeta: meta_v1.ObjectMeta{
+				Name:   e.Config.Namespace,
+				Labels: map[string]string{"istio-injection": "disabled"},
+			},
+		}); err != nil {
+			return err
+		}
+		e.remoteNamespaceCreated = true
+	} else if err != nil {
+		return err
+	}
+
+	if e.Auth == meshconfig.MeshConfig_MUTUAL_TLS {
+		if err := e.copyRemoteCerts(); err != nil {
+			return err
+		}
+	}
+
+	yaml, err := e.Fill("pilot-remote.yaml.tmpl", e.ToTemplateData())
+	if err != nil {
+		return err
+	}
+	if err = e.KubeApply(yaml, e.Config.IstioNamespace); err != nil {
+		return err
+	}
+	address, err := e.waitForRemotePilot()
+	if err != nil {
+		return err
+	}
+
+	// the sidecar template with the discovery address of the load balancer, from a copy of the mesh
+	_, mesh, err := GetMeshConfig(e.KubeClient, e.Config.IstioNamespace, "istio")
+	if err != nil {
+		return err
+	}
+	port := 15005
+	if e.ControlPlaneAuthPolicy == meshconfig.AuthenticationPolicy_NONE {
+		port = 15007
+	}
+	mesh.DefaultConfig.DiscoveryAddress = fmt.Sprintf("%s:%d", address, port)
+	debugMode := e.Config.DebugImagesAndMode
+	sidecarTemplate, err := inject.GenerateTemplateFromParams(&inject.Params{
+		InitImage:       inject.InitImageName(e.Config.Hub, e.Config.Tag, debugMode),
+		ProxyImage:      inject.ProxyImageName(e.Config.Hub, e.Config.Tag, debugMode),
+		Verbosity:       e.Config.Verbosity,
+		SidecarProxyUID: inject.DefaultSidecarProxyUID,
+		EnableCoreDump:  true,
+		Version:         "integration-test",
+		Mesh:            mesh,
+		DebugMode:       debugMode,
+	})
+	if err != nil {
+		return err
+	}
+
+	app, err := e.fillApp("r-v2", "r", 80, 8080, 90, 9090, 70, 7070, "v2", true, false, "")
+	if err != nil {
+		return err
+	}
+	writer := new(bytes.Buffer)
+	if err = inject.IntoResourceFile(sidecarTemplate, mesh, strings.NewReader(app), writer); err != nil {
+		return err
+	}
+	e.remoteApp = writer.String()
+	if err = e.RemoteKubeApply(e.remoteApp, e.Config.Namespace); err != nil {
+		return err
+	}
+	e.RemoteApps, err = util.GetAppPods(e.RemoteKubeClient, e.Config.RemoteKubeConfig, []string{e.Config.Namespace})
+	return err
+}
+
+// copyRemoteCerts copies the certificates of the default service account of the app namespace to
+// the remote cluster, once Citadel has issued them.
+func (e *Environment) copyRemoteCerts() error {
+	for n := 0; n < remoteWaitBudget; n++ {
+		secret, err := e.KubeClient.CoreV1().Secrets(e.Config.Namespace).Get(remoteCertsSecret, meta_v1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			time.Sleep(time.Second)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		_, err = e.RemoteKubeClient.CoreV1().Secrets(e.Config.Namespace).Create(&v1.Secret{
+			ObjectMeta: meta_v1.ObjectMeta{Name: secret.Name, Annotations: secret.Annotations},
+			Type:       secret.Type,
+			Data:       secret.Data,
+		})
+		if apierrors.IsAlreadyExists(err) {
+			return nil
+		}
+		return err
+	}
+	return fmt.Errorf("secret %s is not in namespace %s after %d attempts", remoteCertsSecret, e.Config.Namespace,
+		remoteWaitBudget)
+}
+
+// waitForRemotePilot waits for the load balancer of Pilot to have an address, and returns it.
+func (e *Environment) waitForRemotePilot() (string, error) {
+	for n := 0; n < remoteWaitBudget; n++ {
+		svc, err := e.KubeClient.CoreV1().Services(e.Config.IstioNamespace).Get(remotePilotService,
+			meta_v1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		for _, ingress := range svc.Status.LoadBalancer.Ingress {
+			if ingress.IP != "" {
+				return ingress.IP, nil
+			}
+			if ingress.Hostname != "" {
+				return ingress.Hostname, nil
+			}
+		}
+		time.Sleep(time.Second)
+	}
+	return "", fmt.Errorf("service %s has no load balancer address after %d attempts", remotePilotService,
+		remoteWaitBudget)
+}
+
+// teardownRemote deletes what deployRemote and createClusterRegistries deployed, the namespaces of
+// the primary cluster possibly being kept by a pool or given on the command line.
+func (e *Environment) teardownRemote() {
+	if e.RemoteKubeClient == nil {
+		return
+	}
+	if e.remoteNamespaceCreated {
+		util.DeleteNamespace(e.RemoteKubeClient, e.Config.Namespace)
+		e.remoteNamespaceCreated = false
+	} else if e.remoteApp != "" {
+		if err := e.RemoteKubeDelete(e.remoteApp, e.Config.Namespace); err != nil {
+			log.Warna(err)
+		}
+		if err := e.RemoteKubeClient.CoreV1().Secrets(e.Config.Namespace).Delete(remoteCertsSecret,
+			&meta_v1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			log.Warna(err)
+		}
+	}
+	e.remoteApp = ""
+	e.RemoteApps = nil
+	if err := e.KubeClient.CoreV1().Services(e.Config.IstioNamespace).Delete(remotePilotService,
+		&meta_v1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		log.Warna(err)
+	}
+	if err := e.KubeClient.CoreV1().Secrets(e.Config.IstioNamespace).Delete(clusterRegistriesSecret,
+		&meta_v1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		log.Warna(err)
+	}
+}
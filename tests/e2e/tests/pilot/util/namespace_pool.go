@@ -0,0 +1,194 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/istio/pilot/test/util"
+	"istio.io/istio/pkg/log"
+)
+
+const (
+	// label of the namespaces of a pool, with the name of the pool as value, for both the app and the
+	// Istio namespaces so that kubectl delete namespace -l drains the pool
+	poolLabel = "istio-test-pool"
+	// label of the app namespace of a pool with the auth mode of its control plane, only shared by the
+	// runs in that mode
+	poolAuthLabel = "istio-test-pool-auth"
+	// annotation of the app namespace of a pool with the Istio namespace running its control plane
+	poolIstioNamespaceAnnotation = "istio-test-pool/istio-namespace"
+	// annotations of the app namespace of a pool claimed by a run, with the run and the claim time
+	poolClaimedByAnnotation = "istio-test-pool/claimed-by"
+	poolClaimedAtAnnotation = "istio-test-pool/claimed-at"
+	// age after which the claim of a run that did not release its namespaces, e.g. because it was
+	// killed, expires
+	poolClaimExpiry = 6 * time.Hour
+)
+
+// claimPooledNamespaces sets the app and Istio namespaces to a free pair of NamespacePool, claiming
+// it, or to a new pair added to the pool when none is free. The control plane and the apps of a
+// pair stay deployed between runs, so that applying them again only rolls out what changed. The
+// Istio configs left over in the app namespace are deleted by resetPooledConfigs.
+func (e *Environment) claimPooledNamespaces() error {
+	namespaces, err := e.KubeClient.CoreV1().Namespaces().List(meta_v1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s,%s=%s", poolLabel, e.Config.NamespacePool, poolAuthLabel, e.poolAuth()),
+	})
+	if err != nil {
+		return err
+	}
+	owner := poolOwner()
+	for i := range namespaces.Items {
+		ns := &namespaces.Items[i]
+		istioNamespace := ns.Annotations[poolIstioNamespaceAnnotation]
+		if istioNamespace == "" || ns.Status.Phase != v1.NamespaceActive || claimed(ns) {
+			continue
+		}
+		claim(ns, owner)
+		// the update fails with a conflict if another run claimed the namespace since the list
+		if _, err = e.KubeClient.CoreV1().Namespaces().Update(ns); err != nil {
+			log.Infof("Could not claim namespace %s of pool %s: %v", ns.Name, e.Config.NamespacePool, err)
+			continue
+		}
+		e.Config.Namespace, e.Config.IstioNamespace = ns.Name, istioNamespace
+		e.pooledNamespace = ns.Name
+		log.Infof("Claimed namespaces %s and %s of pool %s", ns.Name, istioNamespace, e.Config.NamespacePool)
+		return nil
+	}
+
+	log.Infof("No free namespaces in pool %s, adding a pair", e.Config.NamespacePool)
+	appNamespace, err := util.CreateNamespaceWithPrefix(e.KubeClient, "istio-test-app-", e.Config.UseAutomaticInjection)
+	if err != nil {
+		return err
+	}
+	istioNamespace, err := util.CreateNamespaceWithPrefix(e.KubeClient, "istio-test-", false)
+	if err != nil {
+		util.DeleteNamespace(e.KubeClient, appNamespace)
+		return err
+	}
+	label := func(name string, labels, annotations map[string]string) error {
+		ns, getErr := e.KubeClient.CoreV1().Namespaces().Get(name, meta_v1.GetOptions{})
+		if getErr != nil {
+			return getErr
+		}
+		ns.Labels[poolLabel] = e.Config.NamespacePool
+		for key, value := range labels {
+			ns.Labels[key] = value
+		}
+		if ns.Annotations == nil {
+			ns.Annotations = make(map[string]string)
+		}
+		for key, value := range annotations {
+			ns.Annotations[key] = value
+		}
+		_, getErr = e.KubeClient.CoreV1().Namespaces().Update(ns)
+		return getErr
+	}
+	// the Istio namespace first, so that the pair is never claimed without it
+	if err = label(istioNamespace, nil, nil); err == nil {
+		err = label(appNamespace, map[string]string{poolAuthLabel: e.poolAuth()}, map[string]string{
+			poolIstioNamespaceAnnotation: istioNamespace,
+			poolClaimedByAnnotation:      owner,
+			poolClaimedAtAnnotation:      time.Now().UTC().Format(time.RFC3339),
+		})
+	}
+	if err != nil {
+		util.DeleteNamespace(e.KubeClient, appNamespace)
+		util.DeleteNamespace(e.KubeClient, istioNamespace)
+		return err
+	}
+	e.Config.Namespace, e.Config.IstioNamespace = appNamespace, istioNamespace
+	e.pooledNamespace = appNamespace
+	return nil
+}
+
+// resetPooledConfigs deletes the Istio configs that the previous run of the claimed namespaces left
+// in the app namespace, such as the configs of a killed run.
+func (e *Environment) resetPooledConfigs() error {
+	for _, desc := range e.config.ConfigDescriptor() {
+		configs, err := e.config.List(desc.Type, e.Config.Namespace)
+		if err != nil {
+			return err
+		}
+		for _, config := range configs {
+			log.Infof("Delete config %s left in pooled namespace %s", config.Key(), e.Config.Namespace)
+			if err = e.config.Delete(desc.Type, config.Name, config.Namespace); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// releasePooledNamespaces deletes the Istio configs of the claimed namespaces and removes the claim,
+// leaving the control plane and the apps deployed for the next run.
+func (e *Environment) releasePooledNamespaces() {
+	if err := e.resetPooledConfigs(); err != nil {
+		log.Warna(err)
+	}
+	ns, err := e.KubeClient.CoreV1().Namespaces().Get(e.pooledNamespace, meta_v1.GetOptions{})
+	if err != nil {
+		log.Warna(err)
+		return
+	}
+	delete(ns.Annotations, poolClaimedByAnnotation)
+	delete(ns.Annotations, poolClaimedAtAnnotation)
+	if _, err = e.KubeClient.CoreV1().Namespaces().Update(ns); err != nil {
+		log.Warna(err)
+		return
+	}
+	log.Infof("Released namespaces %s and %s to pool %s", e.Config.Namespace, e.Config.IstioNamespace,
+		e.Config.NamespacePool)
+	e.pooledNamespace = ""
+	e.Config.Namespace, e.Config.IstioNamespace = "", ""
+}
+
+// poolAuth returns the value of the poolAuthLabel of the namespaces of the environment.
+func (e *Environment) poolAuth() string {
+	return strings.ToLower(e.Auth.String())
+}
+
+// claimed returns true if a run claimed the namespace less than poolClaimExpiry ago.
+func claimed(ns *v1.Namespace) bool {
+	if ns.Annotations[poolClaimedByAnnotation] == "" {
+		return false
+	}
+	at, err := time.Parse(time.RFC3339, ns.Annotations[poolClaimedAtAnnotation])
+	if err != nil || time.Since(at) > poolClaimExpiry {
+		log.Warnf("The claim of namespace %s by %s expired", ns.Name, ns.Annotations[poolClaimedByAnnotation])
+		return false
+	}
+	return true
+}
+
+func claim(ns *v1.Namespace, owner string) {
+	ns.Annotations[poolClaimedByAnnotation] = owner
+	ns.Annotations[poolClaimedAtAnnotation] = time.Now().UTC().Format(time.RFC3339)
+}
+
+// poolOwner identifies the run claiming namespaces, for whoever looks at the pool.
+func poolOwner() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
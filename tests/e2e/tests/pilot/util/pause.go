@@ -0,0 +1,104 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"istio.io/istio/pilot/pkg/config/kube/crd"
+	"istio.io/istio/pilot/pkg/kube/inject"
+	"istio.io/istio/pilot/test/util"
+	"istio.io/istio/pkg/log"
+)
+
+// pauseMu keeps the tests that fail at once, with -parallel, from prompting together.
+var pauseMu sync.Mutex
+
+// PauseOnFailure prints how to inspect the environment the test failed in, then blocks until the
+// user hits enter, so that the failure can be debugged on live traffic before the teardown. It talks
+// to the terminal of the run, since go test does not hand its stdin down to the tests, and falls
+// back to the standard streams when there is none.
+func (e *Environment) PauseOnFailure(test string, failure error) {
+	pauseMu.Lock()
+	defer pauseMu.Unlock()
+
+	var in io.Reader = os.Stdin
+	var out io.Writer = os.Stderr
+	if tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0); err == nil {
+		defer func() { _ = tty.Close() }()
+		in, out = tty, tty
+	} else {
+		log.Infof("No terminal to pause on, reading the standard input: %v", err)
+	}
+	e.PrintDebugHelp(out, test, failure)
+	fmt.Fprintf(out, "\nPress enter to tear down %s and continue the run... ", test)
+	if _, err := bufio.NewReader(in).ReadString('\n'); err != nil {
+		log.Warnf("Cannot read from the terminal, continuing: %v", err)
+	}
+}
+
+// PrintDebugHelp writes the namespaces of the environment the test failed in, with the kubectl
+// commands showing their pods and Istio configs, and how to reach the Envoy admin port of the
+// sidecars.
+func (e *Environment) PrintDebugHelp(out io.Writer, test string, failure error) {
+	kubectl := "kubectl --kubeconfig " + e.Config.KubeConfig
+	fmt.Fprintf(out, "\n%s failed: %v\n\n", test, failure)
+	fmt.Fprintf(out, "App namespace:   %s\nIstio namespace: %s\n", e.Config.Namespace, e.Config.IstioNamespace)
+	if e.Config.RemoteKubeConfig != "" {
+		fmt.Fprintf(out, "Remote cluster:  %s\n", e.Config.RemoteKubeConfig)
+	}
+
+	var kinds []string
+	if e.config != nil {
+		for _, desc := range e.config.ConfigDescriptor() {
+			kinds = append(kinds, crd.ResourceName(desc.Plural))
+		}
+	}
+	sort.Strings(kinds)
+	fmt.Fprintf(out, "\nPods and configs:\n")
+	fmt.Fprintf(out, "  %s -n %s get pods -o wide\n", kubectl, e.Config.Namespace)
+	fmt.Fprintf(out, "  %s -n %s get pods -o wide\n", kubectl, e.Config.IstioNamespace)
+	if len(kinds) > 0 {
+		fmt.Fprintf(out, "  %s -n %s get %s -o yaml\n", kubectl, e.Config.Namespace, strings.Join(kinds, ","))
+	}
+
+	if e.KubeClient == nil {
+		return
+	}
+	fmt.Fprintf(out, "\nControl plane logs:\n")
+	for _, pod := range util.GetPods(e.KubeClient, e.Config.IstioNamespace) {
+		if strings.HasPrefix(pod, "istio-pilot") {
+			fmt.Fprintf(out, "  %s -n %s logs %s -c discovery\n", kubectl, e.Config.IstioNamespace, pod)
+		}
+	}
+
+	adminPort := 15000
+	if e.meshConfig != nil && e.meshConfig.DefaultConfig != nil {
+		adminPort = int(e.meshConfig.DefaultConfig.ProxyAdminPort)
+	}
+	fmt.Fprintf(out, "\nSidecars, whose Envoy admin API serves /config_dump, /clusters and /stats once forwarded:\n")
+	for _, pod := range util.GetPods(e.KubeClient, e.Config.Namespace) {
+		fmt.Fprintf(out, "  %s\n", pod)
+		fmt.Fprintf(out, "    %s -n %s logs %s -c %s\n", kubectl, e.Config.Namespace, pod, inject.ProxyContainerName)
+		fmt.Fprintf(out, "    %s -n %s port-forward %s %d:%d, then curl localhost:%d/config_dump\n", kubectl,
+			e.Config.Namespace, pod, adminPort, adminPort, adminPort)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/istio/pilot/pkg/kube/inject"
+	"istio.io/istio/pkg/log"
+)
+
+const (
+	// path of the capture in the sidecar containers
+	pcapPath = "/tmp/pilot-e2e.pcap"
+	// packets after which tcpdump stops, to bound the size of a capture of a long run
+	pcapPacketLimit = 20000
+)
+
+// PacketCapture runs tcpdump in the sidecars of the app namespace, started by StartPacketCapture.
+type PacketCapture struct {
+	env  *Environment
+	pods []string
+	wg   sync.WaitGroup
+}
+
+// StartPacketCapture starts capturing the packets of every interface of the pods of the app namespace
+// with a sidecar, for a failure to be debugged from the handshakes and resets it saw. tcpdump runs
+// through sudo in the sidecar, which takes the debug proxy image and its privileged mode, set with
+// DebugImagesAndMode; the capture fails on the pods without them. Failures are only logged.
+func (e *Environment) StartPacketCapture() *PacketCapture {
+	c := &PacketCapture{env: e}
+	if !e.Config.DebugImagesAndMode {
+		log.Warnf("Cannot capture packets without the debug proxy images and mode")
+		return c
+	}
+	pods, err := e.KubeClient.CoreV1().Pods(e.Config.Namespace).List(meta_v1.ListOptions{})
+	if err != nil {
+		log.Warnf("Cannot list the pods to capture the packets of: %v", err)
+		return c
+	}
+	for i := range pods.Items {
+		if !hasContainer(&pods.Items[i], inject.ProxyContainerName) {
+			continue
+		}
+		pod := pods.Items[i].Name
+		cmd := exec.Command("kubectl", "exec", pod, "--kubeconfig", e.Config.KubeConfig, "-n", e.Config.Namespace,
+			"-c", inject.ProxyContainerName, "--", "sudo", "-n", "tcpdump", "-i", "any", "-s", "0", "-U",
+			"-c", strconv.Itoa(pcapPacketLimit), "-w", pcapPath) // #nosec
+		if err = cmd.Start(); err != nil {
+			log.Warnf("Cannot capture the packets of %s: %v", pod, err)
+			continue
+		}
+		c.pods = append(c.pods, pod)
+		c.wg.Add(1)
+		go func(pod string) {
+			defer c.wg.Done()
+			// tcpdump exits on the interrupt of Stop, with an error status
+			if err := cmd.Wait(); err != nil {
+				log.Infof("Capture of the packets of %s ended: %v", pod, err)
+			}
+		}(pod)
+	}
+	return c
+}
+
+// Stop stops the capture, and writes it to ErrorLogsDir/<name>/<pod>/capture.pcap if keep is set and
+// ErrorLogsDir is not empty. The captures are removed from the sidecars either way.
+func (c *PacketCapture) Stop(name string, keep bool) {
+	e := c.env
+	for _, pod := range c.pods {
+		if _, err := e.podProxyExec(pod, "sudo", "-n", "pkill", "-INT", "tcpdump"); err != nil {
+			log.Infof("Cannot stop the capture of the packets of %s: %v", pod, err)
+		}
+	}
+	c.wg.Wait()
+
+	for _, pod := range c.pods {
+		if keep && e.Config.ErrorLogsDir != "" {
+			if err := c.save(name, pod); err != nil {
+				log.Warnf("Cannot save the capture of the packets of %s: %v", pod, err)
+			}
+		}
+		if _, err := e.podProxyExec(pod, "sudo", "-n", "rm", "-f", pcapPath); err != nil {
+			log.Infof("Cannot remove the capture of the packets of %s: %v", pod, err)
+		}
+	}
+	if keep && len(c.pods) > 0 && e.Config.ErrorLogsDir != "" {
+		log.Infof("Packet captures written to %s", filepath.Join(e.Config.ErrorLogsDir, name))
+	}
+}
+
+// save copies the capture of the pod to the logs directory.
+func (c *PacketCapture) save(name, pod string) error {
+	pcap, err := c.env.podProxyExec(pod, "sudo", "-n", "cat", pcapPath)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(c.env.Config.ErrorLogsDir, name, pod)
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "capture.pcap"), pcap, 0644)
+}
+
+// hasContainer returns whether the pod has the container.
+func hasContainer(pod *v1.Pod, name string) bool {
+	for _, container := range pod.Spec.Containers {
+		if container.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// podProxyExec runs the command in the sidecar of the pod, and returns its output, binary or not.
+func (e *Environment) podProxyExec(pod string, command ...string) ([]byte, error) {
+	args := append([]string{"exec", pod, "--kubeconfig", e.Config.KubeConfig, "-n", e.Config.Namespace,
+		"-c", inject.ProxyContainerName, "--"}, command...)
+	out, err := exec.Command("kubectl", args...).Output() // #nosec
+	if err != nil {
+		return nil, fmt.Errorf("%v in the sidecar of %s: %v", command, pod, err)
+	}
+	return out, nil
+}
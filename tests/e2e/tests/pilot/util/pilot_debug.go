@@ -0,0 +1,248 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/istio/pilot/pkg/kube/inject"
+	"istio.io/istio/pilot/pkg/model"
+	envoyv1 "istio.io/istio/pilot/pkg/proxy/envoy/v1"
+	"istio.io/istio/pilot/test/util"
+	"istio.io/istio/pkg/log"
+)
+
+const (
+	// port of the HTTP discovery API inside the Pilot pod
+	pilotHTTPPort = 8080
+	// how long the WaitForPilot helpers wait for Pilot to have the expected state
+	pilotWaitTimeout = time.Minute
+	// delay between two reads of the Pilot state while waiting
+	pilotPollInterval = time.Second
+)
+
+// PilotDebug returns the body of the given path of the HTTP discovery API of Pilot, such as
+// /v1/registration, fetched from within the Pilot pod, or through a port-forward with PortForward.
+func (e *Environment) PilotDebug(path string) (string, error) {
+	if e.Config.PortForward {
+		f, err := e.ForwardPilot()
+		if err != nil {
+			return "", err
+		}
+		return f.Get(path)
+	}
+	pod, err := e.pilotPod()
+	if err != nil {
+		return "", err
+	}
+	cmd := fmt.Sprintf("kubectl exec %s --kubeconfig %s -n %s -c %s -- curl -s -f localhost:%d%s",
+		pod, e.Config.KubeConfig, e.Config.IstioNamespace, inject.ProxyContainerName, pilotHTTPPort, path)
+	return util.Shell(cmd)
+}
+
+// pilotPod returns the first Pilot pod of the Istio namespace.
+func (e *Environment) pilotPod() (string, error) {
+	for _, name := range util.GetPods(e.KubeClient, e.Config.IstioNamespace) {
+		if strings.HasPrefix(name, "istio-pilot") {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("no pilot pod in namespace %s", e.Config.IstioNamespace)
+}
+
+// PilotEndpoints returns the addresses (ip:port) of the endpoints in the Pilot registry, by service
+// key (such as c.<namespace>.svc.cluster.local|http).
+func (e *Environment) PilotEndpoints() (map[string][]string, error) {
+	out, err := e.PilotDebug("/v1/registration")
+	if err != nil {
+		return nil, err
+	}
+	var registration []struct {
+		Key   string `json:"service-key"`
+		Hosts []struct {
+			Address string `json:"ip_address"`
+			Port    int    `json:"port"`
+		} `json:"hosts"`
+	}
+	if err = json.Unmarshal([]byte(out), &registration); err != nil {
+		return nil, fmt.Errorf("cannot parse the pilot registration: %v", err)
+	}
+	endpoints := make(map[string][]string, len(registration))
+	for _, service := range registration {
+		for _, host := range service.Hosts {
+			endpoints[service.Key] = append(endpoints[service.Key], host.Address+":"+strconv.Itoa(host.Port))
+		}
+	}
+	return endpoints, nil
+}
+
+// PilotRoutes returns the HTTP route config Pilot serves to the sidecar of the first pod of the app
+// for the given port.
+func (e *Environment) PilotRoutes(app string, port int) (*envoyv1.HTTPRouteConfig, error) {
+	node, err := e.pilotServiceNode(app)
+	if err != nil {
+		return nil, err
+	}
+	out, err := e.PilotDebug(fmt.Sprintf("/v1/routes/%d/%s/%s", port, app, node))
+	if err != nil {
+		return nil, err
+	}
+	var routes envoyv1.HTTPRouteConfig
+	if err = json.Unmarshal([]byte(out), &routes); err != nil {
+		return nil, fmt.Errorf("cannot parse the pilot routes of %s: %v", app, err)
+	}
+	return &routes, nil
+}
+
+// PilotClusters returns the clusters Pilot serves to the sidecar of the first pod of the app, by name.
+func (e *Environment) PilotClusters(app string) (map[string]*envoyv1.Cluster, error) {
+	node, err := e.pilotServiceNode(app)
+	if err != nil {
+		return nil, err
+	}
+	return e.pilotClusters(app, node)
+}
+
+// pilotClusters returns the clusters Pilot serves to the proxy of the service cluster and node, by name.
+func (e *Environment) pilotClusters(serviceCluster, node string) (map[string]*envoyv1.Cluster, error) {
+	out, err := e.PilotDebug(fmt.Sprintf("/v1/clusters/%s/%s", serviceCluster, node))
+	if err != nil {
+		return nil, err
+	}
+	var cds struct {
+		Clusters []*envoyv1.Cluster `json:"clusters"`
+	}
+	if err = json.Unmarshal([]byte(out), &cds); err != nil {
+		return nil, fmt.Errorf("cannot parse the pilot clusters of %s: %v", serviceCluster, err)
+	}
+	clusters := make(map[string]*envoyv1.Cluster, len(cds.Clusters))
+	for _, cluster := range cds.Clusters {
+		clusters[cluster.Name] = cluster
+	}
+	return clusters, nil
+}
+
+// pilotServiceNode returns the service node of the sidecar of the first pod of the app, as it
+// identifies itself to Pilot.
+func (e *Environment) pilotServiceNode(app string) (string, error) {
+	if len(e.Apps[app]) == 0 {
+		return "", fmt.Errorf("missing pod names for app %q", app)
+	}
+	pod, err := e.KubeClient.CoreV1().Pods(e.Config.Namespace).Get(e.Apps[app][0], meta_v1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	return serviceNode(pod, model.Sidecar), nil
+}
+
+// serviceNode returns the service node of the proxy of the given type in the pod.
+func serviceNode(pod *v1.Pod, nodeType model.NodeType) string {
+	node := model.Proxy{
+		Type:      nodeType,
+		IPAddress: pod.Status.PodIP,
+		ID:        pod.Name + "." + pod.Namespace,
+		Domain:    pod.Namespace + ".svc.cluster.local",
+	}
+	return node.ServiceNode()
+}
+
+// WaitForPilotEndpoints waits until Pilot has at least count endpoints for the named port of the
+// service, given by name in the app namespace.
+func (e *Environment) WaitForPilotEndpoints(service, portName string, count int) error {
+	key := fmt.Sprintf("%s.%s.svc.cluster.local|%s", service, e.Config.Namespace, portName)
+	return waitForPilot(func() error {
+		endpoints, err := e.PilotEndpoints()
+		if err != nil {
+			return err
+		}
+		if len(endpoints[key]) < count {
+			return fmt.Errorf("pilot has %d endpoints for %s, want %d: %v", len(endpoints[key]), key, count,
+				endpoints[key])
+		}
+		return nil
+	})
+}
+
+// WaitForPilotRoute waits until the route config Pilot serves to the sidecar of the app for the port
+// has a virtual host for the domain, with a route to a cluster whose name contains the given string.
+// An empty cluster matches any route.
+func (e *Environment) WaitForPilotRoute(app string, port int, domain, cluster string) error {
+	return waitForPilot(func() error {
+		routes, err := e.PilotRoutes(app, port)
+		if err != nil {
+			return err
+		}
+		for _, host := range routes.VirtualHosts {
+			if !containsString(host.Domains, domain) {
+				continue
+			}
+			for _, route := range host.Routes {
+				if routesTo(route, cluster) {
+					return nil
+				}
+			}
+			return fmt.Errorf("no route of %s to cluster %q for %s:%d", app, cluster, domain, port)
+		}
+		return fmt.Errorf("no virtual host of %s for %s:%d", app, domain, port)
+	})
+}
+
+// routesTo returns true if the route sends traffic to a cluster whose name contains the given string.
+func routesTo(route *envoyv1.HTTPRoute, cluster string) bool {
+	if strings.Contains(route.Cluster, cluster) {
+		return true
+	}
+	if route.WeightedClusters == nil {
+		return false
+	}
+	for _, weighted := range route.WeightedClusters.Clusters {
+		if weighted.Weight > 0 && strings.Contains(weighted.Name, cluster) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// waitForPilot calls check until it succeeds or pilotWaitTimeout is over, and returns its last error.
+func waitForPilot(check func() error) error {
+	deadline := time.Now().Add(pilotWaitTimeout)
+	for {
+		err := check()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		log.Infof("Waiting for pilot: %v", err)
+		time.Sleep(pilotPollInterval)
+	}
+}
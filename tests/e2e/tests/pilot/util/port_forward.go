@@ -0,0 +1,285 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os/exec"
+	"sync"
+	"time"
+
+	"istio.io/istio/pkg/log"
+)
+
+const (
+	// how long a port-forward takes to accept connections on its local port
+	portForwardTimeout = 30 * time.Second
+	// first and longest wait before restarting a port-forward that exited
+	portForwardBackoff    = time.Second
+	portForwardMaxBackoff = 10 * time.Second
+	// timeout of the requests made through a port-forward
+	portForwardRequestTimeout = 10 * time.Second
+)
+
+// PortForward forwards a local port to a port of a pod with kubectl port-forward, and restarts
+// kubectl when it exits, such as when the API server drops the connection, until it is closed.
+// A port-forward follows one pod: it cannot reconnect once the pod is gone.
+type PortForward struct {
+	kubeConfig string
+	namespace  string
+	pod        string
+	port       int
+	localPort  int
+	client     *http.Client
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	closed bool
+	// closed once the supervising goroutine returns
+	done chan struct{}
+}
+
+// PortForward forwards a free local port to the port of the pod, and returns once the local port
+// accepts connections. The caller closes the port-forward.
+func (e *Environment) PortForward(namespace, pod string, port int) (*PortForward, error) {
+	localPort, err := freePort()
+	if err != nil {
+		return nil, err
+	}
+	f := &PortForward{
+		kubeConfig: e.Config.KubeConfig,
+		namespace:  namespace,
+		pod:        pod,
+		port:       port,
+		localPort:  localPort,
+		client:     &http.Client{Timeout: portForwardRequestTimeout},
+		done:       make(chan struct{}),
+	}
+	go f.supervise()
+	if err = f.waitReady(portForwardTimeout); err != nil {
+		f.Close()
+		return nil, err
+	}
+	log.Infof("Forwarding %s to %s", f.Address(), f)
+	return f, nil
+}
+
+// String describes the target of the port-forward.
+func (f *PortForward) String() string {
+	return fmt.Sprintf("%s/%s:%d", f.namespace, f.pod, f.port)
+}
+
+// Address returns the local address forwarded to the pod, as host:port.
+func (f *PortForward) Address() string {
+	return fmt.Sprintf("localhost:%d", f.localPort)
+}
+
+// URL returns the URL of the path on the port of the pod.
+func (f *PortForward) URL(path string) string {
+	return "http://" + f.Address() + path
+}
+
+// Do makes a GET request of the path to the port of the pod, and returns the status code and the body
+// of the response whatever the code. If the request cannot be made, it restarts the port-forward and
+// tries once more.
+func (f *PortForward) Do(path string) (int, string, error) {
+	code, body, err := f.get(path)
+	if err != nil {
+		log.Warnf("request of %s through the port-forward to %s failed, reconnecting: %v", path, f, err)
+		if err = f.Reconnect(); err != nil {
+			return 0, "", err
+		}
+		code, body, err = f.get(path)
+	}
+	return code, body, err
+}
+
+// Get makes a GET request of the path like Do, and returns the body of the response, or an error if its
+// status code is an error.
+func (f *PortForward) Get(path string) (string, error) {
+	code, body, err := f.Do(path)
+	if err != nil {
+		return "", err
+	}
+	if code >= 400 {
+		return body, fmt.Errorf("GET %s of %s answered %d: %s", path, f, code, body)
+	}
+	return body, nil
+}
+
+// get makes one GET request of the path.
+func (f *PortForward) get(path string) (int, string, error) {
+	resp, err := f.client.Get(f.URL(path))
+	if err != nil {
+		return 0, "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, "", err
+	}
+	return resp.StatusCode, string(data), nil
+}
+
+// Reconnect restarts kubectl, and returns once the local port accepts connections again.
+func (f *PortForward) Reconnect() error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return fmt.Errorf("the port-forward to %s is closed", f)
+	}
+	f.kill()
+	f.mu.Unlock()
+	return f.waitReady(portForwardTimeout)
+}
+
+// Close stops the port-forward, and returns once kubectl exited.
+func (f *PortForward) Close() {
+	f.mu.Lock()
+	f.closed = true
+	f.kill()
+	f.mu.Unlock()
+	<-f.done
+}
+
+// kill kills the running kubectl, if any, which the supervising goroutine restarts unless the
+// port-forward is closed. It is called with mu held.
+func (f *PortForward) kill() {
+	if f.cmd == nil {
+		return
+	}
+	if err := f.cmd.Process.Kill(); err != nil {
+		log.Warna(err)
+	}
+}
+
+// supervise runs kubectl port-forward until the port-forward is closed, restarting it with a growing
+// backoff while it keeps failing.
+func (f *PortForward) supervise() {
+	defer close(f.done)
+	backoff := portForwardBackoff
+	for {
+		f.mu.Lock()
+		if f.closed {
+			f.mu.Unlock()
+			return
+		}
+		cmd := exec.Command("kubectl", "port-forward", "--kubeconfig", f.kubeConfig, // #nosec
+			"-n", f.namespace, f.pod, fmt.Sprintf("%d:%d", f.localPort, f.port))
+		err := cmd.Start()
+		if err == nil {
+			f.cmd = cmd
+		}
+		f.mu.Unlock()
+
+		started := time.Now()
+		if err == nil {
+			err = cmd.Wait()
+		}
+
+		f.mu.Lock()
+		f.cmd = nil
+		closed := f.closed
+		f.mu.Unlock()
+		if closed {
+			return
+		}
+		if time.Since(started) > portForwardMaxBackoff {
+			backoff = portForwardBackoff
+		}
+		log.Warnf("port-forward to %s exited, restarting in %v: %v", f, backoff, err)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > portForwardMaxBackoff {
+			backoff = portForwardMaxBackoff
+		}
+	}
+}
+
+// waitReady waits for the local port to accept connections.
+func (f *PortForward) waitReady(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		conn, err := net.DialTimeout("tcp", f.Address(), time.Second)
+		if err == nil {
+			_ = conn.Close()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("the port-forward to %s is not ready after %v: %v", f, timeout, err)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// portForwards are the port-forwards an environment keeps open until its teardown, shared by the
+// copies of ForTest.
+type portForwards struct {
+	mu       sync.Mutex
+	forwards map[string]*PortForward
+}
+
+// forward returns the port-forward to the port of the pod, starting it if needed. Teardown closes it.
+func (e *Environment) forward(namespace, pod string, port int) (*PortForward, error) {
+	e.portForwards.mu.Lock()
+	defer e.portForwards.mu.Unlock()
+	key := fmt.Sprintf("%s/%s:%d", namespace, pod, port)
+	if f, ok := e.portForwards.forwards[key]; ok {
+		return f, nil
+	}
+	f, err := e.PortForward(namespace, pod, port)
+	if err != nil {
+		return nil, err
+	}
+	if e.portForwards.forwards == nil {
+		e.portForwards.forwards = make(map[string]*PortForward)
+	}
+	e.portForwards.forwards[key] = f
+	return f, nil
+}
+
+// ForwardApp returns the port-forward to the port of the app container of the first pod of the app.
+func (e *Environment) ForwardApp(app string, port int) (*PortForward, error) {
+	if len(e.Apps[app]) == 0 {
+		return nil, fmt.Errorf("missing pod names for app %q", app)
+	}
+	return e.forward(e.Config.Namespace, e.Apps[app][0], port)
+}
+
+// ForwardProxyAdmin returns the port-forward to the admin port of the sidecar of the pod.
+func (e *Environment) ForwardProxyAdmin(pod string) (*PortForward, error) {
+	return e.forward(e.Config.Namespace, pod, int(e.meshConfig.DefaultConfig.ProxyAdminPort))
+}
+
+// ForwardPilot returns the port-forward to the HTTP discovery API of Pilot.
+func (e *Environment) ForwardPilot() (*PortForward, error) {
+	pod, err := e.pilotPod()
+	if err != nil {
+		return nil, err
+	}
+	return e.forward(e.Config.IstioNamespace, pod, pilotHTTPPort)
+}
+
+// closePortForwards closes the port-forwards of the environment.
+func (e *Environment) closePortForwards() {
+	e.portForwards.mu.Lock()
+	defer e.portForwards.mu.Unlock()
+	for key, f := range e.portForwards.forwards {
+		f.Close()
+		delete(e.portForwards.forwards, key)
+	}
+}
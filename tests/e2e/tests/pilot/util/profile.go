@@ -0,0 +1,188 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/istio/pkg/log"
+)
+
+const (
+	// duration of the CPU profiles collected from Pilot
+	pprofCPUSeconds = 10
+)
+
+// pilotDebugAddress returns the host:port of the Pilot debug port exposed by its load balancer.
+func (e *Environment) pilotDebugAddress() (string, error) {
+	if e.Config.DebugPort == 0 {
+		return "", fmt.Errorf("pilot debug port is not set")
+	}
+	svc, err := e.KubeClient.CoreV1().Services(e.Config.IstioNamespace).Get("istio-pilot", meta_v1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		host := ingress.IP
+		if host == "" {
+			host = ingress.Hostname
+		}
+		if host != "" {
+			return host + ":" + strconv.Itoa(e.Config.DebugPort), nil
+		}
+	}
+	return "", fmt.Errorf("pilot service has no load balancer address")
+}
+
+// CollectPilotProfiles fetches a CPU and a heap profile from the Pilot debug port and writes them
+// to Config.PprofDir as <name>-cpu.pprof and <name>-heap.pprof. It is a no-op when the directory
+// is not set, and only logs a warning if the debug port cannot be reached.
+func (e *Environment) CollectPilotProfiles(name string) {
+	if e.Config.PprofDir == "" {
+		return
+	}
+	e.collectPilotProfiles(e.Config.PprofDir, name)
+}
+
+// collectPilotProfiles writes a CPU and a heap profile of Pilot to the directory.
+func (e *Environment) collectPilotProfiles(dir, name string) {
+	address, err := e.pilotDebugAddress()
+	if err != nil {
+		log.Warnf("Skipping pilot profiles for %s: %v", name, err)
+		return
+	}
+
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		log.Warnf("Skipping pilot profiles for %s: %v", name, err)
+		return
+	}
+
+	profiles := []struct {
+		suffix string
+		path   string
+	}{
+		{"cpu", fmt.Sprintf("/debug/pprof/profile?seconds=%d", pprofCPUSeconds)},
+		{"heap", "/debug/pprof/heap"},
+	}
+	client := &http.Client{Timeout: (pprofCPUSeconds + 20) * time.Second}
+	for _, profile := range profiles {
+		file := filepath.Join(dir, fmt.Sprintf("%s-%s.pprof", name, profile.suffix))
+		if err = fetchToFile(client, "http://"+address+profile.path, file); err != nil {
+			log.Warnf("Skipping pilot %s profile for %s: %v", profile.suffix, name, err)
+			return
+		}
+		log.Infof("Wrote pilot %s profile to %s", profile.suffix, file)
+	}
+}
+
+// PilotProfiler collects Pilot profiles periodically during the run, started by StartPilotProfiler.
+type PilotProfiler struct {
+	env      *Environment
+	dir      string
+	name     string
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+
+	mu sync.Mutex
+	// test running when the next profiles are collected
+	test string
+}
+
+// StartPilotProfiler collects a CPU and a heap profile of Pilot every interval, named
+// <name>-<sequence>-<running test> in PprofDir, or in the pprof directory of ReportDir if it is not
+// set, of the working directory if neither is, so that a regression of the control plane can be bisected from the artifacts of the run.
+// The interval is at least the duration of the CPU profile.
+func (e *Environment) StartPilotProfiler(name string, interval time.Duration) *PilotProfiler {
+	dir := e.Config.PprofDir
+	if dir == "" {
+		dir = filepath.Join(e.Config.ReportDir, "pprof")
+	}
+	if interval < pprofCPUSeconds*time.Second {
+		interval = pprofCPUSeconds * time.Second
+	}
+	p := &PilotProfiler{
+		env:      e,
+		dir:      dir,
+		name:     name,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		test:     "setup",
+	}
+	e.profiler = p
+	go p.run()
+	return p
+}
+
+// SetRunningTest labels the next periodic Pilot profiles with the test, if the profiler runs.
+func (e *Environment) SetRunningTest(test string) {
+	if e.profiler == nil {
+		return
+	}
+	e.profiler.mu.Lock()
+	e.profiler.test = test
+	e.profiler.mu.Unlock()
+}
+
+func (p *PilotProfiler) run() {
+	defer close(p.done)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for sequence := 1; ; sequence++ {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+		p.mu.Lock()
+		test := p.test
+		p.mu.Unlock()
+		p.env.collectPilotProfiles(p.dir, fmt.Sprintf("%s-%04d-%s", p.name, sequence, test))
+	}
+}
+
+// Stop stops collecting the profiles, once the profiles being collected are written.
+func (p *PilotProfiler) Stop() {
+	close(p.stop)
+	<-p.done
+	p.env.profiler = nil
+}
+
+func fetchToFile(client *http.Client, url, file string) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	// nolint: errcheck
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", url, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0644)
+}
@@ -0,0 +1,159 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"istio.io/istio/pilot/test/util"
+)
+
+const (
+	// port of the Prometheus server inside its pod
+	prometheusPort = 9090
+	// how long Prometheus takes to answer through the port-forward
+	prometheusForwardTimeout = 30 * time.Second
+)
+
+// Prometheus queries the Prometheus server of the Istio namespace through a port-forward to its pod.
+type Prometheus struct {
+	address string
+	forward *PortForward
+}
+
+// Prometheus port-forwards a free local port to the Prometheus pod of the Istio namespace, and returns
+// once the server answers on it. Close stops the port-forward.
+func (e *Environment) Prometheus() (*Prometheus, error) {
+	var pod string
+	for _, name := range util.GetPods(e.KubeClient, e.Config.IstioNamespace) {
+		if strings.HasPrefix(name, "prometheus") {
+			pod = name
+			break
+		}
+	}
+	if pod == "" {
+		return nil, fmt.Errorf("no prometheus pod in namespace %s", e.Config.IstioNamespace)
+	}
+	forward, err := e.PortForward(e.Config.IstioNamespace, pod, prometheusPort)
+	if err != nil {
+		return nil, err
+	}
+	p := &Prometheus{address: forward.URL(""), forward: forward}
+	deadline := time.Now().Add(prometheusForwardTimeout)
+	for {
+		if _, err = p.Query("up"); err == nil {
+			return p, nil
+		}
+		if time.Now().After(deadline) {
+			p.Close()
+			return nil, fmt.Errorf("prometheus is not reachable through the port-forward: %v", err)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// PrometheusSample is a sample of an instant vector, with the labels of its series.
+type PrometheusSample struct {
+	Labels map[string]string
+	Value  float64
+}
+
+// Query evaluates the instant vector expression, and returns the sum of the values of its samples,
+// 0 when it has none.
+func (p *Prometheus) Query(expr string) (float64, error) {
+	samples, err := p.QuerySamples(expr)
+	if err != nil {
+		return 0, err
+	}
+	var sum float64
+	for _, sample := range samples {
+		sum += sample.Value
+	}
+	return sum, nil
+}
+
+// QuerySamples evaluates the instant vector expression, and returns its samples.
+func (p *Prometheus) QuerySamples(expr string) ([]PrometheusSample, error) {
+	resp, err := http.Get(p.address + "/api/v1/query?query=" + url.QueryEscape(expr))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			ResultType string `json:"resultType"`
+			Result     []struct {
+				Metric map[string]string `json:"metric"`
+				// timestamp and value as a string
+				Value []interface{} `json:"value"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	if err = json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("cannot parse the prometheus response to %q: %v", expr, err)
+	}
+	if result.Status != "success" {
+		return nil, fmt.Errorf("prometheus query %q failed: %s", expr, result.Error)
+	}
+	if result.Data.ResultType != "vector" {
+		return nil, fmt.Errorf("prometheus query %q returned a %s, want a vector", expr, result.Data.ResultType)
+	}
+	samples := make([]PrometheusSample, 0, len(result.Data.Result))
+	for _, sample := range result.Data.Result {
+		if len(sample.Value) != 2 {
+			return nil, fmt.Errorf("prometheus query %q returned the sample %v", expr, sample.Value)
+		}
+		text, ok := sample.Value[1].(string)
+		if !ok {
+			return nil, fmt.Errorf("prometheus query %q returned the value %v", expr, sample.Value[1])
+		}
+		value, parseErr := strconv.ParseFloat(text, 64)
+		if parseErr != nil {
+			return nil, parseErr
+		}
+		samples = append(samples, PrometheusSample{Labels: sample.Metric, Value: value})
+	}
+	return samples, nil
+}
+
+// Close stops the port-forward.
+func (p *Prometheus) Close() {
+	p.forward.Close()
+}
+
+// freePort returns a local port that no process listens on.
+func freePort() (int, error) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = listener.Close() }()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+const (
+	// delay between two reads of the config_dump of a sidecar while waiting for a config
+	propagationPollInterval = 200 * time.Millisecond
+)
+
+// ErrNoConfigDump is returned by TimeConfigPropagation when a sidecar does not serve /config_dump.
+var ErrNoConfigDump = errors.New("the sidecar does not serve /config_dump")
+
+// TimeConfigPropagation applies the configuration like ApplyConfig, then polls the config_dump of the
+// sidecar of each pod until it contains the marker, a string only found in the new configuration.
+// It returns the time from the apply to the marker showing up, by pod, or ErrNoConfigDump if a
+// sidecar has no config_dump to poll. The resolution is limited by
+// the time kubectl exec takes to read a config_dump.
+func (e *Environment) TimeConfigPropagation(inFile string, data interface{}, pods []string, marker string,
+	timeout time.Duration) (map[string]time.Duration, error) {
+	start := time.Now()
+	if err := e.applyConfig(inFile, data); err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	var errs error
+	noConfigDump := false
+	latencies := make(map[string]time.Duration, len(pods))
+	var wg sync.WaitGroup
+	for _, pod := range pods {
+		wg.Add(1)
+		go func(pod string) {
+			defer wg.Done()
+			latency, err := e.waitForConfigDump(pod, marker, start, timeout)
+			mu.Lock()
+			defer mu.Unlock()
+			if err == ErrNoConfigDump {
+				noConfigDump = true
+			} else if err != nil {
+				errs = multierror.Append(errs, fmt.Errorf("%s: %v", pod, err))
+				return
+			}
+			latencies[pod] = latency
+		}(pod)
+	}
+	wg.Wait()
+	if noConfigDump {
+		return nil, ErrNoConfigDump
+	}
+	return latencies, errs
+}
+
+// waitForConfigDump polls the config_dump of the sidecar of the pod until it contains the marker,
+// and returns the time since start.
+func (e *Environment) waitForConfigDump(pod, marker string, start time.Time, timeout time.Duration) (time.Duration,
+	error) {
+	for {
+		out, err := e.PodProxyAdmin(pod, "/config_dump")
+		if err != nil {
+			return 0, err
+		}
+		if !strings.HasPrefix(strings.TrimSpace(out), "{") {
+			// older proxies answer with the admin help
+			return 0, ErrNoConfigDump
+		}
+		if strings.Contains(out, marker) {
+			return time.Since(start), nil
+		}
+		if time.Since(start) > timeout {
+			return 0, fmt.Errorf("config with %q not in the config_dump after %v", marker, timeout)
+		}
+		time.Sleep(propagationPollInterval)
+	}
+}
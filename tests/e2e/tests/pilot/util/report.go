@@ -0,0 +1,430 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Outcome is the outcome of one attempt of a test.
+type Outcome string
+
+const (
+	// OutcomePassed is the outcome of an attempt that ran without error.
+	OutcomePassed Outcome = "passed"
+	// OutcomeFlaky is the outcome of an attempt that failed, but passed once retried.
+	OutcomeFlaky Outcome = "flaky"
+	// OutcomeFailed is the outcome of an attempt whose setup or run failed on every try.
+	OutcomeFailed Outcome = "failed"
+	// OutcomeSkipped is the outcome of an attempt that was skipped.
+	OutcomeSkipped Outcome = "skipped"
+)
+
+// Measured is implemented by tests that measure something beyond pass or fail, such as a latency.
+// The harness adds the measurements of a passed attempt to its report.
+type Measured interface {
+	// Measurements returns the distributions measured by the last Run, by name.
+	Measurements() map[string]Distribution
+}
+
+// Distribution summarizes measured durations, in milliseconds.
+type Distribution struct {
+	Samples int     `json:"samples"`
+	P50     float64 `json:"p50_ms"`
+	P90     float64 `json:"p90_ms"`
+	P99     float64 `json:"p99_ms"`
+	Max     float64 `json:"max_ms"`
+}
+
+// NewDistribution returns the distribution of the samples.
+func NewDistribution(samples []time.Duration) Distribution {
+	if len(samples) == 0 {
+		return Distribution{}
+	}
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return Distribution{
+		Samples: len(sorted),
+		P50:     percentile(sorted, 50),
+		P90:     percentile(sorted, 90),
+		P99:     percentile(sorted, 99),
+		Max:     percentile(sorted, 100),
+	}
+}
+
+// String formats the distribution for logs.
+func (d Distribution) String() string {
+	return fmt.Sprintf("%d samples, p50 %.0fms, p90 %.0fms, p99 %.0fms, max %.0fms", d.Samples, d.P50, d.P90, d.P99, d.Max)
+}
+
+// AttemptReport is the report of one attempt of a test in one auth mode.
+type AttemptReport struct {
+	Auth    string
+	Test    string
+	Attempt int
+	Outcome Outcome
+	// time spent in each step, over all the tries of the attempt
+	Setup    time.Duration
+	Run      time.Duration
+	Teardown time.Duration
+	// error of a failed attempt, or reason of a skipped one
+	Message string
+	// errors of the tries that failed and were retried, in order
+	RetryFailures []string
+	// distributions measured by a passed attempt of a Measured test, by name
+	Measurements map[string]Distribution
+	// timing of the assertions checked with Environment.Eventually over all the tries, in the order
+	// they ended
+	Assertions []AssertionReport
+	// peak usage of the proxies during the attempt, if sampled against -proxy-cpu-ceiling or
+	// -proxy-memory-ceiling
+	ProxyResources []ResourceUsage
+}
+
+// Duration returns the time spent in the attempt.
+func (a *AttemptReport) Duration() time.Duration {
+	return a.Setup + a.Run + a.Teardown
+}
+
+// TestReport holds the reports of all the attempts of one test in one auth mode.
+type TestReport struct {
+	Auth     string
+	Test     string
+	Attempts []AttemptReport
+}
+
+// Reports collects the report of every test attempt across auth modes. It is safe for concurrent use.
+type Reports struct {
+	mu      sync.Mutex
+	reports []*TestReport
+}
+
+// Add records the report of one attempt.
+func (r *Reports) Add(attempt AttemptReport) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, report := range r.reports {
+		if report.Auth == attempt.Auth && report.Test == attempt.Test {
+			report.Attempts = append(report.Attempts, attempt)
+			return
+		}
+	}
+	r.reports = append(r.reports, &TestReport{
+		Auth:     attempt.Auth,
+		Test:     attempt.Test,
+		Attempts: []AttemptReport{attempt},
+	})
+}
+
+// Tests returns the reports of all tests, in the order they were first recorded.
+func (r *Reports) Tests() []TestReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tests := make([]TestReport, 0, len(r.reports))
+	for _, report := range r.reports {
+		tests = append(tests, TestReport{
+			Auth:     report.Auth,
+			Test:     report.Test,
+			Attempts: append([]AttemptReport(nil), report.Attempts...),
+		})
+	}
+	return tests
+}
+
+// WriteDir writes one report file per reporter to the directory, creating it if needed.
+func (r *Reports) WriteDir(dir string, reporters ...Reporter) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tests := r.Tests()
+	for _, reporter := range reporters {
+		path := filepath.Join(dir, reporter.FileName())
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		writeErr := reporter.Write(f, tests)
+		if closeErr := f.Close(); writeErr == nil {
+			writeErr = closeErr
+		}
+		if writeErr != nil {
+			return fmt.Errorf("cannot write %s: %v", path, writeErr)
+		}
+	}
+	return nil
+}
+
+// Reporter writes the reports of a run in one format.
+type Reporter interface {
+	// FileName returns the name of the report file in the report directory.
+	FileName() string
+	Write(w io.Writer, tests []TestReport) error
+}
+
+// JUnitReporter writes the reports as JUnit XML, with one test suite per auth mode
+// and one test case per attempt.
+type JUnitReporter struct{}
+
+type junitSuites struct {
+	XMLName xml.Name      `xml:"testsuites"`
+	Suites  []*junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name       string           `xml:"name,attr"`
+	ClassName  string           `xml:"classname,attr"`
+	Time       string           `xml:"time,attr"`
+	Properties *junitProperties `xml:"properties,omitempty"`
+	Failure    *junitMessage    `xml:"failure,omitempty"`
+	Skipped    *junitMessage    `xml:"skipped,omitempty"`
+	// failed tries of a test case that passed or failed once retried, as Maven Surefire reports reruns
+	FlakyFailures []junitMessage `xml:"flakyFailure,omitempty"`
+	RerunFailures []junitMessage `xml:"rerunFailure,omitempty"`
+	SystemOut     string         `xml:"system-out,omitempty"`
+}
+
+type junitProperties struct {
+	Properties []junitProperty `xml:"property"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// FileName implements Reporter.
+func (JUnitReporter) FileName() string {
+	return "junit.xml"
+}
+
+// Write implements Reporter.
+func (JUnitReporter) Write(w io.Writer, tests []TestReport) error {
+	var suites junitSuites
+	byAuth := make(map[string]*junitSuite)
+	durations := make(map[string]time.Duration)
+	for _, test := range tests {
+		suite, exists := byAuth[test.Auth]
+		if !exists {
+			suite = &junitSuite{Name: test.Auth}
+			byAuth[test.Auth] = suite
+			suites.Suites = append(suites.Suites, suite)
+		}
+		for _, attempt := range test.Attempts {
+			name := test.Test
+			if len(test.Attempts) > 1 {
+				name += "_attempt_" + strconv.Itoa(attempt.Attempt)
+			}
+			c := junitCase{
+				Name:      name,
+				ClassName: "pilot." + test.Auth,
+				Time:      seconds(attempt.Duration()),
+				SystemOut: fmt.Sprintf("setup: %v\nrun: %v\nteardown: %v\n",
+					attempt.Setup, attempt.Run, attempt.Teardown) + assertionsOut(attempt.Assertions),
+			}
+			c.Properties = measurementProperties(attempt.Measurements)
+			var retried []junitMessage
+			for _, failure := range attempt.RetryFailures {
+				retried = append(retried, junitMessage{Message: firstLine(failure), Text: failure})
+			}
+			switch attempt.Outcome {
+			case OutcomeFailed:
+				c.Failure = &junitMessage{Message: firstLine(attempt.Message), Text: attempt.Message}
+				c.RerunFailures = retried
+				suite.Failures++
+			case OutcomeFlaky:
+				c.FlakyFailures = retried
+			case OutcomeSkipped:
+				c.Skipped = &junitMessage{Message: attempt.Message}
+				suite.Skipped++
+			}
+			suite.Tests++
+			suite.Cases = append(suite.Cases, c)
+			durations[test.Auth] += attempt.Duration()
+			suite.Time = seconds(durations[test.Auth])
+		}
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// measurementProperties returns the measurements as JUnit properties, such as <name>.p50_ms,
+// sorted by name, or nil if there are none.
+func measurementProperties(measurements map[string]Distribution) *junitProperties {
+	if len(measurements) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(measurements))
+	for name := range measurements {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	properties := &junitProperties{}
+	for _, name := range names {
+		d := measurements[name]
+		for _, value := range []struct {
+			suffix string
+			value  float64
+		}{{"p50_ms", d.P50}, {"p90_ms", d.P90}, {"p99_ms", d.P99}, {"max_ms", d.Max}} {
+			properties.Properties = append(properties.Properties, junitProperty{
+				Name:  name + "." + value.suffix,
+				Value: strconv.FormatFloat(value.value, 'f', 1, 64),
+			})
+		}
+		properties.Properties = append(properties.Properties, junitProperty{
+			Name:  name + ".samples",
+			Value: strconv.Itoa(d.Samples),
+		})
+	}
+	return properties
+}
+
+// assertionsOut returns the timing of the assertions, one per line, the slowest first.
+func assertionsOut(assertions []AssertionReport) string {
+	sorted := append([]AssertionReport(nil), assertions...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Duration > sorted[j].Duration })
+	var out strings.Builder
+	for _, a := range sorted {
+		outcome := "passed"
+		if !a.Passed {
+			outcome = "failed"
+		}
+		fmt.Fprintf(&out, "assertion %s %s in %v (%d attempts, %d failures)\n", a.Name, outcome,
+			a.Duration, a.Attempts, a.Failures)
+	}
+	return out.String()
+}
+
+// JSONReporter writes the reports as a JSON array with one object per test, with the flake summary
+// of the tests attempted more than once.
+type JSONReporter struct{}
+
+type jsonTest struct {
+	Auth     string        `json:"auth"`
+	Test     string        `json:"test"`
+	Attempts []jsonAttempt `json:"attempts"`
+	// summary of the attempts of a test run more than once
+	Summary *FlakeSummary `json:"summary,omitempty"`
+}
+
+type jsonAttempt struct {
+	Attempt      int                     `json:"attempt"`
+	Outcome      Outcome                 `json:"outcome"`
+	Setup        float64                 `json:"setup_seconds"`
+	Run          float64                 `json:"run_seconds"`
+	Teardown     float64                 `json:"teardown_seconds"`
+	Message      string                  `json:"message,omitempty"`
+	Retries      []string                `json:"retry_failures,omitempty"`
+	Measurements map[string]Distribution `json:"measurements,omitempty"`
+	Assertions   []jsonAssertion         `json:"assertions,omitempty"`
+	Resources    []ResourceUsage         `json:"proxy_resources,omitempty"`
+}
+
+type jsonAssertion struct {
+	Name     string  `json:"name"`
+	Seconds  float64 `json:"seconds"`
+	Attempts int     `json:"attempts"`
+	Failures int     `json:"failures"`
+	Passed   bool    `json:"passed"`
+}
+
+// FileName implements Reporter.
+func (JSONReporter) FileName() string {
+	return "report.json"
+}
+
+// Write implements Reporter.
+func (JSONReporter) Write(w io.Writer, tests []TestReport) error {
+	out := make([]jsonTest, 0, len(tests))
+	for _, test := range tests {
+		t := jsonTest{Auth: test.Auth, Test: test.Test}
+		for _, attempt := range test.Attempts {
+			var assertions []jsonAssertion
+			for _, a := range attempt.Assertions {
+				assertions = append(assertions, jsonAssertion{
+					Name:     a.Name,
+					Seconds:  a.Duration.Seconds(),
+					Attempts: a.Attempts,
+					Failures: a.Failures,
+					Passed:   a.Passed,
+				})
+			}
+			t.Attempts = append(t.Attempts, jsonAttempt{
+				Attempt:      attempt.Attempt,
+				Outcome:      attempt.Outcome,
+				Setup:        attempt.Setup.Seconds(),
+				Run:          attempt.Run.Seconds(),
+				Teardown:     attempt.Teardown.Seconds(),
+				Message:      attempt.Message,
+				Retries:      attempt.RetryFailures,
+				Measurements: attempt.Measurements,
+				Assertions:   assertions,
+				Resources:    attempt.ProxyResources,
+			})
+		}
+		if len(test.Attempts) > 1 {
+			summary := test.Summary()
+			t.Summary = &summary
+		}
+		out = append(out, t)
+	}
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// seconds formats the duration in seconds, as JUnit expects.
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// firstLine returns the first line of the message.
+func firstLine(message string) string {
+	return strings.SplitN(message, "\n", 2)[0]
+}
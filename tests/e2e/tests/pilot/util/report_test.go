@@ -0,0 +1,133 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"istio.io/istio/pilot/test/util"
+)
+
+// testReports returns the reports of a run with every outcome: tests attempted once and several times,
+// retried, measured, with assertions and with proxy resources.
+func testReports() *Reports {
+	r := &Reports{}
+	r.Add(AttemptReport{
+		Auth: "disabled", Test: "http-reachability", Attempt: 1, Outcome: OutcomePassed,
+		Setup: 2 * time.Second, Run: 5500 * time.Millisecond, Teardown: 500 * time.Millisecond,
+		Assertions: []AssertionReport{
+			{Name: "a to b", Duration: time.Second, Attempts: 2, Passed: true},
+			{Name: "a to c", Duration: 3 * time.Second, Attempts: 4, Failures: 1, Passed: true},
+		},
+	})
+	r.Add(AttemptReport{
+		Auth: "disabled", Test: "routing-rules", Attempt: 1, Outcome: OutcomeFlaky,
+		Setup: time.Second, Run: 10 * time.Second, Teardown: time.Second,
+		RetryFailures: []string{"request from a-v1-5d4f8b9c6-xk2lp to c failed: 503\nafter 3 attempts"},
+	})
+	r.Add(AttemptReport{
+		Auth: "disabled", Test: "routing-rules", Attempt: 2, Outcome: OutcomeFailed,
+		Setup: time.Second, Run: 20 * time.Second, Teardown: time.Second,
+		Message:       "request from a-v1-7c9d6f5b4-q8w2e to c failed: 503",
+		RetryFailures: []string{"request from a-v1-7c9d6f5b4-q8w2e to c failed: 504"},
+	})
+	r.Add(AttemptReport{
+		Auth: "disabled", Test: "routing-rules", Attempt: 3, Outcome: OutcomePassed,
+		Setup: time.Second, Run: 9 * time.Second, Teardown: time.Second,
+	})
+	r.Add(AttemptReport{
+		Auth: "disabled", Test: "routing-rules", Attempt: 4, Outcome: OutcomeSkipped,
+		Message: "v1alpha2 routing rules are disabled",
+	})
+	r.Add(AttemptReport{
+		Auth: "enabled", Test: "latency", Attempt: 1, Outcome: OutcomePassed,
+		Run: 30 * time.Second,
+		Measurements: map[string]Distribution{
+			"b": NewDistribution([]time.Duration{3 * time.Millisecond, time.Millisecond, 2 * time.Millisecond}),
+			"a": NewDistribution([]time.Duration{10 * time.Millisecond}),
+		},
+		ProxyResources: []ResourceUsage{{Namespace: "istio-e2e", Pod: "a-v1-5d4f8b9c6-xk2lp", CPU: 120,
+			Memory: 64 << 20, Samples: 6}},
+	})
+	r.Add(AttemptReport{
+		Auth: "enabled", Test: "mtls", Attempt: 1, Outcome: OutcomeSkipped,
+		Message: "mutual TLS is disabled",
+	})
+	return r
+}
+
+func TestReporters(t *testing.T) {
+	for _, reporter := range []Reporter{JUnitReporter{}, JSONReporter{}} {
+		t.Run(reporter.FileName(), func(t *testing.T) {
+			var out bytes.Buffer
+			if err := reporter.Write(&out, testReports().Tests()); err != nil {
+				t.Fatal(err)
+			}
+			util.CompareContent(out.Bytes(), "testdata/"+reporter.FileName()+".golden", t)
+		})
+	}
+}
+
+func TestPrintFlakes(t *testing.T) {
+	var out bytes.Buffer
+	if err := testReports().PrintFlakes(&out); err != nil {
+		t.Fatal(err)
+	}
+	util.CompareContent(out.Bytes(), "testdata/flakes.txt.golden", t)
+}
+
+func TestFlakeSummary(t *testing.T) {
+	tests := testReports().Tests()
+	got := tests[1].Summary()
+	want := FlakeSummary{
+		Attempts:     4,
+		Passed:       1,
+		Flaky:        1,
+		Failed:       1,
+		Skipped:      1,
+		PassRate:     1.0 / 3,
+		MeanDuration: 15000,
+		Duration:     NewDistribution([]time.Duration{12 * time.Second, 22 * time.Second, 11 * time.Second}),
+		// the 503s and the 504 of the same request, from different pods, share a signature
+		Failures: []FailureSignature{{Signature: "request from a-vN-* to c failed: N", Count: 3}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Summary() got %+v, want %+v", got, want)
+	}
+
+	if got := tests[0].Summary(); got.Attempts != 1 || got.PassRate != 1 || len(got.Failures) != 0 {
+		t.Errorf("Summary() of a single passed attempt got %+v", got)
+	}
+	skipped := TestReport{Attempts: []AttemptReport{{Outcome: OutcomeSkipped}, {Outcome: OutcomeSkipped}}}
+	if got := skipped.Summary(); !reflect.DeepEqual(got, FlakeSummary{Attempts: 2, Skipped: 2}) {
+		t.Errorf("Summary() of skipped attempts got %+v", got)
+	}
+}
+
+func TestFailureSignature(t *testing.T) {
+	cases := map[string]string{
+		"request from a-v1-5d4f8b9c6-xk2lp to c failed: 503": "request from a-vN-* to c failed: N",
+		"no response from 10.0.12.3:8080 after 2.5s\nmore":   "no response from N:N after Ns",
+		"failed all 90 attempts for a to b":                  "failed all N attempts for a to b",
+	}
+	for message, want := range cases {
+		if got := failureSignature(message); got != want {
+			t.Errorf("failureSignature(%q) got %q, want %q", message, got, want)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	multierror "github.com/hashicorp/go-multierror"
+
+	"istio.io/istio/pkg/log"
+	"istio.io/istio/tests/util"
+)
+
+// resultRex matches the result of a request the client logs with -json.
+var resultRex = regexp.MustCompile(`\[\d+ json\] (\{.*\})`)
+
+// RequestResult is the result of one request of the client, as it logs it with -json.
+type RequestResult struct {
+	Index int    `json:"index"`
+	URL   string `json:"url"`
+	// status code of the HTTP response, 0 for the other protocols
+	Code int `json:"code"`
+	// version, port and pod of the app that served the request
+	Version  string `json:"version"`
+	Port     string `json:"port"`
+	Hostname string `json:"hostname"`
+	// x-request-id the app saw
+	ID string `json:"id"`
+	// headers or gRPC metadata the app saw, by canonical name
+	Headers         map[string][]string `json:"headers"`
+	ResponseHeaders map[string][]string `json:"response_headers"`
+	LatencyMs       float64             `json:"latency_ms"`
+	// error of a failed request
+	Error string `json:"error"`
+	// app the request was sent from, set by DriveTraffic
+	Source string `json:"source,omitempty"`
+}
+
+// Reached returns true if the request reached an app without error and, for HTTP, without an error
+// status code.
+func (r *RequestResult) Reached() bool {
+	return r.Error == "" && r.Port != "" && r.Code < 400
+}
+
+// Latency returns the time the request took, as measured by the client.
+func (r *RequestResult) Latency() time.Duration {
+	return time.Duration(r.LatencyMs * float64(time.Millisecond))
+}
+
+// String describes the request for the errors of the assertions.
+func (r *RequestResult) String() string {
+	request := fmt.Sprintf("request #%d to %s", r.Index, r.URL)
+	if r.Source != "" {
+		request += " from " + r.Source
+	}
+	switch {
+	case r.Error != "":
+		return fmt.Sprintf("%s failed: %s", request, r.Error)
+	case r.Code != 0:
+		return fmt.Sprintf("%s answered %d by %s %s", request, r.Code, r.Hostname, r.Version)
+	default:
+		return fmt.Sprintf("%s answered by %s %s", request, r.Hostname, r.Version)
+	}
+}
+
+// Requests are the results of the requests of one call of the client, in the order they were made.
+type Requests []RequestResult
+
+// ClientRequests makes the given requests from within the k8s environment like ClientRequest, and
+// returns the result of each, the failed ones included. It only fails if the client cannot run.
+func (e *Environment) ClientRequests(app, url string, count int, extra string) (Requests, error) {
+	if len(e.Apps[app]) == 0 {
+		return nil, fmt.Errorf("missing pod names for app %q", app)
+	}
+	cmd := fmt.Sprintf("kubectl exec %s --kubeconfig %s -n %s -c app -- client -json -url %s -count %d %s",
+		e.Apps[app][0], e.Config.KubeConfig, e.Config.Namespace, url, count, extra)
+	out, err := util.Shell(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("client request error %v for %s in %s", err, url, app)
+	}
+	return parseRequests(out)
+}
+
+// parseRequests returns the results the client logged.
+func parseRequests(out string) (Requests, error) {
+	var requests Requests
+	for _, match := range resultRex.FindAllStringSubmatch(out, -1) {
+		var r RequestResult
+		if err := json.Unmarshal([]byte(match[1]), &r); err != nil {
+			return nil, fmt.Errorf("cannot parse the result of a request %q: %v", match[1], err)
+		}
+		requests = append(requests, r)
+	}
+	return requests, nil
+}
+
+// Versions returns the version of the app that served each request, empty if none did.
+func (r Requests) Versions() []string {
+	versions := make([]string, 0, len(r))
+	for _, request := range r {
+		versions = append(versions, request.Version)
+	}
+	return versions
+}
+
+// Counts returns the number of requests each version of the app served, under "" for the requests
+// no version served.
+func (r Requests) Counts() map[string]int {
+	count := make(map[string]int)
+	for _, request := range r {
+		count[request.Version]++
+	}
+	return count
+}
+
+// Hostnames returns the pods that served the requests, each once, sorted.
+func (r Requests) Hostnames() []string {
+	seen := make(map[string]bool)
+	var hostnames []string
+	for _, request := range r {
+		if request.Hostname != "" && !seen[request.Hostname] {
+			seen[request.Hostname] = true
+			hostnames = append(hostnames, request.Hostname)
+		}
+	}
+	sort.Strings(hostnames)
+	return hostnames
+}
+
+// Latencies returns the latency of each request that reached an app.
+func (r Requests) Latencies() []time.Duration {
+	var latencies []time.Duration
+	for _, request := range r {
+		if request.Reached() {
+			latencies = append(latencies, request.Latency())
+		}
+	}
+	return latencies
+}
+
+// ExpectReachable returns an error listing the requests that did not reach an app, or if there are no
+// requests at all.
+func (r Requests) ExpectReachable() error {
+	if len(r) == 0 {
+		return fmt.Errorf("the client logged no request")
+	}
+	var errs error
+	for i := range r {
+		if !r[i].Reached() {
+			errs = multierror.Append(errs, fmt.Errorf("%v", &r[i]))
+		}
+	}
+	return errs
+}
+
+// ExpectCode returns an error listing the requests answered with another HTTP status code.
+func (r Requests) ExpectCode(code int) error {
+	if len(r) == 0 {
+		return fmt.Errorf("the client logged no request")
+	}
+	var errs error
+	for i := range r {
+		if r[i].Error != "" || r[i].Code != code {
+			errs = multierror.Append(errs, fmt.Errorf("%v, want %d", &r[i], code))
+		}
+	}
+	return errs
+}
+
+// ExpectDistribution returns an error if the share of the requests served by a version differs from
+// its weight, in percent, by more than the tolerance, a share. The requests that reached no version
+// count against every weight.
+func (r Requests) ExpectDistribution(weights map[string]int, tolerance float64) error {
+	if len(r) == 0 {
+		return fmt.Errorf("the client logged no request")
+	}
+	count := r.Counts()
+	log.Infof("request counts %v", count)
+
+	versions := make([]string, 0, len(weights))
+	for version := range weights {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	var errs error
+	for _, version := range versions {
+		share := float64(count[version]) / float64(len(r))
+		expected := float64(weights[version]) / 100
+		if math.Abs(share-expected) > tolerance {
+			errs = multierror.Append(errs, fmt.Errorf("expected %.2f of the requests (+/-%.2f) to reach %s => Got %.3f",
+				expected, tolerance, version, share))
+		}
+	}
+	return errs
+}
+
+// ExpectHeader returns an error listing the requests that reached an app without the header with the
+// value, a value of the header being enough if the value is empty.
+func (r Requests) ExpectHeader(name, value string) error {
+	if len(r) == 0 {
+		return fmt.Errorf("the client logged no request")
+	}
+	var errs error
+	for i := range r {
+		values := headerValues(r[i].Headers, name)
+		if value == "" && len(values) > 0 || containsString(values, value) {
+			continue
+		}
+		if len(values) == 0 {
+			errs = multierror.Append(errs, fmt.Errorf("%v without header %s", &r[i], name))
+		} else {
+			errs = multierror.Append(errs, fmt.Errorf("%v with header %s: %s, want %q", &r[i], name,
+				strings.Join(values, ", "), value))
+		}
+	}
+	return errs
+}
+
+// headerValues returns the values of the header, whatever the case of its name.
+func headerValues(headers map[string][]string, name string) []string {
+	for key, values := range headers {
+		if strings.EqualFold(key, name) {
+			return values
+		}
+	}
+	return nil
+}
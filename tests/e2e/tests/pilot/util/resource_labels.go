@@ -0,0 +1,284 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	multierror "github.com/hashicorp/go-multierror"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"istio.io/istio/pkg/log"
+)
+
+const (
+	// RunLabel is the label of the resources applied through an environment holding the ID of the run.
+	RunLabel = "istio-test/run"
+	// TestLabel is the label of the resources applied through an environment during a test holding the
+	// name of the test.
+	TestLabel = "istio-test/test"
+)
+
+// labeledKinds are the kinds of the namespaced resources the cleanup by label deletes with kubectl, besides
+// the Istio configs and the namespaces. The cluster-scoped resources are left out: applying a resource that
+// already exists labels it too, and the run did not necessarily create it.
+var labeledKinds = []string{
+	"deployments", "statefulsets", "services", "serviceaccounts", "configmaps", "secrets", "ingresses",
+	"persistentvolumeclaims", "policies.authentication.istio.io", "serviceroles.rbac.istio.io",
+	"servicerolebindings.rbac.istio.io", "rules.config.istio.io",
+}
+
+var (
+	// separator of the documents of a YAML stream
+	yamlSeparatorRex = regexp.MustCompile(`(?m)^---\s*$`)
+	// characters not allowed in the value of a label
+	labelValueRex = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+)
+
+// NewRunID returns a new ID for a run, from the time it starts and a random suffix.
+func NewRunID() string {
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		log.Warna(err)
+	}
+	return time.Now().UTC().Format("20060102-150405") + "-" + hex.EncodeToString(suffix)
+}
+
+// LabelTest makes the resources applied through the environment from now on carry the name of the test
+// in their TestLabel.
+func (e *Environment) LabelTest(test string) {
+	e.testLabel = test
+}
+
+// resourceLabels returns the labels of the resources applied through the environment, none if the run
+// has no ID.
+func (e *Environment) resourceLabels() map[string]string {
+	if e.Config.RunID == "" {
+		return nil
+	}
+	out := map[string]string{RunLabel: labelValue(e.Config.RunID)}
+	if e.testLabel != "" {
+		out[TestLabel] = labelValue(e.testLabel)
+	}
+	return out
+}
+
+// labelValue returns the value as the value of a label, which is at most 63 characters among
+// letters, digits, '-', '_' and '.', and starting and ending with a letter or a digit.
+func labelValue(value string) string {
+	value = labelValueRex.ReplaceAllString(value, "-")
+	if len(value) > 63 {
+		value = value[:63]
+	}
+	return strings.Trim(value, "-_.")
+}
+
+// editYAML returns the YAML stream with each of its resources changed by the edit functions.
+func editYAML(stream string, edits ...func(object map[string]interface{})) (string, error) {
+	if len(edits) == 0 {
+		return stream, nil
+	}
+	docs := yamlSeparatorRex.Split(stream, -1)
+	out := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		var object map[string]interface{}
+		if err := yaml.Unmarshal([]byte(doc), &object); err != nil {
+			return "", fmt.Errorf("cannot parse the resource %q: %v", doc, err)
+		}
+		// comments or blank lines only
+		if len(object) == 0 {
+			continue
+		}
+		for _, edit := range edits {
+			edit(object)
+		}
+		edited, err := yaml.Marshal(object)
+		if err != nil {
+			return "", err
+		}
+		out = append(out, string(edited))
+	}
+	return strings.Join(out, "---\n"), nil
+}
+
+// prepareYAML returns the YAML stream as the environment applies it: with the labels of the run and the
+// test, and with the image pull policy and secret of the config.
+func (e *Environment) prepareYAML(stream string) (string, error) {
+	var edits []func(object map[string]interface{})
+	if resourceLabels := e.resourceLabels(); len(resourceLabels) > 0 {
+		edits = append(edits, func(object map[string]interface{}) {
+			labelObject(object, resourceLabels)
+		})
+	}
+	if e.Config.ImagePullPolicy != "" || e.Config.ImagePullSecret != "" {
+		edits = append(edits, e.setImagePull)
+	}
+	return editYAML(stream, edits...)
+}
+
+// labelObject adds the labels to the metadata of the resource.
+func labelObject(object map[string]interface{}, resourceLabels map[string]string) {
+	objectLabels := childMap(childMap(object, "metadata"), "labels")
+	for key, value := range resourceLabels {
+		objectLabels[key] = value
+	}
+}
+
+// childMap returns the map under the key of the object, adding an empty one if it has none.
+func childMap(object map[string]interface{}, key string) map[string]interface{} {
+	child, _ := object[key].(map[string]interface{})
+	if child == nil {
+		child = make(map[string]interface{})
+		object[key] = child
+	}
+	return child
+}
+
+// labelNamespace adds the labels of the resources of the environment to the namespace it created.
+func (e *Environment) labelNamespace(name string) error {
+	resourceLabels := e.resourceLabels()
+	if len(resourceLabels) == 0 {
+		return nil
+	}
+	namespaces := e.KubeClient.CoreV1().Namespaces()
+	namespace, err := namespaces.Get(name, meta_v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if namespace.Labels == nil {
+		namespace.Labels = make(map[string]string)
+	}
+	for key, value := range resourceLabels {
+		namespace.Labels[key] = value
+	}
+	_, err = namespaces.Update(namespace)
+	return err
+}
+
+// CleanupTest deletes the resources applied through the environment during the test in this run, such as
+// those a test left after a teardown that failed or was skipped.
+func (e *Environment) CleanupTest(test string) error {
+	if e.Config.RunID == "" {
+		return fmt.Errorf("cannot clean up %s: the run has no ID", test)
+	}
+	return e.deleteLabeled(labels.Set{RunLabel: labelValue(e.Config.RunID), TestLabel: labelValue(test)})
+}
+
+// CleanupRun deletes the resources of the run with the ID in all namespaces, the namespaces the run created
+// included, such as those an aborted run or a run with -skip-cleanup left.
+func (e *Environment) CleanupRun(runID string) error {
+	return e.deleteLabeled(labels.Set{RunLabel: labelValue(runID)})
+}
+
+// deleteLabeled deletes the Istio configs, the resources of labeledKinds and the namespaces carrying the
+// labels, in all namespaces. It connects to the cluster if the environment was not set up, as when cleaning
+// up another run.
+func (e *Environment) deleteLabeled(set labels.Set) error {
+	if e.KubeClient == nil {
+		if err := e.setupClients(); err != nil {
+			return err
+		}
+	}
+	selector := labels.SelectorFromSet(set)
+	log.Infof("Deleting the resources labeled %s", selector)
+	var errs error
+	for _, desc := range e.config.ConfigDescriptor() {
+		configs, err := e.config.List(desc.Type, meta_v1.NamespaceAll)
+		if err != nil {
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		for _, config := range configs {
+			if !selector.Matches(labels.Set(config.Labels)) {
+				continue
+			}
+			log.Infof("Delete config %s", config.Key())
+			if e.Config.DryRun {
+				DryRunf("delete %s %s from namespace %s", desc.Type, config.Name, config.Namespace)
+			}
+			if err = e.config.Delete(desc.Type, config.Name, config.Namespace); err != nil {
+				errs = multierror.Append(errs, err)
+			}
+		}
+	}
+
+	for _, kind := range labeledKinds {
+		if err := e.deleteLabeledKind(kind, selector.String()); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+
+	namespaces, err := e.KubeClient.CoreV1().Namespaces().List(meta_v1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return multierror.Append(errs, err)
+	}
+	for _, namespace := range namespaces.Items {
+		log.Infof("Delete namespace %s", namespace.Name)
+		if err = e.KubeClient.CoreV1().Namespaces().Delete(namespace.Name, &meta_v1.DeleteOptions{}); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	return errs
+}
+
+// deleteLabeledKind deletes the resources of the kind matching the selector with kubectl, in all
+// namespaces. A kind the cluster does not serve, such as that of a CRD that is not installed, is skipped.
+func (e *Environment) deleteLabeledKind(kind, selector string) error {
+	if e.Config.DryRun {
+		DryRunf("delete the %s labeled %s from all namespaces", kind, selector)
+		return nil
+	}
+	out, err := exec.Command("kubectl", "get", kind, "--kubeconfig", e.Config.KubeConfig, // #nosec
+		"--all-namespaces", "-l", selector,
+		"-o", `jsonpath={range .items[*]}{.metadata.namespace} {.metadata.name}{"\n"}{end}`).CombinedOutput()
+	if err != nil {
+		if strings.Contains(string(out), "the server doesn't have a resource type") {
+			return nil
+		}
+		return fmt.Errorf("cannot list the %s labeled %s: %v: %s", kind, selector, err, out)
+	}
+	byNamespace := make(map[string][]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			byNamespace[fields[0]] = append(byNamespace[fields[0]], fields[1])
+		}
+	}
+	namespaces := make([]string, 0, len(byNamespace))
+	for namespace := range byNamespace {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	var errs error
+	for _, namespace := range namespaces {
+		names := byNamespace[namespace]
+		log.Infof("Delete %s %s in namespace %s", kind, strings.Join(names, " "), namespace)
+		args := append([]string{"delete", kind, "--kubeconfig", e.Config.KubeConfig, "-n", namespace,
+			"--ignore-not-found"}, names...)
+		if out, err = exec.Command("kubectl", args...).CombinedOutput(); err != nil { // #nosec
+			errs = multierror.Append(errs, fmt.Errorf("cannot delete the %s of namespace %s: %v: %s", kind,
+				namespace, err, out))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,229 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"istio.io/istio/pkg/log"
+)
+
+const (
+	// container of the sidecars, of the gateway and of the ingress
+	proxyContainer = "istio-proxy"
+	// path of the pod metrics of a namespace in the metrics API
+	podMetricsPath = "/apis/metrics.k8s.io/v1beta1/namespaces/%s/pods"
+)
+
+// ResourceUsage is the peak usage of the proxy container of a pod sampled by a ResourceMonitor.
+type ResourceUsage struct {
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	// peak CPU in millicores and memory in bytes over the samples
+	CPU     int64 `json:"cpu_millicores"`
+	Memory  int64 `json:"memory_bytes"`
+	Samples int   `json:"samples"`
+}
+
+// String formats the usage for logs.
+func (u ResourceUsage) String() string {
+	return fmt.Sprintf("proxy of %s/%s: %dm CPU, %d MiB (%d samples)", u.Namespace, u.Pod, u.CPU,
+		u.Memory/1024/1024, u.Samples)
+}
+
+// podMetricsList is the part of a PodMetricsList of the metrics API the monitor reads.
+type podMetricsList struct {
+	Items []struct {
+		Metadata struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+		Containers []struct {
+			Name  string            `json:"name"`
+			Usage map[string]string `json:"usage"`
+		} `json:"containers"`
+	} `json:"items"`
+}
+
+// ResourceMonitor samples the CPU and memory of the proxy containers of the Istio and app namespaces from
+// the metrics API during a test, started by StartResourceMonitor.
+type ResourceMonitor struct {
+	env      *Environment
+	test     string
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+
+	mu    sync.Mutex
+	usage map[string]*ResourceUsage
+	// error of the last sample, reported if no sample succeeded
+	lastErr error
+}
+
+// StartResourceMonitor samples the proxy containers every ResourceInterval until Stop, if a ceiling
+// of -proxy-cpu-ceiling or -proxy-memory-ceiling is set. It returns nil otherwise, and on a dry run.
+func (e *Environment) StartResourceMonitor(test string) *ResourceMonitor {
+	if (e.Config.ProxyCPUCeiling == 0 && e.Config.ProxyMemoryCeiling == 0) || e.Config.DryRun {
+		return nil
+	}
+	interval := e.Config.ResourceInterval
+	if interval <= 0 {
+		interval = defaultResourceInterval
+	}
+	m := &ResourceMonitor{
+		env:      e,
+		test:     test,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		usage:    make(map[string]*ResourceUsage),
+	}
+	go m.run()
+	return m
+}
+
+func (m *ResourceMonitor) run() {
+	defer close(m.done)
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		m.sample()
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sample adds the current usage of the proxy containers to their peaks.
+func (m *ResourceMonitor) sample() {
+	for _, namespace := range []string{m.env.Config.IstioNamespace, m.env.Config.Namespace} {
+		metrics, err := m.env.podMetrics(namespace)
+		m.mu.Lock()
+		m.lastErr = err
+		m.mu.Unlock()
+		if err != nil {
+			log.Infof("Cannot sample the proxies of %s during %s: %v", namespace, m.test, err)
+			continue
+		}
+		for _, item := range metrics.Items {
+			for _, container := range item.Containers {
+				if container.Name != proxyContainer {
+					continue
+				}
+				cpu, cpuErr := resource.ParseQuantity(container.Usage["cpu"])
+				memory, memErr := resource.ParseQuantity(container.Usage["memory"])
+				if cpuErr != nil || memErr != nil {
+					log.Warnf("Cannot parse the usage %v of the proxy of %s/%s", container.Usage, namespace,
+						item.Metadata.Name)
+					continue
+				}
+				m.add(namespace, item.Metadata.Name, cpu.MilliValue(), memory.Value())
+			}
+		}
+	}
+}
+
+func (m *ResourceMonitor) add(namespace, pod string, cpu, memory int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := namespace + "/" + pod
+	usage, exists := m.usage[key]
+	if !exists {
+		usage = &ResourceUsage{Namespace: namespace, Pod: pod}
+		m.usage[key] = usage
+	}
+	if cpu > usage.CPU {
+		usage.CPU = cpu
+	}
+	if memory > usage.Memory {
+		usage.Memory = memory
+	}
+	usage.Samples++
+}
+
+// Stop stops the sampling and returns the peak usage of each proxy container sampled, sorted by namespace
+// and pod, and an error naming those over the ceilings. With -resource-warn-only, the proxies over the
+// ceilings are only logged. It is a no-op on a nil monitor.
+func (m *ResourceMonitor) Stop() ([]ResourceUsage, error) {
+	if m == nil {
+		return nil, nil
+	}
+	close(m.stop)
+	<-m.done
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.usage) == 0 && m.lastErr != nil {
+		log.Warnf("No proxy resource usage sampled during %s: %v", m.test, m.lastErr)
+		return nil, nil
+	}
+	usages := make([]ResourceUsage, 0, len(m.usage))
+	for _, usage := range m.usage {
+		usages = append(usages, *usage)
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		if usages[i].Namespace != usages[j].Namespace {
+			return usages[i].Namespace < usages[j].Namespace
+		}
+		return usages[i].Pod < usages[j].Pod
+	})
+
+	config := m.env.Config
+	var over []string
+	for _, usage := range usages {
+		log.Infof("Peak usage during %s of the %v", m.test, usage)
+		if config.ProxyCPUCeiling > 0 && usage.CPU > int64(config.ProxyCPUCeiling) {
+			over = append(over, fmt.Sprintf("the proxy of %s/%s used %dm CPU, want at most %dm",
+				usage.Namespace, usage.Pod, usage.CPU, config.ProxyCPUCeiling))
+		}
+		if config.ProxyMemoryCeiling > 0 && usage.Memory > int64(config.ProxyMemoryCeiling)*1024*1024 {
+			over = append(over, fmt.Sprintf("the proxy of %s/%s used %d MiB, want at most %d MiB",
+				usage.Namespace, usage.Pod, usage.Memory/1024/1024, config.ProxyMemoryCeiling))
+		}
+	}
+	if len(over) == 0 {
+		return usages, nil
+	}
+	err := fmt.Errorf("proxies over their resource ceilings during %s:\n%s", m.test, strings.Join(over, "\n"))
+	if config.ResourceWarnOnly {
+		log.Warna(err)
+		return usages, nil
+	}
+	return usages, err
+}
+
+// podMetrics returns the pod metrics of the namespace from the metrics API, served by metrics-server.
+func (e *Environment) podMetrics(namespace string) (*podMetricsList, error) {
+	out, err := exec.Command("kubectl", "get", "--raw", fmt.Sprintf(podMetricsPath, namespace), // #nosec
+		"--kubeconfig", e.Config.KubeConfig).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get the pod metrics of %s: %v: %s", namespace, err, out)
+	}
+	var metrics podMetricsList
+	if err = json.Unmarshal(out, &metrics); err != nil {
+		return nil, fmt.Errorf("cannot parse the pod metrics of %s: %v", namespace, err)
+	}
+	return &metrics, nil
+}
@@ -0,0 +1,198 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Result is the outcome of all the attempts of one test in one auth mode.
+type Result struct {
+	Auth     string
+	Test     string
+	Attempts int
+	Failures int
+	Skips    int
+	// attempts that passed once retried
+	Flakes int
+	// reason of the first skipped attempt
+	SkipReason string
+	Duration   time.Duration
+}
+
+// Passed returns true if no attempt of the test failed.
+func (r *Result) Passed() bool {
+	return r.Failures == 0
+}
+
+// Skipped returns true if an attempt of the test was skipped and none failed.
+func (r *Result) Skipped() bool {
+	return r.Passed() && r.Skips > 0
+}
+
+// Flaky returns true if an attempt of the test only passed once retried, and none failed.
+func (r *Result) Flaky() bool {
+	return r.Passed() && r.Flakes > 0
+}
+
+// Outcome returns the outcome of the test over all its attempts, as counted by Counts.
+func (r *Result) Outcome() Outcome {
+	switch {
+	case r.Skipped():
+		return OutcomeSkipped
+	case r.Flaky():
+		return OutcomeFlaky
+	case r.Passed():
+		return OutcomePassed
+	default:
+		return OutcomeFailed
+	}
+}
+
+// Results collects test results across auth modes. It is safe for concurrent use.
+type Results struct {
+	mu      sync.Mutex
+	results []*Result
+}
+
+// Record adds the outcome of one attempt of a test.
+func (r *Results) Record(auth, test string, passed bool, duration time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := r.result(auth, test)
+	result.Attempts++
+	if !passed {
+		result.Failures++
+	}
+	result.Duration += duration
+}
+
+// RecordFlaky adds one attempt of a test that passed once retried.
+func (r *Results) RecordFlaky(auth, test string, duration time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := r.result(auth, test)
+	result.Attempts++
+	result.Flakes++
+	result.Duration += duration
+}
+
+// RecordSkip adds one attempt of a test that was skipped for the given reason.
+func (r *Results) RecordSkip(auth, test, reason string, duration time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := r.result(auth, test)
+	result.Attempts++
+	if result.Skips == 0 {
+		result.SkipReason = reason
+	}
+	result.Skips++
+	result.Duration += duration
+}
+
+// result returns the result of the test, adding it if needed. The caller must hold the lock.
+func (r *Results) result(auth, test string) *Result {
+	for _, existing := range r.results {
+		if existing.Auth == auth && existing.Test == test {
+			return existing
+		}
+	}
+	result := &Result{Auth: auth, Test: test}
+	r.results = append(r.results, result)
+	return result
+}
+
+// Counts returns the number of passed, flaky, failed and skipped tests. Flaky tests are not
+// counted as passed.
+func (r *Results) Counts() (passed, flaky, failed, skipped int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, result := range r.results {
+		switch result.Outcome() {
+		case OutcomeSkipped:
+			skipped++
+		case OutcomeFlaky:
+			flaky++
+		case OutcomePassed:
+			passed++
+		default:
+			failed++
+		}
+	}
+	return passed, flaky, failed, skipped
+}
+
+// All returns the results of all tests, in the order they were first recorded.
+func (r *Results) All() []Result {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	all := make([]Result, 0, len(r.results))
+	for _, result := range r.results {
+		all = append(all, *result)
+	}
+	return all
+}
+
+// Skipped returns the results of the skipped tests, in the order they were first recorded.
+func (r *Results) Skipped() []Result {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var skipped []Result
+	for _, result := range r.results {
+		if result.Skipped() {
+			skipped = append(skipped, *result)
+		}
+	}
+	return skipped
+}
+
+// Print writes a table of all results, in the order they were first recorded, followed by a rollup line.
+func (r *Results) Print(out io.Writer) error {
+	r.mu.Lock()
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "AUTH\tTEST\tATTEMPTS\tRESULT\tDURATION")
+	for _, result := range r.results {
+		status := "PASS"
+		if !result.Passed() {
+			status = fmt.Sprintf("FAIL (%d/%d)", result.Failures, result.Attempts)
+		} else if result.Skipped() {
+			status = "SKIP (" + result.SkipReason + ")"
+		} else if result.Flaky() {
+			status = fmt.Sprintf("FLAKY (%d/%d passed on retry)", result.Flakes, result.Attempts)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%v\n", result.Auth, result.Test, result.Attempts, status,
+			result.Duration.Round(time.Millisecond))
+	}
+	r.mu.Unlock()
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	passed, flaky, failed, skipped := r.Counts()
+	rollup := "PASS"
+	if failed > 0 {
+		rollup = "FAIL"
+	}
+	_, err := fmt.Fprintf(out, "%s: %d passed, %d flaky, %d failed, %d skipped\n",
+		rollup, passed, flaky, failed, skipped)
+	return err
+}
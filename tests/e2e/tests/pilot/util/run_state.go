@@ -0,0 +1,124 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"istio.io/istio/pkg/log"
+)
+
+// RunState records the tests that passed in an environment to the state file, so that a run of the
+// same environment with -resume skips them and continues from the first failure. It is safe for
+// concurrent use.
+type RunState struct {
+	mu   sync.Mutex
+	path string
+	// Environment identifies the environment the tests passed in, see EnvironmentKey
+	Environment string `json:"environment"`
+	// Passed holds the names of the tests that passed, by auth mode
+	Passed map[string][]string `json:"passed"`
+	// tests that passed before this run, skipped with -resume
+	previous map[string]bool
+}
+
+// EnvironmentKey identifies the environment the config deploys, from its cluster, images and
+// namespaces. The namespaces generated for a run do not count, so that a new run of the same
+// config resumes the last one.
+func EnvironmentKey(c *Config) string {
+	return strings.Join([]string{c.KubeConfig, c.Hub, c.Tag, c.Namespace, c.IstioNamespace, c.Registry}, "|")
+}
+
+// LoadRunState returns the state of the file for the environment. The tests that passed before are
+// only kept when resuming the same environment, otherwise the state starts empty, and the file is
+// removed when not resuming so that a later -resume does not skip the tests of an older run. An empty
+// path keeps the state in memory.
+func LoadRunState(path, environment string, resume bool) (*RunState, error) {
+	s := &RunState{
+		path:        path,
+		Environment: environment,
+		Passed:      make(map[string][]string),
+		previous:    make(map[string]bool),
+	}
+	if path == "" {
+		return s, nil
+	}
+	if !resume {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		return s, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Infof("No state file %s, running all tests", path)
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var saved RunState
+	if err = json.Unmarshal(data, &saved); err != nil {
+		return nil, err
+	}
+	if saved.Environment != environment {
+		log.Infof("State file %s is of another environment, running all tests", path)
+		return s, nil
+	}
+	for auth, tests := range saved.Passed {
+		s.Passed[auth] = tests
+		for _, test := range tests {
+			s.previous[auth+"/"+test] = true
+		}
+	}
+	return s, nil
+}
+
+// PassedBefore returns whether the test passed in the auth mode in a previous run being resumed.
+func (s *RunState) PassedBefore(auth, test string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.previous[auth+"/"+test]
+}
+
+// MarkPassed records that the test passed in the auth mode, and writes the state file.
+func (s *RunState) MarkPassed(auth, test string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, passed := range s.Passed[auth] {
+		if passed == test {
+			return nil
+		}
+	}
+	s.Passed[auth] = append(s.Passed[auth], test)
+	sort.Strings(s.Passed[auth])
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	// replaced at once, so that a killed run leaves the previous state
+	tmp := s.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,126 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"istio.io/istio/pilot/test/util"
+)
+
+func TestRunState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "run-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+	path := filepath.Join(dir, "state.json")
+
+	// a first run records the tests that passed
+	s, err := LoadRunState(path, "env", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, passed := range []struct{ auth, test string }{
+		{"disabled", "tcp"}, {"disabled", "http"}, {"enabled", "tcp"}, {"disabled", "tcp"},
+	} {
+		if err = s.MarkPassed(passed.auth, passed.test); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	util.CompareContent(data, "testdata/run_state.json.golden", t)
+
+	// the cases run in order, each on the state file the previous one left
+	cases := []struct {
+		name        string
+		environment string
+		resume      bool
+		passed      map[string]bool
+		removed     bool
+	}{
+		{
+			name:        "resuming the same environment skips the tests that passed",
+			environment: "env",
+			resume:      true,
+			passed:      map[string]bool{"disabled/tcp": true, "disabled/http": true, "enabled/tcp": true, "enabled/http": false},
+		},
+		{
+			name:        "resuming another environment runs every test",
+			environment: "other-env",
+			resume:      true,
+			passed:      map[string]bool{"disabled/tcp": false, "enabled/tcp": false},
+		},
+		{
+			name:        "not resuming runs every test and removes the state",
+			environment: "env",
+			passed:      map[string]bool{"disabled/tcp": false, "enabled/tcp": false},
+			removed:     true,
+		},
+		{
+			name:        "resuming without a state runs every test",
+			environment: "env",
+			resume:      true,
+			passed:      map[string]bool{"disabled/tcp": false},
+			removed:     true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s, err := LoadRunState(path, c.environment, c.resume)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for key, want := range c.passed {
+				test := strings.SplitN(key, "/", 2)
+				if got := s.PassedBefore(test[0], test[1]); got != want {
+					t.Errorf("PassedBefore(%s) got %v, want %v", key, got, want)
+				}
+			}
+			if _, err = os.Stat(path); os.IsNotExist(err) != c.removed {
+				t.Errorf("state file removed: got %v, want %v", os.IsNotExist(err), c.removed)
+			}
+		})
+	}
+}
+
+func TestRunStateInMemory(t *testing.T) {
+	s, err := LoadRunState("", "env", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.MarkPassed("disabled", "tcp"); err != nil {
+		t.Fatal(err)
+	}
+	if s.PassedBefore("disabled", "tcp") {
+		t.Errorf("PassedBefore() got true for a test that passed in this run")
+	}
+}
+
+func TestEnvironmentKey(t *testing.T) {
+	c := &Config{KubeConfig: "kube", Hub: "hub", Tag: "tag", Namespace: "ns", IstioNamespace: "istio-system"}
+	key := EnvironmentKey(c)
+	c.Tag = "other"
+	if EnvironmentKey(c) == key {
+		t.Errorf("EnvironmentKey() is the same for another tag: %s", key)
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	multierror "github.com/hashicorp/go-multierror"
+
+	"istio.io/istio/pkg/log"
+)
+
+const (
+	// share of the requests, in percent, that a version may miss or exceed its expected share by
+	defaultScenarioTolerance = 10
+	// tries of each request of a scenario, for its configs to propagate
+	scenarioRepeat = 5
+)
+
+// Scenario is a test case described in a YAML file instead of Go: the configs it applies, and the
+// requests it sends with the responses they expect. ScenarioTest runs it.
+type Scenario struct {
+	Name string `json:"name"`
+	// routing API of the configs, v1alpha1 or v1alpha2, the scenario being skipped when it is disabled
+	Routing string `json:"routing"`
+	// labels of the test, see Labeled
+	Labels   []string          `json:"labels"`
+	Configs  []ScenarioConfig  `json:"configs"`
+	Requests []ScenarioRequest `json:"requests"`
+}
+
+// ScenarioConfig is a config template of testdata applied by a scenario, with its values.
+type ScenarioConfig struct {
+	Template string            `json:"template"`
+	Values   map[string]string `json:"values"`
+}
+
+// ScenarioRequest is a request of a scenario, from the client of an app.
+type ScenarioRequest struct {
+	Src string `json:"src"`
+	URL string `json:"url"`
+	// number of times the request is sent, 1 by default
+	Count   int               `json:"count"`
+	Method  string            `json:"method"`
+	Headers map[string]string `json:"headers"`
+	Expect  ScenarioExpect    `json:"expect"`
+}
+
+// ScenarioExpect is the response a scenario request expects. Only the given fields are checked.
+type ScenarioExpect struct {
+	// status code of every response, 200 by default
+	Code string `json:"code"`
+	// values of the response headers, an empty value meaning that the header must be missing
+	Headers map[string]string `json:"headers"`
+	// share of the requests each version of the destination serves, in percent
+	Versions map[string]int `json:"versions"`
+	// how many percent a version may miss or exceed its share by, 10 by default
+	Tolerance int `json:"tolerance"`
+}
+
+// LoadScenarios reads the scenarios of the YAML files in the directory, sorted by name.
+func LoadScenarios(dir string) ([]*Scenario, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]string)
+	scenarios := make([]*Scenario, 0, len(files))
+	for _, file := range files {
+		data, readErr := ioutil.ReadFile(file)
+		if readErr != nil {
+			return nil, readErr
+		}
+		var scenario Scenario
+		if err = yaml.Unmarshal(data, &scenario); err != nil {
+			return nil, fmt.Errorf("cannot parse the scenario %s: %v", file, err)
+		}
+		if scenario.Name == "" {
+			return nil, fmt.Errorf("the scenario %s has no name", file)
+		}
+		if other, ok := names[scenario.Name]; ok {
+			return nil, fmt.Errorf("the scenarios %s and %s are both named %q", other, file, scenario.Name)
+		}
+		names[scenario.Name] = file
+		scenarios = append(scenarios, &scenario)
+	}
+	sort.Slice(scenarios, func(i, j int) bool { return scenarios[i].Name < scenarios[j].Name })
+	return scenarios, nil
+}
+
+// ScenarioTest is the Test running a scenario.
+type ScenarioTest struct {
+	*Environment
+	Scenario *Scenario
+}
+
+// String returns the name of the test, scenario- followed by the name of the scenario.
+func (t *ScenarioTest) String() string {
+	return "scenario-" + t.Scenario.Name
+}
+
+// Labels returns the labels of the scenario, and LabelFull.
+func (t *ScenarioTest) Labels() []string {
+	return append([]string{LabelFull}, t.Scenario.Labels...)
+}
+
+// Setup applies the configs of the scenario.
+func (t *ScenarioTest) Setup() error {
+	if !t.routingEnabled() {
+		return nil
+	}
+	for _, config := range t.Scenario.Configs {
+		if err := t.ApplyConfig(config.Template, config.Values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run sends the requests of the scenario in turn, and checks their responses.
+func (t *ScenarioTest) Run() error {
+	if !t.routingEnabled() {
+		return Skip("%s routing rules are disabled", t.Scenario.Routing)
+	}
+	for i, req := range t.Scenario.Requests {
+		Tlog("Checking scenario "+t.Scenario.Name, fmt.Sprintf("request %d from %s to %s", i, req.Src, req.URL))
+		request := req
+		if err := Repeat(func() error { return t.check(&request) }, scenarioRepeat, time.Second); err != nil {
+			return fmt.Errorf("request %d from %s to %s: %v", i, req.Src, req.URL, err)
+		}
+	}
+	return nil
+}
+
+// Teardown deletes the configs of the scenario.
+func (t *ScenarioTest) Teardown() {
+	if !t.routingEnabled() {
+		return
+	}
+	if err := t.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+}
+
+func (t *ScenarioTest) routingEnabled() bool {
+	switch t.Scenario.Routing {
+	case "v1alpha1":
+		return t.Config.V1alpha1
+	case "v1alpha2":
+		return t.Config.V1alpha2
+	}
+	return true
+}
+
+// check sends the request and checks its responses against the expectations of the scenario.
+func (t *ScenarioTest) check(req *ScenarioRequest) error {
+	count := req.Count
+	if count == 0 {
+		count = 1
+	}
+	var extra []string
+	if req.Method != "" {
+		extra = append(extra, "-method "+req.Method)
+	}
+	for name, value := range req.Headers {
+		extra = append(extra, "-headers "+name+":"+value)
+	}
+	resp := t.ClientRequest(req.Src, req.URL, count, strings.Join(extra, " "))
+
+	code := req.Expect.Code
+	if code == "" {
+		code = httpOk
+	}
+	if len(resp.Code) != count {
+		return fmt.Errorf("%d of %d requests were answered", len(resp.Code), count)
+	}
+	for _, got := range resp.Code {
+		if got != code {
+			return fmt.Errorf("got code %s, want %s", got, code)
+		}
+	}
+
+	var errs error
+	for name, value := range req.Expect.Headers {
+		got := ""
+		headerRex := regexp.MustCompile("ResponseHeader=" + regexp.QuoteMeta(name) + ":(.*)")
+		if matches := headerRex.FindStringSubmatch(resp.Body); len(matches) > 1 {
+			got = matches[1]
+		}
+		if got != value {
+			errs = multierror.Append(errs, fmt.Errorf("got header %s=%q, want %q", name, got, value))
+		}
+	}
+
+	tolerance := req.Expect.Tolerance
+	if tolerance == 0 {
+		tolerance = defaultScenarioTolerance
+	}
+	versions := make(map[string]int)
+	for _, version := range resp.Version {
+		versions[version]++
+	}
+	for version, share := range req.Expect.Versions {
+		got := versions[version] * 100 / count
+		if got < share-tolerance || got > share+tolerance {
+			errs = multierror.Append(errs, fmt.Errorf("%d%% of the requests reached %s, want %d%% (+/-%d%%): %v",
+				got, version, share, tolerance, versions))
+		}
+	}
+	return errs
+}
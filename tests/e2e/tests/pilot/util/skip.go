@@ -0,0 +1,40 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import "fmt"
+
+// SkipError is returned by the Run of a test that could not check anything in this environment,
+// such as when a feature is disabled or unsupported, so that the harness can report why.
+type SkipError struct {
+	Reason string
+}
+
+func (e *SkipError) Error() string {
+	return "skipped: " + e.Reason
+}
+
+// Skip returns a SkipError with the formatted reason.
+func Skip(format string, args ...interface{}) error {
+	return &SkipError{Reason: fmt.Sprintf(format, args...)}
+}
+
+// SkipReason returns the reason of err if it is a SkipError.
+func SkipReason(err error) (string, bool) {
+	if skip, ok := err.(*SkipError); ok {
+		return skip.Reason, true
+	}
+	return "", false
+}
@@ -0,0 +1,237 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"istio.io/istio/pkg/log"
+)
+
+const (
+	// app receiving the background traffic of a soak run, and whose routing is churned
+	soakApp = "soak"
+	// route rule applied and removed in turn by the config churn
+	soakConfig = "v1alpha2/rule-soak.yaml.tmpl"
+	// number of requests sent from "a" to the soak app every soakTrafficInterval
+	soakBatch           = 10
+	soakTrafficInterval = time.Second
+	// delay between two changes of the soak route rule
+	soakChurnInterval = 10 * time.Second
+)
+
+// SoakWindow is the background traffic and config churn of one window of a soak run.
+type SoakWindow struct {
+	Auth          string    `json:"auth"`
+	Start         time.Time `json:"start"`
+	End           time.Time `json:"end"`
+	Requests      int       `json:"requests"`
+	Errors        int       `json:"errors"`
+	ConfigChanges int       `json:"config_changes"`
+	ConfigErrors  int       `json:"config_errors"`
+}
+
+// ErrorRate returns the share of the requests of the window that failed.
+func (w *SoakWindow) ErrorRate() float64 {
+	if w.Requests == 0 {
+		return 0
+	}
+	return float64(w.Errors) / float64(w.Requests)
+}
+
+// SoakResults collects the windows of soak runs across auth modes. It is safe for concurrent use.
+type SoakResults struct {
+	mu      sync.Mutex
+	windows []SoakWindow
+}
+
+func (r *SoakResults) add(window SoakWindow) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.windows = append(r.windows, window)
+}
+
+// Empty returns true if no window was recorded.
+func (r *SoakResults) Empty() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.windows) == 0
+}
+
+// Print writes a table of all windows, in the order they ended.
+func (r *SoakResults) Print(out io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "AUTH\tWINDOW\tREQUESTS\tERRORS\tERROR RATE\tCONFIG CHANGES\tCONFIG ERRORS")
+	for _, window := range r.windows {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%.2f%%\t%d\t%d\n", window.Auth, window.Start.Format(time.RFC3339),
+			window.Requests, window.Errors, 100*window.ErrorRate(), window.ConfigChanges, window.ConfigErrors)
+	}
+	return w.Flush()
+}
+
+// WriteFile writes all windows as a JSON array.
+func (r *SoakResults) WriteFile(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data, err := json.MarshalIndent(r.windows, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// Soak sends traffic from "a" to a soak app and keeps applying and removing a route rule for it
+// in the background of the tests, recording the outcome in windows of the given length.
+type Soak struct {
+	// own copy of the environment, whose apps and configs are not touched by the tests
+	env     *Environment
+	auth    string
+	length  time.Duration
+	results *SoakResults
+	app     string
+
+	mu     sync.Mutex
+	window SoakWindow
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// StartSoak deploys the soak app and starts the background traffic and config churn. Stop must
+// be called to end them and delete the app.
+func StartSoak(env *Environment, auth string, length time.Duration, results *SoakResults) (*Soak, error) {
+	s := &Soak{
+		env:     env.ForTest(soakApp),
+		auth:    auth,
+		length:  length,
+		results: results,
+		stop:    make(chan struct{}),
+	}
+	var err error
+	if s.app, err = s.env.DeployApp(AppSpec{Deployment: soakApp, Version: "v1"}); err != nil {
+		return nil, err
+	}
+	if err = s.env.RefreshApps(); err != nil {
+		s.deleteApp()
+		return nil, err
+	}
+
+	s.window = SoakWindow{Auth: auth, Start: time.Now()}
+	s.done.Add(3)
+	go s.loop(soakTrafficInterval, s.sendTraffic)
+	go s.loop(length, s.closeWindow)
+	if env.Config.V1alpha2 {
+		round := 0
+		go s.loop(soakChurnInterval, func() {
+			round++
+			s.churn(round)
+		})
+	} else {
+		log.Infof("v1alpha2 routing rules are disabled, soaking without config churn")
+		s.done.Done()
+	}
+	return s, nil
+}
+
+// Stop ends the background traffic and config churn, records the last window and deletes the soak app.
+func (s *Soak) Stop() {
+	close(s.stop)
+	s.done.Wait()
+	s.closeWindow()
+	if err := s.env.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+	s.deleteApp()
+}
+
+func (s *Soak) deleteApp() {
+	if err := s.env.KubeDelete(s.app, s.env.Config.Namespace); err != nil {
+		log.Warna(err)
+	}
+}
+
+// loop calls f every interval until the soak is stopped.
+func (s *Soak) loop(interval time.Duration, f func()) {
+	defer s.done.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			f()
+		}
+	}
+}
+
+func (s *Soak) sendTraffic() {
+	resp := s.env.ClientRequest("a", "http://"+soakApp+"/a", soakBatch, "")
+	ok := 0
+	for _, code := range resp.Code {
+		if code == httpOk {
+			ok++
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.window.Requests += soakBatch
+	s.window.Errors += soakBatch - ok
+}
+
+// churn applies the soak route rule on odd rounds and removes it on even ones.
+func (s *Soak) churn(round int) {
+	var err error
+	if round%2 == 1 {
+		err = s.env.ApplyConfig(soakConfig, map[string]string{"Round": strconv.Itoa(round)})
+	} else {
+		err = s.env.DeleteAllConfigs()
+	}
+	if err != nil {
+		log.Warnf("Soak config change %d failed: %v", round, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.window.ConfigChanges++
+	if err != nil {
+		s.window.ConfigErrors++
+	}
+}
+
+// closeWindow records the current window and starts the next one.
+func (s *Soak) closeWindow() {
+	s.mu.Lock()
+	window := s.window
+	window.End = time.Now()
+	s.window = SoakWindow{Auth: s.auth, Start: window.End}
+	s.mu.Unlock()
+
+	s.results.add(window)
+	log.Infof("Soak window: %d requests, %.2f%% errors, %d config changes (%d failed)",
+		window.Requests, 100*window.ErrorRate(), window.ConfigChanges, window.ConfigErrors)
+	// pods of "a" may have been replaced by the tests
+	if err := s.env.RefreshApps(); err != nil {
+		log.Warna(err)
+	}
+}
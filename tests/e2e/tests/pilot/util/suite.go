@@ -0,0 +1,813 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/davecgh/go-spew/spew"
+
+	"istio.io/istio/pkg/log"
+)
+
+const (
+	authTestName   = "Auth"
+	noAuthTestName = "NoAuth"
+)
+
+// AuthMode is an enumeration for the auth mode flag.
+type authMode string
+
+const (
+	authModeEnable  authMode = "enable"
+	authModeDisable authMode = "disable"
+	authModeBoth    authMode = "both"
+)
+
+// TestFactory returns the tests to run in the environment of an auth mode, in order. With -parallel, the
+// tests built with the environment concurrent returns for their name run alongside each other once the other
+// tests are done, each prefixing the names of its configs with its own name. Only tests that do not change
+// the routing another concurrent test relies on are built so.
+type TestFactory func(env *Environment, concurrent func(name string) *Environment) []Test
+
+// Suite runs the tests of a package against a cluster: it sets up an environment per auth mode, runs the
+// tests of its TestFactory in it with the selection, retries, deadlines and diagnostics of the flags, tears
+// it down and reports the outcome of the run. A package runs its tests with a suite from its TestMain:
+//
+//	var suite = util.NewSuite(tests)
+//
+//	func TestMain(m *testing.M) {
+//		os.Exit(suite.Main(m))
+//	}
+//
+//	func TestSuite(t *testing.T) {
+//		suite.Run(t)
+//	}
+type Suite struct {
+	// Config of the environments, whose fields are the defaults of the flags until Main parses them
+	Config *Config
+	Tests  TestFactory
+	// directory of the scenarios described in YAML, run after the tests of the factory, none if empty
+	ScenarioDir string
+	// tests that churn routing config, profiled around when -pprof-dir is set
+	ProfiledTests map[string]bool
+
+	// Enable/disable auth, or run both for the tests.
+	authMode string
+	verbose  bool
+	// YAML file of flag values, for the flags not passed on the command line
+	configFile string
+	// carries the -suite-deadline of the whole run down to doTest
+	ctx context.Context
+
+	// outcome of every test in every auth mode, printed when all tests are done
+	results Results
+	// latency percentiles measured with -benchmark, written to the benchmark file when all tests are done
+	benchmarks BenchmarkResults
+	// setup, run and teardown of every test attempt, written to -report-dir when all tests are done
+	reports Reports
+	// background traffic and config churn of -soak-duration runs, printed when all tests are done
+	soakResults SoakResults
+	// lifecycle events of every test attempt, appended to the -jsonl-output file as they happen
+	events *EventLog
+	// tests that passed in this environment, skipped on a run with -resume
+	state *RunState
+	// outputs of the run packaged into one bundle when all tests are done, with -artifacts-dir
+	artifacts *Artifacts
+	// start of the run, and clusters and images of every environment as it is torn down, written to the
+	// summary of -report-dir when all tests are done
+	started      time.Time
+	fingerprints struct {
+		sync.Mutex
+		list []Fingerprint
+	}
+
+	// environments set up and not torn down yet, torn down when the suite deadline fires
+	liveEnvs struct {
+		sync.Mutex
+		envs map[*Environment]bool
+	}
+}
+
+// NewSuite creates a suite running the tests of the factory, with the default config.
+func NewSuite(tests TestFactory) *Suite {
+	s := &Suite{
+		Config:        NewConfig(),
+		Tests:         tests,
+		ProfiledTests: make(map[string]bool),
+		authMode:      string(authModeBoth),
+		ctx:           context.Background(),
+		started:       time.Now(),
+	}
+	s.liveEnvs.envs = make(map[*Environment]bool)
+	return s
+}
+
+// registerFlags defines the flags of the config of the suite and of the suite itself on the flag set.
+func (s *Suite) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&s.configFile, "config", "",
+		"YAML file mapping flag names to values, such as a profile of testdata/profiles, for the flags not "+
+			"passed on the command line nor set by their E2E_<FLAG> environment variable, such as E2E_HUB for -hub")
+	fs.BoolVar(&s.verbose, "verbose", false, "Debug level noise from proxies")
+	fs.StringVar(&s.authMode, "auth", s.authMode,
+		fmt.Sprintf("Auth mode for the tests (Choose from %s, %s, %s)", authModeEnable, authModeDisable, authModeBoth))
+	s.Config.RegisterFlags(fs)
+}
+
+func (s *Suite) setup(authName string, env *Environment, t *testing.T) {
+	Tlog("Deploying infrastructure", spew.Sdump(env.Config))
+	if s.Config.DryRun {
+		DryRunf("setup of the %s environment", authName)
+	}
+	start := time.Now()
+	if env.Err = env.Setup(); env.Err != nil {
+		env.NotifyError("", env.Err)
+		s.results.Record(authName, "infrastructure-setup", false, time.Since(start))
+		s.reports.Add(AttemptReport{Auth: authName, Test: "infrastructure-setup", Attempt: 1,
+			Outcome: OutcomeFailed, Setup: time.Since(start), Message: env.Err.Error()})
+		t.Fatal(env.Err)
+	}
+}
+
+func (s *Suite) teardown(authName string, env *Environment, t *testing.T) {
+	s.liveEnvs.Lock()
+	live := s.liveEnvs.envs[env]
+	delete(s.liveEnvs.envs, env)
+	s.liveEnvs.Unlock()
+	if !live {
+		return
+	}
+	if s.Config.DryRun {
+		DryRunf("teardown of the %s environment", authName)
+	}
+	s.recordFingerprint(authName, env)
+	env.Teardown()
+	if !env.Config.VerifyCleanup {
+		return
+	}
+	start := time.Now()
+	if err := env.VerifyCleanup(env.Config.CleanupTimeout); err != nil {
+		s.results.Record(authName, "cleanup-verification", false, time.Since(start))
+		s.reports.Add(AttemptReport{Auth: authName, Test: "cleanup-verification", Attempt: 1,
+			Outcome: OutcomeFailed, Teardown: time.Since(start), Message: err.Error()})
+		t.Error(err)
+	}
+}
+
+// recordFingerprint adds the clusters and images of the environment, once the tests ran against it, to the
+// summary of the run.
+func (s *Suite) recordFingerprint(authName string, env *Environment) {
+	if s.Config.ReportDir == "" {
+		return
+	}
+	fingerprint, err := env.Fingerprint(authName)
+	if err != nil {
+		log.Warnf("cannot fingerprint the %s environment: %v", authName, err)
+	}
+	s.fingerprints.Lock()
+	s.fingerprints.list = append(s.fingerprints.list, fingerprint)
+	s.fingerprints.Unlock()
+}
+
+// Run runs the tests of the suite in the auth modes of -auth, each in its own environment, as subtests of t.
+// It is called from a test function once Main parsed the flags, and skips the tests unless the cluster, hub
+// and tag to run against are given.
+func (s *Suite) Run(t *testing.T) {
+	if s.verbose {
+		s.Config.Verbosity = 3
+	}
+
+	// Only run the tests if the user has defined the KUBECONFIG environment variable.
+	if s.Config.KubeConfig == "" {
+		t.Skip("Env variable KUBECONFIG not set. Skipping tests")
+	}
+
+	// the hub and tag are those of the control plane deployed from the templates, unless it comes from a
+	// manifest or is already installed, and those of the sidecars injected by hand into the apps
+	if (s.Config.IstioManifest == "" && !s.Config.UseExistingIstio) || !s.Config.UseAutomaticInjection {
+		if s.Config.Hub == "" {
+			t.Skip("HUB not specified. Skipping tests")
+		}
+		if s.Config.Tag == "" {
+			t.Skip("TAG not specified. Skipping tests")
+		}
+	}
+
+	if s.Config.AppImageHub() == "" {
+		t.Skip("Hub of the test apps not specified, see -app-hub. Skipping tests")
+	}
+
+	if s.Config.AppImageTag() == "" {
+		t.Skip("Tag of the test apps not specified, see -app-tag. Skipping tests")
+	}
+
+	if s.Config.Namespace != "" && authMode(s.authMode) == authModeBoth {
+		t.Skipf("When namespace(=%s) is specified, auth mode(=%s) must be one of enable or disable. Skipping tests.",
+			s.Config.Namespace, s.authMode)
+	}
+
+	noAuthConfig := *s.Config
+	authConfig := *s.Config
+	authConfig.Auth = true
+
+	switch authMode(s.authMode) {
+	case authModeEnable:
+		s.doTest(s.ctx, authTestName, &authConfig, t)
+	case authModeDisable:
+		s.doTest(s.ctx, noAuthTestName, &noAuthConfig, t)
+	case authModeBoth:
+		s.doTest(s.ctx, noAuthTestName, &noAuthConfig, t)
+		s.doTest(s.ctx, authTestName, &authConfig, t)
+	default:
+		t.Fatalf("Unknown auth mode(=%s).", s.authMode)
+	}
+}
+
+func (s *Suite) doTest(ctx context.Context, authName string, config *Config, t *testing.T) {
+	t.Run(authName, func(t *testing.T) {
+		if ctx.Err() != nil {
+			s.results.RecordSkip(authName, "all", "the suite deadline was exceeded", 0)
+			t.Skipf("skipping %s tests since the suite deadline was exceeded", authName)
+		}
+		env := NewEnvironment(*config)
+		s.liveEnvs.Lock()
+		s.liveEnvs.envs[env] = true
+		s.liveEnvs.Unlock()
+		defer s.teardown(authName, env, t)
+		if config.PprofInterval > 0 && !config.DryRun {
+			// stopped before the teardown
+			defer env.StartPilotProfiler(authName, config.PprofInterval).Stop()
+		}
+		s.setup(authName, env, t)
+
+		// With -parallel, the tests whose environment comes from concurrent run alongside each other
+		// once the other tests are done, each prefixing the names of its configs with its own name.
+		// Only tests that do not change the routing another concurrent test relies on are built so.
+		concurrentEnvs := make(map[string]*Environment)
+		concurrent := func(name string) *Environment {
+			if config.Parallel <= 1 {
+				return env
+			}
+			concurrentEnvs[name] = env.ForTest(name)
+			return concurrentEnvs[name]
+		}
+
+		tests := s.Tests(env, concurrent)
+
+		// The scenarios described in YAML run after the tests written in Go
+		if s.ScenarioDir != "" {
+			scenarios, err := LoadScenarios(s.ScenarioDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, scenario := range scenarios {
+				tests = append(tests, &ScenarioTest{Environment: env, Scenario: scenario})
+			}
+		}
+
+		// If the user has selected tests, skip all other tests but their dependencies
+		tests, err := OrderTests(tests, config.Selection())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if config.SoakDuration > 0 {
+			s.soak(ctx, authName, env, tests, concurrentEnvs, t)
+			return
+		}
+
+		var parallel []Test
+		for _, test := range tests {
+			if _, ok := concurrentEnvs[test.String()]; ok {
+				parallel = append(parallel, test)
+				continue
+			}
+			// Run the test the configured number of times.
+			for i := 0; i < config.TestCount; i++ {
+				attempt := i + 1
+				t.Run(s.attemptName(test, attempt), func(t *testing.T) {
+					s.runAttempt(ctx, authName, env, test, attempt, t)
+				})
+			}
+		}
+		if len(parallel) == 0 {
+			return
+		}
+
+		// the group only returns once its parallel subtests are done, so env outlives them
+		t.Run("parallel", func(t *testing.T) {
+			slots := make(chan struct{}, config.Parallel)
+			for _, test := range parallel {
+				testEnv := concurrentEnvs[test.String()]
+				// the attempts of a test share its configs, so they still run one at a time
+				var attempts sync.Mutex
+				for i := 0; i < config.TestCount; i++ {
+					attempt := i + 1
+					test := test
+					t.Run(s.attemptName(test, attempt), func(t *testing.T) {
+						t.Parallel()
+						attempts.Lock()
+						defer attempts.Unlock()
+						slots <- struct{}{}
+						defer func() { <-slots }()
+						s.runAttempt(ctx, authName, testEnv, test, attempt, t)
+					})
+				}
+			}
+		})
+		for _, testEnv := range concurrentEnvs {
+			if testEnv.Err != nil {
+				// dump the logs of the failure on teardown, as for the other tests
+				env.Err = testEnv.Err
+			}
+		}
+	})
+}
+
+// soak runs the tests one after the other, over and over, until -soak-duration is over, while
+// traffic is sent and route rules are changed in the background. Tests built with concurrent run
+// in their own environment, but not alongside the others.
+func (s *Suite) soak(ctx context.Context, authName string, env *Environment, tests []Test,
+	concurrentEnvs map[string]*Environment, t *testing.T) {
+	traffic, err := StartSoak(env, authName, s.Config.SoakWindow, &s.soakResults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer traffic.Stop()
+
+	deadline := time.Now().Add(s.Config.SoakDuration)
+	for attempt := 1; time.Now().Before(deadline) && ctx.Err() == nil; attempt++ {
+		for _, test := range tests {
+			if !time.Now().Before(deadline) {
+				break
+			}
+			testEnv := env
+			if concurrentEnv, ok := concurrentEnvs[test.String()]; ok {
+				testEnv = concurrentEnv
+			}
+			t.Run(s.attemptName(test, attempt), func(t *testing.T) {
+				s.runAttempt(ctx, authName, testEnv, test, attempt, t)
+			})
+			if testEnv.Err != nil {
+				env.Err = testEnv.Err
+			}
+		}
+	}
+}
+
+// attemptName returns the name of the subtest running the given attempt of the test.
+func (s *Suite) attemptName(test Test, attempt int) string {
+	if s.Config.TestCount > 1 || s.Config.SoakDuration > 0 {
+		return test.String() + "_attempt_" + strconv.Itoa(attempt)
+	}
+	return test.String()
+}
+
+// runAttempt sets up, runs and tears down the test once in the given environment, retrying failed
+// tries as configured, and records the outcome.
+func (s *Suite) runAttempt(ctx context.Context, authName string, env *Environment, test Test, attempt int,
+	t *testing.T) {
+	report := AttemptReport{Auth: authName, Test: test.String(), Attempt: attempt}
+	// after the outcome is set, also when the test is skipped
+	defer func() { env.NotifyTestFinish(report) }()
+	if ctx.Err() != nil {
+		s.results.RecordSkip(authName, test.String(), "the suite deadline was exceeded", 0)
+		report.Outcome, report.Message = OutcomeSkipped, "the suite deadline was exceeded"
+		s.reports.Add(report)
+		t.Skip("skipping test since the suite deadline was exceeded")
+	}
+	if s.Config.Resume && s.state.PassedBefore(authName, test.String()) {
+		s.results.RecordSkip(authName, test.String(), "it passed in a resumed run", 0)
+		report.Outcome, report.Message = OutcomeSkipped, "it passed in a resumed run"
+		s.reports.Add(report)
+		t.Skip("skipping test since it passed in a resumed run")
+	}
+	env.SetRunningTest(s.attemptName(test, attempt))
+	env.LabelTest(test.String())
+	if s.ProfiledTests[test.String()] {
+		testName := s.attemptName(test, attempt)
+		env.CollectPilotProfiles(fmt.Sprintf("%s-%s-before", authName, testName))
+		defer env.CollectPilotProfiles(fmt.Sprintf("%s-%s-after", authName, testName))
+	}
+
+	// drop the assertions of the setup of the environment and of the tests before
+	env.TakeAssertions()
+	// the proxies over their ceilings during the attempt fail it, even if its tries passed
+	monitor := env.StartResourceMonitor(s.attemptName(test, attempt))
+	start := time.Now()
+	retries := RetriesOf(test, s.Config.Retries)
+	// the test deadline covers all its tries, but not the teardown of the last one
+	testCtx, cancel := ctx, context.CancelFunc(func() {})
+	if s.Config.TestDeadline > 0 {
+		testCtx, cancel = context.WithTimeout(ctx, s.Config.TestDeadline)
+	}
+	defer cancel()
+	var skip error
+	for retry := 0; ; retry++ {
+		retrying := retry < retries && testCtx.Err() == nil
+		env.Err, skip = s.runTry(testCtx, authName, env, test, attempt, retry, retrying, &report)
+		if env.Err == nil || !retrying {
+			break
+		}
+		log.Infof("Retrying %s %s (%d/%d) after: %v", authName, test.String(), retry+1, retries, env.Err)
+		report.RetryFailures = append(report.RetryFailures, env.Err.Error())
+	}
+	report.Assertions = env.TakeAssertions()
+	var resourceErr error
+	if report.ProxyResources, resourceErr = monitor.Stop(); resourceErr != nil && env.Err == nil && skip == nil {
+		env.Err = resourceErr
+	}
+
+	if measured, ok := test.(Measured); ok && env.Err == nil && skip == nil {
+		report.Measurements = measured.Measurements()
+	}
+	switch reason, skipped := SkipReason(skip); {
+	case skipped:
+		s.results.RecordSkip(authName, test.String(), reason, time.Since(start))
+		report.Outcome, report.Message = OutcomeSkipped, reason
+		s.reports.Add(report)
+		t.Skip(reason)
+	case env.Err != nil:
+		s.results.Record(authName, test.String(), false, time.Since(start))
+		report.Outcome, report.Message = OutcomeFailed, env.Err.Error()
+		s.reports.Add(report)
+		t.Error(env.Err)
+	case len(report.RetryFailures) > 0:
+		s.results.RecordFlaky(authName, test.String(), time.Since(start))
+		report.Outcome = OutcomeFlaky
+		s.reports.Add(report)
+		s.markPassed(authName, test)
+		t.Logf("passed on retry %d after: %v", len(report.RetryFailures), report.RetryFailures)
+	default:
+		s.results.Record(authName, test.String(), true, time.Since(start))
+		report.Outcome = OutcomePassed
+		s.reports.Add(report)
+		s.markPassed(authName, test)
+	}
+}
+
+// markPassed records to the state file that the test passed, for a later run to -resume.
+func (s *Suite) markPassed(authName string, test Test) {
+	if err := s.state.MarkPassed(authName, test.String()); err != nil {
+		log.Warnf("Cannot record %s %s to the state file: %v", authName, test.String(), err)
+	}
+}
+
+// runTry sets up, runs and tears down the test once, adding the time spent in each step to the report.
+// It returns the error of the setup or run, or the skip error the run returned. If the setup fails,
+// the test is only torn down when it is retried, so that its next setup starts from scratch. The setup
+// and run fail once they exceed -setup-deadline and -run-deadline, or ctx is done.
+func (s *Suite) runTry(ctx context.Context, authName string, env *Environment, test Test, attempt, retry int,
+	retrying bool, report *AttemptReport) (err, skip error) {
+	failed := EventRunFailed
+	if retrying {
+		failed = EventRunRetried
+	}
+
+	s.events.Emit(EventTestStarted, authName, test.String(), attempt, retry, nil)
+	configs := env.SnapshotConfigs()
+	if s.Config.DryRun {
+		DryRunf("setup of %s %s", authName, test.String())
+	}
+	start := time.Now()
+	err = s.runPhase(ctx, "setup", test, s.Config.SetupDeadline, test.Setup)
+	if err == nil && s.Config.ConfigSyncTimeout > 0 {
+		// most flaky runs started before the config of the setup reached the proxies
+		err = s.runPhase(ctx, "config sync", test, s.Config.SetupDeadline, func() error {
+			return env.WaitForConfigSync(s.Config.ConfigSyncTimeout)
+		})
+	}
+	report.Setup += time.Since(start)
+	if err != nil {
+		s.events.Emit(failed, authName, test.String(), attempt, retry, err)
+		env.NotifyError(test.String(), err)
+		s.dumpDiagnostics(authName, env, test, attempt, retry, err)
+		s.pauseOnFailure(authName, env, test, retrying, err)
+		if retrying {
+			if leakErr := s.teardownTry(authName, env, test, attempt, retry, configs, report); leakErr != nil {
+				log.Warna(leakErr)
+			}
+		}
+		return err, nil
+	}
+	s.events.Emit(EventSetupDone, authName, test.String(), attempt, retry, nil)
+	defer func() {
+		// with -test-leak-check=fail, a test leaking configs fails even if it passed, as does one whose
+		// teardown exceeded its deadline
+		if leakErr := s.teardownTry(authName, env, test, attempt, retry, configs, report); leakErr != nil && err == nil {
+			err, skip = leakErr, nil
+			s.events.Emit(failed, authName, test.String(), attempt, retry, err)
+			env.NotifyError(test.String(), err)
+		}
+	}()
+
+	start = time.Now()
+	run := test.Run
+	switch {
+	case s.Config.DryRun:
+		run = func() error { return Skip("dry run") }
+	case s.Config.Benchmark:
+		run = func() error { return s.runBenchmark(authName, test) }
+	}
+	var capture *PacketCapture
+	if s.Config.CapturePcap && !s.Config.DryRun {
+		capture = env.StartPacketCapture()
+	}
+	err = s.runPhase(ctx, "run", test, s.Config.RunDeadline, run)
+	report.Run += time.Since(start)
+	if capture != nil {
+		_, skipped := SkipReason(err)
+		capture.Stop(s.tryName(authName, test, attempt, retry), err != nil && !skipped)
+	}
+	if _, skipped := SkipReason(err); skipped {
+		s.events.Emit(EventRunSkipped, authName, test.String(), attempt, retry, err)
+		return nil, err
+	}
+	if err != nil {
+		s.events.Emit(failed, authName, test.String(), attempt, retry, err)
+		env.NotifyError(test.String(), err)
+		// before the teardown, while the config of the failed run is still applied
+		s.dumpDiagnostics(authName, env, test, attempt, retry, err)
+		s.pauseOnFailure(authName, env, test, retrying, err)
+		return err, nil
+	}
+	s.events.Emit(EventRunPassed, authName, test.String(), attempt, retry, nil)
+	return nil, nil
+}
+
+// tryName returns the name of the directory of the diagnostics of a try of the test under -errorlogsdir.
+func (s *Suite) tryName(authName string, test Test, attempt, retry int) string {
+	name := authName + "-" + s.attemptName(test, attempt)
+	if retry > 0 {
+		name += "_retry_" + strconv.Itoa(retry)
+	}
+	return name
+}
+
+// runPhase runs the phase of the test, failing it with a DeadlineError after the deadline of the phase,
+// or once ctx is done after the test deadline or the suite deadline, whichever fired.
+func (s *Suite) runPhase(ctx context.Context, phase string, test Test, deadline time.Duration, f func() error) error {
+	err := RunWithDeadline(ctx, phase+" of "+test.String(), deadline, f)
+	if err == context.DeadlineExceeded {
+		if s.ctx.Err() == context.DeadlineExceeded {
+			return &DeadlineError{What: "suite", Deadline: s.Config.SuiteDeadline}
+		}
+		return &DeadlineError{What: test.String(), Deadline: s.Config.TestDeadline}
+	}
+	return err
+}
+
+// dumpDiagnostics writes the state of the sidecars after a failed try of the test under -errorlogsdir,
+// along with the goroutines of the harness when the try exceeded a deadline.
+func (s *Suite) dumpDiagnostics(authName string, env *Environment, test Test, attempt, retry int, err error) {
+	name := s.tryName(authName, test, attempt, retry)
+	if IsDeadline(err) {
+		env.DumpGoroutines(name)
+	}
+	env.DumpProxyDiagnostics(name)
+}
+
+// pauseOnFailure waits for the user before the teardown of a try that failed for good, with
+// -pause-on-failure.
+func (s *Suite) pauseOnFailure(authName string, env *Environment, test Test, retrying bool, err error) {
+	if !s.Config.PauseOnFailure || retrying {
+		return
+	}
+	env.PauseOnFailure(authName+" "+test.String(), err)
+}
+
+// teardownTry tears down one try of the test, adding the time spent to the report. It returns the error of
+// the configs the teardown left compared to the snapshot taken before the setup, with -test-leak-check=fail,
+// or a DeadlineError if the teardown exceeded -teardown-deadline. The teardown is not bound by the test
+// deadline, so that a test out of time still cleans up.
+func (s *Suite) teardownTry(authName string, env *Environment, test Test, attempt, retry int,
+	configs ConfigSnapshot, report *AttemptReport) error {
+	if s.Config.DryRun {
+		DryRunf("teardown of %s %s", authName, test.String())
+	}
+	start := time.Now()
+	err := RunWithDeadline(context.Background(), "teardown of "+test.String(), s.Config.TeardownDeadline,
+		func() error {
+			test.Teardown()
+			return nil
+		})
+	report.Teardown += time.Since(start)
+	if err != nil {
+		s.dumpDiagnostics(authName, env, test, attempt, retry, err)
+		return err
+	}
+	s.events.Emit(EventTeardownDone, authName, test.String(), attempt, retry, nil)
+	return env.CheckLeaks(configs, test.String())
+}
+
+// runBenchmark records the latencies measured by the test, and skips it if it has no benchmark mode.
+func (s *Suite) runBenchmark(authName string, test Test) error {
+	b, ok := test.(Benchmark)
+	if !ok {
+		return Skip("it has no benchmark mode")
+	}
+	latencies, err := b.BenchmarkRun()
+	if err != nil {
+		return err
+	}
+	s.benchmarks.Record(authName, test.String(), latencies)
+	return nil
+}
+
+// Main defines the flags of the suite on the command line, parses them, runs the tests of m and reports their
+// outcome, returning the exit code of the run, for the TestMain of the package. With -cleanup-run, it only
+// deletes the resources of that run.
+func (s *Suite) Main(m *testing.M) int {
+	s.registerFlags(flag.CommandLine)
+	flag.Parse()
+	_ = log.Configure(log.DefaultOptions())
+
+	var err error
+	if err = LoadConfigEnv(flag.CommandLine); err != nil {
+		log.Errorf("cannot configure the run from the environment: %v", err)
+		return 1
+	}
+	if s.configFile != "" {
+		if err = LoadConfigFile(s.configFile, flag.CommandLine); err != nil {
+			log.Errorf("cannot load the config file: %v", err)
+			return 1
+		}
+	}
+	if s.Config.CleanupRun != "" {
+		return s.cleanupRun()
+	}
+	if s.Config.RunID == "" {
+		s.Config.RunID = NewRunID()
+	}
+	log.Infof("Run %s, whose leftovers -cleanup-run %s deletes", s.Config.RunID, s.Config.RunID)
+	if s.artifacts, err = StageArtifacts(s.Config); err != nil {
+		log.Errorf("cannot stage the artifacts of the run: %v", err)
+		return 1
+	}
+	if s.events, err = OpenEventLog(s.Config.JSONLOutput); err != nil {
+		log.Errorf("cannot open the JSON lines output: %v", err)
+		return 1
+	}
+	if s.state, err = LoadRunState(s.Config.StateFile, EnvironmentKey(s.Config), s.Config.Resume); err != nil {
+		log.Errorf("cannot load the state file: %v", err)
+		return 1
+	}
+
+	cancel := func() {}
+	if s.Config.SuiteDeadline > 0 {
+		s.ctx, cancel = context.WithTimeout(context.Background(), s.Config.SuiteDeadline)
+		go s.abortOnDeadline(s.ctx)
+	}
+
+	// Run all tests.
+	code := m.Run()
+	cancel()
+	return s.finish(code)
+}
+
+// cleanupRun deletes the resources of the run of -cleanup-run, or of its test -cleanup-test, and returns
+// the exit code.
+func (s *Suite) cleanupRun() int {
+	s.Config.RunID = s.Config.CleanupRun
+	env := NewEnvironment(*s.Config)
+	var err error
+	if s.Config.CleanupTest == "" {
+		err = env.CleanupRun(s.Config.CleanupRun)
+	} else {
+		err = env.CleanupTest(s.Config.CleanupTest)
+	}
+	if err != nil {
+		log.Errorf("cannot clean up run %s: %v", s.Config.CleanupRun, err)
+		return 1
+	}
+	return 0
+}
+
+// abortOnDeadline waits for the suite deadline, then tears down all live environments and exits,
+// aborting the test that is still running.
+func (s *Suite) abortOnDeadline(ctx context.Context) {
+	<-ctx.Done()
+	if ctx.Err() != context.DeadlineExceeded {
+		return
+	}
+	log.Errorf("suite deadline of %v exceeded, aborting the run", s.Config.SuiteDeadline)
+
+	s.liveEnvs.Lock()
+	envs := s.liveEnvs.envs
+	s.liveEnvs.envs = make(map[*Environment]bool)
+	s.liveEnvs.Unlock()
+	dumped := false
+	for env := range envs {
+		// the goroutines show the test that is stuck
+		if !dumped {
+			env.DumpGoroutines("suite-deadline")
+			dumped = true
+		}
+		env.DumpProxyDiagnostics("suite-deadline")
+		authName := noAuthTestName
+		if env.Config.Auth {
+			authName = authTestName
+		}
+		s.recordFingerprint(authName, env)
+		// Teardown honors SkipCleanup
+		env.Teardown()
+	}
+
+	s.finish(1)
+	fmt.Fprintf(os.Stderr, "FAIL: suite deadline of %v exceeded\n", s.Config.SuiteDeadline)
+	os.Exit(1)
+}
+
+// finish reports the collected results and returns the exit code of the run.
+func (s *Suite) finish(code int) int {
+	if s.Config.Benchmark {
+		if err := s.benchmarks.WriteFile(s.Config.BenchmarkFile); err != nil {
+			log.Warna(err)
+		}
+	}
+	if s.Config.ReportDir != "" {
+		if err := s.reports.WriteDir(s.Config.ReportDir, JUnitReporter{}, JSONReporter{}); err != nil {
+			log.Warna(err)
+		}
+	}
+	if s.Config.CoverageDir != "" {
+		if err := MergeCoverage(s.Config.CoverageDir); err != nil {
+			log.Warna(err)
+		}
+	}
+	if err := s.results.Print(os.Stdout); err != nil {
+		log.Warna(err)
+	}
+	if s.Config.TestCount > 1 {
+		if err := s.reports.PrintFlakes(os.Stdout); err != nil {
+			log.Warna(err)
+		}
+	}
+	if !s.soakResults.Empty() {
+		if err := s.soakResults.Print(os.Stdout); err != nil {
+			log.Warna(err)
+		}
+		if s.Config.ReportDir != "" {
+			if err := s.soakResults.WriteFile(filepath.Join(s.Config.ReportDir, "soak.json")); err != nil {
+				log.Warna(err)
+			}
+		}
+	}
+	if err := s.events.Close(); err != nil {
+		log.Warna(err)
+	}
+	if _, _, failed, _ := s.results.Counts(); failed > 0 && code == 0 {
+		code = 1
+	}
+	if skipped := s.results.Skipped(); s.Config.FailOnSkip && len(skipped) > 0 {
+		fmt.Fprintf(os.Stderr, "FAIL: %d tests skipped with -fail-on-skip:\n", len(skipped))
+		for _, result := range skipped {
+			fmt.Fprintf(os.Stderr, "  %s/%s: %s\n", result.Auth, result.Test, result.SkipReason)
+		}
+		code = 1
+	}
+	if s.Config.ReportDir != "" {
+		s.fingerprints.Lock()
+		summary := NewSummary(s.Config, s.started, code, s.fingerprints.list, s.results.All())
+		s.fingerprints.Unlock()
+		if err := summary.WriteDir(s.Config.ReportDir); err != nil {
+			log.Warna(err)
+		}
+	}
+	if s.artifacts != nil {
+		passed, flaky, failed, skipped := s.results.Counts()
+		bundle, err := s.artifacts.Package(RunMetadata{
+			ExitCode: code,
+			Passed:   passed,
+			Flaky:    flaky,
+			Failed:   failed,
+			Skipped:  skipped,
+		})
+		if err != nil {
+			log.Warna(err)
+		} else {
+			log.Infof("Artifacts of the run packaged to %s", bundle)
+		}
+	}
+	return code
+}
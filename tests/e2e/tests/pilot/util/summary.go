@@ -0,0 +1,162 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/istio/pkg/log"
+	"istio.io/istio/tests/util"
+)
+
+// SummaryFile is the name of the summary of the run in ReportDir.
+const SummaryFile = "summary.json"
+
+// DeployedImage is an image a container of the environment runs, with the digest the node pulled.
+type DeployedImage struct {
+	Namespace string `json:"namespace"`
+	Container string `json:"container"`
+	Image     string `json:"image"`
+	// image ID of the container status, holding the digest of the image
+	ImageID string `json:"image_id"`
+}
+
+// Fingerprint identifies the environment one auth mode ran against.
+type Fingerprint struct {
+	Auth           string          `json:"auth"`
+	ClusterVersion string          `json:"cluster_version"`
+	Images         []DeployedImage `json:"images"`
+}
+
+// SummaryTest is the result of one test in one auth mode.
+type SummaryTest struct {
+	Auth       string  `json:"auth"`
+	Test       string  `json:"test"`
+	Outcome    Outcome `json:"outcome"`
+	Attempts   int     `json:"attempts"`
+	Failures   int     `json:"failures"`
+	Flakes     int     `json:"flakes"`
+	SkipReason string  `json:"skip_reason,omitempty"`
+	DurationMs int64   `json:"duration_ms"`
+}
+
+// Summary is the machine-readable summary of a run, correlating the results of the tests with the
+// config, the source and the images they ran against.
+type Summary struct {
+	Started      time.Time     `json:"started"`
+	Finished     time.Time     `json:"finished"`
+	ExitCode     int           `json:"exit_code"`
+	GitSHA       string        `json:"git_sha"`
+	Config       *Config       `json:"config"`
+	Environments []Fingerprint `json:"environments"`
+	Tests        []SummaryTest `json:"tests"`
+}
+
+// NewSummary returns the summary of the results, at the commit checked out in the working directory.
+func NewSummary(config *Config, started time.Time, code int, fingerprints []Fingerprint, results []Result) *Summary {
+	s := &Summary{
+		Started:      started,
+		Finished:     time.Now(),
+		ExitCode:     code,
+		Config:       config,
+		Environments: fingerprints,
+		Tests:        make([]SummaryTest, 0, len(results)),
+	}
+	if sha, err := util.ShellMuteOutput("git rev-parse HEAD"); err != nil {
+		log.Warnf("cannot get the git SHA of the run: %v", err)
+	} else {
+		s.GitSHA = strings.TrimSpace(sha)
+	}
+	for _, result := range results {
+		s.Tests = append(s.Tests, SummaryTest{
+			Auth:       result.Auth,
+			Test:       result.Test,
+			Outcome:    result.Outcome(),
+			Attempts:   result.Attempts,
+			Failures:   result.Failures,
+			Flakes:     result.Flakes,
+			SkipReason: result.SkipReason,
+			DurationMs: int64(result.Duration / time.Millisecond),
+		})
+	}
+	return s
+}
+
+// WriteDir writes the summary to SummaryFile in the directory, creating it if needed.
+func (s *Summary) WriteDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, SummaryFile), data, 0644)
+}
+
+// Fingerprint returns the version of the cluster and the images the containers of the Istio and the
+// app namespaces run, each once per namespace and container.
+func (e *Environment) Fingerprint(auth string) (Fingerprint, error) {
+	f := Fingerprint{Auth: auth}
+	version, err := e.KubeClient.Discovery().ServerVersion()
+	if err != nil {
+		return f, err
+	}
+	f.ClusterVersion = version.GitVersion
+
+	seen := make(map[DeployedImage]bool)
+	for _, namespace := range []string{e.Config.IstioNamespace, e.Config.Namespace} {
+		pods, err := e.KubeClient.CoreV1().Pods(namespace).List(meta_v1.ListOptions{})
+		if err != nil {
+			return f, err
+		}
+		for _, pod := range pods.Items {
+			statuses := append(append([]v1.ContainerStatus(nil), pod.Status.InitContainerStatuses...),
+				pod.Status.ContainerStatuses...)
+			for _, status := range statuses {
+				image := DeployedImage{
+					Namespace: namespace,
+					Container: status.Name,
+					Image:     status.Image,
+					ImageID:   status.ImageID,
+				}
+				if !seen[image] {
+					seen[image] = true
+					f.Images = append(f.Images, image)
+				}
+			}
+		}
+	}
+	sort.Slice(f.Images, func(i, j int) bool {
+		a, b := f.Images[i], f.Images[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Container != b.Container {
+			return a.Container < b.Container
+		}
+		return a.ImageID < b.ImageID
+	})
+	return f, nil
+}
@@ -14,6 +14,12 @@
 
 package util
 
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
 // Test is the interface for all integration tests.
 // TODO(nmittler): Remove this after all tests are converted to standard golang tests.
 type Test interface {
@@ -22,3 +28,190 @@ type Test interface {
 	Run() error
 	Teardown()
 }
+
+// Dependent is implemented by tests that rely on other tests having run first in the same
+// environment. DependsOn returns the String() names of those tests.
+type Dependent interface {
+	DependsOn() []string
+}
+
+// Retrier is implemented by tests that override -retries, to retry a known flaky test more
+// often, or to never retry a test whose failures are not transient.
+type Retrier interface {
+	Retries() int
+}
+
+// RetriesOf returns how many times a failed try of the test is retried, given the default
+// number of retries.
+func RetriesOf(test Test, retries int) int {
+	if retrier, ok := test.(Retrier); ok {
+		return retrier.Retries()
+	}
+	return retries
+}
+
+const (
+	// LabelSmoke is the label of the few tests that quickly check basic traffic.
+	LabelSmoke = "smoke"
+	// LabelFull is the label of all the tests of a complete run.
+	LabelFull = "full"
+	// LabelReachability is the label of the tests of basic traffic between the apps.
+	LabelReachability = "reachability"
+	// LabelRouting is the label of the tests of route rules, virtual services and destination rules.
+	LabelRouting = "routing"
+	// LabelSecurity is the label of the tests of mutual TLS, authentication and authorization.
+	LabelSecurity = "security"
+	// LabelEgress is the label of the tests of traffic to services outside of the mesh.
+	LabelEgress = "egress"
+	// LabelGateway is the label of the tests of traffic entering the mesh through ingress or a gateway.
+	LabelGateway = "gateway"
+	// LabelTelemetry is the label of the tests of traces, metrics and Mixer policies.
+	LabelTelemetry = "telemetry"
+	// LabelSlow is the label of the tests that take minutes, to leave them out of quick runs.
+	LabelSlow = "slow"
+)
+
+// Labeled is implemented by tests that have labels, such as LabelRouting and LabelSlow. Labels
+// describe the kind of run a test belongs to, what it covers and how long it takes. Tests that do
+// not implement it have no label.
+type Labeled interface {
+	Labels() []string
+}
+
+// hasLabel returns true if the test has the given label.
+func hasLabel(test Test, label string) bool {
+	labeled, ok := test.(Labeled)
+	if !ok {
+		return false
+	}
+	for _, l := range labeled.Labels() {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// Selection picks the tests to run by name, by regular expression and by label. A
+// test is selected if it matches all the non-empty fields.
+type Selection struct {
+	// names of the tests, any of which matches
+	Tests []string
+	// regular expression the name of a test must match, or must not match if prefixed with "!"
+	Filter string
+	// labels a test must have one of, except those prefixed with "!" which it must not have
+	Labels []string
+}
+
+func (s Selection) String() string {
+	return fmt.Sprintf("tests %s, filter %q and labels %s",
+		strings.Join(s.Tests, ","), s.Filter, strings.Join(s.Labels, ","))
+}
+
+// matchesLabels returns true if the test has one of the labels of the selection, if any, and none
+// of the excluded ones.
+func (s Selection) matchesLabels(test Test) bool {
+	wanted, found := false, false
+	for _, label := range s.Labels {
+		if strings.HasPrefix(label, "!") {
+			if hasLabel(test, strings.TrimPrefix(label, "!")) {
+				return false
+			}
+			continue
+		}
+		wanted = true
+		found = found || hasLabel(test, label)
+	}
+	return !wanted || found
+}
+
+// matcher returns a function reporting whether a test matches the selection.
+func (s Selection) matcher() (func(test Test) bool, error) {
+	names := make(map[string]bool, len(s.Tests))
+	for _, name := range s.Tests {
+		names[name] = true
+	}
+	filter, negate := strings.TrimPrefix(s.Filter, "!"), strings.HasPrefix(s.Filter, "!")
+	re, err := regexp.Compile(filter)
+	if err != nil {
+		return nil, fmt.Errorf("invalid test filter %q: %v", s.Filter, err)
+	}
+	return func(test Test) bool {
+		if len(names) > 0 && !names[test.String()] {
+			return false
+		}
+		if filter != "" && re.MatchString(test.String()) == negate {
+			return false
+		}
+		return s.matchesLabels(test)
+	}, nil
+}
+
+// OrderTests returns the tests to run in an order where every test comes after its dependencies,
+// keeping the given order otherwise. Only the tests that match the selection and the tests they
+// transitively depend on are returned. It fails on unknown tests, unknown dependencies, cycles,
+// and when no test matches the selection.
+func OrderTests(tests []Test, selection Selection) ([]Test, error) {
+	byName := make(map[string]Test, len(tests))
+	for _, test := range tests {
+		byName[test.String()] = test
+	}
+
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int, len(tests))
+	var ordered []Test
+	var visit func(test Test, path []string) error
+	visit = func(test Test, path []string) error {
+		name := test.String()
+		path = append(path, name)
+		switch state[name] {
+		case done:
+			return nil
+		case visiting:
+			return fmt.Errorf("test dependency cycle: %s", strings.Join(path, " -> "))
+		}
+		state[name] = visiting
+		if dependent, ok := test.(Dependent); ok {
+			for _, dep := range dependent.DependsOn() {
+				prerequisite, exists := byName[dep]
+				if !exists {
+					return fmt.Errorf("test %s depends on unknown test %s", name, dep)
+				}
+				if err := visit(prerequisite, path); err != nil {
+					return err
+				}
+			}
+		}
+		state[name] = done
+		ordered = append(ordered, test)
+		return nil
+	}
+
+	for _, name := range selection.Tests {
+		if _, exists := byName[name]; !exists {
+			return nil, fmt.Errorf("unknown test %s", name)
+		}
+	}
+	matches, err := selection.matcher()
+	if err != nil {
+		return nil, err
+	}
+	var roots []Test
+	for _, test := range tests {
+		if matches(test) {
+			roots = append(roots, test)
+		}
+	}
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("no test matches %s", selection)
+	}
+	for _, test := range roots {
+		if err := visit(test, nil); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
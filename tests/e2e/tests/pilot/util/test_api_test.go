@@ -0,0 +1,224 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeTest is a test that only has a name, labels and dependencies.
+type fakeTest struct {
+	name   string
+	labels []string
+	deps   []string
+}
+
+func (t *fakeTest) String() string      { return t.name }
+func (t *fakeTest) Setup() error        { return nil }
+func (t *fakeTest) Run() error          { return nil }
+func (t *fakeTest) Teardown()           {}
+func (t *fakeTest) Labels() []string    { return t.labels }
+func (t *fakeTest) DependsOn() []string { return t.deps }
+
+func names(tests []Test) []string {
+	out := make([]string, 0, len(tests))
+	for _, test := range tests {
+		out = append(out, test.String())
+	}
+	return out
+}
+
+func TestOrderTests(t *testing.T) {
+	cases := []struct {
+		name      string
+		tests     []Test
+		selection Selection
+		want      []string
+		err       string
+	}{
+		{
+			name:  "keeps the given order",
+			tests: []Test{&fakeTest{name: "a"}, &fakeTest{name: "b"}, &fakeTest{name: "c"}},
+			want:  []string{"a", "b", "c"},
+		},
+		{
+			name: "puts dependencies first",
+			tests: []Test{
+				&fakeTest{name: "a", deps: []string{"c"}},
+				&fakeTest{name: "b"},
+				&fakeTest{name: "c", deps: []string{"b"}},
+			},
+			want: []string{"b", "c", "a"},
+		},
+		{
+			name: "runs a shared dependency once",
+			tests: []Test{
+				&fakeTest{name: "a", deps: []string{"c"}},
+				&fakeTest{name: "b", deps: []string{"c"}},
+				&fakeTest{name: "c"},
+			},
+			want: []string{"c", "a", "b"},
+		},
+		{
+			name: "adds the dependencies of the selected tests",
+			tests: []Test{
+				&fakeTest{name: "a"},
+				&fakeTest{name: "b", deps: []string{"c"}},
+				&fakeTest{name: "c", deps: []string{"d"}},
+				&fakeTest{name: "d"},
+			},
+			selection: Selection{Tests: []string{"b"}},
+			want:      []string{"d", "c", "b"},
+		},
+		{
+			name: "fails on a cycle",
+			tests: []Test{
+				&fakeTest{name: "a", deps: []string{"b"}},
+				&fakeTest{name: "b", deps: []string{"c"}},
+				&fakeTest{name: "c", deps: []string{"a"}},
+			},
+			err: "test dependency cycle: a -> b -> c -> a",
+		},
+		{
+			name:  "fails on a test depending on itself",
+			tests: []Test{&fakeTest{name: "a", deps: []string{"a"}}},
+			err:   "test dependency cycle: a -> a",
+		},
+		{
+			name:  "fails on an unknown dependency",
+			tests: []Test{&fakeTest{name: "a", deps: []string{"b"}}},
+			err:   "test a depends on unknown test b",
+		},
+		{
+			name:      "fails on an unknown test",
+			tests:     []Test{&fakeTest{name: "a"}},
+			selection: Selection{Tests: []string{"b"}},
+			err:       "unknown test b",
+		},
+		{
+			name:      "fails when no test matches",
+			tests:     []Test{&fakeTest{name: "a"}},
+			selection: Selection{Labels: []string{LabelSmoke}},
+			err:       "no test matches",
+		},
+		{
+			name:      "fails on an invalid filter",
+			tests:     []Test{&fakeTest{name: "a"}},
+			selection: Selection{Filter: "("},
+			err:       "invalid test filter",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ordered, err := OrderTests(c.tests, c.selection)
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Fatalf("OrderTests() got error %v, want %q", err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("OrderTests() got error %v", err)
+			}
+			if got := names(ordered); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("OrderTests() got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestSelection(t *testing.T) {
+	tests := []Test{
+		&fakeTest{name: "tcp", labels: []string{LabelSmoke, LabelFull, LabelReachability}},
+		&fakeTest{name: "routing-rules", labels: []string{LabelFull, LabelRouting}},
+		&fakeTest{name: "routing-parity", labels: []string{LabelFull, LabelRouting, LabelSlow}},
+		&fakeTest{name: "mtls", labels: []string{LabelFull, LabelSecurity}},
+		&fakeTest{name: "unlabeled"},
+	}
+	cases := []struct {
+		name      string
+		selection Selection
+		want      []string
+	}{
+		{
+			name: "selects every test by default",
+			want: []string{"tcp", "routing-rules", "routing-parity", "mtls", "unlabeled"},
+		},
+		{
+			name:      "selects by name",
+			selection: Selection{Tests: []string{"mtls", "tcp"}},
+			want:      []string{"tcp", "mtls"},
+		},
+		{
+			name:      "selects by filter",
+			selection: Selection{Filter: "^routing-"},
+			want:      []string{"routing-rules", "routing-parity"},
+		},
+		{
+			name:      "excludes by negated filter",
+			selection: Selection{Filter: "!^routing-"},
+			want:      []string{"tcp", "mtls", "unlabeled"},
+		},
+		{
+			name:      "selects the tests with any of the labels",
+			selection: Selection{Labels: []string{LabelSmoke, LabelSecurity}},
+			want:      []string{"tcp", "mtls"},
+		},
+		{
+			name:      "excludes the tests with a negated label",
+			selection: Selection{Labels: []string{"!" + LabelSlow}},
+			want:      []string{"tcp", "routing-rules", "mtls", "unlabeled"},
+		},
+		{
+			name:      "combines labels and negated labels",
+			selection: Selection{Labels: []string{LabelRouting, "!" + LabelSlow}},
+			want:      []string{"routing-rules"},
+		},
+		{
+			name:      "selects the full category, which unlabeled tests are not in",
+			selection: Selection{Labels: []string{LabelFull}},
+			want:      []string{"tcp", "routing-rules", "routing-parity", "mtls"},
+		},
+		{
+			name:      "needs every field to match",
+			selection: Selection{Tests: []string{"tcp", "routing-rules"}, Filter: "rules", Labels: []string{LabelFull}},
+			want:      []string{"routing-rules"},
+		},
+		{
+			name:      "selects nothing when the fields disagree",
+			selection: Selection{Tests: []string{"tcp"}, Labels: []string{LabelRouting}},
+			want:      []string{},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			matches, err := c.selection.matcher()
+			if err != nil {
+				t.Fatalf("matcher() got error %v", err)
+			}
+			got := []string{}
+			for _, test := range tests {
+				if matches(test) {
+					got = append(got, test.String())
+				}
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("%s selected %v, want %v", c.selection, got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	multierror "github.com/hashicorp/go-multierror"
+
+	"istio.io/istio/pkg/log"
+	"istio.io/istio/tests/util"
+)
+
+// Traffic describes the requests DriveTraffic sends.
+type Traffic struct {
+	// apps the requests are sent from, from each of their pods
+	Sources []string
+	URL     string
+	// rate of the requests of all the clients together
+	QPS int
+	// clients each source pod runs at once, 1 if 0
+	Concurrency int
+	// requests counted once the warm-up is over, by all the clients together
+	Requests int
+	// how long the clients send requests at QPS before the counted ones, so that those find the
+	// connections open and the proxies warm
+	Warmup time.Duration
+	// more arguments of the client, such as headers
+	Extra string
+}
+
+// DriveTraffic sends the requests from every pod of the sources at once, each running Concurrency
+// clients sharing the QPS, and returns the result of each counted request, the failed ones included,
+// with the app it was sent from. It only fails if a client cannot run.
+func (e *Environment) DriveTraffic(t Traffic) (Requests, error) {
+	if t.QPS < 1 || t.Requests < 1 {
+		return nil, fmt.Errorf("the traffic to %s needs a rate and a number of requests", t.URL)
+	}
+	concurrency := t.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	type client struct{ app, pod string }
+	var clients []client
+	for _, app := range t.Sources {
+		if len(e.Apps[app]) == 0 {
+			return nil, fmt.Errorf("missing pod names for app %q", app)
+		}
+		for _, pod := range e.Apps[app] {
+			for i := 0; i < concurrency; i++ {
+				clients = append(clients, client{app: app, pod: pod})
+			}
+		}
+	}
+	if len(clients) == 0 {
+		return nil, fmt.Errorf("no source of traffic to %s", t.URL)
+	}
+	qps := t.QPS / len(clients)
+	if qps < 1 {
+		qps = 1
+	}
+	duration := time.Duration(t.Requests) * time.Second / time.Duration(t.QPS)
+	log.Infof("Sending %d requests (%s) at %d qps from %d clients of %v, after a warm-up of %v", t.Requests,
+		t.URL, t.QPS, len(clients), t.Sources, t.Warmup)
+
+	var (
+		mu       sync.Mutex
+		requests Requests
+		errs     error
+		wg       sync.WaitGroup
+	)
+	for _, c := range clients {
+		wg.Add(1)
+		go func(c client) {
+			defer wg.Done()
+			sent, err := e.runTrafficClient(c.pod, t, qps, duration)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = multierror.Append(errs, fmt.Errorf("client of %s: %v", c.pod, err))
+				return
+			}
+			for i := range sent {
+				sent[i].Source = c.app
+			}
+			requests = append(requests, sent...)
+		}(c)
+	}
+	wg.Wait()
+	if errs != nil {
+		return nil, errs
+	}
+	log.Infof("Sent %d requests, by version %v", len(requests), requests.Counts())
+	return requests, nil
+}
+
+// runTrafficClient runs a client of the traffic in the pod at qps for the duration after the warm-up.
+func (e *Environment) runTrafficClient(pod string, t Traffic, qps int, duration time.Duration) (Requests, error) {
+	cmd := fmt.Sprintf("kubectl exec %s --kubeconfig %s -n %s -c app -- client -json -url %s -qps %d -warmup %v "+
+		"-duration %v %s", pod, e.Config.KubeConfig, e.Config.Namespace, t.URL, qps, t.Warmup, duration, t.Extra)
+	out, err := util.ShellMuteOutput(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return parseRequests(out)
+}
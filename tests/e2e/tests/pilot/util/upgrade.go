@@ -0,0 +1,198 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/istio/pilot/pkg/kube/inject"
+	"istio.io/istio/pkg/log"
+)
+
+// maximum number of 1s polls waiting for the upgraded deployments to be rolled out
+const upgradeRolloutBudget = 300
+
+// UpgradeControlPlane moves the deployments of the Istio namespace from the images of -base-tag to
+// those of Tag, and waits for them to be rolled out. The sidecars keep running the base tag.
+func (e *Environment) UpgradeControlPlane() error {
+	if e.Config.BaseTag == "" {
+		return fmt.Errorf("no base tag to upgrade the control plane from")
+	}
+	log.Infof("Upgrading the control plane from %s to %s", e.Config.BaseTag, e.Config.Tag)
+	names, err := e.retagDeployments(e.Config.IstioNamespace, e.Config.BaseTag, e.Config.Tag, false)
+	if err != nil {
+		return err
+	}
+	return e.waitForRollout(e.Config.IstioNamespace, names)
+}
+
+// DowngradeControlPlane moves the deployments of the Istio namespace from the images of Tag back to
+// those of the tag, after UpgradeControlPlane, and waits for them to be rolled out. The sidecars keep
+// running Tag.
+func (e *Environment) DowngradeControlPlane(tag string) error {
+	log.Infof("Downgrading the control plane from %s to %s", e.Config.Tag, tag)
+	names, err := e.retagDeployments(e.Config.IstioNamespace, e.Config.Tag, tag, false)
+	if err != nil {
+		return err
+	}
+	return e.waitForRollout(e.Config.IstioNamespace, names)
+}
+
+// UpgradeSidecars moves the sidecars of the apps from the images of -base-tag to those of Tag, rolling
+// all the deployments of the app namespace out, and waits for the new pods. With automatic injection
+// the new sidecars come from the updated configuration of the injector. The deployments made after it
+// get the sidecars of Tag, the environment no longer having a base tag.
+func (e *Environment) UpgradeSidecars() error {
+	if e.Config.BaseTag == "" {
+		return fmt.Errorf("no base tag to upgrade the sidecars from")
+	}
+	log.Infof("Upgrading the sidecars from %s to %s", e.Config.BaseTag, e.Config.Tag)
+	sidecarTemplate, err := e.generateSidecarTemplate(e.Config.Tag)
+	if err != nil {
+		return err
+	}
+	if e.Config.UseAutomaticInjection {
+		if err = e.UpdateSidecarInjectorTemplate(sidecarTemplate); err != nil {
+			return err
+		}
+	}
+	names, err := e.retagDeployments(e.Config.Namespace, e.Config.BaseTag, e.Config.Tag, true)
+	if err != nil {
+		return err
+	}
+	e.Config.SidecarTemplate = sidecarTemplate
+	e.Config.BaseTag = ""
+	if err = e.waitForRollout(e.Config.Namespace, names); err != nil {
+		return err
+	}
+	return e.RefreshApps()
+}
+
+// retagDeployments replaces the from tag of the images of the Hub in the deployments of the namespace
+// with the to tag, and returns the names of the deployments changed. If all is set, every deployment
+// is rolled out, even those without an image of the from tag.
+func (e *Environment) retagDeployments(namespace, from, to string, all bool) ([]string, error) {
+	deployments := e.KubeClient.ExtensionsV1beta1().Deployments(namespace)
+	list, err := deployments.List(meta_v1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for i := range list.Items {
+		deployment := &list.Items[i]
+		template := &deployment.Spec.Template
+		changed := e.retagContainers(template.Spec.InitContainers, from, to)
+		changed = e.retagContainers(template.Spec.Containers, from, to) || changed
+		if !changed && !all {
+			continue
+		}
+		if template.Annotations == nil {
+			template.Annotations = make(map[string]string)
+		}
+		template.Annotations[rolloutAnnotation] = time.Now().UTC().Format(time.RFC3339Nano)
+		if _, err = deployments.Update(deployment); err != nil {
+			return nil, err
+		}
+		names = append(names, deployment.Name)
+	}
+	return names, nil
+}
+
+// retagContainers replaces the from tag of the images of the Hub in the containers with the to tag,
+// and reports whether any image changed.
+func (e *Environment) retagContainers(containers []v1.Container, from, to string) bool {
+	changed := false
+	suffix := ":" + from
+	for i := range containers {
+		image := containers[i].Image
+		if strings.HasPrefix(image, e.Config.Hub+"/") && strings.HasSuffix(image, suffix) {
+			containers[i].Image = strings.TrimSuffix(image, suffix) + ":" + to
+			changed = true
+		}
+	}
+	return changed
+}
+
+// waitForRollout waits for the deployments of the namespace to run only pods of their latest template.
+func (e *Environment) waitForRollout(namespace string, names []string) error {
+	deployments := e.KubeClient.ExtensionsV1beta1().Deployments(namespace)
+	for _, name := range names {
+		rolledOut := false
+		for n := 0; n < upgradeRolloutBudget && !rolledOut; n++ {
+			deployment, err := deployments.Get(name, meta_v1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			replicas := int32(1)
+			if deployment.Spec.Replicas != nil {
+				replicas = *deployment.Spec.Replicas
+			}
+			status := deployment.Status
+			rolledOut = status.ObservedGeneration >= deployment.Generation && status.Replicas == replicas &&
+				status.UpdatedReplicas == replicas && status.AvailableReplicas == replicas
+			if !rolledOut {
+				time.Sleep(time.Second)
+			}
+		}
+		if !rolledOut {
+			return fmt.Errorf("deployment %s in namespace %s is not rolled out after %d attempts", name, namespace,
+				upgradeRolloutBudget)
+		}
+	}
+	return nil
+}
+
+// UpdateSidecarInjectorTemplate replaces the sidecar template of the configuration of the injector,
+// and restarts it rather than waiting for the kubelet to update its volume. The pods created before
+// keep the sidecar they got.
+func (e *Environment) UpdateSidecarInjectorTemplate(sidecarTemplate string) error {
+	configData, err := yaml.Marshal(&inject.Config{
+		Policy:   inject.InjectionPolicyEnabled,
+		Template: sidecarTemplate,
+	})
+	if err != nil {
+		return err
+	}
+	configMaps := e.KubeClient.CoreV1().ConfigMaps(e.Config.IstioNamespace)
+	configMap, err := configMaps.Get("istio-inject", meta_v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	configMap.Data["config"] = string(configData)
+	if _, err = configMaps.Update(configMap); err != nil {
+		return err
+	}
+
+	deployments := e.KubeClient.ExtensionsV1beta1().Deployments(e.Config.IstioNamespace)
+	deployment, err := deployments.Get(sidecarInjectorService, meta_v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	template := &deployment.Spec.Template
+	if template.Annotations == nil {
+		template.Annotations = make(map[string]string)
+	}
+	template.Annotations[rolloutAnnotation] = time.Now().UTC().Format(time.RFC3339Nano)
+	if _, err = deployments.Update(deployment); err != nil {
+		return err
+	}
+	return e.waitForRollout(e.Config.IstioNamespace, []string{sidecarInjectorService})
+}
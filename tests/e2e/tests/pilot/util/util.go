@@ -15,12 +15,10 @@
 package util
 
 import (
-	"context"
 	"errors"
 	"fmt"
 	"time"
 
-	"github.com/golang/sync/errgroup"
 	multierror "github.com/hashicorp/go-multierror"
 
 	"istio.io/istio/pkg/log"
@@ -39,56 +37,33 @@ var (
 	ErrAgain = Status(errors.New("try again"))
 )
 
-// TODO(nmittler): Can we remove this?
-
-// Parallel runs the given functions in parallel with retries. All funcs must succeed for the function to succeed
+// Parallel runs the given functions in parallel with retries. All funcs must succeed for the function to succeed.
+// It does not record the timing of the functions, see Environment.Eventually.
 func Parallel(fs map[string]func() Status) error {
-	g, ctx := errgroup.WithContext(context.Background())
-	repeat := func(name string, f func() Status) func() error {
-		return func() error {
-			for n := 0; n < budget; n++ {
-				log.Infof("%s (attempt %d)", name, n)
-				err := f()
-				switch err {
-				case nil:
-					// success
-					return nil
-				case ErrAgain:
-					// do nothing
-				default:
-					return fmt.Errorf("failed %s at attempt %d: %v", name, n, err)
-				}
-				select {
-				case <-time.After(time.Second):
-					// try again
-				case <-ctx.Done():
-					return nil
-				}
-			}
-			return fmt.Errorf("failed all %d attempts for %s", budget, name)
-		}
-	}
-	for name, f := range fs {
-		g.Go(repeat(name, f))
-	}
-	return g.Wait()
+	return eventually(DefaultBudget, fs, nil)
 }
 
-// TODO(nmittler): Can we remove this?
-
 // Repeat will reattempt the given function up to budget times or until it does not return an error
 func Repeat(f func() error, budget int, delay time.Duration) error {
+	if budget <= 0 {
+		return nil
+	}
 	var errs error
-	for i := 0; i < budget; i++ {
+	attempt := 0
+	check := func() Status {
 		err := f()
 		if err == nil {
 			return nil
 		}
-		errs = multierror.Append(errs, multierror.Prefix(err, fmt.Sprintf("attempt %d", i)))
-		log.Infof("attempt #%d failed with %v", i, err)
-		time.Sleep(delay)
+		errs = multierror.Append(errs, multierror.Prefix(err, fmt.Sprintf("attempt %d", attempt)))
+		log.Infof("attempt #%d failed with %v", attempt, err)
+		attempt++
+		return ErrAgain
+	}
+	if eventually(ErrorBudget{Attempts: budget, Interval: delay}, map[string]func() Status{"repeat": check}, nil) != nil {
+		return errs
 	}
-	return errs
+	return nil
 }
 
 // Tlog is a utility function that prints a progress message for the currently running test
@@ -0,0 +1,97 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"strings"
+
+	"istio.io/istio/pkg/log"
+	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+)
+
+const (
+	// number of messages sent over each websocket, all but the first echoed verbatim by b
+	websocketMessages = 3
+	websocketHost     = "ws.example.com"
+)
+
+type websocketRouting struct {
+	*tutil.Environment
+}
+
+func (t *websocketRouting) String() string {
+	return "websocket"
+}
+
+func (t *websocketRouting) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelReachability, tutil.LabelRouting}
+}
+
+func (t *websocketRouting) Setup() error {
+	if !t.Config.V1alpha2 {
+		return nil
+	}
+	return t.ApplyConfig("v1alpha2/websocket.yaml.tmpl", nil)
+}
+
+// Run opens websockets to "b" from the sidecar of "a" and through the gateway, with websocket
+// upgrades enabled on both routes, and checks that every message sent is echoed back.
+func (t *websocketRouting) Run() error {
+	if !t.Config.V1alpha2 {
+		return tutil.Skip("v1alpha2 routing rules are disabled")
+	}
+
+	extra := fmt.Sprintf("-messages %d", websocketMessages)
+	cases := []struct {
+		name  string
+		src   string
+		url   string
+		extra string
+	}{
+		{"sidecar", "a", "ws://b/websocket", extra},
+		{"gateway", "t", fmt.Sprintf("ws://%s.%s/websocket", gatewayServiceName, t.Config.IstioNamespace),
+			extra + " -key Host -val " + websocketHost},
+	}
+	funcs := make(map[string]func() tutil.Status)
+	for _, cs := range cases {
+		name := fmt.Sprintf("Websocket from %s to b through the %s", cs.src, cs.name)
+		funcs[name] = (func(src, url, extra string) func() tutil.Status {
+			return func() tutil.Status {
+				resp := t.ClientRequest(src, url, 1, extra)
+				if len(resp.Hostname) == 0 || !containsPod(t.Apps["b"], resp.Hostname[0]) {
+					log.Infof("Websocket from %s to %s was not answered by b: %q", src, url, resp.Body)
+					return tutil.ErrAgain
+				}
+				if echoes := strings.Count(resp.Body, "Echo="); echoes != websocketMessages-1 {
+					log.Infof("Websocket from %s to %s echoed %d/%d messages", src, url, echoes, websocketMessages-1)
+					return tutil.ErrAgain
+				}
+				return nil
+			}
+		})(cs.src, cs.url, cs.extra)
+	}
+	return t.Eventually(tutil.DefaultBudget, funcs)
+}
+
+func (t *websocketRouting) Teardown() {
+	if !t.Config.V1alpha2 {
+		return
+	}
+	log.Info("Cleaning up websocket route rules...")
+	if err := t.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+}
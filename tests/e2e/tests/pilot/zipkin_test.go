@@ -16,42 +16,218 @@ package pilot
 
 import (
 	"fmt"
+	"math"
 	"strings"
 	"sync"
 
 	uuid "github.com/satori/go.uuid"
 
+	"istio.io/istio/pkg/log"
 	tutil "istio.io/istio/tests/e2e/tests/pilot/util"
+	"istio.io/istio/tests/e2e/tests/pilot/util/builder"
 )
 
 const (
 	traceHeader = "X-Client-Trace-Id"
 	numTraces   = 5
+	// number of recent traces read from the backend, enough for the sampled requests
+	traceQueryLimit = 500
+	// host routed to a by the gateway of the test
+	traceGateway     = "zipkin-gateway"
+	traceGatewayHost = "zipkin.example.com"
+	// service of the spans of the gateway, its service cluster, which keeps the default of the proxy agent
+	traceGatewayService = "istio-proxy"
+	// requests from a to b sent without a client trace ID, for the proxies to sample them
+	traceSamplingRequests = 200
+	// difference allowed between the share of the requests sampled and -trace-sampling
+	traceSamplingTolerance = 0.1
 )
 
+// traceSpan is a span collected by a trace backend, with the services of the proxies that reported it.
+type traceSpan struct {
+	traceID string
+	id      string
+	// ID of the parent of the span, empty for the root span of the trace
+	parentID string
+	// several proxies report a span when the client and the server share its context
+	services []string
+	// values of the tags of the span, such as the X-Client-Trace-Id or the x-request-id of the request
+	tags []string
+}
+
+// hasService returns true if the proxy of the service reported the span.
+func (s *traceSpan) hasService(service string) bool {
+	for _, name := range s.services {
+		if name == service {
+			return true
+		}
+	}
+	return false
+}
+
+// addService records that the proxy of the service reported the span.
+func (s *traceSpan) addService(service string) {
+	if service != "" && !s.hasService(service) {
+		s.services = append(s.services, service)
+	}
+}
+
+// traceBackend reads the spans a tracing backend collected from the proxies.
+type traceBackend interface {
+	// spans returns the spans of the recent traces that the proxies of the app took part in.
+	spans(app string) ([]traceSpan, tutil.Status)
+}
+
+// zipkinBackend reads the traces from the v1 API of Zipkin.
+type zipkinBackend struct {
+	*tutil.Environment
+}
+
+func (b *zipkinBackend) spans(app string) ([]traceSpan, tutil.Status) {
+	response := b.ClientRequest("t",
+		fmt.Sprintf("http://zipkin.%s:9411/api/v1/traces?serviceName=%s&limit=%d", b.Config.IstioNamespace, app,
+			traceQueryLimit), 1, "")
+	if !response.IsHTTPOk() {
+		return nil, tutil.ErrAgain
+	}
+	var traces [][]struct {
+		TraceID     string `json:"traceId"`
+		ID          string `json:"id"`
+		ParentID    string `json:"parentId"`
+		Annotations []struct {
+			Endpoint struct {
+				ServiceName string `json:"serviceName"`
+			} `json:"endpoint"`
+		} `json:"annotations"`
+		BinaryAnnotations []struct {
+			Value    interface{} `json:"value"`
+			Endpoint struct {
+				ServiceName string `json:"serviceName"`
+			} `json:"endpoint"`
+		} `json:"binaryAnnotations"`
+	}
+	if err := response.BodyJSON(&traces); err != nil {
+		return nil, fmt.Errorf("cannot parse the zipkin traces: %v", err)
+	}
+	var spans []traceSpan
+	for _, trace := range traces {
+		for _, span := range trace {
+			out := traceSpan{traceID: span.TraceID, id: span.ID, parentID: span.ParentID}
+			for _, annotation := range span.Annotations {
+				out.addService(annotation.Endpoint.ServiceName)
+			}
+			for _, annotation := range span.BinaryAnnotations {
+				out.addService(annotation.Endpoint.ServiceName)
+				out.tags = append(out.tags, fmt.Sprint(annotation.Value))
+			}
+			spans = append(spans, out)
+		}
+	}
+	return spans, nil
+}
+
+// jaegerBackend reads the traces from the query API of Jaeger.
+type jaegerBackend struct {
+	*tutil.Environment
+}
+
+func (b *jaegerBackend) spans(app string) ([]traceSpan, tutil.Status) {
+	response := b.ClientRequest("t",
+		fmt.Sprintf("http://jaeger-query.%s:16686/api/traces?service=%s&limit=%d", b.Config.IstioNamespace, app,
+			traceQueryLimit), 1, "")
+	if !response.IsHTTPOk() {
+		return nil, tutil.ErrAgain
+	}
+	var traces struct {
+		Data []struct {
+			Spans []struct {
+				TraceID    string `json:"traceID"`
+				SpanID     string `json:"spanID"`
+				ProcessID  string `json:"processID"`
+				References []struct {
+					RefType string `json:"refType"`
+					SpanID  string `json:"spanID"`
+				} `json:"references"`
+				Tags []struct {
+					Value interface{} `json:"value"`
+				} `json:"tags"`
+			} `json:"spans"`
+			Processes map[string]struct {
+				ServiceName string `json:"serviceName"`
+			} `json:"processes"`
+		} `json:"data"`
+	}
+	if err := response.BodyJSON(&traces); err != nil {
+		return nil, fmt.Errorf("cannot parse the jaeger traces: %v", err)
+	}
+	var spans []traceSpan
+	for _, trace := range traces.Data {
+		for _, span := range trace.Spans {
+			out := traceSpan{traceID: span.TraceID, id: span.SpanID}
+			out.addService(trace.Processes[span.ProcessID].ServiceName)
+			for _, reference := range span.References {
+				if reference.RefType == "CHILD_OF" {
+					out.parentID = reference.SpanID
+				}
+			}
+			for _, tag := range span.Tags {
+				out.tags = append(out.tags, fmt.Sprint(tag.Value))
+			}
+			spans = append(spans, out)
+		}
+	}
+	return spans, nil
+}
+
+// zipkin checks the traces of the proxies in the backend selected by -trace-backend.
 type zipkin struct {
 	*tutil.Environment
-	mutex  sync.Mutex
-	traces []string
+	backend traceBackend
+	mutex   sync.Mutex
+	traces  []string
+	// client trace ID of the request from a to b forwarded to c
+	chain string
+	// client trace ID of the request through the gateway to a forwarded to b
+	gatewayChain string
+	// x-request-id of the requests sent for the proxies to sample
+	sampled []string
 }
 
 func (t *zipkin) String() string {
 	return "zipkin"
 }
 
+func (t *zipkin) Labels() []string {
+	return []string{tutil.LabelFull, tutil.LabelTelemetry}
+}
+
 func (t *zipkin) Setup() error {
 	if !t.Config.Zipkin {
 		return nil
 	}
 
+	switch t.Config.TraceBackend {
+	case tutil.TraceBackendZipkin:
+		t.backend = &zipkinBackend{Environment: t.Environment}
+	case tutil.TraceBackendJaeger:
+		t.backend = &jaegerBackend{Environment: t.Environment}
+	default:
+		return fmt.Errorf("unknown trace backend %q", t.Config.TraceBackend)
+	}
 	t.traces = make([]string, 0, numTraces)
-	return nil
+	if !t.Config.V1alpha2 {
+		return nil
+	}
+	return builder.ApplyAndWait(t.Environment, "v1alpha2/zipkin-gateway",
+		builder.Gateway(traceGateway).Server(builder.Port(80, "http", "HTTP"), traceGatewayHost),
+		builder.VirtualService("zipkin-gateway", traceGatewayHost).Gateways(traceGateway).
+			HTTP(builder.HTTPRoute().Route("a", "", 0)))
 }
 
-// ensure that requests are picked up by Zipkin
+// ensure that requests are picked up by the trace backend
 func (t *zipkin) Run() error {
 	if !t.Config.Zipkin {
-		return nil
+		return tutil.Skip("zipkin is disabled")
 	}
 
 	if err := t.makeRequests(); err != nil {
@@ -61,7 +237,8 @@ func (t *zipkin) Run() error {
 	return t.verifyTraces()
 }
 
-// make requests for Zipkin to pick up
+// make requests for the trace backend to pick up, one from a to b that b forwards to c, one through the
+// gateway to a that a forwards to b, and the requests from a to b left to the sampling of the proxies
 func (t *zipkin) makeRequests() error {
 	funcs := make(map[string]func() tutil.Status)
 	for i := 0; i < numTraces; i++ {
@@ -78,37 +255,213 @@ func (t *zipkin) makeRequests() error {
 			return tutil.ErrAgain
 		}
 	}
-	return tutil.Parallel(funcs)
+	funcs["Trace request from a to b forwarded to c"] = func() tutil.Status {
+		id := uuid.NewV4()
+		// b answers with the code of c before its own payload
+		response := t.Environment.ClientRequest("a", "http://b/a?forward=http://c/b", 1,
+			fmt.Sprintf("-key %v -val %v", traceHeader, id))
+		if !response.IsHTTPOk() || !strings.Contains(response.Body, "ForwardedCode=200") {
+			return tutil.ErrAgain
+		}
+		t.mutex.Lock()
+		t.chain = id.String()
+		t.mutex.Unlock()
+		return nil
+	}
+	if t.Config.V1alpha2 {
+		funcs["Trace request through the gateway to a forwarded to b"] = func() tutil.Status {
+			id := uuid.NewV4()
+			response := t.Environment.ClientRequest("t",
+				fmt.Sprintf("http://%s.%s/zipkin?forward=http://b/zipkin", gatewayServiceName, t.Config.IstioNamespace),
+				1, fmt.Sprintf("-key Host -val %s -headers %s:%v", traceGatewayHost, traceHeader, id))
+			if !response.IsHTTPOk() || !strings.Contains(response.Body, "ForwardedCode=200") {
+				return tutil.ErrAgain
+			}
+			t.mutex.Lock()
+			t.gatewayChain = id.String()
+			t.mutex.Unlock()
+			return nil
+		}
+	}
+	funcs["Requests from a to b left to sampling"] = func() tutil.Status {
+		// without a client trace ID, which forces the proxies to trace a request
+		requests, err := t.ClientRequests("a", "http://b/sampling", traceSamplingRequests, "")
+		if err != nil {
+			return err
+		}
+		if err = requests.ExpectReachable(); err != nil {
+			log.Infof("requests from a to b left to sampling: %v", err)
+			return tutil.ErrAgain
+		}
+		t.mutex.Lock()
+		t.sampled = nil
+		for _, request := range requests {
+			t.sampled = append(t.sampled, request.ID)
+		}
+		t.mutex.Unlock()
+		return nil
+	}
+	return t.Eventually(tutil.DefaultBudget, funcs)
 }
 
-// verify that the traces were picked up by Zipkin
+// verify that the traces were picked up by the trace backend, and that the spans of the forwarded
+// request from a to b and from b to c share a trace
 func (t *zipkin) verifyTraces() error {
 	f := func() tutil.Status {
+		spans, status := t.backend.spans("a")
+		if status != nil {
+			return status
+		}
 		for _, id := range t.traces {
-			response := t.Environment.ClientRequest(
-				"t",
-				fmt.Sprintf("http://zipkin.%s:9411/api/v1/traces",
-					t.Config.IstioNamespace),
-				1, "",
-			)
-
-			if !response.IsHTTPOk() {
+			if traceOf(spans, id) == "" {
 				return tutil.ErrAgain
 			}
+		}
+		return nil
+	}
 
-			// ensure that sent trace IDs are a subset of the trace IDs in Zipkin.
-			// this is inefficient, but the alternatives are ugly regexps or extensive JSON parsing.
-			if !strings.Contains(response.Body, id) {
+	chain := func() tutil.Status {
+		spans, status := t.backend.spans("c")
+		if status != nil {
+			return status
+		}
+		traceID := traceOf(spans, t.chain)
+		if traceID == "" {
+			return tutil.ErrAgain
+		}
+		services := make(map[string]bool)
+		for _, span := range spans {
+			if span.traceID == traceID {
+				for _, service := range span.services {
+					services[service] = true
+				}
+			}
+		}
+		for _, app := range []string{"a", "b", "c"} {
+			if !services[app] {
 				return tutil.ErrAgain
 			}
 		}
 		return nil
 	}
 
-	return tutil.Parallel(map[string]func() tutil.Status{
-		"Ensure traces are picked up by Zipkin": f,
-	})
+	gatewayChain := func() tutil.Status {
+		spans, status := t.backend.spans("b")
+		if status != nil {
+			return status
+		}
+		traceID := traceOf(spans, t.gatewayChain)
+		if traceID == "" {
+			return tutil.ErrAgain
+		}
+		if err := checkSpanChain(spans, traceID, traceGatewayService, "a", "b"); err != nil {
+			log.Infof("trace %s of the request through the gateway: %v", traceID, err)
+			return tutil.ErrAgain
+		}
+		return nil
+	}
+
+	sampling := func() tutil.Status {
+		spans, status := t.backend.spans("b")
+		if status != nil {
+			return status
+		}
+		traced := 0
+		for _, id := range t.sampled {
+			if traceOf(spans, id) != "" {
+				traced++
+			}
+		}
+		share := float64(traced) / float64(len(t.sampled))
+		if math.Abs(share-t.Config.TraceSampling) > traceSamplingTolerance {
+			// the backend may not have collected all the spans yet
+			log.Infof("%d/%d requests from a to b were traced, want a share of %.2f (+/-%.2f)", traced,
+				len(t.sampled), t.Config.TraceSampling, traceSamplingTolerance)
+			return tutil.ErrAgain
+		}
+		return nil
+	}
+
+	funcs := map[string]func() tutil.Status{
+		"Ensure traces are picked up by " + t.Config.TraceBackend:                                f,
+		"Ensure the spans from a to b and from b to c share their trace":                         chain,
+		fmt.Sprintf("Ensure a share of %.2f of the requests is sampled", t.Config.TraceSampling): sampling,
+	}
+	if t.Config.V1alpha2 {
+		funcs["Ensure the spans from the gateway to a and from a to b are parent and child"] = gatewayChain
+	}
+	return t.Eventually(tutil.DefaultBudget, funcs)
+}
+
+// checkSpanChain returns an error unless the spans of the trace form a single tree rooted at a span of
+// the first service, and a span of each of the services is, or descends from, a span of the previous one.
+func checkSpanChain(spans []traceSpan, traceID string, services ...string) error {
+	byID := make(map[string]*traceSpan)
+	for i := range spans {
+		if spans[i].traceID == traceID {
+			byID[spans[i].id] = &spans[i]
+		}
+	}
+	var roots []*traceSpan
+	for _, span := range byID {
+		if span.parentID == "" {
+			roots = append(roots, span)
+		} else if byID[span.parentID] == nil {
+			return fmt.Errorf("the parent %s of the span %s of %v is not in the trace", span.parentID, span.id,
+				span.services)
+		}
+	}
+	if len(roots) != 1 {
+		return fmt.Errorf("the trace has %d root spans, want 1", len(roots))
+	}
+	if !roots[0].hasService(services[0]) {
+		return fmt.Errorf("the root span of the trace is of %v, want %s", roots[0].services, services[0])
+	}
+	for i := 1; i < len(services); i++ {
+		parent, child := services[i-1], services[i]
+		found := false
+		for _, span := range byID {
+			if span.hasService(child) && descendsFrom(byID, span, parent) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("no span of %s descends from a span of %s", child, parent)
+		}
+	}
+	return nil
+}
+
+// descendsFrom returns true if the span, or one of its ancestors, is a span of the service.
+func descendsFrom(byID map[string]*traceSpan, span *traceSpan, service string) bool {
+	// bounded by the number of spans, in case the parents loop
+	for n := 0; span != nil && n <= len(byID); n++ {
+		if span.hasService(service) {
+			return true
+		}
+		span = byID[span.parentID]
+	}
+	return false
+}
+
+// traceOf returns the ID of the trace of the span tagged with the client trace ID, if any.
+func traceOf(spans []traceSpan, id string) string {
+	for _, span := range spans {
+		for _, tag := range span.tags {
+			if tag == id {
+				return span.traceID
+			}
+		}
+	}
+	return ""
 }
 
 func (t *zipkin) Teardown() {
+	if !t.Config.Zipkin || !t.Config.V1alpha2 {
+		return
+	}
+	if err := t.DeleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
 }
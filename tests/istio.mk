@@ -85,30 +85,28 @@ e2e_pilot: istioctl generate_yaml
 
 # Target for running e2e pilot in a minikube env. Used by CI
 test/minikube/auth/e2e_pilot: istioctl generate_yaml
-	mkdir -p ${OUT_DIR}/logs
+	mkdir -p ${OUT_DIR}/artifacts
 	kubectl create ns istio-system || true
 	kubectl create ns istio-test || true
 	go test -test.v -timeout 20m ./tests/e2e/tests/pilot -args \
 		-hub ${HUB} -tag ${TAG} \
 		--skip-cleanup --mixer=true --auth=enable \
-		-errorlogsdir=${OUT_DIR}/logs \
+		-artifacts-dir=${OUT_DIR}/artifacts \
 		--use-sidecar-injector=false \
-		--core-files-dir=${OUT_DIR}/logs \
          --ns istio-system \
         -n istio-test \
            ${TESTOPTS}
 
 # Target for running e2e pilot in a minikube env. Used by CI
 test/minikube/noauth/e2e_pilot: istioctl generate_yaml
-	mkdir -p ${OUT_DIR}/logs
+	mkdir -p ${OUT_DIR}/artifacts
 	kubectl create ns istio-system || true
 	kubectl create ns istio-test || true
 	go test -test.v -timeout 20m ./tests/e2e/tests/pilot -args \
 		-hub ${HUB} -tag ${TAG} \
 		--skip-cleanup --mixer=true \
-		-errorlogsdir=${OUT_DIR}/logs \
+		-artifacts-dir=${OUT_DIR}/artifacts \
 		--use-sidecar-injector=false \
-		--core-files-dir=${OUT_DIR}/logs \
          --ns istio-system \
         -n istio-test \
            ${TESTOPTS}
@@ -59,7 +59,7 @@ $(ISTIO_DOCKER)/node_agent.crt $(ISTIO_DOCKER)/node_agent.key: ${GEN_CERT} $(IST
 # directives to copy files to docker scratch directory
 
 # tell make which files are copied form go/out
-DOCKER_FILES_FROM_ISTIO_OUT:=pilot-test-client pilot-test-server pilot-test-eurekamirror \
+DOCKER_FILES_FROM_ISTIO_OUT:=pilot-test-client pilot-test-server pilot-test-eurekamirror pilot-test-consulmirror \
                              pilot-discovery pilot-agent sidecar-injector servicegraph mixs \
                              istio_ca flexvolume node_agent multicluster_ca
 $(foreach FILE,$(DOCKER_FILES_FROM_ISTIO_OUT), \
@@ -85,6 +85,7 @@ $(ISTIO_DOCKER)/envoy-debug: ${ISTIO_ENVOY_DEBUG_PATH} | $(ISTIO_DOCKER); cp ${I
 docker.app: $(ISTIO_DOCKER)/pilot-test-client $(ISTIO_DOCKER)/pilot-test-server \
             $(ISTIO_DOCKER)/cert.crt $(ISTIO_DOCKER)/cert.key
 docker.eurekamirror: $(ISTIO_DOCKER)/pilot-test-eurekamirror
+docker.consulmirror: $(ISTIO_DOCKER)/pilot-test-consulmirror
 docker.pilot:        $(ISTIO_DOCKER)/pilot-discovery
 docker.proxy docker.proxy_debug: $(ISTIO_DOCKER)/pilot-agent
 docker.proxy: $(ISTIO_DOCKER)/envoy
@@ -94,7 +95,7 @@ docker.proxy docker.proxy_debug: $(ISTIO_DOCKER)/envoy_bootstrap_tmpl.json
 docker.proxy_init: $(ISTIO_DOCKER)/prepare_proxy.sh
 docker.sidecar_injector: $(ISTIO_DOCKER)/sidecar-injector
 
-PILOT_DOCKER:=docker.app docker.eurekamirror docker.pilot docker.proxy \
+PILOT_DOCKER:=docker.app docker.eurekamirror docker.consulmirror docker.pilot docker.proxy \
               docker.proxy_debug docker.proxy_init docker.sidecar_injector
 $(PILOT_DOCKER): pilot/docker/Dockerfile$$(suffix $$@) | $(ISTIO_DOCKER)
 	$(DOCKER_RULE)